@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"strconv"
@@ -9,10 +10,12 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/metal-toolbox/audito-maldito/internal/health"
 	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/eventfilter"
 )
 
 const usage = `audito-maldito
@@ -22,6 +25,10 @@ DESCRIPTION
   produces structured audit events describing what authenticated users
   did while logged in (e.g., what programs they executed).
 
+SUBCOMMANDS
+  replay - Process previously-captured sshd and audit log files
+           (refer to 'audito-maldito replay -h' for more information)
+
 OPTIONS
 `
 
@@ -38,6 +45,20 @@ const (
 	DefaultAuditModifyTimeThreshold = 86400
 )
 
+// buildLogger builds a zap.Logger from the optional zap.Config with
+// the specified log level. A production config is used if optConfig
+// is nil.
+func buildLogger(level zapcore.Level, optConfig *zap.Config) (*zap.Logger, error) {
+	if optConfig == nil {
+		cfg := zap.NewProductionConfig()
+		optConfig = &cfg
+	}
+
+	optConfig.Level = zap.NewAtomicLevelAt(level)
+
+	return optConfig.Build()
+}
+
 type metricsConfig struct {
 	enableMetrics                    bool
 	enableHealthz                    bool
@@ -125,3 +146,24 @@ func handleAuditLogMetrics(
 		}
 	})
 }
+
+// newEventFilter returns an eventfilter.Filter that uses the rules found
+// in the config file at configPath. It returns nil, which keeps every
+// event, if configPath is empty.
+func newEventFilter(configPath string, pprov *metrics.PrometheusMetricsProvider) (*eventfilter.Filter, error) {
+	if configPath == "" {
+		return nil, nil
+	}
+
+	config, err := eventfilter.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := eventfilter.NewFilter(config, pprov)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event filter - %w", err)
+	}
+
+	return filter, nil
+}
@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/go-logr/zapr"
 	"github.com/metal-toolbox/auditevent"
@@ -14,20 +16,60 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/metal-toolbox/audito-maldito/ingesters/auditlog"
+	"github.com/metal-toolbox/audito-maldito/ingesters/journald"
 	"github.com/metal-toolbox/audito-maldito/ingesters/namedpipe"
 	"github.com/metal-toolbox/audito-maldito/ingesters/syslog"
 	"github.com/metal-toolbox/audito-maldito/internal/common"
 	"github.com/metal-toolbox/audito-maldito/internal/health"
 	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 	"github.com/metal-toolbox/audito-maldito/processors/auditd"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/dirreader"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/procsessions"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
 	"github.com/metal-toolbox/audito-maldito/processors/sshd"
 )
 
+const (
+	// sshdNamedPipeComponentName and auditdNamedPipeComponentName
+	// distinguish the named pipe ingesters in the health check.
+	sshdNamedPipeComponentName   = "sshd-" + namedpipe.NamedPipeProcessorComponentName
+	auditdNamedPipeComponentName = "auditd-" + namedpipe.NamedPipeProcessorComponentName
+
+	// auditLinesChanName and loginsChanName identify the channels
+	// between the ingesters and the audit processor in metrics.
+	auditLinesChanName = "audit-lines"
+	loginsChanName     = "logins"
+)
+
 func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLoggerConfig *zap.Config) error {
 	var appEventsOutput string
 	var auditdLogFilePath string
+	var auditdLogDirPath string
 	var sshdLogFilePath string
+	var sshdPipeFormat string
 	var metricsConfig metricsConfig
+	var journaldConfig journaldConfig
+	var syslogConfig syslogListenerConfig
+	var sessionStatePath string
+	var sessionStateInterval time.Duration
+	var recoverSessions bool
+	var procRoot string
+	var systemActions string
+	var sessionIdleTimeout time.Duration
+	var actionDetails string
+	var auditKeys string
+	var eventFilterConfigPath string
+	var cacheConfig sessiontracker.CacheConfig
+	var cacheOverflow string
+	var correlation sessiontracker.CorrelationConfig
+	var sshdPIDNamespace string
+	var auditChanConfig common.OverflowChanConfig
+	var auditChanOverflow string
+	var loginsChanConfig common.OverflowChanConfig
+	var loginsChanOverflow string
+	var chanSpillDir string
+	var chanSpillMaxBytes int64
+	var badLines auditd.BadLineConfig
 
 	logLevel := zapcore.InfoLevel
 
@@ -63,11 +105,186 @@ func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLog
 		"sshd-pipe-path",
 		"/app-audit/sshd-pipe",
 		"Path to the sshd log named pipe file")
+	flagSet.StringVar(
+		&sshdPipeFormat,
+		"sshd-pipe-format",
+		string(syslog.PipeFormatText),
+		"The format of the lines read from -sshd-pipe-path ('text' or 'json')")
 	flagSet.StringVar(
 		&auditdLogFilePath,
 		"auditd-pipe-path",
 		"/app-audit/audit-pipe",
 		"Path to the audit log named pipe file")
+	flagSet.StringVar(
+		&auditdLogDirPath,
+		"auditd-log-dir",
+		"",
+		"Path to an audit log directory (e.g., /var/log/audit) to read instead of -auditd-pipe-path")
+	flagSet.BoolVar(
+		&journaldConfig.enabled,
+		"sshd-journald",
+		false,
+		"Read sshd logs from journalctl instead of -sshd-pipe-path")
+	flagSet.StringVar(
+		&journaldConfig.format,
+		"sshd-journald-format",
+		string(journald.FormatJSON),
+		"The journalctl output format to read ('json' or 'export')")
+	flagSet.BoolVar(
+		&journaldConfig.stdin,
+		"sshd-journald-stdin",
+		false,
+		"Read journalctl output from stdin instead of running journalctl (requires -sshd-journald)")
+	flagSet.StringVar(
+		&syslogConfig.udpAddr,
+		"sshd-syslog-udp-addr",
+		"",
+		"Address to listen on for sshd syslog messages over UDP (e.g., 127.0.0.1:514)")
+	flagSet.StringVar(
+		&syslogConfig.tcpAddr,
+		"sshd-syslog-tcp-addr",
+		"",
+		"Address to listen on for sshd syslog messages over TCP (e.g., 127.0.0.1:514)")
+	flagSet.StringVar(
+		&syslogConfig.unixgramPath,
+		"sshd-syslog-unix-path",
+		"",
+		"Path of a unix datagram socket to listen on for sshd syslog messages")
+	flagSet.StringVar(
+		&sessionStatePath,
+		"session-state-path",
+		"",
+		"Path to a file that tracked sessions are saved to and restored from after a restart")
+	flagSet.DurationVar(
+		&sessionStateInterval,
+		"session-state-interval",
+		auditd.DefaultStateSaveInterval,
+		"Interval at which tracked sessions are saved to -session-state-path")
+	flagSet.BoolVar(
+		&recoverSessions,
+		"recover-sessions",
+		false,
+		"Find SSH sessions that started before audito-maldito by scanning -proc-root")
+	flagSet.StringVar(
+		&procRoot,
+		"proc-root",
+		procsessions.DefaultProcRoot,
+		"Path to the proc file system (e.g., the host's /proc mounted in a container). It is used by -recover-sessions, -correlate-parent-pid and -sshd-pid-namespace")
+	flagSet.StringVar(
+		&systemActions,
+		"system-actions",
+		string(sessiontracker.SystemActionsNone),
+		"Write SystemAction events for audit events without a session ('none', 'all' or 'keyed')")
+	flagSet.DurationVar(
+		&sessionIdleTimeout,
+		"session-idle-timeout",
+		0,
+		"End sessions that have no audit events for this long (e.g., 24h). Zero means sessions never expire")
+	flagSet.StringVar(
+		&actionDetails,
+		"action-details",
+		"all",
+		"Comma-separated audit record field groups to add to UserAction events ('process', 'files', 'syscall', 'users', 'terminal', 'all' or 'none')")
+	flagSet.StringVar(
+		&auditKeys,
+		"audit-keys",
+		"",
+		"Only write UserAction and SystemAction events produced by audit rules with one of these comma-separated keys (e.g., 'identity,privesc')")
+	flagSet.StringVar(
+		&eventFilterConfigPath,
+		"event-filter-config",
+		"",
+		"Path to a JSON file containing rules that drop or keep UserAction and SystemAction events")
+	flagSet.IntVar(
+		&cacheConfig.MaxEventsPerSession,
+		"max-cached-events-per-session",
+		0,
+		"Maximum number of audit events cached for a session whose remote user login was not found yet. Zero means no limit")
+	flagSet.IntVar(
+		&cacheConfig.MaxEvents,
+		"max-cached-events",
+		0,
+		"Maximum number of audit events cached for all sessions whose remote user logins were not found yet. Zero means no limit")
+	flagSet.StringVar(
+		&cacheOverflow,
+		"cache-overflow",
+		string(sessiontracker.OverflowDropOldest),
+		"What to do with the oldest cached audit events when a cache limit is reached ('drop-oldest' or 'write-unattributed')")
+	flagSet.BoolVar(
+		&correlation.ParentPID,
+		"correlate-parent-pid",
+		true,
+		"Correlate remote user logins with audit sessions started by the parent or a child of the process that logged them")
+	flagSet.BoolVar(
+		&correlation.Address,
+		"correlate-address",
+		false,
+		"Correlate remote user logins with audit sessions that have the same source address, if the match is unambiguous")
+	flagSet.DurationVar(
+		&correlation.AddressGracePeriod,
+		"correlate-address-grace-period",
+		sessiontracker.DefaultAddressGracePeriod,
+		"How long remote user logins and audit sessions wait to be correlated by PID before they are correlated by -correlate-address")
+	flagSet.StringVar(
+		&sshdPIDNamespace,
+		"sshd-pid-namespace",
+		procsessions.HostPIDNamespace,
+		"PID namespace of the PIDs in sshd's logs when sshd runs in a container ('host', a namespace inode, 'pid:[<inode>]' or a path such as /proc/<pid>/ns/pid). The PIDs are translated using -proc-root")
+	flagSet.IntVar(
+		&auditChanConfig.BufferSize,
+		"audit-chan-size",
+		10000,
+		"Number of audit log lines buffered between the -auditd-pipe-path or -auditd-log-dir ingester and the audit processor")
+	flagSet.StringVar(
+		&auditChanOverflow,
+		"audit-chan-overflow",
+		string(common.ChanOverflowBlock),
+		"What to do with audit log lines when -audit-chan-size is reached ('block', 'drop-newest', 'drop-oldest' or 'spill')")
+	flagSet.IntVar(
+		&loginsChanConfig.BufferSize,
+		"logins-chan-size",
+		0,
+		"Number of remote user logins buffered between the sshd processor and the audit processor")
+	flagSet.StringVar(
+		&loginsChanOverflow,
+		"logins-chan-overflow",
+		string(common.ChanOverflowBlock),
+		"What to do with remote user logins when -logins-chan-size is reached ('block', 'drop-newest', 'drop-oldest' or 'spill')")
+	flagSet.StringVar(
+		&chanSpillDir,
+		"chan-spill-dir",
+		"",
+		"Directory in which the 'spill' overflow policy writes values until the audit processor catches up")
+	flagSet.Int64Var(
+		&chanSpillMaxBytes,
+		"chan-spill-max-bytes",
+		common.DefaultSpillMaxBytes,
+		"Maximum size of each channel's spill file. Senders wait when it is reached")
+	flagSet.BoolVar(
+		&badLines.Tolerate,
+		"tolerate-bad-audit-lines",
+		false,
+		"Skip audit log lines that cannot be parsed instead of exiting")
+	flagSet.StringVar(
+		&badLines.DeadLetterPath,
+		"audit-dead-letter-path",
+		"",
+		"File that skipped audit log lines are appended to as JSON objects (requires -tolerate-bad-audit-lines)")
+	flagSet.Int64Var(
+		&badLines.DeadLetterMaxBytes,
+		"audit-dead-letter-max-bytes",
+		auditd.DefaultDeadLetterMaxBytes,
+		"Maximum size of -audit-dead-letter-path before it is rotated to a file with a '.1' suffix")
+	flagSet.IntVar(
+		&badLines.MaxBadLines,
+		"max-bad-audit-lines",
+		100,
+		"Exit if more than this many audit log lines are skipped within -bad-audit-lines-window. Zero means no limit")
+	flagSet.DurationVar(
+		&badLines.Window,
+		"bad-audit-lines-window",
+		auditd.DefaultBadLineWindow,
+		"Period over which -max-bad-audit-lines applies")
 
 	flagSet.Usage = func() {
 		os.Stderr.WriteString(usage)
@@ -79,14 +296,58 @@ func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLog
 		return err
 	}
 
-	if optLoggerConfig == nil {
-		cfg := zap.NewProductionConfig()
-		optLoggerConfig = &cfg
+	if journaldConfig.stdin && !journaldConfig.enabled {
+		return errors.New("-sshd-journald-stdin requires -sshd-journald")
+	}
+
+	if journaldConfig.enabled && syslogConfig.enabled() {
+		return errors.New("-sshd-journald cannot be used with the -sshd-syslog-* flags")
+	}
+
+	pipeFormat, err := syslog.ParsePipeFormat(sshdPipeFormat)
+	if err != nil {
+		return err
+	}
+
+	systemActionMode, err := sessiontracker.ParseSystemActionMode(systemActions)
+	if err != nil {
+		return err
+	}
+
+	actionDetailConfig, err := sessiontracker.ParseActionDetailConfig(actionDetails)
+	if err != nil {
+		return err
 	}
 
-	optLoggerConfig.Level = zap.NewAtomicLevelAt(logLevel)
+	cacheConfig.Overflow, err = sessiontracker.ParseOverflowPolicy(cacheOverflow)
+	if err != nil {
+		return err
+	}
+
+	correlation.ProcRoot = procRoot
+
+	correlation.SshdPIDNamespace, err = procsessions.ParsePIDNamespace(sshdPIDNamespace)
+	if err != nil {
+		return err
+	}
+
+	auditChanConfig.Name = auditLinesChanName
+	auditChanConfig.SpillDir = chanSpillDir
+	auditChanConfig.SpillMaxBytes = chanSpillMaxBytes
+	auditChanConfig.Policy, err = common.ParseChanOverflowPolicy(auditChanOverflow)
+	if err != nil {
+		return err
+	}
 
-	l, err := optLoggerConfig.Build()
+	loginsChanConfig.Name = loginsChanName
+	loginsChanConfig.SpillDir = chanSpillDir
+	loginsChanConfig.SpillMaxBytes = chanSpillMaxBytes
+	loginsChanConfig.Policy, err = common.ParseChanOverflowPolicy(loginsChanOverflow)
+	if err != nil {
+		return err
+	}
+
+	l, err := buildLogger(logLevel, optLoggerConfig)
 	if err != nil {
 		return err
 	}
@@ -110,6 +371,22 @@ func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLog
 		return fmt.Errorf("failed to get node name: %w", nodenameerr)
 	}
 
+	bootID, bootiderr := common.GetBootID()
+	if bootiderr != nil {
+		return fmt.Errorf("failed to get boot id: %w", bootiderr)
+	}
+
+	var existingLogins map[string]common.RemoteUserLogin
+	if recoverSessions {
+		sessions, err := procsessions.Find(procRoot)
+		if err != nil {
+			return fmt.Errorf("failed to find existing ssh sessions: %w", err)
+		}
+
+		logger.Infof("found %d existing ssh sessions in %s", len(sessions), procRoot)
+		existingLogins = procsessions.RemoteUserLogins(sessions, nodeName, mid)
+	}
+
 	eg, groupCtx := errgroup.WithContext(ctx)
 
 	auf, auditfileerr := helpers.OpenAuditLogFileUntilSuccessWithContext(groupCtx, appEventsOutput, zapr.NewLogger(l))
@@ -118,61 +395,122 @@ func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLog
 	}
 
 	eventWriter := auditevent.NewDefaultAuditEventWriter(auf)
-	logins := make(chan common.RemoteUserLogin)
 	pprov := metrics.NewPrometheusMetricsProvider()
 
+	logins, err := common.NewOverflowChan[common.RemoteUserLogin](loginsChanConfig, pprov, logger)
+	if err != nil {
+		return err
+	}
+
+	eg.Go(func() error {
+		return logins.Run(groupCtx)
+	})
+
+	eventFilter, err := newEventFilter(eventFilterConfigPath, pprov)
+	if err != nil {
+		return err
+	}
+
 	logger.Infoln("starting workers...")
 	handleMetricsAndHealth(groupCtx, metricsConfig, eg, h)
 	handleAuditLogMetrics(groupCtx, metricsConfig, eg, pprov)
 
-	h.AddReadiness(namedpipe.NamedPipeProcessorComponentName)
-	eg.Go(func() error {
-		err := common.IsNamedPipe(sshdLogFilePath)
+	sshdProcessor := sshd.NewSshdProcessor(groupCtx, logins.In(), nodeName, mid, eventWriter, pprov)
+
+	switch {
+	case journaldConfig.enabled:
+		err = handleSshdJournald(groupCtx, journaldConfig, sshdProcessor, eg, h)
 		if err != nil {
-			return fmt.Errorf("failed to check if sshd log path is a named pipe: %q - %w",
-				sshdLogFilePath, err)
+			return err
 		}
+	case syslogConfig.enabled():
+		handleSshdSyslogListener(groupCtx, syslogConfig, sshdProcessor, eg, h)
+	default:
+		h.AddReadiness(sshdNamedPipeComponentName)
+		eg.Go(func() error {
+			err := common.IsNamedPipe(sshdLogFilePath)
+			if err != nil {
+				return fmt.Errorf("failed to check if sshd log path is a named pipe: %q - %w",
+					sshdLogFilePath, err)
+			}
+
+			npi := namedpipe.NewNamedPipeIngester(logger, h)
+			npi.ComponentName = sshdNamedPipeComponentName
+			npi.Metrics = pprov
+
+			sli := syslog.NewSyslogIngester(sshdLogFilePath, pipeFormat, sshdProcessor, npi, logger)
+			err = sli.Ingest(groupCtx)
+
+			if logger.Level().Enabled(zap.DebugLevel) {
+				logger.Debugf("syslog ingester exited (%v)", err)
+			}
+			return err
+		})
+	}
 
-		sshdProcessor := sshd.NewSshdProcessor(groupCtx, logins, nodeName, mid, eventWriter, pprov)
-		npi := namedpipe.NewNamedPipeIngester(logger, h)
-
-		sli := syslog.NewSyslogIngester(sshdLogFilePath, sshdProcessor, npi)
-		err = sli.Ingest(groupCtx)
-
-		if logger.Level().Enabled(zap.DebugLevel) {
-			logger.Debugf("syslog ingester exited (%v)", err)
-		}
+	// The audit log lines pass through the same channel whether
+	// they are read from a named pipe or from the log directory.
+	auditLogChan, err := common.NewOverflowChan[string](auditChanConfig, pprov, logger)
+	if err != nil {
 		return err
-	})
-
-	auditLogChanBufSize := 10000
-	auditLogChan := make(chan string, auditLogChanBufSize)
+	}
 
-	h.AddReadiness(namedpipe.NamedPipeProcessorComponentName)
 	eg.Go(func() error {
-		err := common.IsNamedPipe(auditdLogFilePath)
-		if err != nil {
-			return fmt.Errorf("failed to check if auditd log path is a named pipe: %q - %w",
-				auditdLogFilePath, err)
-		}
-
-		np := namedpipe.NewNamedPipeIngester(logger, h)
-		alp := auditlog.NewAuditLogIngester(auditdLogFilePath, auditLogChan, np)
+		return auditLogChan.Run(groupCtx)
+	})
 
-		err = alp.Ingest(groupCtx)
-		if logger.Level().Enabled(zap.DebugLevel) {
-			logger.Debugf("audit log ingester exited (%v)", err)
+	if auditdLogDirPath != "" {
+		err = handleAuditLogDir(groupCtx, auditdLogDirPath, auditLogChan.In(), eg, h)
+		if err != nil {
+			return err
 		}
-		return err
-	})
+	} else {
+		h.AddReadiness(auditdNamedPipeComponentName)
+		eg.Go(func() error {
+			err := common.IsNamedPipe(auditdLogFilePath)
+			if err != nil {
+				return fmt.Errorf("failed to check if auditd log path is a named pipe: %q - %w",
+					auditdLogFilePath, err)
+			}
+
+			np := namedpipe.NewNamedPipeIngester(logger, h)
+			np.ComponentName = auditdNamedPipeComponentName
+			np.Metrics = pprov
+			alp := auditlog.NewAuditLogIngester(auditdLogFilePath, auditLogChan.In(), np)
+
+			err = alp.Ingest(groupCtx)
+			if logger.Level().Enabled(zap.DebugLevel) {
+				logger.Debugf("audit log ingester exited (%v)", err)
+			}
+			return err
+		})
+	}
 
 	h.AddReadiness(auditd.AuditdProcessorComponentName)
 	eg.Go(func() error {
 		ap := auditd.Auditd{
-			Audits: auditLogChan,
-			Logins: logins,
-			EventW: eventWriter,
-			Health: h,
+			Audits:             auditLogChan.Out(),
+			Logins:             logins.Out(),
+			EventW:             eventWriter,
+			Health:             h,
+			StateFilePath:      sessionStatePath,
+			StateSaveInterval:  sessionStateInterval,
+			MachineID:          mid,
+			BootID:             bootID,
+			ExistingLogins:     existingLogins,
+			SessionIdleTimeout: sessionIdleTimeout,
+			ActionDetails:      actionDetailConfig,
+			KeyFilter:          sessiontracker.ParseKeyFilter(auditKeys),
+			EventFilter:        eventFilter,
+			CacheConfig:        cacheConfig,
+			Correlation:        correlation,
+			BadLines:           badLines,
+			Metrics:            pprov,
+			SystemActions: sessiontracker.SystemActionConfig{
+				Mode:      systemActionMode,
+				NodeName:  nodeName,
+				MachineID: mid,
+			},
 		}
 
 		err := ap.Read(groupCtx)
@@ -197,3 +535,133 @@ func RunNamedPipe(ctx context.Context, osArgs []string, h *health.Health, optLog
 
 	return nil
 }
+
+// handleAuditLogDir starts a dirreader.LogDirReader for the audit log
+// directory found at dirPath. The reader's lines are sent to lines
+// (i.e., the audit log lines channel consumed by auditd.Auditd).
+//
+// The reader is marked as ready once the pre-existing audit logs have
+// been read. Its resources are released when the errgroup's context
+// is cancelled.
+func handleAuditLogDir(
+	ctx context.Context,
+	dirPath string,
+	lines chan<- string,
+	eg *errgroup.Group,
+	h *health.Health,
+) error {
+	logDirReader, err := dirreader.StartLogDirReader(ctx, dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to start audit log directory reader for %q - %w",
+			dirPath, err)
+	}
+
+	h.AddReadiness(dirreader.DirReaderComponentName)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-logDirReader.InitFilesDone():
+			h.OnReady(dirreader.DirReaderComponentName)
+		}
+	}()
+
+	eg.Go(func() error {
+		err := logDirReader.Wait()
+		if logger.Level().Enabled(zap.DebugLevel) {
+			logger.Debugf("audit log directory reader exited (%v)", err)
+		}
+		return err
+	})
+
+	eg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case line := <-logDirReader.Lines():
+				select {
+				case <-ctx.Done():
+					return nil
+				case lines <- line:
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+type journaldConfig struct {
+	enabled bool
+	format  string
+	stdin   bool
+}
+
+// handleSshdJournald starts a journald.JournaldIngester that passes
+// sshd journal entries to sshdProcessor. The entries are read from
+// a journalctl child process or from stdin.
+func handleSshdJournald(
+	ctx context.Context,
+	jc journaldConfig,
+	sshdProcessor sshd.SshdProcessor,
+	eg *errgroup.Group,
+	h *health.Health,
+) error {
+	format, err := journald.ParseFormat(jc.format)
+	if err != nil {
+		return err
+	}
+
+	h.AddReadiness(journald.JournaldIngesterComponentName)
+	eg.Go(func() error {
+		ji := journald.NewJournaldIngester(format, sshdProcessor, logger, h)
+
+		var err error
+		if jc.stdin {
+			err = ji.IngestReader(ctx, os.Stdin)
+		} else {
+			err = ji.Ingest(ctx)
+		}
+
+		if logger.Level().Enabled(zap.DebugLevel) {
+			logger.Debugf("journald ingester exited (%v)", err)
+		}
+		return err
+	})
+
+	return nil
+}
+
+type syslogListenerConfig struct {
+	udpAddr      string
+	tcpAddr      string
+	unixgramPath string
+}
+
+func (o syslogListenerConfig) enabled() bool {
+	return o.udpAddr != "" || o.tcpAddr != "" || o.unixgramPath != ""
+}
+
+// handleSshdSyslogListener starts a syslog.SyslogListener that passes
+// sshd syslog messages received from the network to sshdProcessor.
+func handleSshdSyslogListener(
+	ctx context.Context,
+	sc syslogListenerConfig,
+	sshdProcessor sshd.SshdProcessor,
+	eg *errgroup.Group,
+	h *health.Health,
+) {
+	h.AddReadiness(syslog.SyslogListenerComponentName)
+	eg.Go(func() error {
+		sl := syslog.NewSyslogListener(sshdProcessor, logger, h)
+		sl.UDPAddr = sc.udpAddr
+		sl.TCPAddr = sc.tcpAddr
+		sl.UnixgramPath = sc.unixgramPath
+
+		err := sl.Listen(ctx)
+		if logger.Level().Enabled(zap.DebugLevel) {
+			logger.Debugf("syslog listener exited (%v)", err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/metal-toolbox/audito-maldito/ingesters/replay"
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+)
+
+// ReplayCommandName is the name of the replay subcommand.
+const ReplayCommandName = "replay"
+
+const replayUsage = `audito-maldito replay
+
+DESCRIPTION
+  replay processes previously-captured sshd and Linux audit log files
+  and writes the resulting audit events. Events are attributed to the
+  users that logged in through sshd in the same way they are when
+  audito-maldito runs as a daemon. The logs' original timestamps are
+  used rather than the current time.
+
+  Each log file argument can be specified more than once. Rotated log
+  files should be specified from oldest to newest.
+
+EXAMPLE
+  audito-maldito replay -sshd-log auth.log.1 -sshd-log auth.log \
+    -audit-log audit.log.1 -audit-log audit.log -out events.jsonl
+
+OPTIONS
+`
+
+// RunReplay runs the replay subcommand. osArgs is expected to start
+// with the subcommand name (i.e., os.Args[1:]).
+func RunReplay(ctx context.Context, osArgs []string, optLoggerConfig *zap.Config) error {
+	var sshdLogPaths stringsFlag
+	var auditLogPaths stringsFlag
+	var outPath string
+	var since time.Time
+	var until time.Time
+	var nodeName string
+	var machineID string
+	var bootID string
+	var systemActions string
+	var actionDetails string
+	var auditKeys string
+	var eventFilterConfigPath string
+	var cacheConfig sessiontracker.CacheConfig
+	var cacheOverflow string
+	var correlation sessiontracker.CorrelationConfig
+	var badLines auditd.BadLineConfig
+
+	logLevel := zapcore.InfoLevel
+
+	flagSet := flag.NewFlagSet(osArgs[0], flag.ContinueOnError)
+
+	flagSet.Var(&logLevel, "log-level", "Set the log level according to zapcore.Level")
+	flagSet.Var(&sshdLogPaths, "sshd-log",
+		"Path to a syslog file containing sshd logs (e.g., /var/log/auth.log)")
+	flagSet.Var(&auditLogPaths, "audit-log",
+		"Path to a Linux audit log file (e.g., /var/log/audit/audit.log)")
+	flagSet.StringVar(&outPath, "out", "-",
+		"Path to write the resulting events to ('-' means stdout)")
+	flagSet.Func("since", "Ignore logs that occurred before this RFC 3339 timestamp",
+		timeFlagFn(&since))
+	flagSet.Func("until", "Ignore logs that occurred after this RFC 3339 timestamp",
+		timeFlagFn(&until))
+	flagSet.StringVar(&nodeName, "node-name", common.UnknownUser,
+		"The name of the node that produced the logs")
+	flagSet.StringVar(&machineID, "machine-id", common.UnknownUser,
+		"The machine ID of the node that produced the logs")
+	flagSet.StringVar(&bootID, "boot-id", "",
+		"The boot ID of the node when it produced the logs (refer to "+common.BootIDPath+"). Required by -audit-log")
+	flagSet.StringVar(&systemActions, "system-actions", string(sessiontracker.SystemActionsNone),
+		"Write SystemAction events for audit events without a session ('none', 'all' or 'keyed')")
+	flagSet.StringVar(&actionDetails, "action-details", "all",
+		"Comma-separated audit record field groups to add to UserAction events ('process', 'files', 'syscall', 'users', 'terminal', 'all' or 'none')")
+	flagSet.StringVar(&auditKeys, "audit-keys", "",
+		"Only write UserAction and SystemAction events produced by audit rules with one of these comma-separated keys (e.g., 'identity,privesc')")
+	flagSet.StringVar(&eventFilterConfigPath, "event-filter-config", "",
+		"Path to a JSON file containing rules that drop or keep UserAction and SystemAction events")
+	flagSet.IntVar(&cacheConfig.MaxEventsPerSession, "max-cached-events-per-session", 0,
+		"Maximum number of audit events cached for a session whose remote user login was not found yet. Zero means no limit")
+	flagSet.IntVar(&cacheConfig.MaxEvents, "max-cached-events", 0,
+		"Maximum number of audit events cached for all sessions whose remote user logins were not found yet. Zero means no limit")
+	flagSet.StringVar(&cacheOverflow, "cache-overflow", string(sessiontracker.OverflowDropOldest),
+		"What to do with the oldest cached audit events when a cache limit is reached ('drop-oldest' or 'write-unattributed')")
+	flagSet.BoolVar(&correlation.ParentPID, "correlate-parent-pid", true,
+		"Correlate remote user logins with audit sessions started by the parent or a child of the process that logged them")
+	flagSet.BoolVar(&correlation.Address, "correlate-address", false,
+		"Correlate remote user logins with audit sessions that have the same source address, if the match is unambiguous")
+	flagSet.DurationVar(&correlation.AddressGracePeriod, "correlate-address-grace-period", sessiontracker.DefaultAddressGracePeriod,
+		"How long remote user logins and audit sessions wait to be correlated by PID before they are correlated by -correlate-address")
+	flagSet.BoolVar(&badLines.Tolerate, "tolerate-bad-audit-lines", false,
+		"Skip audit log lines that cannot be parsed instead of exiting")
+	flagSet.StringVar(&badLines.DeadLetterPath, "audit-dead-letter-path", "",
+		"File that skipped audit log lines are appended to as JSON objects (requires -tolerate-bad-audit-lines)")
+	flagSet.Int64Var(&badLines.DeadLetterMaxBytes, "audit-dead-letter-max-bytes", auditd.DefaultDeadLetterMaxBytes,
+		"Maximum size of -audit-dead-letter-path before it is rotated to a file with a '.1' suffix")
+	flagSet.IntVar(&badLines.MaxBadLines, "max-bad-audit-lines", 0,
+		"Exit if more than this many audit log lines are skipped within -bad-audit-lines-window. Zero means no limit")
+	flagSet.DurationVar(&badLines.Window, "bad-audit-lines-window", auditd.DefaultBadLineWindow,
+		"Period over which -max-bad-audit-lines applies")
+
+	flagSet.Usage = func() {
+		os.Stderr.WriteString(replayUsage)
+		flagSet.PrintDefaults()
+		os.Exit(1)
+	}
+	err := flagSet.Parse(osArgs[1:])
+	if err != nil {
+		return err
+	}
+
+	if len(sshdLogPaths) == 0 && len(auditLogPaths) == 0 {
+		return fmt.Errorf("at least one of -sshd-log or -audit-log must be specified")
+	}
+
+	// Session correlation IDs are derived from the boot ID. If all
+	// replays shared a placeholder, sessions from different boots
+	// that reused an audit session ID would get the same ID.
+	if len(auditLogPaths) > 0 && (bootID == "" || bootID == common.UnknownUser) {
+		return fmt.Errorf("-boot-id must be specified when -audit-log is specified")
+	}
+
+	if !since.IsZero() && !until.IsZero() && until.Before(since) {
+		return fmt.Errorf("-until (%s) must not be before -since (%s)", until, since)
+	}
+
+	systemActionMode, err := sessiontracker.ParseSystemActionMode(systemActions)
+	if err != nil {
+		return err
+	}
+
+	actionDetailConfig, err := sessiontracker.ParseActionDetailConfig(actionDetails)
+	if err != nil {
+		return err
+	}
+
+	cacheConfig.Overflow, err = sessiontracker.ParseOverflowPolicy(cacheOverflow)
+	if err != nil {
+		return err
+	}
+
+	l, err := buildLogger(logLevel, optLoggerConfig)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = l.Sync() //nolint
+	}()
+
+	logger = l.Sugar()
+
+	auditd.SetLogger(logger)
+	sshd.SetLogger(logger)
+
+	var out io.Writer = os.Stdout
+	if outPath != "-" {
+		outFile, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open output file - %w", err)
+		}
+		defer outFile.Close()
+
+		out = outFile
+	}
+
+	eventWriter := auditevent.NewDefaultAuditEventWriter(out)
+	logins := make(chan common.RemoteUserLogin)
+	audits := make(chan string)
+
+	// Replaying logs should not affect the default registry,
+	// which is used by the metrics HTTP server.
+	pprov := metrics.NewPrometheusMetricsProviderForRegisterer(prometheus.NewRegistry())
+
+	eventFilter, err := newEventFilter(eventFilterConfigPath, pprov)
+	if err != nil {
+		return err
+	}
+
+	eg, groupCtx := errgroup.WithContext(ctx)
+
+	sshdProcessor := sshd.NewSshdProcessor(groupCtx, logins, nodeName, machineID, eventWriter, pprov)
+
+	eg.Go(func() error {
+		r := replay.NewReplayer(sshdLogPaths, auditLogPaths, sshdProcessor, audits, logger)
+		r.Since = since
+		r.Until = until
+
+		err := r.Replay(groupCtx)
+		if logger.Level().Enabled(zap.DebugLevel) {
+			logger.Debugf("replayer exited (%v)", err)
+		}
+		return err
+	})
+
+	eg.Go(func() error {
+		ap := auditd.Auditd{
+			After:                   since,
+			Before:                  until,
+			Audits:                  audits,
+			Logins:                  logins,
+			EventW:                  eventWriter,
+			Health:                  health.NewSingleReadinessHealth(auditd.AuditdProcessorComponentName),
+			DisableStaleDataCleanup: true,
+			MachineID:               machineID,
+			BootID:                  bootID,
+			ActionDetails:           actionDetailConfig,
+			KeyFilter:               sessiontracker.ParseKeyFilter(auditKeys),
+			EventFilter:             eventFilter,
+			CacheConfig:             cacheConfig,
+			Correlation:             correlation,
+			BadLines:                badLines,
+			Metrics:                 pprov,
+			SystemActions: sessiontracker.SystemActionConfig{
+				Mode:      systemActionMode,
+				NodeName:  nodeName,
+				MachineID: machineID,
+			},
+		}
+
+		err := ap.Read(groupCtx)
+		if logger.Level().Enabled(zap.DebugLevel) {
+			logger.Debugf("audit worker exited (%v)", err)
+		}
+		return err
+	})
+
+	return eg.Wait()
+}
+
+// stringsFlag is a flag.Value that can be specified more than once.
+type stringsFlag []string
+
+func (o *stringsFlag) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *stringsFlag) Set(s string) error {
+	*o = append(*o, s)
+	return nil
+}
+
+// timeFlagFn returns a function that parses an RFC 3339 timestamp
+// into t. It is meant to be used with flag.FlagSet.Func.
+func timeFlagFn(t *time.Time) func(string) error {
+	return func(s string) error {
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return err
+		}
+
+		*t = parsed
+
+		return nil
+	}
+}
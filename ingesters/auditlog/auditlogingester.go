@@ -12,7 +12,7 @@ import (
 
 func NewAuditLogIngester(
 	filePath string,
-	auditLogChan chan string,
+	auditLogChan chan<- string,
 	namedPipeIngester namedpipe.NamedPipeIngester,
 ) AuditLogIngester {
 	return AuditLogIngester{
@@ -25,7 +25,7 @@ func NewAuditLogIngester(
 type AuditLogIngester struct {
 	namedPipeIngester namedpipe.NamedPipeIngester
 	FilePath          string
-	AuditLogChan      chan string
+	AuditLogChan      chan<- string
 }
 
 func (a *AuditLogIngester) Ingest(ctx context.Context) error {
@@ -33,6 +33,10 @@ func (a *AuditLogIngester) Ingest(ctx context.Context) error {
 }
 
 func (a *AuditLogIngester) Process(ctx context.Context, line string) error {
-	a.AuditLogChan <- line
-	return nil
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case a.AuditLogChan <- line:
+		return nil
+	}
 }
@@ -0,0 +1,540 @@
+// journald package reads sshd logs from the systemd journal using the
+// output of journalctl. Entries can be read from a journalctl child
+// process or from any io.Reader (such as stdin).
+//
+// The cursor of the last processed journal entry is periodically
+// saved to a flush file. This allows the ingester to resume where it
+// left off after a restart.
+package journald
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+)
+
+const (
+	// JournaldIngesterComponentName is the name of the component
+	// that reads from journald. This is used in the health check.
+	JournaldIngesterComponentName = "journald-ingester"
+
+	// DefaultFlushInterval is the default interval at which the
+	// last-read journal cursor is saved.
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// Format is a journalctl output format.
+type Format string
+
+const (
+	// FormatJSON is journalctl's "json" output format.
+	// Each journal entry is a single line JSON object.
+	FormatJSON Format = "json"
+
+	// FormatExport is journalctl's "export" output format.
+	// Each journal entry is a series of "FIELD=value" lines
+	// terminated by an empty line.
+	FormatExport Format = "export"
+)
+
+// Journal entry field names.
+const (
+	fieldCursor             = "__CURSOR"
+	fieldMessage            = "MESSAGE"
+	fieldPID                = "_PID"
+	fieldSyslogIdentifier   = "SYSLOG_IDENTIFIER"
+	fieldRealtimeTimestamp  = "__REALTIME_TIMESTAMP"
+	exportBinaryFieldLenLen = 8
+	maxExportBinaryFieldLen = 16 << 20
+)
+
+// ParseFormat parses s into a Format.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case FormatJSON, FormatExport:
+		return Format(s), nil
+	default:
+		return "", fmt.Errorf("unknown journalctl output format: %q", s)
+	}
+}
+
+func NewJournaldIngester(
+	format Format,
+	sshdProcessor sshd.SshdProcessor,
+	logger *zap.SugaredLogger,
+	h *health.Health,
+) JournaldIngester {
+	return JournaldIngester{
+		Format:        format,
+		SshdProcessor: sshdProcessor,
+		FlushInterval: DefaultFlushInterval,
+		Logger:        logger,
+		Health:        h,
+		getLastReadFn: common.GetLastReadCursor,
+		flushFn:       common.FlushLastReadCursor,
+		ensureDirFn:   common.EnsureFlushDirectory,
+		lastRead:      &atomic.Value{},
+	}
+}
+
+// JournaldIngester reads journal entries produced by journalctl and
+// passes sshd entries to an sshd.SshdProcessor.
+type JournaldIngester struct {
+	Format        Format
+	SshdProcessor sshd.SshdProcessor
+	FlushInterval time.Duration
+	Logger        *zap.SugaredLogger
+	Health        *health.Health
+
+	getLastReadFn func() (string, error)
+	flushFn       func(string) error
+	ensureDirFn   func() error
+
+	// lastRead is the __CURSOR (a string) of the
+	// last-processed journal entry.
+	lastRead *atomic.Value
+}
+
+// Ingest runs journalctl as a child process and processes its output
+// until the context is marked as done or journalctl exits.
+//
+// If a last-read cursor was previously saved, journalctl is asked
+// to start reading from the entry after it. Otherwise, only new
+// journal entries are read.
+func (j *JournaldIngester) Ingest(ctx context.Context) error {
+	lastRead := j.loadLastRead()
+
+	// journalctl must be killed if we stop reading its output
+	// before it exits. Otherwise, cmd.Wait would block forever.
+	cmdCtx, cancelCmdFn := context.WithCancel(ctx)
+	defer cancelCmdFn()
+
+	//nolint:gosec // The arguments are not user-controlled.
+	cmd := exec.CommandContext(cmdCtx, "journalctl", journalctlArgs(j.Format, lastRead)...)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get journalctl stdout pipe - %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start journalctl - %w", err)
+	}
+
+	j.Logger.Infof("started journalctl (pid: %d)", cmd.Process.Pid)
+
+	readErr := j.ingestReader(ctx, stdout, lastRead)
+
+	cancelCmdFn()
+	waitErr := cmd.Wait()
+
+	switch {
+	case readErr != nil:
+		return readErr
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case waitErr != nil:
+		return fmt.Errorf("journalctl exited unexpectedly - %w", waitErr)
+	default:
+		return errors.New("journalctl exited unexpectedly")
+	}
+}
+
+// IngestReader processes journalctl output read from r until the
+// context is marked as done or r returns an error. io.EOF results
+// in a nil error.
+//
+// Journal entries up to and including the entry of the saved
+// last-read cursor are skipped (refer to journalCursor.precedes).
+func (j *JournaldIngester) IngestReader(ctx context.Context, r io.Reader) error {
+	return j.ingestReader(ctx, r, j.loadLastRead())
+}
+
+func (j *JournaldIngester) ingestReader(ctx context.Context, r io.Reader, lastRead string) error {
+	j.lastRead.Store(lastRead)
+
+	var skipUntil *journalCursor
+	if lastRead != "" {
+		c := parseJournalCursor(lastRead)
+		skipUntil = &c
+	}
+
+	flushDone := make(chan struct{})
+	flushCtx, cancelFlushFn := context.WithCancel(ctx)
+	defer func() {
+		cancelFlushFn()
+		<-flushDone
+	}()
+
+	go func() {
+		j.flushLoop(flushCtx)
+		close(flushDone)
+	}()
+
+	j.Health.OnReady(JournaldIngesterComponentName)
+
+	var next func() (map[string]string, error)
+	switch j.Format {
+	case FormatExport:
+		next = newExportReader(r).next
+	case FormatJSON:
+		next = newJSONReader(r).next
+	default:
+		return fmt.Errorf("unsupported journalctl output format: %q", j.Format)
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		fields, err := next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to read journal entry - %w", err)
+		}
+
+		err = j.processEntry(ctx, fields, skipUntil)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (j *JournaldIngester) processEntry(ctx context.Context, fields map[string]string, skipUntil *journalCursor) error {
+	var loggedAt time.Time
+
+	ts, err := strconv.ParseUint(fields[fieldRealtimeTimestamp], 10, 64)
+	if err != nil {
+		j.Logger.Warnf("failed to parse journal entry %s (%q) - %s",
+			fieldRealtimeTimestamp, fields[fieldRealtimeTimestamp], err)
+	} else {
+		loggedAt = time.UnixMicro(int64(ts))
+	}
+
+	cursor := fields[fieldCursor]
+	if skipUntil != nil && !skipUntil.precedes(cursor, ts) {
+		return nil
+	}
+
+	if sshd.IsProcessName(fields[fieldSyslogIdentifier]) {
+		err = j.SshdProcessor.ProcessSshdLogEntry(ctx, sshd.SshdLogEntry{
+			PID:       fields[fieldPID],
+			Message:   fields[fieldMessage],
+			Process:   fields[fieldSyslogIdentifier],
+			Timestamp: loggedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if cursor != "" {
+		j.lastRead.Store(cursor)
+	}
+
+	return nil
+}
+
+// loadLastRead returns the saved last-read cursor. An empty string
+// is returned if no cursor was saved or if it cannot be read.
+func (j *JournaldIngester) loadLastRead() string {
+	lastRead, err := j.getLastReadFn()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			j.Logger.Warnf("failed to read last-read journal cursor, "+
+				"only new journal entries will be read - %s", err)
+		}
+
+		return ""
+	}
+
+	return lastRead
+}
+
+// flushLoop periodically saves the last-read cursor until
+// the context is marked as done. The cursor is saved one
+// last time before returning.
+func (j *JournaldIngester) flushLoop(ctx context.Context) {
+	err := j.ensureDirFn()
+	if err != nil {
+		j.Logger.Errorf("failed to ensure flush directory exists, "+
+			"last-read journal cursor will not be saved - %s", err)
+		return
+	}
+
+	ticker := time.NewTicker(j.FlushInterval)
+	defer ticker.Stop()
+
+	var flushed string
+	flush := func() {
+		lastRead, _ := j.lastRead.Load().(string)
+		if lastRead == "" || lastRead == flushed {
+			return
+		}
+
+		err := j.flushFn(lastRead)
+		if err != nil {
+			j.Logger.Errorf("failed to save last-read journal cursor - %s", err)
+			return
+		}
+
+		flushed = lastRead
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// journalctlArgs returns the journalctl arguments needed to follow
+// sshd journal entries in the specified output format.
+func journalctlArgs(format Format, lastRead string) []string {
+	args := []string{
+		"--follow",
+		"--no-pager",
+		"--output", string(format),
+	}
+
+	if lastRead != "" {
+		args = append(args, "--after-cursor", lastRead)
+	} else {
+		args = append(args, "--lines", "0")
+	}
+
+	for _, id := range sshd.ProcessNames {
+		args = append(args, fieldSyslogIdentifier+"="+id)
+	}
+
+	return args
+}
+
+// journalCursor is a journal entry's position, parsed from its
+// __CURSOR field. A cursor is a series of "key=value" pairs separated
+// by semicolons (e.g., "s=<seqnum ID>;i=<seqnum>;b=<boot ID>;
+// m=<monotonic time>;t=<realtime>;x=<hash>"). Its format is not
+// guaranteed to be stable, so any of these values may be missing.
+type journalCursor struct {
+	raw string
+
+	// seqnumID identifies the journal files whose entries are
+	// numbered by seqnum.
+	seqnumID string
+	seqnum   uint64
+	// hasSeqnum is true if seqnum was parsed.
+	hasSeqnum bool
+
+	// realtime is the entry's __REALTIME_TIMESTAMP,
+	// or zero if it could not be parsed.
+	realtime uint64
+}
+
+func parseJournalCursor(raw string) journalCursor {
+	c := journalCursor{raw: raw}
+
+	for _, kv := range strings.Split(raw, ";") {
+		key, value, _ := strings.Cut(kv, "=")
+
+		switch key {
+		case "s":
+			c.seqnumID = value
+		case "i":
+			seqnum, err := strconv.ParseUint(value, 16, 64)
+			if err == nil {
+				c.seqnum = seqnum
+				c.hasSeqnum = true
+			}
+		case "t":
+			realtime, err := strconv.ParseUint(value, 16, 64)
+			if err == nil {
+				c.realtime = realtime
+			}
+		}
+	}
+
+	return c
+}
+
+// precedes returns true if the journal entry with the specified cursor
+// and __REALTIME_TIMESTAMP comes after the entry of o, meaning that it
+// has not been read yet.
+//
+// Entries from the same journal files are ordered by their sequence
+// numbers. Otherwise, they are ordered by their realtime timestamps;
+// different entries that share o's timestamp are not skipped, as they
+// cannot be told apart from entries that were not read.
+func (o journalCursor) precedes(cursor string, realtime uint64) bool {
+	if cursor == o.raw {
+		return false
+	}
+
+	if cursor != "" {
+		c := parseJournalCursor(cursor)
+		if o.seqnumID != "" && c.seqnumID == o.seqnumID && o.hasSeqnum && c.hasSeqnum {
+			return c.seqnum > o.seqnum
+		}
+
+		if c.realtime > 0 {
+			realtime = c.realtime
+		}
+	}
+
+	if realtime == 0 || o.realtime == 0 {
+		return true
+	}
+
+	return realtime >= o.realtime
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	return &jsonReader{
+		decoder: json.NewDecoder(r),
+	}
+}
+
+// jsonReader reads journal entries in journalctl's "json" format.
+type jsonReader struct {
+	decoder *json.Decoder
+}
+
+func (o *jsonReader) next() (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	err := o.decoder.Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		fields[k] = jsonFieldValue(v)
+	}
+
+	return fields, nil
+}
+
+// jsonFieldValue converts a journalctl JSON field value to a string.
+//
+// journalctl encodes values as strings, as arrays of numbers if the
+// value is not valid UTF-8, or as null if the value is too large.
+// Refer to "man journalctl" for details.
+func jsonFieldValue(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+
+	var ints []int
+	if json.Unmarshal(raw, &ints) == nil {
+		b := make([]byte, len(ints))
+		for i := range ints {
+			b[i] = byte(ints[i])
+		}
+
+		return string(b)
+	}
+
+	return ""
+}
+
+func newExportReader(r io.Reader) *exportReader {
+	return &exportReader{
+		reader: bufio.NewReader(r),
+	}
+}
+
+// exportReader reads journal entries in journalctl's "export" format.
+//
+// Refer to the "Journal Export Format" documentation for details:
+// https://systemd.io/JOURNAL_EXPORT_FORMATS/
+type exportReader struct {
+	reader *bufio.Reader
+}
+
+func (o *exportReader) next() (map[string]string, error) {
+	fields := make(map[string]string)
+
+	for {
+		line, err := o.reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(fields) > 0 {
+				return fields, nil
+			}
+
+			return nil, err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(fields) == 0 {
+				continue
+			}
+
+			return fields, nil
+		}
+
+		name, value, isText := strings.Cut(line, "=")
+		if !isText {
+			// Binary-safe field: the field name is followed by a
+			// newline, a little-endian 64-bit size and the data.
+			value, err = o.readBinaryValue()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read binary value for field %q - %w", name, err)
+			}
+		}
+
+		fields[name] = value
+	}
+}
+
+func (o *exportReader) readBinaryValue() (string, error) {
+	var sizeBuf [exportBinaryFieldLenLen]byte
+	_, err := io.ReadFull(o.reader, sizeBuf[:])
+	if err != nil {
+		return "", err
+	}
+
+	size := binary.LittleEndian.Uint64(sizeBuf[:])
+	if size > maxExportBinaryFieldLen {
+		return "", fmt.Errorf("binary value size %d exceeds maximum of %d bytes",
+			size, maxExportBinaryFieldLen)
+	}
+
+	value := make([]byte, size+1)
+	_, err = io.ReadFull(o.reader, value)
+	if err != nil {
+		return "", err
+	}
+
+	if value[size] != '\n' {
+		return "", errors.New("binary value is not terminated by a newline")
+	}
+
+	return string(value[:size]), nil
+}
@@ -0,0 +1,368 @@
+package journald
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+)
+
+//nolint:lll // These are test cases.
+const testJSONEntries = `{"__CURSOR":"s=abc;i=1;t=5eb8e4a00c0c1","__REALTIME_TIMESTAMP":"1666371243000001","_PID":"100","SYSLOG_IDENTIFIER":"sshd","MESSAGE":"Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo"}
+{"__CURSOR":"s=abc;i=2;t=5eb8e4a00c0c2","__REALTIME_TIMESTAMP":"1666371243000002","_PID":"1","SYSLOG_IDENTIFIER":"systemd","MESSAGE":"Started Session 1 of User core."}
+{"__CURSOR":"s=abc;i=3;t=5eb8e4a00c0c3","__REALTIME_TIMESTAMP":"1666371243000003","_PID":"101","SYSLOG_IDENTIFIER":"sshd","MESSAGE":[73,110,118,97,108,105,100,32,117,115,101,114]}
+{"__CURSOR":"s=abc;i=4;t=5eb8e4a00c0c4","__REALTIME_TIMESTAMP":"1666371243000004","_PID":"102","SYSLOG_IDENTIFIER":"sshd","MESSAGE":null}
+`
+
+// testSplitProcessJSONEntries are logged by OpenSSH 9.8+, which logs
+// connections from the sshd listener, its sshd-session processes and
+// their sshd-auth processes.
+//
+//nolint:lll // These are test cases.
+const testSplitProcessJSONEntries = `{"__CURSOR":"s=def;i=1;t=6256d03762001","__REALTIME_TIMESTAMP":"1730000000000001","_PID":"900","SYSLOG_IDENTIFIER":"sshd","MESSAGE":"Server listening on 0.0.0.0 port 22."}
+{"__CURSOR":"s=def;i=2;t=6256d03762002","__REALTIME_TIMESTAMP":"1730000000000002","_PID":"1201","SYSLOG_IDENTIFIER":"sshd-session","MESSAGE":"Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo"}
+{"__CURSOR":"s=def;i=3;t=6256d03762003","__REALTIME_TIMESTAMP":"1730000000000003","_PID":"1201","SYSLOG_IDENTIFIER":"sshd-session","MESSAGE":"pam_unix(sshd:session): session opened for user core(uid=1000) by core(uid=0)"}
+{"__CURSOR":"s=def;i=4;t=6256d03762004","__REALTIME_TIMESTAMP":"1730000000000004","_PID":"1","SYSLOG_IDENTIFIER":"systemd","MESSAGE":"Started Session 1 of User core."}
+{"__CURSOR":"s=def;i=5;t=6256d03762005","__REALTIME_TIMESTAMP":"1730000000000005","_PID":"1301","SYSLOG_IDENTIFIER":"sshd-session","MESSAGE":"Connection closed by authenticating user core 127.0.0.1 port 667 [preauth]"}
+{"__CURSOR":"s=def;i=6;t=6256d03762006","__REALTIME_TIMESTAMP":"1730000000000006","_PID":"1402","SYSLOG_IDENTIFIER":"sshd-auth","MESSAGE":"Unable to negotiate with 127.0.0.1 port 668: no matching key exchange method found."}
+`
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	f, err := ParseFormat("json")
+	require.NoError(t, err)
+	assert.Equal(t, FormatJSON, f)
+
+	f, err = ParseFormat("export")
+	require.NoError(t, err)
+	assert.Equal(t, FormatExport, f)
+
+	_, err = ParseFormat("short")
+	assert.Error(t, err)
+}
+
+func TestJournalctlArgs(t *testing.T) {
+	t.Parallel()
+
+	args := journalctlArgs(FormatJSON, "")
+	assert.Equal(t, []string{
+		"--follow", "--no-pager", "--output", "json", "--lines", "0",
+		"SYSLOG_IDENTIFIER=sshd", "SYSLOG_IDENTIFIER=sshd-session", "SYSLOG_IDENTIFIER=sshd-auth",
+	}, args)
+
+	args = journalctlArgs(FormatExport, "s=abc;i=1;t=5eb8e4a00c0c1")
+	assert.Equal(t, []string{
+		"--follow", "--no-pager", "--output", "export", "--after-cursor", "s=abc;i=1;t=5eb8e4a00c0c1",
+		"SYSLOG_IDENTIFIER=sshd", "SYSLOG_IDENTIFIER=sshd-session", "SYSLOG_IDENTIFIER=sshd-auth",
+	}, args)
+}
+
+func TestJournaldIngester_IngestReader_JSON(t *testing.T) {
+	t.Parallel()
+
+	ji, p, flushed := newTestJournaldIngester(t, FormatJSON, "")
+
+	err := ji.IngestReader(context.Background(), strings.NewReader(testJSONEntries))
+	require.NoError(t, err)
+
+	assert.Equal(t, []sshd.SshdLogEntry{
+		{
+			PID:       "100",
+			Message:   "Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo",
+			Process:   sshd.ProcessSshd,
+			Timestamp: time.UnixMicro(1666371243000001),
+		},
+		{
+			PID:       "101",
+			Message:   "Invalid user",
+			Process:   sshd.ProcessSshd,
+			Timestamp: time.UnixMicro(1666371243000003),
+		},
+		{
+			PID:       "102",
+			Message:   "",
+			Process:   sshd.ProcessSshd,
+			Timestamp: time.UnixMicro(1666371243000004),
+		},
+	}, p.entries)
+
+	assert.Equal(t, "s=abc;i=4;t=5eb8e4a00c0c4", flushed.last())
+}
+
+func TestJournaldIngester_IngestReader_SplitProcesses(t *testing.T) {
+	t.Parallel()
+
+	ji, p, flushed := newTestJournaldIngester(t, FormatJSON, "")
+
+	err := ji.IngestReader(context.Background(), strings.NewReader(testSplitProcessJSONEntries))
+	require.NoError(t, err)
+
+	assert.Equal(t, []sshd.SshdLogEntry{
+		{
+			PID:       "900",
+			Message:   "Server listening on 0.0.0.0 port 22.",
+			Process:   sshd.ProcessSshd,
+			Timestamp: time.UnixMicro(1730000000000001),
+		},
+		{
+			PID:       "1201",
+			Message:   "Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo",
+			Process:   sshd.ProcessSshdSession,
+			Timestamp: time.UnixMicro(1730000000000002),
+		},
+		{
+			PID:       "1201",
+			Message:   "pam_unix(sshd:session): session opened for user core(uid=1000) by core(uid=0)",
+			Process:   sshd.ProcessSshdSession,
+			Timestamp: time.UnixMicro(1730000000000003),
+		},
+		{
+			PID:       "1301",
+			Message:   "Connection closed by authenticating user core 127.0.0.1 port 667 [preauth]",
+			Process:   sshd.ProcessSshdSession,
+			Timestamp: time.UnixMicro(1730000000000005),
+		},
+		{
+			PID:       "1402",
+			Message:   "Unable to negotiate with 127.0.0.1 port 668: no matching key exchange method found.",
+			Process:   sshd.ProcessSshdAuth,
+			Timestamp: time.UnixMicro(1730000000000006),
+		},
+	}, p.entries)
+
+	assert.Equal(t, "s=def;i=6;t=6256d03762006", flushed.last())
+}
+
+func TestJournaldIngester_IngestReader_SkipsAlreadyRead(t *testing.T) {
+	t.Parallel()
+
+	ji, p, flushed := newTestJournaldIngester(t, FormatJSON, "s=abc;i=2;t=5eb8e4a00c0c2")
+
+	err := ji.IngestReader(context.Background(), strings.NewReader(testJSONEntries))
+	require.NoError(t, err)
+
+	require.Len(t, p.entries, 2)
+	assert.Equal(t, "101", p.entries[0].PID)
+	assert.Equal(t, "102", p.entries[1].PID)
+	assert.Equal(t, "s=abc;i=4;t=5eb8e4a00c0c4", flushed.last())
+}
+
+func TestJournaldIngester_IngestReader_SkipsAlreadyReadSameTimestamp(t *testing.T) {
+	t.Parallel()
+
+	// The entries share a timestamp. Only the ones up to
+	// and including the saved cursor were read.
+	//
+	//nolint:lll // These are test cases.
+	entries := `{"__CURSOR":"s=abc;i=1;t=5eb8e4a00c0c1","__REALTIME_TIMESTAMP":"1666371243000001","_PID":"100","SYSLOG_IDENTIFIER":"sshd","MESSAGE":"a"}
+{"__CURSOR":"s=abc;i=2;t=5eb8e4a00c0c1","__REALTIME_TIMESTAMP":"1666371243000001","_PID":"101","SYSLOG_IDENTIFIER":"sshd","MESSAGE":"b"}
+{"__CURSOR":"s=abc;i=3;t=5eb8e4a00c0c1","__REALTIME_TIMESTAMP":"1666371243000001","_PID":"102","SYSLOG_IDENTIFIER":"sshd","MESSAGE":"c"}
+`
+
+	ji, p, flushed := newTestJournaldIngester(t, FormatJSON, "s=abc;i=2;t=5eb8e4a00c0c1")
+
+	err := ji.IngestReader(context.Background(), strings.NewReader(entries))
+	require.NoError(t, err)
+
+	require.Len(t, p.entries, 1)
+	assert.Equal(t, "102", p.entries[0].PID)
+	assert.Equal(t, "s=abc;i=3;t=5eb8e4a00c0c1", flushed.last())
+}
+
+func TestJournalCursor_Precedes(t *testing.T) {
+	t.Parallel()
+
+	saved := parseJournalCursor("s=abc;i=a;b=boot;m=1;t=5eb8e4a00c0c2;x=hash")
+	assert.Equal(t, "abc", saved.seqnumID)
+	assert.Equal(t, uint64(10), saved.seqnum)
+	assert.Equal(t, uint64(1666371243000002), saved.realtime)
+
+	// Same entry.
+	assert.False(t, saved.precedes("s=abc;i=a;b=boot;m=1;t=5eb8e4a00c0c2;x=hash", 1666371243000002))
+
+	// Same journal files: ordered by seqnum regardless of timestamps.
+	assert.False(t, saved.precedes("s=abc;i=9;t=5eb8e4a00c0c3", 0))
+	assert.True(t, saved.precedes("s=abc;i=b;t=5eb8e4a00c0c1", 0))
+
+	// Other journal files: ordered by timestamp.
+	assert.False(t, saved.precedes("s=def;i=b;t=5eb8e4a00c0c1", 0))
+	assert.True(t, saved.precedes("s=def;i=1;t=5eb8e4a00c0c2", 0))
+	assert.True(t, saved.precedes("s=def;i=1;t=5eb8e4a00c0c3", 0))
+
+	// No cursor: ordered by the __REALTIME_TIMESTAMP field.
+	assert.False(t, saved.precedes("", 1666371243000001))
+	assert.True(t, saved.precedes("", 1666371243000003))
+	assert.True(t, saved.precedes("", 0))
+}
+
+func TestJournaldIngester_IngestReader_Export(t *testing.T) {
+	t.Parallel()
+
+	binaryMsg := "Accepted password for core\nfrom 127.0.0.1 port 666 ssh2"
+	binarySize := make([]byte, exportBinaryFieldLenLen)
+	binary.LittleEndian.PutUint64(binarySize, uint64(len(binaryMsg)))
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("__CURSOR=s=abc;i=1\n" +
+		"__REALTIME_TIMESTAMP=1666371243000001\n" +
+		"_PID=200\n" +
+		"SYSLOG_IDENTIFIER=sshd\n" +
+		"MESSAGE=Invalid user cow from 47.8.6.9 port 64433\n" +
+		"\n" +
+		"__CURSOR=s=abc;i=2\n" +
+		"__REALTIME_TIMESTAMP=1666371243000002\n" +
+		"_PID=201\n" +
+		"SYSLOG_IDENTIFIER=sshd-session\n" +
+		"MESSAGE\n")
+	buf.Write(binarySize)
+	buf.WriteString(binaryMsg + "\n" +
+		"\n" +
+		"__CURSOR=s=abc;i=3\n" +
+		"__REALTIME_TIMESTAMP=1666371243000003\n" +
+		"_PID=1\n" +
+		"SYSLOG_IDENTIFIER=systemd\n" +
+		"MESSAGE=Reached target Multi-User System.\n")
+
+	ji, p, flushed := newTestJournaldIngester(t, FormatExport, "")
+
+	err := ji.IngestReader(context.Background(), buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, []sshd.SshdLogEntry{
+		{
+			PID:       "200",
+			Message:   "Invalid user cow from 47.8.6.9 port 64433",
+			Process:   sshd.ProcessSshd,
+			Timestamp: time.UnixMicro(1666371243000001),
+		},
+		{
+			PID:       "201",
+			Message:   binaryMsg,
+			Process:   sshd.ProcessSshdSession,
+			Timestamp: time.UnixMicro(1666371243000002),
+		},
+	}, p.entries)
+
+	assert.Equal(t, "s=abc;i=3", flushed.last())
+}
+
+func TestJournaldIngester_IngestReader_ExportBadBinaryValue(t *testing.T) {
+	t.Parallel()
+
+	binarySize := make([]byte, exportBinaryFieldLenLen)
+	binary.LittleEndian.PutUint64(binarySize, 3)
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("MESSAGE\n")
+	buf.Write(binarySize)
+	buf.WriteString("abcd")
+
+	ji, _, _ := newTestJournaldIngester(t, FormatExport, "")
+
+	err := ji.IngestReader(context.Background(), buf)
+	assert.Error(t, err)
+}
+
+func TestJournaldIngester_IngestReader_ProcessorErr(t *testing.T) {
+	t.Parallel()
+
+	ji, p, _ := newTestJournaldIngester(t, FormatJSON, "")
+
+	expErr := errors.New("processor failure")
+	p.err = expErr
+
+	err := ji.IngestReader(context.Background(), strings.NewReader(testJSONEntries))
+	assert.ErrorIs(t, err, expErr)
+}
+
+func TestJournaldIngester_IngestReader_BadJSON(t *testing.T) {
+	t.Parallel()
+
+	ji, _, _ := newTestJournaldIngester(t, FormatJSON, "")
+
+	err := ji.IngestReader(context.Background(), strings.NewReader("{not json\n"))
+	assert.Error(t, err)
+}
+
+func TestJournaldIngester_LoadLastRead_NoFlushFile(t *testing.T) {
+	t.Parallel()
+
+	ji, _, _ := newTestJournaldIngester(t, FormatJSON, "")
+	ji.getLastReadFn = func() (string, error) {
+		return "", os.ErrNotExist
+	}
+
+	assert.Equal(t, "", ji.loadLastRead())
+}
+
+func newTestJournaldIngester(t *testing.T, format Format, lastRead string) (*JournaldIngester, *testSshdProcessor, *testFlusher) {
+	t.Helper()
+
+	p := &testSshdProcessor{}
+	flushed := &testFlusher{}
+
+	ji := NewJournaldIngester(
+		format,
+		p,
+		zap.NewNop().Sugar(),
+		health.NewSingleReadinessHealth(JournaldIngesterComponentName))
+	ji.FlushInterval = time.Hour
+	ji.getLastReadFn = func() (string, error) {
+		return lastRead, nil
+	}
+	ji.flushFn = flushed.flush
+	ji.ensureDirFn = func() error {
+		return nil
+	}
+
+	return &ji, p, flushed
+}
+
+type testSshdProcessor struct {
+	entries []sshd.SshdLogEntry
+	err     error
+}
+
+func (o *testSshdProcessor) ProcessSshdLogEntry(_ context.Context, sm sshd.SshdLogEntry) error {
+	if o.err != nil {
+		return o.err
+	}
+
+	o.entries = append(o.entries, sm)
+
+	return nil
+}
+
+type testFlusher struct {
+	mu     sync.Mutex
+	cursor string
+}
+
+func (o *testFlusher) flush(cursor string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.cursor = cursor
+
+	return nil
+}
+
+func (o *testFlusher) last() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.cursor
+}
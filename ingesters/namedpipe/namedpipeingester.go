@@ -3,11 +3,17 @@ package namedpipe
 import (
 	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"go.uber.org/zap"
 
 	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 )
 
 const (
@@ -18,64 +24,222 @@ const (
 
 func NewNamedPipeIngester(logger *zap.SugaredLogger, h *health.Health) NamedPipeIngester {
 	return NamedPipeIngester{
-		Logger: logger,
-		Health: h,
+		ComponentName: NamedPipeProcessorComponentName,
+		Logger:        logger,
+		Health:        h,
+		newBackOffFn:  newReopenBackOff,
 	}
 }
 
+// NamedPipeIngester reads from a named pipe. The named pipe is reopened
+// when its writer disconnects (e.g., when rsyslog restarts).
 type NamedPipeIngester struct {
-	Logger *zap.SugaredLogger
-	Health *health.Health
+	// ComponentName is the name of the component in the health check.
+	// The component is marked as not ready while the named pipe's
+	// writer is disconnected.
+	ComponentName string
+	Logger        *zap.SugaredLogger
+	Health        *health.Health
+
+	// Metrics, if non-nil, counts the number of times
+	// the named pipe is reopened.
+	Metrics *metrics.PrometheusMetricsProvider
+
+	newBackOffFn func() backoff.BackOff
 }
 
 type Callback func(context.Context, string) error
 
+// newReopenBackOff returns the backoff.BackOff used to delay reopening
+// a named pipe. It never stops because the named pipe's writer may be
+// restarted at any time.
+func newReopenBackOff() backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxElapsedTime = 0
+
+	return bo
+}
+
+// Ingest reads delim-terminated strings from the named pipe at filePath
+// and passes them to callback until the context is marked as done or
+// callback returns an error. The named pipe is reopened each time its
+// writer disconnects.
 func (n *NamedPipeIngester) Ingest(
 	ctx context.Context,
 	filePath string,
 	delim byte,
 	callback Callback,
 ) error {
+	openBackOff := backoff.WithContext(n.newBackOffFn(), ctx)
+	reopenBackOff := backoff.WithContext(n.newBackOffFn(), ctx)
+
+	// Waiting for the writer to open the named pipe for the
+	// first time is expected (e.g., while rsyslog starts).
+	// As a result, the component is ready at this point.
+	n.Health.OnReady(n.ComponentName)
+
+	for {
+		file, err := n.open(ctx, filePath, openBackOff)
+		if err != nil {
+			return err
+		}
+
+		n.Health.OnReady(n.ComponentName)
+
+		numRead, err := n.read(ctx, file, delim, callback)
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		n.Health.OnNotReady(n.ComponentName)
+
+		n.Logger.Warnf("writer disconnected from %s, reopening...", filePath)
+
+		if n.Metrics != nil {
+			n.Metrics.IncNamedPipeReconnects(filePath)
+		}
+
+		// Avoid spinning if the writer repeatedly
+		// connects and disconnects without writing.
+		if numRead > 0 {
+			reopenBackOff.Reset()
+		}
+
+		err = sleepContext(ctx, reopenBackOff.NextBackOff())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// open opens the named pipe for reading, retrying according to bo
+// if an error occurs. Opening a named pipe blocks until a writer
+// opens it.
+func (n *NamedPipeIngester) open(ctx context.Context, filePath string, bo backoff.BackOff) (*os.File, error) {
 	var file *os.File
-	var err error
-	ready := make(chan struct{})
 
-	n.Health.OnReady(NamedPipeProcessorComponentName)
+	err := backoff.RetryNotify(
+		func() error {
+			var err error
+			file, err = openContext(ctx, filePath)
+			if err != nil && ctx.Err() != nil {
+				// Force back-off retry to exit.
+				// See backoff.Retry for details.
+				return backoff.Permanent(ctx.Err())
+			}
+
+			return err
+		},
+		bo,
+		func(err error, next time.Duration) {
+			n.Logger.Warnf("failed to open %s, retrying in %s - %s", filePath, next, err)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	n.Logger.Infof("Successfully opened %s", filePath)
+
+	return file, nil
+}
+
+// openContext opens the named pipe at filePath for reading
+// until a writer opens it or the context is marked as done.
+func openContext(ctx context.Context, filePath string) (*os.File, error) {
+	type openResult struct {
+		file *os.File
+		err  error
+	}
+
+	ready := make(chan openResult, 1)
+
 	// os.OpenFile blocks. Put in go routine so we can gracefully exit.
 	go func() {
-		file, err = os.OpenFile(filePath, os.O_RDONLY, os.ModeNamedPipe)
-		close(ready)
+		file, err := os.OpenFile(filePath, os.O_RDONLY, os.ModeNamedPipe)
+		ready <- openResult{file: file, err: err}
 	}()
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
-	case <-ready:
-	}
+		go func() {
+			// Close the file if the writer shows up later.
+			if result := <-ready; result.file != nil {
+				_ = result.file.Close()
+			}
+		}()
 
-	if err != nil {
-		return err
+		return nil, ctx.Err()
+	case result := <-ready:
+		return result.file, result.err
 	}
+}
+
+// read reads delim-terminated strings from file and passes them to
+// callback. It returns the number of strings read and io.EOF when the
+// writer disconnects. file is closed when read returns.
+func (n *NamedPipeIngester) read(
+	ctx context.Context,
+	file *os.File,
+	delim byte,
+	callback Callback,
+) (int, error) {
+	readCtx, cancelFn := context.WithCancel(ctx)
+	defer cancelFn()
 
 	go func() {
-		<-ctx.Done()
+		<-readCtx.Done()
 		file.Close()
 	}()
 
-	n.Logger.Infof("Successfully opened %s", filePath)
-	defer file.Close()
-
 	r := bufio.NewReader(file)
+	numRead := 0
 
 	for {
 		line, err := r.ReadString(delim)
 		if err != nil {
-			n.Logger.Errorf("error reading from ", file.Name())
-			return err
+			if ctx.Err() != nil {
+				return numRead, ctx.Err()
+			}
+
+			if errors.Is(err, io.EOF) {
+				if line != "" {
+					n.Logger.Warnf("discarding partial line from %s", file.Name())
+				}
+
+				return numRead, io.EOF
+			}
+
+			n.Logger.Errorf("error reading from %s - %s", file.Name(), err)
+
+			return numRead, fmt.Errorf("failed to read from %s - %w", file.Name(), err)
 		}
+
+		numRead++
+
 		err = callback(ctx, line)
 		if err != nil {
-			return err
+			return numRead, err
 		}
 	}
 }
+
+// sleepContext sleeps for d or until the context is marked as done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d == backoff.Stop {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return errors.New("named pipe reopen backoff stopped")
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/metal-toolbox/audito-maldito/ingesters/namedpipe"
 	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
 )
 
 func TestIngest(t *testing.T) {
@@ -58,3 +63,73 @@ func TestIngest(t *testing.T) {
 	<-done
 	assert.Equal(t, 5, callCount)
 }
+
+func TestIngest_ReopensAfterWriterDisconnects(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	pipePath := filepath.Join(t.TempDir(), "named-pipe")
+	err := syscall.Mkfifo(pipePath, 0o664)
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	h := health.NewSingleReadinessHealth("test-pipe")
+	np := namedpipe.NewNamedPipeIngester(zap.NewNop().Sugar(), h)
+	np.ComponentName = "test-pipe"
+	np.Metrics = metrics.NewPrometheusMetricsProviderForRegisterer(registry)
+
+	lines := make(chan string)
+	ingestErr := make(chan error, 1)
+	go func() {
+		ingestErr <- np.Ingest(ctx, pipePath, '\n', func(ctx context.Context, line string) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case lines <- line:
+				return nil
+			}
+		})
+	}()
+
+	writeAndClose := func(line string) {
+		file, err := os.OpenFile(pipePath, os.O_WRONLY, os.ModeNamedPipe)
+		require.NoError(t, err)
+
+		_, err = file.WriteString(line)
+		require.NoError(t, err)
+
+		require.Equal(t, line, <-lines)
+		require.NoError(t, file.Close())
+	}
+
+	writeAndClose("first writer\n")
+
+	assert.Eventually(t, func() bool {
+		return !h.IsReady()
+	}, 5*time.Second, 10*time.Millisecond, "component should not be ready while disconnected")
+
+	writeAndClose("second writer\n")
+
+	assert.True(t, h.IsReady(), "component should be ready after reconnecting")
+
+	assert.Eventually(t, func() bool {
+		return !h.IsReady()
+	}, 5*time.Second, 10*time.Millisecond, "component should not be ready after second disconnect")
+
+	cancelFn()
+	assert.ErrorIs(t, <-ingestErr, context.Canceled)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	var reconnects float64
+	for _, family := range families {
+		if family.GetName() == "audito_maldito_named_pipe_reconnects_total" {
+			reconnects = family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+
+	assert.Equal(t, float64(2), reconnects)
+}
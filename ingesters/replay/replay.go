@@ -0,0 +1,315 @@
+// replay package reads previously-captured sshd and Linux audit logs
+// from files. The logs are merged into a single stream ordered by
+// their original timestamps, which allows the sshd and auditd
+// processors to produce the same events they would have produced
+// had they processed the logs as they were written.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/ingesters/syslog"
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+)
+
+// auditMsgPrefix precedes the timestamp and serial number
+// of an audit log line (e.g., "msg=audit(1364481363.243:24287):").
+const auditMsgPrefix = "msg=audit("
+
+func NewReplayer(
+	sshdLogPaths []string,
+	auditLogPaths []string,
+	sshdProcessor sshd.SshdProcessor,
+	audits chan<- string,
+	logger *zap.SugaredLogger,
+) Replayer {
+	return Replayer{
+		SshdLogPaths:  sshdLogPaths,
+		AuditLogPaths: auditLogPaths,
+		SshdProcessor: sshdProcessor,
+		Audits:        audits,
+		Logger:        logger,
+	}
+}
+
+// Replayer reads sshd and audit logs from files. sshd log entries are
+// passed to an sshd.SshdProcessor and audit log lines are written to
+// the Audits channel, which is meant to be consumed by auditd.Auditd.
+//
+// Each list of files is read in order. Rotated log files should
+// therefore be specified from oldest to newest.
+type Replayer struct {
+	// SshdLogPaths are syslog files containing sshd log lines
+	// (e.g., /var/log/auth.log). Lines produced by programs
+	// other than sshd are ignored.
+	SshdLogPaths []string
+
+	// AuditLogPaths are Linux audit log files
+	// (e.g., /var/log/audit/audit.log).
+	AuditLogPaths []string
+
+	// Since and Until filter sshd log entries that occurred outside
+	// of a period of time. A zero time.Time means no entries are
+	// filtered. Note that audit events are filtered by auditd.Auditd.
+	Since time.Time
+	Until time.Time
+
+	SshdProcessor sshd.SshdProcessor
+	Audits        chan<- string
+	Logger        *zap.SugaredLogger
+}
+
+// Replay reads the log files until they have been fully read, an error
+// occurs or the context is marked as done. The Audits channel is closed
+// when Replay returns.
+func (o *Replayer) Replay(ctx context.Context) error {
+	defer close(o.Audits)
+
+	sshdLogs := &sshdLogSource{files: &multiFileReader{paths: o.SshdLogPaths}}
+	defer sshdLogs.files.close()
+
+	auditLogs := &auditLogSource{files: &multiFileReader{paths: o.AuditLogPaths}}
+	defer auditLogs.files.close()
+
+	entry, sshdErr := sshdLogs.next()
+	line, lineTS, auditErr := auditLogs.next()
+
+	for {
+		sshdDone := errors.Is(sshdErr, io.EOF)
+		if sshdErr != nil && !sshdDone {
+			return sshdErr
+		}
+
+		auditDone := errors.Is(auditErr, io.EOF)
+		if auditErr != nil && !auditDone {
+			return auditErr
+		}
+
+		if sshdDone && auditDone {
+			o.Logger.Infoln("finished reading log files")
+			return nil
+		}
+
+		// sshd log entries are processed first when timestamps
+		// are equal because a sshd login normally precedes the
+		// audit session that it starts.
+		if !sshdDone && (auditDone || !entry.Timestamp.After(lineTS)) {
+			if o.isInWindow(entry.Timestamp) {
+				err := o.SshdProcessor.ProcessSshdLogEntry(ctx, entry)
+				if err != nil {
+					return fmt.Errorf("failed to process sshd log entry - %w", err)
+				}
+			}
+
+			entry, sshdErr = sshdLogs.next()
+
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case o.Audits <- line:
+		}
+
+		line, lineTS, auditErr = auditLogs.next()
+	}
+}
+
+func (o *Replayer) isInWindow(t time.Time) bool {
+	if t.Before(o.Since) {
+		return false
+	}
+
+	return o.Until.IsZero() || !t.After(o.Until)
+}
+
+// sshdLogSource reads sshd log entries from syslog files.
+type sshdLogSource struct {
+	files *multiFileReader
+
+	// last is the timestamp of the last entry. It is used
+	// for entries whose timestamp could not be parsed.
+	last time.Time
+}
+
+// next returns the next sshd log entry. It returns io.EOF
+// when all of the files have been read.
+func (o *sshdLogSource) next() (sshd.SshdLogEntry, error) {
+	for {
+		line, err := o.files.next()
+		if err != nil {
+			return sshd.SshdLogEntry{}, err
+		}
+
+		// Syslog files do not specify the year, so the file's
+		// modification time is the best reference we have.
+		msg := syslog.ParseLogFileLine(line, o.files.modTime)
+		if !sshd.IsProcessName(msg.AppName) {
+			continue
+		}
+
+		if msg.Timestamp.IsZero() {
+			msg.Timestamp = o.last
+		} else {
+			o.last = msg.Timestamp
+		}
+
+		return sshd.SshdLogEntry{
+			Message:   msg.Msg,
+			PID:       msg.ProcID,
+			Process:   msg.AppName,
+			Timestamp: msg.Timestamp,
+		}, nil
+	}
+}
+
+// auditLogSource reads lines from Linux audit log files.
+type auditLogSource struct {
+	files *multiFileReader
+
+	// last is the timestamp of the last line. It is used
+	// for lines whose timestamp could not be parsed.
+	last time.Time
+}
+
+// next returns the next audit log line and its timestamp.
+// It returns io.EOF when all of the files have been read.
+func (o *auditLogSource) next() (string, time.Time, error) {
+	for {
+		line, err := o.files.next()
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		if line == "" {
+			continue
+		}
+
+		ts, err := auditLineTimestamp(line)
+		if err == nil {
+			o.last = ts
+		}
+
+		return line, o.last, nil
+	}
+}
+
+// auditLineTimestamp parses the timestamp of a Linux audit log line.
+// For example, the timestamp of the following line is 1364481363.243:
+//
+//	type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e ...
+func auditLineTimestamp(line string) (time.Time, error) {
+	start := strings.Index(line, auditMsgPrefix)
+	if start == -1 {
+		return time.Time{}, fmt.Errorf("line does not contain %q", auditMsgPrefix)
+	}
+
+	timestamp, _, found := strings.Cut(line[start+len(auditMsgPrefix):], ":")
+	if !found {
+		return time.Time{}, errors.New("audit message timestamp is missing serial number")
+	}
+
+	secStr, fracStr, _ := strings.Cut(timestamp, ".")
+
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse audit message seconds - %w", err)
+	}
+
+	var nsec int64
+	if fracStr != "" {
+		const nanoDigits = 9
+		if len(fracStr) > nanoDigits {
+			fracStr = fracStr[:nanoDigits]
+		}
+
+		nsec, err = strconv.ParseInt(fracStr+strings.Repeat("0", nanoDigits-len(fracStr)), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse audit message fractional seconds - %w", err)
+		}
+	}
+
+	return time.Unix(sec, nsec), nil
+}
+
+// multiFileReader reads lines from a list of files in order.
+type multiFileReader struct {
+	paths []string
+
+	file   *os.File
+	reader *bufio.Reader
+
+	// modTime is the modification time of the current file.
+	modTime time.Time
+}
+
+// next returns the next line without its line ending. It returns
+// io.EOF when all of the files have been read.
+func (o *multiFileReader) next() (string, error) {
+	for {
+		if o.reader == nil {
+			if len(o.paths) == 0 {
+				return "", io.EOF
+			}
+
+			err := o.open(o.paths[0])
+			if err != nil {
+				return "", err
+			}
+
+			o.paths = o.paths[1:]
+		}
+
+		line, err := o.reader.ReadString('\n')
+		if err == nil || (errors.Is(err, io.EOF) && line != "") {
+			// A final line lacking a line ending is returned
+			// as-is. The following call returns io.EOF.
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("failed to read from %q - %w", o.file.Name(), err)
+		}
+
+		o.close()
+	}
+}
+
+func (o *multiFileReader) open(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open log file - %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat log file %q - %w", filePath, err)
+	}
+
+	o.file = f
+	o.reader = bufio.NewReader(f)
+	o.modTime = info.ModTime()
+
+	return nil
+}
+
+func (o *multiFileReader) close() {
+	if o.file != nil {
+		_ = o.file.Close()
+	}
+
+	o.file = nil
+	o.reader = nil
+}
@@ -0,0 +1,235 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+)
+
+const testSshdLog = `Jan  5 12:00:01 myhost sshd[100]: Accepted publickey for core from 127.0.0.1 port 666 ssh2
+Jan  5 12:00:02 myhost systemd[1]: Started Session 1 of User core.
+Jan  5 12:00:04 myhost sshd-session[200]: Accepted password for core from 127.0.0.1 port 667 ssh2`
+
+func TestReplayer_Replay(t *testing.T) {
+	t.Parallel()
+
+	r, p, audits := newTestReplayer(t)
+
+	err := r.Replay(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []testSshdCall{
+		{
+			entry: sshd.SshdLogEntry{
+				Message:   "Accepted publickey for core from 127.0.0.1 port 666 ssh2",
+				PID:       "100",
+				Process:   sshd.ProcessSshd,
+				Timestamp: time.Date(2023, 1, 5, 12, 0, 1, 0, time.Local),
+			},
+			numAuditsBefore: 0,
+		},
+		{
+			entry: sshd.SshdLogEntry{
+				Message:   "Accepted password for core from 127.0.0.1 port 667 ssh2",
+				PID:       "200",
+				Process:   sshd.ProcessSshdSession,
+				Timestamp: time.Date(2023, 1, 5, 12, 0, 4, 0, time.Local),
+			},
+			numAuditsBefore: 3,
+		},
+	}, p.calls)
+
+	var lines []string
+	for line := range audits {
+		lines = append(lines, line)
+	}
+
+	assert.Equal(t, []string{
+		testAuditLine(time.Date(2023, 1, 5, 12, 0, 1, 5e8, time.Local), 1, "LOGIN"),
+		testAuditLine(time.Date(2023, 1, 5, 12, 0, 3, 0, time.Local), 2, "SYSCALL"),
+		testAuditLine(time.Date(2023, 1, 5, 12, 0, 3, 0, time.Local), 2, "EOE"),
+		testAuditLine(time.Date(2023, 1, 5, 12, 0, 5, 0, time.Local), 3, "SYSCALL"),
+	}, lines)
+}
+
+func TestReplayer_Replay_SinceUntil(t *testing.T) {
+	t.Parallel()
+
+	r, p, _ := newTestReplayer(t)
+	r.Since = time.Date(2023, 1, 5, 12, 0, 2, 0, time.Local)
+	r.Until = time.Date(2023, 1, 5, 12, 0, 3, 0, time.Local)
+
+	err := r.Replay(context.Background())
+	require.NoError(t, err)
+
+	assert.Empty(t, p.calls)
+
+	r, p, _ = newTestReplayer(t)
+	r.Since = time.Date(2023, 1, 5, 12, 0, 2, 0, time.Local)
+
+	err = r.Replay(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, p.calls, 1)
+	assert.Equal(t, "200", p.calls[0].entry.PID)
+}
+
+func TestReplayer_Replay_MissingFile(t *testing.T) {
+	t.Parallel()
+
+	audits := make(chan string)
+	r := NewReplayer(
+		nil,
+		[]string{filepath.Join(t.TempDir(), "audit.log")},
+		&testSshdProcessor{audits: audits},
+		audits,
+		zap.NewNop().Sugar())
+
+	err := r.Replay(context.Background())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, isOpen := <-audits
+	assert.False(t, isOpen, "audits channel should be closed")
+}
+
+func TestReplayer_Replay_ProcessorErr(t *testing.T) {
+	t.Parallel()
+
+	r, p, _ := newTestReplayer(t)
+	p.err = errors.New("processor failure")
+
+	err := r.Replay(context.Background())
+	assert.ErrorIs(t, err, p.err)
+}
+
+func TestMultiFileReader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "a.log.1"),
+		filepath.Join(dir, "empty.log"),
+		filepath.Join(dir, "a.log"),
+	}
+
+	require.NoError(t, os.WriteFile(paths[0], []byte("a\r\nb\n"), 0o600))
+	require.NoError(t, os.WriteFile(paths[1], nil, 0o600))
+	// The last line is missing a line ending.
+	require.NoError(t, os.WriteFile(paths[2], []byte("c\n\nd"), 0o600))
+
+	mfr := &multiFileReader{paths: paths}
+	defer mfr.close()
+
+	var lines []string
+	for {
+		line, err := mfr.next()
+		if err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+			break
+		}
+
+		lines = append(lines, line)
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "", "d"}, lines)
+}
+
+func TestAuditLineTimestamp(t *testing.T) {
+	t.Parallel()
+
+	ts, err := auditLineTimestamp("type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=2")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1364481363, 243e6), ts)
+
+	ts, err = auditLineTimestamp("node=foo type=EOE msg=audit(1364481363:24287): ")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1364481363, 0), ts)
+
+	for _, line := range []string{
+		"type=SYSCALL arch=c000003e",
+		"type=SYSCALL msg=audit(1364481363.243",
+		"type=SYSCALL msg=audit(abc.243:1):",
+		"type=SYSCALL msg=audit(1364481363.abc:1):",
+	} {
+		_, err = auditLineTimestamp(line)
+		assert.Error(t, err, line)
+	}
+}
+
+// newTestReplayer returns a Replayer that reads testSshdLog and a set of
+// audit logs. The audits channel is buffered so that the test sshd
+// processor can determine how many audit lines preceded each sshd entry.
+func newTestReplayer(t *testing.T) (*Replayer, *testSshdProcessor, <-chan string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	sshdLogPath := filepath.Join(dir, "auth.log")
+	auditLogPaths := []string{
+		filepath.Join(dir, "audit.log.1"),
+		filepath.Join(dir, "audit.log"),
+	}
+
+	require.NoError(t, os.WriteFile(sshdLogPath, []byte(testSshdLog), 0o600))
+
+	// Syslog files do not specify the year. The replayer
+	// uses the file's modification time to determine it.
+	modTime := time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local)
+	require.NoError(t, os.Chtimes(sshdLogPath, modTime, modTime))
+
+	require.NoError(t, os.WriteFile(auditLogPaths[0], []byte(
+		testAuditLine(time.Date(2023, 1, 5, 12, 0, 1, 5e8, time.Local), 1, "LOGIN")+"\n"+
+			testAuditLine(time.Date(2023, 1, 5, 12, 0, 3, 0, time.Local), 2, "SYSCALL")+"\n"),
+		0o600))
+
+	require.NoError(t, os.WriteFile(auditLogPaths[1], []byte(
+		testAuditLine(time.Date(2023, 1, 5, 12, 0, 3, 0, time.Local), 2, "EOE")+"\n"+
+			testAuditLine(time.Date(2023, 1, 5, 12, 0, 5, 0, time.Local), 3, "SYSCALL")+"\n"),
+		0o600))
+
+	audits := make(chan string, 100)
+	p := &testSshdProcessor{audits: audits}
+
+	r := NewReplayer([]string{sshdLogPath}, auditLogPaths, p, audits, zap.NewNop().Sugar())
+
+	return &r, p, audits
+}
+
+func testAuditLine(t time.Time, serial int, recordType string) string {
+	return fmt.Sprintf("type=%s msg=audit(%d.%03d:%d): pid=1",
+		recordType, t.Unix(), t.Nanosecond()/int(time.Millisecond), serial)
+}
+
+type testSshdCall struct {
+	entry           sshd.SshdLogEntry
+	numAuditsBefore int
+}
+
+type testSshdProcessor struct {
+	audits chan string
+	calls  []testSshdCall
+	err    error
+}
+
+func (o *testSshdProcessor) ProcessSshdLogEntry(_ context.Context, sm sshd.SshdLogEntry) error {
+	if o.err != nil {
+		return o.err
+	}
+
+	o.calls = append(o.calls, testSshdCall{
+		entry:           sm,
+		numAuditsBefore: len(o.audits),
+	})
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package syslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// JSONMessage is a syslog message encoded as a single line JSON object,
+// as written by the JSON template in contrib/rsyslog:
+//
+//	{"timestamp":"2023-01-05T12:34:56.123456+00:00","hostname":"myhost",
+//	 "identifier":"sshd-session","pid":"1201","message":"Accepted ..."}
+//
+// Unlike the "<PID> <Message>" format, its fields are not separated by
+// spaces, so they may be empty or contain spaces.
+type JSONMessage struct {
+	// Timestamp is the RFC 3339 time at which the message was logged
+	// (e.g., rsyslog's "timereported" property).
+	Timestamp string `json:"timestamp"`
+
+	// Hostname is the name of the host that logged the message.
+	Hostname string `json:"hostname"`
+
+	// Identifier is the syslog identifier (i.e., the program name)
+	// of the process that logged the message.
+	Identifier string `json:"identifier"`
+
+	// PID is the PID of the process that logged the message.
+	// It may be encoded as a JSON string or number.
+	PID jsonPID `json:"pid"`
+
+	// Message is the message itself.
+	Message string `json:"message"`
+}
+
+// ParseJSONMessage parses a JSONMessage from a line of JSON.
+func ParseJSONMessage(line []byte) (JSONMessage, error) {
+	var msg JSONMessage
+
+	err := json.Unmarshal(line, &msg)
+	if err != nil {
+		return JSONMessage{}, fmt.Errorf("failed to parse json syslog message - %w", err)
+	}
+
+	return msg, nil
+}
+
+// Time returns the parsed Timestamp. The zero time is returned
+// if Timestamp is empty or is not an RFC 3339 timestamp.
+func (o JSONMessage) Time() time.Time {
+	if o.Timestamp == "" {
+		return time.Time{}
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, o.Timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return ts
+}
+
+// jsonPID is a PID that may be encoded as a JSON string or number.
+type jsonPID string
+
+func (o *jsonPID) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte(`"`)) {
+		var s string
+		err := json.Unmarshal(b, &s)
+		if err != nil {
+			return err
+		}
+
+		*o = jsonPID(s)
+
+		return nil
+	}
+
+	var n json.Number
+	err := json.Unmarshal(b, &n)
+	if err != nil {
+		return fmt.Errorf("pid must be a string or a number - %w", err)
+	}
+
+	*o = jsonPID(n)
+
+	return nil
+}
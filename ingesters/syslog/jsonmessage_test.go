@@ -0,0 +1,103 @@
+package syslog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+)
+
+func TestParseJSONMessage(t *testing.T) {
+	t.Parallel()
+
+	msg, err := ParseJSONMessage([]byte(`{"timestamp":"2023-01-05T12:34:56.123456+00:00",` +
+		`"hostname":"myhost","identifier":"sshd-session","pid":"1201",` +
+		`"message":"Accepted publickey for core  from 127.0.0.1 port 666 ssh2"}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, JSONMessage{
+		Timestamp:  "2023-01-05T12:34:56.123456+00:00",
+		Hostname:   "myhost",
+		Identifier: "sshd-session",
+		PID:        "1201",
+		Message:    "Accepted publickey for core  from 127.0.0.1 port 666 ssh2",
+	}, msg)
+
+	assert.True(t, time.Date(2023, 1, 5, 12, 34, 56, 123456000, time.UTC).Equal(msg.Time()))
+}
+
+func TestParseJSONMessage_PID(t *testing.T) {
+	t.Parallel()
+
+	for raw, exp := range map[string]jsonPID{
+		`{"pid":"100"}`: "100",
+		`{"pid":100}`:   "100",
+		`{"pid":""}`:    "",
+		`{"pid":null}`:  "",
+		`{}`:            "",
+	} {
+		msg, err := ParseJSONMessage([]byte(raw))
+		require.NoError(t, err, raw)
+		assert.Equal(t, exp, msg.PID, raw)
+	}
+
+	_, err := ParseJSONMessage([]byte(`{"pid":[100]}`))
+	assert.Error(t, err)
+}
+
+func TestParseJSONMessage_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseJSONMessage([]byte(`100 Accepted publickey for core`))
+	assert.Error(t, err)
+}
+
+func TestJSONMessage_Time(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, JSONMessage{}.Time().IsZero())
+	assert.True(t, JSONMessage{Timestamp: "Jan  5 12:34:56"}.Time().IsZero())
+	assert.Equal(t, testRFC5424Time, JSONMessage{Timestamp: "2023-01-05T12:34:56Z"}.Time())
+}
+
+func TestSyslogIngester_ProcessJSON(t *testing.T) {
+	t.Parallel()
+
+	p := &testSshdProcessor{}
+	si := SyslogIngester{
+		Format:        PipeFormatJSON,
+		SshdProcessor: p,
+		Logger:        zap.NewNop().Sugar(),
+	}
+
+	ctx := context.Background()
+
+	for _, line := range []string{
+		`{"timestamp":"2023-01-05T12:34:56Z","hostname":"myhost","identifier":"sshd","pid":"100",` +
+			`"message":"Accepted publickey for core"}`,
+		`{"timestamp":"2023-01-05T12:34:56Z","hostname":"myhost","identifier":"systemd","pid":"1",` +
+			`"message":"Started Session 1 of User core."}`,
+		`not json`,
+		``,
+		`{"hostname":"myhost","pid":"","message":"Connection closed by 127.0.0.1 port 666"}`,
+	} {
+		require.NoError(t, si.Process(ctx, line))
+	}
+
+	assert.Equal(t, []sshd.SshdLogEntry{
+		{
+			PID:       "100",
+			Message:   "Accepted publickey for core",
+			Process:   sshd.ProcessSshd,
+			Timestamp: testRFC5424Time,
+		},
+		{
+			Message: "Connection closed by 127.0.0.1 port 666",
+		},
+	}, p.entries)
+}
@@ -0,0 +1,418 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+)
+
+const (
+	// SyslogListenerComponentName is the name of the component
+	// that listens for syslog messages. This is used in the
+	// health check.
+	SyslogListenerComponentName = "syslog-listener"
+
+	// DefaultMaxMessageSize is the default maximum size of
+	// a syslog message in bytes.
+	DefaultMaxMessageSize = 64 * 1024
+
+	// maxOctetCountLen is the maximum number of digits in
+	// an RFC 6587 octet-counting MSG-LEN.
+	maxOctetCountLen = 10
+
+	messagesChanBufSize = 1000
+)
+
+func NewSyslogListener(
+	sshdProcessor sshd.SshdProcessor,
+	logger *zap.SugaredLogger,
+	h *health.Health,
+) SyslogListener {
+	return SyslogListener{
+		MaxMessageSize: DefaultMaxMessageSize,
+		SshdProcessor:  sshdProcessor,
+		Logger:         logger,
+		Health:         h,
+	}
+}
+
+// SyslogListener receives syslog messages from the network and passes
+// sshd messages to an sshd.SshdProcessor.
+//
+// Messages can be received over UDP, TCP and a unix datagram socket.
+// Each UDP and unix datagram contains a single message. TCP streams
+// may use either LF framing or RFC 6587 octet-counting framing.
+//
+// Listeners are only started for addresses that are not empty.
+type SyslogListener struct {
+	UDPAddr        string
+	TCPAddr        string
+	UnixgramPath   string
+	MaxMessageSize int
+	SshdProcessor  sshd.SshdProcessor
+	Logger         *zap.SugaredLogger
+	Health         *health.Health
+}
+
+// listeners contains the sockets opened by a SyslogListener.
+// Fields are nil if the corresponding address was not set.
+type listeners struct {
+	udp      net.PacketConn
+	tcp      net.Listener
+	unixgram net.PacketConn
+}
+
+func (o *listeners) close() {
+	if o.udp != nil {
+		_ = o.udp.Close()
+	}
+
+	if o.tcp != nil {
+		_ = o.tcp.Close()
+	}
+
+	if o.unixgram != nil {
+		_ = o.unixgram.Close()
+	}
+}
+
+// Listen opens the configured sockets and processes syslog messages
+// until the context is marked as done or an error occurs.
+func (o *SyslogListener) Listen(ctx context.Context) error {
+	ls, err := o.listen()
+	if err != nil {
+		return err
+	}
+
+	if o.UnixgramPath != "" {
+		defer os.Remove(o.UnixgramPath)
+	}
+
+	o.Health.OnReady(SyslogListenerComponentName)
+
+	return o.serve(ctx, ls)
+}
+
+func (o *SyslogListener) listen() (*listeners, error) {
+	if o.UDPAddr == "" && o.TCPAddr == "" && o.UnixgramPath == "" {
+		return nil, errors.New("at least one syslog listen address must be specified")
+	}
+
+	ls := &listeners{}
+	var err error
+
+	if o.UDPAddr != "" {
+		ls.udp, err = net.ListenPacket("udp", o.UDPAddr)
+		if err != nil {
+			ls.close()
+			return nil, fmt.Errorf("failed to listen on udp address %q - %w", o.UDPAddr, err)
+		}
+	}
+
+	if o.TCPAddr != "" {
+		ls.tcp, err = net.Listen("tcp", o.TCPAddr)
+		if err != nil {
+			ls.close()
+			return nil, fmt.Errorf("failed to listen on tcp address %q - %w", o.TCPAddr, err)
+		}
+	}
+
+	if o.UnixgramPath != "" {
+		err = removeStaleSocket(o.UnixgramPath)
+		if err != nil {
+			ls.close()
+			return nil, err
+		}
+
+		ls.unixgram, err = net.ListenPacket("unixgram", o.UnixgramPath)
+		if err != nil {
+			ls.close()
+			return nil, fmt.Errorf("failed to listen on unix datagram socket %q - %w",
+				o.UnixgramPath, err)
+		}
+	}
+
+	return ls, nil
+}
+
+// removeStaleSocket removes the unix socket at path if it
+// exists. This is typically left behind by a previous instance
+// that did not shut down cleanly.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to stat unix socket path %q - %w", path, err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unix socket path %q exists and is not a socket", path)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("failed to remove stale unix socket %q - %w", path, err)
+	}
+
+	return nil
+}
+
+func (o *SyslogListener) serve(ctx context.Context, ls *listeners) error {
+	eg, groupCtx := errgroup.WithContext(ctx)
+
+	// Messages are processed by a single Go routine because
+	// the sshd processor is not safe for concurrent use.
+	messages := make(chan Message, messagesChanBufSize)
+
+	eg.Go(func() error {
+		<-groupCtx.Done()
+		ls.close()
+		return nil
+	})
+
+	if ls.udp != nil {
+		eg.Go(func() error {
+			return o.readPackets(groupCtx, ls.udp, messages)
+		})
+	}
+
+	if ls.tcp != nil {
+		eg.Go(func() error {
+			return o.acceptTCP(groupCtx, ls.tcp, messages)
+		})
+	}
+
+	if ls.unixgram != nil {
+		eg.Go(func() error {
+			return o.readPackets(groupCtx, ls.unixgram, messages)
+		})
+	}
+
+	eg.Go(func() error {
+		for {
+			select {
+			case <-groupCtx.Done():
+				return groupCtx.Err()
+			case msg := <-messages:
+				err := o.process(groupCtx, msg)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return eg.Wait()
+}
+
+// process passes msg to the sshd processor if it was produced by
+// one of the sshd processes (i.e., its APP-NAME or RFC 3164 TAG is
+// one of sshd.ProcessNames).
+func (o *SyslogListener) process(ctx context.Context, msg Message) error {
+	if !sshd.IsProcessName(msg.AppName) {
+		return nil
+	}
+
+	return o.SshdProcessor.ProcessSshdLogEntry(ctx, sshd.SshdLogEntry{
+		PID:       msg.ProcID,
+		Message:   msg.Msg,
+		Process:   msg.AppName,
+		Timestamp: msg.Timestamp,
+	})
+}
+
+// readPackets reads one syslog message per datagram from conn.
+func (o *SyslogListener) readPackets(ctx context.Context, conn net.PacketConn, messages chan<- Message) error {
+	buf := make([]byte, o.MaxMessageSize)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("failed to read from %s - %w", conn.LocalAddr(), err)
+		}
+
+		err = o.handleRaw(ctx, string(buf[:n]), messages)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// acceptTCP accepts TCP connections until the listener is closed.
+// Errors that occur on individual connections are logged and do
+// not stop the listener.
+func (o *SyslogListener) acceptTCP(ctx context.Context, listener net.Listener, messages chan<- Message) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("failed to accept tcp connection - %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := o.handleTCPConn(ctx, conn, messages)
+			if err != nil && ctx.Err() == nil {
+				o.Logger.Warnf("syslog tcp connection from %s failed: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// handleTCPConn reads syslog messages from a TCP connection until
+// the peer closes it. The framing of each message is determined by
+// its first byte. RFC 6587 octet-counted messages begin with the
+// message length, while LF-framed messages begin with '<'.
+func (o *SyslogListener) handleTCPConn(ctx context.Context, conn net.Conn, messages chan<- Message) error {
+	connCtx, cancelFn := context.WithCancel(ctx)
+	defer cancelFn()
+
+	go func() {
+		<-connCtx.Done()
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReaderSize(conn, o.MaxMessageSize)
+
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		var raw string
+		if first[0] >= '0' && first[0] <= '9' {
+			raw, err = readOctetCounted(r, o.MaxMessageSize)
+		} else {
+			raw, err = readLF(r)
+		}
+
+		if raw != "" {
+			handleErr := o.handleRaw(ctx, raw, messages)
+			if handleErr != nil {
+				return handleErr
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// readOctetCounted reads an RFC 6587 octet-counted message
+// in the form of "MSG-LEN SP SYSLOG-MSG".
+func readOctetCounted(r *bufio.Reader, maxSize int) (string, error) {
+	var lenStr []byte
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		if b == ' ' {
+			break
+		}
+
+		if b < '0' || b > '9' || len(lenStr) == maxOctetCountLen {
+			return "", fmt.Errorf("invalid octet-counted message length: %q", append(lenStr, b))
+		}
+
+		lenStr = append(lenStr, b)
+	}
+
+	msgLen, err := strconv.Atoi(string(lenStr))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse octet-counted message length - %w", err)
+	}
+
+	if msgLen > maxSize {
+		return "", fmt.Errorf("octet-counted message length %d exceeds maximum of %d",
+			msgLen, maxSize)
+	}
+
+	buf := make([]byte, msgLen)
+
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read octet-counted message - %w", err)
+	}
+
+	return string(buf), nil
+}
+
+// readLF reads a message terminated by a LF. A message that is
+// cut short by the end of the stream is returned with io.EOF.
+func readLF(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", errors.New("lf-framed message exceeds maximum message size")
+		}
+
+		return string(line), err
+	}
+
+	return string(line), nil
+}
+
+// handleRaw parses a raw syslog message and sends it to the messages
+// channel. Messages that cannot be parsed are logged and discarded.
+func (o *SyslogListener) handleRaw(ctx context.Context, raw string, messages chan<- Message) error {
+	// Some senders terminate octet-counted messages with
+	// a trailing LF, which results in an empty message.
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+
+	msg, err := ParseMessage(raw, time.Now())
+	if err != nil {
+		o.Logger.Warnf("failed to parse syslog message: %s", err)
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case messages <- msg:
+		return nil
+	}
+}
@@ -0,0 +1,263 @@
+package syslog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
+)
+
+const (
+	testRFC5424Msg = "<38>1 2023-01-05T12:34:56Z myhost sshd 100 - - Accepted publickey for core"
+	testRFC3164Msg = "<38>Jan  5 12:34:56 myhost sshd[101]: Invalid user cow from 47.8.6.9 port 64433"
+	testOtherMsg   = "<38>Jan  5 12:34:56 myhost systemd[1]: Started Session 1 of User core."
+
+	testSplitProcessMsg = "<38>Jan  5 12:34:56 myhost sshd-session[102]: Connection closed"
+)
+
+var testRFC5424Time = time.Date(2023, 1, 5, 12, 34, 56, 0, time.UTC)
+
+func TestSyslogListener_UDP(t *testing.T) {
+	t.Parallel()
+
+	sl, p := newTestSyslogListener(t)
+	sl.UDPAddr = "127.0.0.1:0"
+
+	ls := startTestSyslogListener(t, sl)
+
+	conn, err := net.Dial("udp", ls.udp.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, msg := range []string{testOtherMsg, testRFC5424Msg, testRFC3164Msg} {
+		_, err = conn.Write([]byte(msg))
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []sshd.SshdLogEntry{
+		{PID: "100", Message: "Accepted publickey for core", Process: sshd.ProcessSshd, Timestamp: testRFC5424Time},
+		{
+			PID:       "101",
+			Message:   "Invalid user cow from 47.8.6.9 port 64433",
+			Process:   sshd.ProcessSshd,
+			Timestamp: testMessageTime(t, testRFC3164Msg),
+		},
+	}, p.waitForEntries(t, 2))
+}
+
+func TestSyslogListener_TCP(t *testing.T) {
+	t.Parallel()
+
+	sl, p := newTestSyslogListener(t)
+	sl.TCPAddr = "127.0.0.1:0"
+
+	ls := startTestSyslogListener(t, sl)
+
+	conn, err := net.Dial("tcp", ls.tcp.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Mix LF framing and octet-counting framing. The final
+	// message is terminated by closing the connection.
+	_, err = fmt.Fprintf(conn, "%s\n%d %s%d %s\n%s",
+		testOtherMsg,
+		len(testRFC5424Msg), testRFC5424Msg,
+		len(testRFC3164Msg), testRFC3164Msg,
+		testSplitProcessMsg)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.Close())
+
+	assert.Equal(t, []sshd.SshdLogEntry{
+		{PID: "100", Message: "Accepted publickey for core", Process: sshd.ProcessSshd, Timestamp: testRFC5424Time},
+		{
+			PID:       "101",
+			Message:   "Invalid user cow from 47.8.6.9 port 64433",
+			Process:   sshd.ProcessSshd,
+			Timestamp: testMessageTime(t, testRFC3164Msg),
+		},
+		{
+			PID:       "102",
+			Message:   "Connection closed",
+			Process:   sshd.ProcessSshdSession,
+			Timestamp: testMessageTime(t, testSplitProcessMsg),
+		},
+	}, p.waitForEntries(t, 3))
+}
+
+func TestSyslogListener_TCPMessageTooLarge(t *testing.T) {
+	t.Parallel()
+
+	sl, p := newTestSyslogListener(t)
+	sl.TCPAddr = "127.0.0.1:0"
+	sl.MaxMessageSize = 100
+
+	ls := startTestSyslogListener(t, sl)
+
+	tooLarge, err := net.Dial("tcp", ls.tcp.Addr().String())
+	require.NoError(t, err)
+	defer tooLarge.Close()
+
+	_, err = fmt.Fprintf(tooLarge, "1000 %s\n", testRFC5424Msg)
+	require.NoError(t, err)
+
+	// The listener should continue to accept connections
+	// after closing the misbehaving one.
+	conn, err := net.Dial("tcp", ls.tcp.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "%s\n", testRFC5424Msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, []sshd.SshdLogEntry{
+		{PID: "100", Message: "Accepted publickey for core", Process: sshd.ProcessSshd, Timestamp: testRFC5424Time},
+	}, p.waitForEntries(t, 1))
+}
+
+func TestSyslogListener_Unixgram(t *testing.T) {
+	t.Parallel()
+
+	sl, p := newTestSyslogListener(t)
+	sl.UnixgramPath = filepath.Join(t.TempDir(), "syslog.sock")
+
+	// Create a stale socket to verify that it is replaced.
+	stale, err := net.ListenPacket("unixgram", sl.UnixgramPath)
+	require.NoError(t, err)
+	require.NoError(t, stale.Close())
+
+	startTestSyslogListener(t, sl)
+
+	conn, err := net.Dial("unixgram", sl.UnixgramPath)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(testRFC3164Msg))
+	require.NoError(t, err)
+
+	assert.Equal(t, []sshd.SshdLogEntry{
+		{
+			PID:       "101",
+			Message:   "Invalid user cow from 47.8.6.9 port 64433",
+			Process:   sshd.ProcessSshd,
+			Timestamp: testMessageTime(t, testRFC3164Msg),
+		},
+	}, p.waitForEntries(t, 1))
+}
+
+func TestSyslogListener_NoAddresses(t *testing.T) {
+	t.Parallel()
+
+	sl, _ := newTestSyslogListener(t)
+
+	err := sl.Listen(context.Background())
+	assert.Error(t, err)
+}
+
+func TestReadOctetCounted_InvalidLength(t *testing.T) {
+	t.Parallel()
+
+	sl, _ := newTestSyslogListener(t)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sl.handleTCPConn(context.Background(), server, make(chan Message, 1))
+	}()
+
+	_, err := client.Write([]byte("12a <38>foo"))
+	require.NoError(t, err)
+
+	assert.ErrorContains(t, <-errs, "invalid octet-counted message length")
+}
+
+func newTestSyslogListener(t *testing.T) (*SyslogListener, *testSshdProcessor) {
+	t.Helper()
+
+	p := &testSshdProcessor{}
+
+	sl := NewSyslogListener(
+		p,
+		zap.NewNop().Sugar(),
+		health.NewSingleReadinessHealth(SyslogListenerComponentName))
+
+	return &sl, p
+}
+
+// startTestSyslogListener opens the listener's sockets and serves
+// them until the test completes.
+func startTestSyslogListener(t *testing.T, sl *SyslogListener) *listeners {
+	t.Helper()
+
+	ls, err := sl.listen()
+	require.NoError(t, err)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sl.serve(ctx, ls)
+	}()
+
+	t.Cleanup(func() {
+		cancelFn()
+		assert.ErrorIs(t, <-errs, context.Canceled)
+	})
+
+	return ls
+}
+
+type testSshdProcessor struct {
+	mu      sync.Mutex
+	entries []sshd.SshdLogEntry
+}
+
+func (o *testSshdProcessor) ProcessSshdLogEntry(_ context.Context, sm sshd.SshdLogEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.entries = append(o.entries, sm)
+
+	return nil
+}
+
+func (o *testSshdProcessor) waitForEntries(t *testing.T, n int) []sshd.SshdLogEntry {
+	t.Helper()
+
+	var entries []sshd.SshdLogEntry
+
+	assert.Eventually(t, func() bool {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		entries = append([]sshd.SshdLogEntry(nil), o.entries...)
+
+		return len(entries) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return entries
+}
+
+// testMessageTime returns the timestamp of a syslog message. RFC 3164
+// timestamps do not specify a year, so it depends on the current time.
+func testMessageTime(t *testing.T, raw string) time.Time {
+	t.Helper()
+
+	msg, err := ParseMessage(raw, time.Now())
+	require.NoError(t, err)
+	require.False(t, msg.Timestamp.IsZero())
+
+	return msg.Timestamp
+}
@@ -0,0 +1,268 @@
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nilValue is the RFC 5424 NILVALUE, which indicates that
+// a header field's value is unknown or not applicable.
+const nilValue = "-"
+
+const (
+	maxPriority     = 191
+	rfc5424Version  = "1"
+	rfc3164StampLen = len(time.Stamp)
+)
+
+var errInvalidPriority = errors.New("invalid syslog priority")
+
+// Message is a syslog message parsed from either the RFC 5424
+// format or the older RFC 3164 (BSD syslog) format.
+//
+// Fields are empty if they were not present in the message
+// or if they were set to the RFC 5424 NILVALUE ("-").
+type Message struct {
+	Priority  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	Msg       string
+}
+
+// ParseMessage parses an RFC 5424 or RFC 3164 syslog message.
+// The format is determined by the presence of the RFC 5424
+// version field that follows the priority.
+//
+// now is used to fill in the year of RFC 3164 timestamps,
+// which do not specify one.
+func ParseMessage(raw string, now time.Time) (Message, error) {
+	raw = strings.TrimRight(raw, "\r\n\x00")
+
+	priority, rest, err := parsePriority(raw)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if strings.HasPrefix(rest, rfc5424Version+" ") {
+		msg, err := parseRFC5424(rest[len(rfc5424Version)+1:])
+		if err != nil {
+			return Message{}, fmt.Errorf("failed to parse rfc 5424 message - %w", err)
+		}
+
+		msg.Priority = priority
+
+		return msg, nil
+	}
+
+	msg := parseRFC3164(rest, now)
+	msg.Priority = priority
+
+	return msg, nil
+}
+
+// ParseLogFileLine parses a line from a syslog log file, such as
+// /var/log/auth.log. These lines are RFC 3164 messages that lack
+// the "<PRI>" prefix (e.g., "Jan  5 12:34:56 myhost sshd[123]: ...").
+//
+// now is used to fill in the year of RFC 3164 timestamps.
+func ParseLogFileLine(line string, now time.Time) Message {
+	return parseRFC3164(strings.TrimRight(line, "\r\n"), now)
+}
+
+// parsePriority parses the "<PRI>" part of a syslog message and
+// returns the remainder of the message.
+func parsePriority(raw string) (int, string, error) {
+	if !strings.HasPrefix(raw, "<") {
+		return 0, "", fmt.Errorf("%w: message does not start with '<'", errInvalidPriority)
+	}
+
+	end := strings.IndexByte(raw, '>')
+	// PRI is one to three digits.
+	if end < 2 || end > 4 {
+		return 0, "", fmt.Errorf("%w: missing or malformed '>'", errInvalidPriority)
+	}
+
+	priority, err := strconv.Atoi(raw[1:end])
+	if err != nil || priority < 0 || priority > maxPriority {
+		return 0, "", fmt.Errorf("%w: %q", errInvalidPriority, raw[1:end])
+	}
+
+	return priority, raw[end+1:], nil
+}
+
+// parseRFC5424 parses the part of an RFC 5424 message that follows
+// the "<PRI>VERSION " prefix:
+//
+//	TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+func parseRFC5424(s string) (Message, error) {
+	const numHeaderFields = 5
+
+	var msg Message
+
+	fields := make([]string, 0, numHeaderFields)
+	for i := 0; i < numHeaderFields; i++ {
+		field, rest, found := strings.Cut(s, " ")
+		if !found {
+			return Message{}, fmt.Errorf("message is missing header field %d", i)
+		}
+
+		fields = append(fields, field)
+		s = rest
+	}
+
+	if fields[0] != nilValue {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return Message{}, fmt.Errorf("failed to parse timestamp - %w", err)
+		}
+
+		msg.Timestamp = ts
+	}
+
+	msg.Hostname = nilToEmpty(fields[1])
+	msg.AppName = nilToEmpty(fields[2])
+	msg.ProcID = nilToEmpty(fields[3])
+	msg.MsgID = nilToEmpty(fields[4])
+
+	rest, err := skipStructuredData(s)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Remove the optional UTF-8 byte order mark.
+	msg.Msg = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+
+	return msg, nil
+}
+
+// skipStructuredData skips the STRUCTURED-DATA part of an RFC 5424
+// message and returns the remainder of the message.
+func skipStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, nilValue) {
+		return s[len(nilValue):], nil
+	}
+
+	for strings.HasPrefix(s, "[") {
+		inQuotes := false
+		end := -1
+
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if inQuotes {
+					// Skip the escaped character.
+					i++
+				}
+			case '"':
+				inQuotes = !inQuotes
+			case ']':
+				if !inQuotes {
+					end = i
+				}
+			}
+
+			if end > -1 {
+				break
+			}
+		}
+
+		if end == -1 {
+			return "", errors.New("structured data element is missing ']'")
+		}
+
+		s = s[end+1:]
+	}
+
+	return s, nil
+}
+
+// parseRFC3164 parses the part of an RFC 3164 message that follows
+// the "<PRI>" prefix:
+//
+//	TIMESTAMP SP HOSTNAME SP TAG[PID]: MSG
+//
+// RFC 3164 describes observed behavior rather than a standard.
+// As a result, this function is lenient. A missing timestamp
+// or hostname is tolerated, and an RFC 3339 timestamp is
+// accepted in place of the "Mmm dd hh:mm:ss" timestamp.
+func parseRFC3164(s string, now time.Time) Message {
+	var msg Message
+
+	if len(s) >= rfc3164StampLen {
+		ts, err := time.ParseInLocation(time.Stamp, s[:rfc3164StampLen], now.Location())
+		if err == nil {
+			msg.Timestamp = withYear(ts, now)
+			s = strings.TrimPrefix(s[rfc3164StampLen:], " ")
+		}
+	}
+
+	if msg.Timestamp.IsZero() {
+		field, rest, found := strings.Cut(s, " ")
+		if found {
+			ts, err := time.Parse(time.RFC3339Nano, field)
+			if err == nil {
+				msg.Timestamp = ts
+				s = rest
+			}
+		}
+	}
+
+	// The hostname is frequently omitted by local senders, such as
+	// syslog(3) writing to /dev/log. If the first token looks like
+	// a tag, then we assume that there is no hostname.
+	field, rest, found := strings.Cut(s, " ")
+	if found && !looksLikeTag(field) {
+		msg.Hostname = field
+		s = rest
+	}
+
+	tag, rest, found := strings.Cut(s, ":")
+	if !found || strings.ContainsAny(tag, " ") {
+		msg.Msg = s
+		return msg
+	}
+
+	msg.Msg = strings.TrimPrefix(rest, " ")
+
+	if start := strings.IndexByte(tag, '['); start > -1 && strings.HasSuffix(tag, "]") {
+		msg.AppName = tag[:start]
+		msg.ProcID = tag[start+1 : len(tag)-1]
+	} else {
+		msg.AppName = tag
+	}
+
+	return msg
+}
+
+// looksLikeTag returns true if s looks like an RFC 3164 TAG
+// (e.g., "sshd[123]:" or "sshd:").
+func looksLikeTag(s string) bool {
+	return strings.HasSuffix(s, ":")
+}
+
+// withYear sets the year of ts, which was parsed from a timestamp
+// lacking a year, based on now. Timestamps that would be more than
+// a day in the future are assumed to be from the previous year
+// (e.g., a December message received in January).
+func withYear(ts time.Time, now time.Time) time.Time {
+	ts = ts.AddDate(now.Year(), 0, 0)
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+
+	return ts
+}
+
+func nilToEmpty(s string) string {
+	if s == nilValue {
+		return ""
+	}
+
+	return s
+}
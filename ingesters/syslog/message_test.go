@@ -0,0 +1,174 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessage_RFC5424(t *testing.T) {
+	t.Parallel()
+
+	msg, err := ParseMessage(
+		"<38>1 2023-01-05T12:34:56.123456+00:00 myhost sshd 1234 - - "+
+			"Accepted publickey for core from 127.0.0.1 port 666 ssh2\n",
+		time.Now())
+	require.NoError(t, err)
+
+	assert.Equal(t, Message{
+		Priority:  38,
+		Timestamp: time.Date(2023, 1, 5, 12, 34, 56, 123456000, time.UTC),
+		Hostname:  "myhost",
+		AppName:   "sshd",
+		ProcID:    "1234",
+		Msg:       "Accepted publickey for core from 127.0.0.1 port 666 ssh2",
+	}, msg.withUTCTimestamp())
+}
+
+func TestParseMessage_RFC5424StructuredData(t *testing.T) {
+	t.Parallel()
+
+	msg, err := ParseMessage(
+		`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com sshd - ID47 `+
+			`[exampleSDID@32473 iut="3" eventSource="App]lication"][meta x="a\"]b"] `+
+			"\ufeffInvalid user cow from 47.8.6.9 port 64433",
+		time.Now())
+	require.NoError(t, err)
+
+	assert.Equal(t, "mymachine.example.com", msg.Hostname)
+	assert.Equal(t, "sshd", msg.AppName)
+	assert.Equal(t, "", msg.ProcID)
+	assert.Equal(t, "ID47", msg.MsgID)
+	assert.Equal(t, "Invalid user cow from 47.8.6.9 port 64433", msg.Msg)
+}
+
+func TestParseMessage_RFC5424NilValues(t *testing.T) {
+	t.Parallel()
+
+	msg, err := ParseMessage("<13>1 - - - - - -", time.Now())
+	require.NoError(t, err)
+
+	assert.Equal(t, Message{Priority: 13}, msg)
+}
+
+func TestParseMessage_RFC5424Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"missing header fields":        "<13>1 - myhost sshd",
+		"bad timestamp":                "<13>1 yesterday myhost sshd 1 - - foo",
+		"unterminated structured data": `<13>1 - myhost sshd 1 - [foo x="]"`,
+	}
+
+	for name, raw := range tests {
+		raw := raw
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseMessage(raw, time.Now())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseMessage_RFC3164(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	msg, err := ParseMessage(
+		"<38>Feb 28 23:59:01 myhost sshd[1234]: Accepted password for core from 127.0.0.1 port 666 ssh2",
+		now)
+	require.NoError(t, err)
+
+	assert.Equal(t, Message{
+		Priority:  38,
+		Timestamp: time.Date(2023, 2, 28, 23, 59, 1, 0, time.UTC),
+		Hostname:  "myhost",
+		AppName:   "sshd",
+		ProcID:    "1234",
+		Msg:       "Accepted password for core from 127.0.0.1 port 666 ssh2",
+	}, msg)
+}
+
+func TestParseMessage_RFC3164NoHostname(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	msg, err := ParseMessage("<38>Mar  1 00:00:00 sshd[99]: Connection closed", now)
+	require.NoError(t, err)
+
+	assert.Equal(t, "", msg.Hostname)
+	assert.Equal(t, "sshd", msg.AppName)
+	assert.Equal(t, "99", msg.ProcID)
+	assert.Equal(t, "Connection closed", msg.Msg)
+}
+
+func TestParseMessage_RFC3164PreviousYear(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 1, 1, 0, 0, 5, 0, time.UTC)
+
+	msg, err := ParseMessage("<38>Dec 31 23:59:59 myhost sshd[1]: foo", now)
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Date(2022, 12, 31, 23, 59, 59, 0, time.UTC), msg.Timestamp)
+}
+
+func TestParseMessage_RFC3164RFC3339Timestamp(t *testing.T) {
+	t.Parallel()
+
+	msg, err := ParseMessage("<38>2023-01-05T12:34:56Z myhost sshd: foo bar", time.Now())
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Date(2023, 1, 5, 12, 34, 56, 0, time.UTC), msg.Timestamp)
+	assert.Equal(t, "myhost", msg.Hostname)
+	assert.Equal(t, "sshd", msg.AppName)
+	assert.Equal(t, "", msg.ProcID)
+	assert.Equal(t, "foo bar", msg.Msg)
+}
+
+func TestParseMessage_RFC3164NoTag(t *testing.T) {
+	t.Parallel()
+
+	msg, err := ParseMessage("<38>myhost some text without a tag", time.Now())
+	require.NoError(t, err)
+
+	assert.Equal(t, "myhost", msg.Hostname)
+	assert.Equal(t, "", msg.AppName)
+	assert.Equal(t, "some text without a tag", msg.Msg)
+}
+
+func TestParseMessage_BadPriority(t *testing.T) {
+	t.Parallel()
+
+	for _, raw := range []string{"", "38>foo", "<>foo", "<1000>foo", "<192>foo", "<abc>foo"} {
+		_, err := ParseMessage(raw, time.Now())
+		assert.ErrorIs(t, err, errInvalidPriority, raw)
+	}
+}
+
+func TestParseLogFileLine(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	msg := ParseLogFileLine("Feb 28 23:59:01 myhost sshd[1234]: Connection closed by 127.0.0.1 port 666\n", now)
+
+	assert.Equal(t, Message{
+		Timestamp: time.Date(2023, 2, 28, 23, 59, 1, 0, time.UTC),
+		Hostname:  "myhost",
+		AppName:   "sshd",
+		ProcID:    "1234",
+		Msg:       "Connection closed by 127.0.0.1 port 666",
+	}, msg)
+}
+
+func (o Message) withUTCTimestamp() Message {
+	o.Timestamp = o.Timestamp.UTC()
+	return o
+}
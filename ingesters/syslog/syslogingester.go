@@ -2,20 +2,52 @@ package syslog
 
 import (
 	"context"
+	"fmt"
 	"strings"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/metal-toolbox/audito-maldito/ingesters/namedpipe"
 	"github.com/metal-toolbox/audito-maldito/processors/sshd"
 )
 
+// PipeFormat is the format of the lines read from a named pipe.
+type PipeFormat string
+
+const (
+	// PipeFormatText means each line is in the form of "<PID> <Message>",
+	// optionally with a timestamp and process name. See
+	// SyslogIngester.ParseSyslogMessage.
+	PipeFormatText PipeFormat = "text"
+
+	// PipeFormatJSON means each line is a JSON object.
+	// See JSONMessage.
+	PipeFormatJSON PipeFormat = "json"
+)
+
+// ParsePipeFormat parses s into a PipeFormat.
+func ParsePipeFormat(s string) (PipeFormat, error) {
+	switch PipeFormat(s) {
+	case PipeFormatText, PipeFormatJSON:
+		return PipeFormat(s), nil
+	default:
+		return "", fmt.Errorf("unknown named pipe format: %q", s)
+	}
+}
+
 func NewSyslogIngester(
 	filePath string,
+	format PipeFormat,
 	sshdProcessor sshd.SshdProcessor,
 	namedPipeIngester namedpipe.NamedPipeIngester,
+	logger *zap.SugaredLogger,
 ) SyslogIngester {
 	return SyslogIngester{
 		FilePath:          filePath,
+		Format:            format,
 		SshdProcessor:     sshdProcessor,
+		Logger:            logger,
 		namedPipeIngester: namedPipeIngester,
 	}
 }
@@ -23,7 +55,9 @@ func NewSyslogIngester(
 type SyslogIngester struct {
 	namedPipeIngester namedpipe.NamedPipeIngester
 	FilePath          string
+	Format            PipeFormat
 	SshdProcessor     sshd.SshdProcessor
+	Logger            *zap.SugaredLogger
 }
 
 func (s *SyslogIngester) Ingest(ctx context.Context) error {
@@ -31,21 +65,72 @@ func (s *SyslogIngester) Ingest(ctx context.Context) error {
 }
 
 func (s *SyslogIngester) Process(ctx context.Context, line string) error {
+	if s.Format == PipeFormatJSON {
+		return s.processJSON(ctx, line)
+	}
+
 	sm := s.ParseSyslogMessage(line)
 	return s.SshdProcessor.ProcessSshdLogEntry(ctx, sm)
 }
 
-// ParseSyslogMessage expects a message in the form of "<PID> <Message>".
+// processJSON passes a JSONMessage to the sshd processor if it was
+// produced by one of the sshd processes. Messages without an identifier
+// are assumed to be produced by sshd. Lines that cannot be parsed are
+// logged and discarded.
+func (s *SyslogIngester) processJSON(ctx context.Context, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	msg, err := ParseJSONMessage([]byte(line))
+	if err != nil {
+		s.Logger.Warnf("failed to parse sshd named pipe line: %s", err)
+		return nil
+	}
+
+	if msg.Identifier != "" && !sshd.IsProcessName(msg.Identifier) {
+		return nil
+	}
+
+	return s.SshdProcessor.ProcessSshdLogEntry(ctx, sshd.SshdLogEntry{
+		PID:       string(msg.PID),
+		Message:   msg.Message,
+		Process:   msg.Identifier,
+		Timestamp: msg.Time(),
+	})
+}
+
+// ParseSyslogMessage expects a message in the form of "<PID> <Message>"
+// or "<Process>[<PID>] <Message>". The latter identifies which of the
+// sshd processes (e.g., sshd-session) produced the message. Either form
+// may be preceded by the RFC 3339 timestamp at which sshd logged the
+// message (e.g., rsyslog's "%timereported:::date-rfc3339%").
 func (s *SyslogIngester) ParseSyslogMessage(entry string) sshd.SshdLogEntry {
 	minimumEntrySplitLength := 2
 	entrySplit := strings.Split(entry, " ")
 
+	var timestamp time.Time
+	if len(entrySplit) > minimumEntrySplitLength {
+		ts, err := time.Parse(time.RFC3339Nano, entrySplit[0])
+		if err == nil {
+			timestamp = ts
+			entrySplit = entrySplit[1:]
+		}
+	}
+
 	if len(entrySplit) < minimumEntrySplitLength {
 		return sshd.SshdLogEntry{}
 	}
 
 	pid := entrySplit[0]
+	var process string
+	if start := strings.IndexByte(pid, '['); start > -1 && strings.HasSuffix(pid, "]") {
+		process = pid[:start]
+		pid = pid[start+1 : len(pid)-1]
+	}
+
 	logMsg := strings.Join(entrySplit[1:], " ")
 	logMsg = strings.TrimLeft(logMsg, " ")
-	return sshd.SshdLogEntry{PID: pid, Message: logMsg}
+	return sshd.SshdLogEntry{PID: pid, Message: logMsg, Process: process, Timestamp: timestamp}
 }
@@ -6,14 +6,17 @@ import (
 	"os"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/metal-toolbox/audito-maldito/ingesters/namedpipe"
 	"github.com/metal-toolbox/audito-maldito/ingesters/syslog"
 	"github.com/metal-toolbox/audito-maldito/ingesters/syslog/fakes"
 	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/processors/sshd"
 )
 
 func TestIngest(t *testing.T) {
@@ -31,8 +34,10 @@ func TestIngest(t *testing.T) {
 	namedPipeIngester := namedpipe.NewNamedPipeIngester(logger, h)
 	sli := syslog.NewSyslogIngester(
 		pipePath,
+		syslog.PipeFormatText,
 		&fakes.SshdProcessorFaker{CountChan: countChan, ExpectedPID: expectedPID},
 		namedPipeIngester,
+		logger,
 	)
 
 	ctx := context.Background()
@@ -59,3 +64,45 @@ func TestIngest(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePipeFormat(t *testing.T) {
+	t.Parallel()
+
+	f, err := syslog.ParsePipeFormat("text")
+	require.NoError(t, err)
+	assert.Equal(t, syslog.PipeFormatText, f)
+
+	f, err = syslog.ParsePipeFormat("json")
+	require.NoError(t, err)
+	assert.Equal(t, syslog.PipeFormatJSON, f)
+
+	_, err = syslog.ParsePipeFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestParseSyslogMessage(t *testing.T) {
+	t.Parallel()
+
+	var sli syslog.SyslogIngester
+
+	assert.Equal(t, sshd.SshdLogEntry{
+		PID:     "10",
+		Message: "Accepted publickey for core from 127.0.0.1 port 666 ssh2",
+	}, sli.ParseSyslogMessage("10 Accepted publickey for core from 127.0.0.1 port 666 ssh2"))
+
+	assert.Equal(t, sshd.SshdLogEntry{
+		PID:     "11",
+		Message: "Accepted publickey for core from 127.0.0.1 port 666 ssh2",
+		Process: sshd.ProcessSshdSession,
+	}, sli.ParseSyslogMessage("sshd-session[11] Accepted publickey for core from 127.0.0.1 port 666 ssh2"))
+
+	assert.Equal(t, sshd.SshdLogEntry{
+		PID:       "12",
+		Message:   "Accepted publickey for core from 127.0.0.1 port 666 ssh2",
+		Process:   sshd.ProcessSshd,
+		Timestamp: time.Date(2023, 1, 2, 3, 4, 5, 123456000, time.FixedZone("", 2*60*60)),
+	}, sli.ParseSyslogMessage("2023-01-02T03:04:05.123456+02:00 sshd[12] Accepted publickey for core from 127.0.0.1 port 666 ssh2"))
+
+	assert.Equal(t, sshd.SshdLogEntry{}, sli.ParseSyslogMessage("10"))
+	assert.Equal(t, sshd.SshdLogEntry{}, sli.ParseSyslogMessage("2023-01-02T03:04:05Z"))
+}
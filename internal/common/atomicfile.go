@@ -0,0 +1,25 @@
+package common
+
+import (
+	"fmt"
+	"os"
+)
+
+// WriteFileAtomic writes data to a temporary file which then replaces
+// the file found at path. This prevents a partially-written file from
+// being read if the application exits mid-write.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+
+	err := os.WriteFile(tmpPath, data, perm)
+	if err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "state")
+
+	require.NoError(t, WriteFileAtomic(filePath, []byte("foo"), 0o600))
+	require.NoError(t, WriteFileAtomic(filePath, []byte("bar"), 0o600))
+
+	contents, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(contents))
+
+	_, err = os.Stat(filePath + ".tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestWriteFileAtomic_DirDoesNotExist(t *testing.T) {
+	t.Parallel()
+
+	err := WriteFileAtomic(filepath.Join(t.TempDir(), "foo", "state"), []byte("foo"), 0o600)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
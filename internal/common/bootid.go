@@ -0,0 +1,26 @@
+package common
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	// BootIDPath is the path to a file containing an identifier
+	// that is randomly generated each time the kernel boots.
+	BootIDPath = "/proc/sys/kernel/random/boot_id"
+)
+
+// GetBootID returns the boot ID.
+func GetBootID() (string, error) {
+	return getBootID(BootIDPath)
+}
+
+// getBootID is the actual (testable) implementation of GetBootID.
+func getBootID(filePath string) (string, error) {
+	bootID, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bootID)), nil
+}
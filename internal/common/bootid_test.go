@@ -0,0 +1,29 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBootID(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "boot_id")
+	require.Nil(t, os.WriteFile(filePath, []byte("0b3e2b69-3c1e-4b4c-a4e5-1e6f4a7d0c2d\n"), 0o600))
+
+	id, err := getBootID(filePath)
+	require.Nil(t, err)
+
+	assert.Equal(t, "0b3e2b69-3c1e-4b4c-a4e5-1e6f4a7d0c2d", id)
+}
+
+func TestGetBootID_IDFileDoesNotExist(t *testing.T) {
+	t.Parallel()
+
+	_, err := getBootID(filepath.Join(t.TempDir(), "boot_id"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
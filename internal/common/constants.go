@@ -1,9 +1,10 @@
 package common
 
 const (
-	ActionLoginIdentifier = "UserLogin"
-	ActionUserAction      = "UserAction"
-	ActionSystemAction    = "SystemAction"
+	ActionLoginIdentifier  = "UserLogin"
+	ActionLogoutIdentifier = "UserLogout"
+	ActionUserAction       = "UserAction"
+	ActionSystemAction     = "SystemAction"
 )
 
 const (
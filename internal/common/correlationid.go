@@ -0,0 +1,21 @@
+package common
+
+import (
+	"github.com/google/uuid"
+)
+
+// sessionCorrelationIDNamespace is the namespace of the name-based
+// UUIDs returned by SessionCorrelationID. It must never change.
+var sessionCorrelationIDNamespace = uuid.MustParse("3c4b8a3e-5f0b-4c1e-9d4a-7f2d6b1e8c90")
+
+// SessionCorrelationID returns an identifier for a Linux audit session.
+// Audit session IDs reset when the computer reboots and are only unique
+// to a single computer. The returned identifier is derived from the
+// computer's machine ID, its boot ID (refer to GetBootID) and the audit
+// session ID, making it globally unique. It is a name-based UUID, which
+// means the same inputs always produce the same identifier (e.g., when
+// replaying logs).
+func SessionCorrelationID(machineID string, bootID string, sessionID string) string {
+	return uuid.NewSHA1(sessionCorrelationIDNamespace,
+		[]byte(machineID+"\x00"+bootID+"\x00"+sessionID)).String()
+}
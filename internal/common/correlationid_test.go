@@ -0,0 +1,28 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionCorrelationID(t *testing.T) {
+	t.Parallel()
+
+	id := SessionCorrelationID("deadbeef", "4ad2d4a5-d3c4-4a6a-9e77-a0b5e0a4c1a1", "5")
+
+	parsed, err := uuid.Parse(id)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Version(5), parsed.Version())
+
+	assert.Equal(t, id, SessionCorrelationID("deadbeef", "4ad2d4a5-d3c4-4a6a-9e77-a0b5e0a4c1a1", "5"))
+
+	assert.NotEqual(t, id, SessionCorrelationID("deadbeef", "4ad2d4a5-d3c4-4a6a-9e77-a0b5e0a4c1a1", "6"))
+	assert.NotEqual(t, id, SessionCorrelationID("deadbeef", "8f9e0f63-3b0f-46a4-9e55-1d2c6f0e7b2d", "5"))
+	assert.NotEqual(t, id, SessionCorrelationID("cafebabe", "4ad2d4a5-d3c4-4a6a-9e77-a0b5e0a4c1a1", "5"))
+
+	// The separator prevents the inputs from running together.
+	assert.NotEqual(t, SessionCorrelationID("ab", "c", "5"), SessionCorrelationID("a", "bc", "5"))
+}
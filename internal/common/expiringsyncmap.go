@@ -0,0 +1,406 @@
+package common
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. It allows tests
+// to control the passage of time.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock that uses the system's time.
+type SystemClock struct{}
+
+// Now returns time.Now.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ExpiringSyncMapConfig configures an ExpiringSyncMap.
+type ExpiringSyncMapConfig[K comparable, V any] struct {
+	// TTL is how long the entries added by Store live for.
+	// Zero means they never expire.
+	TTL time.Duration
+
+	// RefreshOnAccess extends the deadline of an entry by
+	// TTL each time it is accessed by Load or
+	// WithLockedValueDo. Has does not refresh entries.
+	RefreshOnAccess bool
+
+	// OnEvict, if non-nil, is called with each entry that expires.
+	// It is not called for entries that are deleted or replaced.
+	// It is called while the map is locked, so it must not call
+	// any methods on the map.
+	OnEvict func(key K, value V)
+
+	// Index, if non-nil, returns a secondary key of a value, if it
+	// has one (e.g., the parent PID of the process whose PID is the
+	// entry's key). Entries can then be found by their secondary
+	// keys using LoadByIndex, without iterating over the map.
+	Index func(value V) (K, bool)
+
+	// Clock tells the current time. SystemClock is used if it is nil.
+	Clock Clock
+}
+
+// NewExpiringSyncMap returns a new ExpiringSyncMap.
+func NewExpiringSyncMap[K comparable, V any](config ExpiringSyncMapConfig[K, V]) *ExpiringSyncMap[K, V] {
+	if config.Clock == nil {
+		config.Clock = SystemClock{}
+	}
+
+	return &ExpiringSyncMap[K, V]{
+		m:      make(map[K]*expiringEntry[K, V]),
+		index:  make(map[K]map[K]struct{}),
+		config: config,
+	}
+}
+
+// ExpiringSyncMap is a GenericSyncMap whose entries expire after a
+// deadline. Expired entries are evicted by every method that locks
+// the map, so they are never returned. Eviction only visits expired
+// entries, which makes it cheap enough to replace periodic cleanup,
+// unless something must happen when an entry expires while the map
+// is not in use (refer to DeleteExpired).
+type ExpiringSyncMap[K comparable, V any] struct {
+	m        map[K]*expiringEntry[K, V]
+	deadline expiringEntryQueue[K, V]
+	config   ExpiringSyncMapConfig[K, V]
+	mtx      sync.Mutex
+
+	// index maps the secondary keys returned by
+	// ExpiringSyncMapConfig.Index to the keys of
+	// the entries that have them.
+	index map[K]map[K]struct{}
+}
+
+// Load returns the value stored in the map for a key, or nil if no
+// value is present. The ok result indicates whether value was found
+// in the map.
+func (m *ExpiringSyncMap[K, V]) Load(key K) (V, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+
+	entry, ok := m.m[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	m.refreshUnsafe(entry)
+
+	return entry.value, true
+}
+
+// LoadByIndex returns the key and value of the entry whose secondary
+// key (refer to ExpiringSyncMapConfig.Index) is indexKey. The returned
+// int is the number of entries that have the secondary key. The key
+// and value are only returned if it is one.
+func (m *ExpiringSyncMap[K, V]) LoadByIndex(indexKey K) (K, V, int) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+
+	var key K
+	var value V
+
+	keys := m.index[indexKey]
+	if len(keys) != 1 {
+		return key, value, len(keys)
+	}
+
+	for k := range keys {
+		key = k
+	}
+
+	entry := m.m[key]
+
+	m.refreshUnsafe(entry)
+
+	return key, entry.value, 1
+}
+
+// Has returns true if the key is present in the map.
+func (m *ExpiringSyncMap[K, V]) Has(key K) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+
+	_, ok := m.m[key]
+	return ok
+}
+
+// Store sets the value for a key. The entry expires after
+// ExpiringSyncMapConfig.TTL, if it is non-zero.
+func (m *ExpiringSyncMap[K, V]) Store(key K, value V) {
+	var deadline time.Time
+	if m.config.TTL > 0 {
+		deadline = m.config.Clock.Now().Add(m.config.TTL)
+	}
+
+	m.StoreUntil(key, value, deadline)
+}
+
+// StoreUntil sets the value for a key. The entry expires at
+// deadline. A zero deadline means the entry never expires.
+func (m *ExpiringSyncMap[K, V]) StoreUntil(key K, value V, deadline time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.DeleteUnsafe(key)
+
+	entry := &expiringEntry[K, V]{
+		key:      key,
+		value:    value,
+		deadline: deadline,
+		index:    -1,
+	}
+
+	m.m[key] = entry
+
+	if indexKey, ok := m.indexKeyOf(value); ok {
+		keys, ok := m.index[indexKey]
+		if !ok {
+			keys = make(map[K]struct{})
+			m.index[indexKey] = keys
+		}
+
+		keys[key] = struct{}{}
+	}
+
+	if !deadline.IsZero() {
+		heap.Push(&m.deadline, entry)
+	}
+
+	m.evictUnsafe()
+}
+
+// Refresh extends the deadline of an entry by
+// ExpiringSyncMapConfig.TTL. It returns false
+// if the key is not present in the map. The
+// deadline is not changed if the TTL is zero,
+// like Store.
+func (m *ExpiringSyncMap[K, V]) Refresh(key K) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+
+	entry, ok := m.m[key]
+	if !ok {
+		return false
+	}
+
+	if m.config.TTL <= 0 {
+		return true
+	}
+
+	m.setDeadlineUnsafe(entry, m.config.Clock.Now().Add(m.config.TTL))
+
+	return true
+}
+
+// Delete deletes the value for a key.
+func (m *ExpiringSyncMap[K, V]) Delete(key K) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.DeleteUnsafe(key)
+}
+
+// DeleteUnsafe deletes the value for a key without locking.
+func (m *ExpiringSyncMap[K, V]) DeleteUnsafe(key K) {
+	entry, ok := m.m[key]
+	if !ok {
+		return
+	}
+
+	delete(m.m, key)
+
+	if indexKey, ok := m.indexKeyOf(entry.value); ok {
+		keys := m.index[indexKey]
+		delete(keys, key)
+
+		if len(keys) == 0 {
+			delete(m.index, indexKey)
+		}
+	}
+
+	if entry.index >= 0 {
+		heap.Remove(&m.deadline, entry.index)
+	}
+}
+
+// indexKeyOf returns the secondary key of a value,
+// if ExpiringSyncMapConfig.Index is set.
+func (m *ExpiringSyncMap[K, V]) indexKeyOf(value V) (K, bool) {
+	if m.config.Index == nil {
+		var zero K
+		return zero, false
+	}
+
+	return m.config.Index(value)
+}
+
+// DeleteExpired evicts the expired entries. Expired entries are evicted
+// by the map's other methods as well. This method allows OnEvict to be
+// called for entries that expire while the map is not in use.
+func (m *ExpiringSyncMap[K, V]) DeleteExpired() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+}
+
+// Len returns the number of items in the map.
+func (m *ExpiringSyncMap[K, V]) Len() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+
+	return len(m.m)
+}
+
+// Iterate iterates over the map and calls the callback for each key/value
+// pair. If the callback returns false, the iteration stops.
+// Note that the callback is called while the map is locked, so it should
+// not call any methods on the map except DeleteUnsafe.
+func (m *ExpiringSyncMap[K, V]) Iterate(cb func(key K, value V) bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+
+	for k, entry := range m.m {
+		if !cb(k, entry.value) {
+			break
+		}
+	}
+}
+
+// WithLockedValueDo calls the callback with the value for the given key,
+// if it exists. The callback is called while the map is locked, so modifying
+// values in the map should be safe. Calling locking methods on the map
+// from the callback will cause a deadlock.
+func (m *ExpiringSyncMap[K, V]) WithLockedValueDo(key K, cb func(value V) error) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+
+	entry, ok := m.m[key]
+	if !ok {
+		return nil
+	}
+
+	m.refreshUnsafe(entry)
+
+	return cb(entry.value)
+}
+
+// WithLockedValuesDo calls the callback with a copy of the map's
+// key/value pairs. The callback is called while the map is locked, so
+// it may compare the values and delete some of them using DeleteUnsafe
+// without the map changing in between. Calling locking methods on the
+// map from the callback will cause a deadlock.
+func (m *ExpiringSyncMap[K, V]) WithLockedValuesDo(cb func(values map[K]V) error) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.evictUnsafe()
+
+	values := make(map[K]V, len(m.m))
+	for k, entry := range m.m {
+		values[k] = entry.value
+	}
+
+	return cb(values)
+}
+
+// evictUnsafe deletes the expired entries and calls
+// ExpiringSyncMapConfig.OnEvict for each of them.
+func (m *ExpiringSyncMap[K, V]) evictUnsafe() {
+	now := m.config.Clock.Now()
+
+	for len(m.deadline) > 0 && !m.deadline[0].deadline.After(now) {
+		entry := m.deadline[0]
+
+		m.DeleteUnsafe(entry.key)
+
+		if m.config.OnEvict != nil {
+			m.config.OnEvict(entry.key, entry.value)
+		}
+	}
+}
+
+// refreshUnsafe extends the deadline of an accessed
+// entry if ExpiringSyncMapConfig.RefreshOnAccess is set.
+func (m *ExpiringSyncMap[K, V]) refreshUnsafe(entry *expiringEntry[K, V]) {
+	if m.config.RefreshOnAccess && m.config.TTL > 0 {
+		m.setDeadlineUnsafe(entry, m.config.Clock.Now().Add(m.config.TTL))
+	}
+}
+
+func (m *ExpiringSyncMap[K, V]) setDeadlineUnsafe(entry *expiringEntry[K, V], deadline time.Time) {
+	entry.deadline = deadline
+
+	if entry.index >= 0 {
+		heap.Fix(&m.deadline, entry.index)
+	} else {
+		heap.Push(&m.deadline, entry)
+	}
+}
+
+// expiringEntry is an entry in an ExpiringSyncMap.
+type expiringEntry[K comparable, V any] struct {
+	key      K
+	value    V
+	deadline time.Time
+
+	// index is the entry's index in the map's expiringEntryQueue,
+	// or -1 if it is not in the queue (i.e., it never expires).
+	index int
+}
+
+// expiringEntryQueue is a min-heap of entries ordered
+// by their deadlines. It implements heap.Interface.
+type expiringEntryQueue[K comparable, V any] []*expiringEntry[K, V]
+
+func (q expiringEntryQueue[K, V]) Len() int {
+	return len(q)
+}
+
+func (q expiringEntryQueue[K, V]) Less(i, j int) bool {
+	return q[i].deadline.Before(q[j].deadline)
+}
+
+func (q expiringEntryQueue[K, V]) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *expiringEntryQueue[K, V]) Push(x any) {
+	entry := x.(*expiringEntry[K, V]) //nolint:forcetypeassert // Only called by heap.Push.
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *expiringEntryQueue[K, V]) Pop() any {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*q = old[:n-1]
+	return entry
+}
@@ -0,0 +1,330 @@
+package common
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+func newTestExpiringSyncMap(t *testing.T, config ExpiringSyncMapConfig[int, string]) (*ExpiringSyncMap[int, string], *testtools.TestClock, *[]int) {
+	t.Helper()
+
+	clock := testtools.NewTestClock(time.Unix(1680000000, 0))
+	evicted := &[]int{}
+
+	config.Clock = clock
+	config.OnEvict = func(key int, value string) {
+		*evicted = append(*evicted, key)
+	}
+
+	return NewExpiringSyncMap(config), clock, evicted
+}
+
+func TestNewExpiringSyncMap(t *testing.T) {
+	t.Parallel()
+
+	m := NewExpiringSyncMap(ExpiringSyncMapConfig[int, string]{})
+
+	assert.NotNil(t, m.m)
+	assert.Equal(t, SystemClock{}, m.config.Clock)
+}
+
+func TestExpiringSyncMap_Store_TTL(t *testing.T) {
+	t.Parallel()
+
+	m, clock, evicted := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+	})
+
+	m.Store(1, "foo")
+	clock.Advance(30 * time.Second)
+	m.Store(2, "bar")
+
+	clock.Advance(30*time.Second - time.Nanosecond)
+	assert.True(t, m.Has(1))
+	assert.Equal(t, 2, m.Len())
+
+	clock.Advance(time.Nanosecond)
+	assert.False(t, m.Has(1))
+	assert.True(t, m.Has(2))
+	assert.Equal(t, []int{1}, *evicted)
+
+	clock.Advance(time.Hour)
+	_, ok := m.Load(2)
+	assert.False(t, ok)
+	assert.Equal(t, []int{1, 2}, *evicted)
+	assert.Equal(t, 0, m.Len())
+}
+
+func TestExpiringSyncMap_StoreUntil(t *testing.T) {
+	t.Parallel()
+
+	m, clock, evicted := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+	})
+
+	m.StoreUntil(1, "forever", time.Time{})
+	m.StoreUntil(2, "soon", clock.Now().Add(time.Second))
+	m.StoreUntil(3, "later", clock.Now().Add(time.Hour))
+	m.StoreUntil(4, "expired", clock.Now().Add(-time.Second))
+
+	assert.Equal(t, []int{4}, *evicted)
+
+	clock.Advance(time.Minute)
+	m.DeleteExpired()
+	assert.Equal(t, []int{4, 2}, *evicted)
+
+	clock.Advance(24 * time.Hour)
+	m.DeleteExpired()
+	assert.Equal(t, []int{4, 2, 3}, *evicted)
+
+	value, ok := m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, "forever", value)
+}
+
+func TestExpiringSyncMap_Store_Replace(t *testing.T) {
+	t.Parallel()
+
+	m, clock, evicted := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+	})
+
+	m.Store(1, "foo")
+	clock.Advance(30 * time.Second)
+	m.Store(1, "bar")
+	clock.Advance(45 * time.Second)
+
+	value, ok := m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", value)
+	assert.Empty(t, *evicted, "replaced entries are not evicted")
+}
+
+func TestExpiringSyncMap_Delete(t *testing.T) {
+	t.Parallel()
+
+	m, clock, evicted := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+	})
+
+	m.Store(1, "foo")
+	m.Store(2, "bar")
+	m.Delete(1)
+
+	clock.Advance(time.Hour)
+	m.DeleteExpired()
+
+	assert.Equal(t, []int{2}, *evicted, "deleted entries are not evicted")
+}
+
+func TestExpiringSyncMap_RefreshOnAccess(t *testing.T) {
+	t.Parallel()
+
+	m, clock, evicted := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL:             time.Minute,
+		RefreshOnAccess: true,
+	})
+
+	m.Store(1, "foo")
+	m.Store(2, "bar")
+	m.Store(3, "baz")
+
+	clock.Advance(45 * time.Second)
+
+	_, ok := m.Load(1)
+	require.True(t, ok)
+
+	err := m.WithLockedValueDo(2, func(string) error { return nil })
+	require.NoError(t, err)
+
+	// Has does not refresh entries.
+	require.True(t, m.Has(3))
+
+	clock.Advance(45 * time.Second)
+	assert.Equal(t, 2, m.Len())
+	assert.Equal(t, []int{3}, *evicted)
+
+	clock.Advance(15 * time.Second)
+	assert.Equal(t, 0, m.Len())
+
+	sort.Ints(*evicted)
+	assert.Equal(t, []int{1, 2, 3}, *evicted)
+}
+
+func TestExpiringSyncMap_Refresh(t *testing.T) {
+	t.Parallel()
+
+	m, clock, _ := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+	})
+
+	m.Store(1, "foo")
+	m.StoreUntil(2, "bar", time.Time{})
+
+	clock.Advance(45 * time.Second)
+	assert.True(t, m.Refresh(1))
+	assert.True(t, m.Refresh(2), "entries without a deadline gain one")
+	assert.False(t, m.Refresh(3))
+
+	clock.Advance(45 * time.Second)
+	assert.True(t, m.Has(1))
+	assert.True(t, m.Has(2))
+
+	clock.Advance(15 * time.Second)
+	assert.False(t, m.Has(1))
+	assert.False(t, m.Has(2))
+}
+
+func TestExpiringSyncMap_Refresh_NoTTL(t *testing.T) {
+	t.Parallel()
+
+	m, clock, evicted := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{})
+
+	m.Store(1, "foo")
+	m.StoreUntil(2, "bar", clock.Now().Add(time.Minute))
+
+	assert.True(t, m.Refresh(1))
+	assert.True(t, m.Refresh(2))
+
+	clock.Advance(time.Hour)
+	assert.True(t, m.Has(1), "entries without a deadline never expire")
+	assert.False(t, m.Has(2), "entries with a deadline keep it")
+	assert.Equal(t, []int{2}, *evicted)
+}
+
+func TestExpiringSyncMap_Iterate(t *testing.T) {
+	t.Parallel()
+
+	m, clock, _ := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+	})
+
+	m.Store(1, "foo")
+	clock.Advance(30 * time.Second)
+	m.Store(2, "bar")
+	m.Store(3, "baz")
+	clock.Advance(30 * time.Second)
+
+	var keys []int
+	m.Iterate(func(key int, _ string) bool {
+		keys = append(keys, key)
+
+		// Deleting entries while iterating is allowed.
+		m.DeleteUnsafe(key)
+
+		return true
+	})
+
+	sort.Ints(keys)
+	assert.Equal(t, []int{2, 3}, keys)
+	assert.Equal(t, 0, m.Len())
+}
+
+func TestExpiringSyncMap_WithLockedValueDo(t *testing.T) {
+	t.Parallel()
+
+	m, clock, _ := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+	})
+
+	m.Store(1, "foo")
+
+	exp := errors.New("blam")
+	err := m.WithLockedValueDo(1, func(value string) error {
+		assert.Equal(t, "foo", value)
+		return exp
+	})
+	assert.ErrorIs(t, err, exp)
+
+	clock.Advance(time.Minute)
+
+	called := false
+	err = m.WithLockedValueDo(1, func(string) error {
+		called = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, called, "expired entries should not be accessed")
+}
+
+func TestExpiringSyncMap_WithLockedValuesDo(t *testing.T) {
+	t.Parallel()
+
+	m, clock, _ := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+	})
+
+	m.Store(1, "foo")
+	clock.Advance(30 * time.Second)
+	m.Store(2, "bar")
+	m.Store(3, "baz")
+	clock.Advance(30 * time.Second)
+
+	exp := errors.New("blam")
+	err := m.WithLockedValuesDo(func(values map[int]string) error {
+		assert.Equal(t, map[int]string{2: "bar", 3: "baz"}, values)
+
+		m.DeleteUnsafe(2)
+
+		return exp
+	})
+	assert.ErrorIs(t, err, exp)
+
+	assert.Equal(t, 1, m.Len())
+	assert.True(t, m.Has(3))
+}
+
+func TestExpiringSyncMap_LoadByIndex(t *testing.T) {
+	t.Parallel()
+
+	m, clock, _ := newTestExpiringSyncMap(t, ExpiringSyncMapConfig[int, string]{
+		TTL: time.Minute,
+		Index: func(value string) (int, bool) {
+			// The secondary key is the value's length.
+			return len(value), value != ""
+		},
+	})
+
+	m.Store(1, "foo")
+	clock.Advance(30 * time.Second)
+	m.Store(2, "bar")
+	m.Store(3, "blam")
+	m.Store(4, "")
+
+	key, value, num := m.LoadByIndex(4)
+	assert.Equal(t, 1, num)
+	assert.Equal(t, 3, key)
+	assert.Equal(t, "blam", value)
+
+	_, _, num = m.LoadByIndex(3)
+	assert.Equal(t, 2, num, "the match is ambiguous")
+
+	_, _, num = m.LoadByIndex(0)
+	assert.Equal(t, 0, num, "values without a secondary key are not indexed")
+
+	// Expired, deleted and replaced entries are removed from the index.
+	clock.Advance(30 * time.Second)
+
+	key, _, num = m.LoadByIndex(3)
+	assert.Equal(t, 1, num)
+	assert.Equal(t, 2, key)
+
+	m.Store(2, "blah!")
+	_, _, num = m.LoadByIndex(3)
+	assert.Equal(t, 0, num)
+
+	m.Delete(3)
+	_, _, num = m.LoadByIndex(4)
+	assert.Equal(t, 0, num)
+
+	key, _, num = m.LoadByIndex(5)
+	assert.Equal(t, 1, num)
+	assert.Equal(t, 2, key)
+}
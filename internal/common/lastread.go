@@ -4,61 +4,76 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 )
 
-const (
-	// TimeFlushPath is a file that contains the timestamp
-	// of the last-read journal entry.
-	//
-	// Refer to the RealtimeTimestamp field in the
-	// sdjournal.JournalEntry struct for details.
-	TimeFlushPath = "/var/run/audito-maldito/flush_time"
-)
+// CursorFlushPath is a file that contains the cursor
+// (i.e., the __CURSOR field) of the last-read journal entry.
+//
+// Refer to "man sd_journal_get_cursor" for details.
+const CursorFlushPath = "/var/run/audito-maldito/journal_cursor"
 
 const (
-	flushDirPerms = 0o750
+	flushDirPerms  = 0o750
+	flushFilePerms = 0o640
 )
 
-// GetLastRead attempts to read the last-read journal timestamp saved
-// by this application. This allows the application to start reading
-// from wherever it left off previously.
-func GetLastRead() (timestampUnix uint64, err error) {
-	return doGetLastRead(TimeFlushPath)
+// GetLastReadCursor attempts to read the last-read journal cursor
+// saved by this application. This allows the application to start
+// reading from the entry after the one it read last.
+func GetLastReadCursor() (string, error) {
+	return doGetLastReadCursor(CursorFlushPath)
 }
 
-// doGetLastRead is the actual (testable) implementation of GetLastRead.
-func doGetLastRead(path string) (timestampUnix uint64, err error) {
+// doGetLastReadCursor is the actual (testable) implementation
+// of GetLastReadCursor.
+func doGetLastReadCursor(path string) (string, error) {
 	contents, err := os.ReadFile(path)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
 
-	const theDecimalNumeralSystemIsTheStandardSystemForDenotingIntegerAndNonIntegerNumbers = 10
-	const inComputerArchitecture64BitIntegersAreThoseThat64BitsWide = 64
-	lastRead, err := strconv.ParseUint(
-		string(contents),
-		theDecimalNumeralSystemIsTheStandardSystemForDenotingIntegerAndNonIntegerNumbers,
-		inComputerArchitecture64BitIntegersAreThoseThat64BitsWide)
+	cursor := strings.TrimSpace(string(contents))
+	if cursor == "" {
+		return "", fmt.Errorf("flush file is empty: %s", path)
+	}
+
+	return cursor, nil
+}
+
+// FlushLastReadCursor saves the provided journal cursor so that it
+// can later be retrieved by GetLastReadCursor.
+func FlushLastReadCursor(cursor string) error {
+	return doFlushLastReadCursor(CursorFlushPath, cursor)
+}
+
+// doFlushLastReadCursor is the actual (testable) implementation
+// of FlushLastReadCursor.
+//
+// The cursor is written using WriteFileAtomic. This prevents
+// a partially-written file from being read if the application
+// exits mid-write.
+func doFlushLastReadCursor(path string, cursor string) error {
+	err := WriteFileAtomic(path, []byte(cursor), flushFilePerms)
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("failed to write flush file: %w", err)
 	}
 
-	return lastRead, nil
+	return nil
 }
 
 // EnsureFlushDirectory ensures that the directory where we store the
-// last-read timestamp file exists.
+// last-read cursor file exists.
 func EnsureFlushDirectory() error {
-	return ensureFlushDirectory(TimeFlushPath)
+	return ensureFlushDirectory(CursorFlushPath)
 }
 
 // ensureFlushDirectory is the actual (testable) implementation
 // of EnsureFlushDirectory.
-func ensureFlushDirectory(timeFlushFilePath string) error {
-	_, err := os.Stat(filepath.Dir(timeFlushFilePath))
+func ensureFlushDirectory(flushFilePath string) error {
+	_, err := os.Stat(filepath.Dir(flushFilePath))
 	if os.IsNotExist(err) {
-		err := os.MkdirAll(filepath.Dir(timeFlushFilePath), flushDirPerms)
+		err := os.MkdirAll(filepath.Dir(flushFilePath), flushDirPerms)
 		if err != nil {
 			return fmt.Errorf("failed to create flush directory: %w", err)
 		}
@@ -4,65 +4,55 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func Test_DoGetLastRead(t *testing.T) {
+func Test_DoFlushLastReadCursor(t *testing.T) {
 	t.Parallel()
 
-	tmpdir := t.TempDir()
+	fPath := filepath.Join(t.TempDir(), "journal_cursor")
 
-	t.Run("InvalidFile", func(t *testing.T) {
-		t.Parallel()
+	exp := "s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece7;b=6c7c6013a8e14d6fb1a2b36d4b4e5b1c;" +
+		"m=139ed8a2d;t=5eb65d6f2d4a0;x=5b0a3c0b8e1b7d31"
+	err := doFlushLastReadCursor(fPath, exp)
+	assert.NoError(t, err)
 
-		fPath := filepath.Join(tmpdir, "never gonna give you up")
+	cursor, err := doGetLastReadCursor(fPath)
+	assert.NoError(t, err)
+	assert.Equal(t, exp, cursor)
 
-		_, err := doGetLastRead(fPath)
-		assert.ErrorIs(t, err, os.ErrNotExist)
-	})
+	exp = "s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece8;b=6c7c6013a8e14d6fb1a2b36d4b4e5b1c;" +
+		"m=139ed8a2e;t=5eb65d6f2d4a1;x=5b0a3c0b8e1b7d32"
+	err = doFlushLastReadCursor(fPath, exp)
+	assert.NoError(t, err)
 
-	t.Run("EmptyFile", func(t *testing.T) {
-		t.Parallel()
+	cursor, err = doGetLastReadCursor(fPath)
+	assert.NoError(t, err)
+	assert.Equal(t, exp, cursor)
 
-		fPath := filepath.Join(tmpdir, filepath.Base(t.Name()))
-
-		err := os.WriteFile(fPath, []byte{}, 0o600)
-		assert.NoError(t, err)
-
-		_, err = doGetLastRead(fPath)
-		assert.ErrorIs(t, err, strconv.ErrSyntax)
-	})
-
-	t.Run("NotAnInt", func(t *testing.T) {
-		t.Parallel()
-
-		fPath := filepath.Join(tmpdir, filepath.Base(t.Name()))
-
-		err := os.WriteFile(fPath, []byte{0x41, 0x41, 0x41, 0x41, 0x0a}, 0o600)
-		assert.NoError(t, err)
-
-		_, err = doGetLastRead(fPath)
-		assert.ErrorIs(t, err, strconv.ErrSyntax)
-	})
+	_, err = os.Stat(fPath + ".tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
 
-	t.Run("ValidInt", func(t *testing.T) {
-		t.Parallel()
+func Test_DoFlushLastReadCursor_DirDoesNotExist(t *testing.T) {
+	t.Parallel()
 
-		fPath := filepath.Join(tmpdir, filepath.Base(t.Name()))
+	fPath := filepath.Join(t.TempDir(), "does-not-exist", "journal_cursor")
 
-		var exp uint64 = 1666371243954575
+	err := doFlushLastReadCursor(fPath, "s=abc")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
 
-		err := os.WriteFile(fPath, []byte(strconv.Itoa(int(exp))), 0o600)
-		assert.NoError(t, err)
+func Test_DoGetLastReadCursor_Empty(t *testing.T) {
+	t.Parallel()
 
-		i, err := doGetLastRead(fPath)
-		assert.NoError(t, err)
+	fPath := filepath.Join(t.TempDir(), "journal_cursor")
+	assert.NoError(t, os.WriteFile(fPath, []byte("\n"), 0o600))
 
-		assert.Equal(t, exp, i)
-	})
+	_, err := doGetLastReadCursor(fPath)
+	assert.Error(t, err)
 }
 
 func TestEnsureFlushDirectory_DirAlreadyExists(t *testing.T) {
@@ -1,11 +1,32 @@
 package common
 
-import "github.com/metal-toolbox/auditevent"
+import (
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+)
 
 type RemoteUserLogin struct {
 	Source     *auditevent.AuditEvent
 	PID        int
 	CredUserID string
+
+	// ParentPID is the PID of the parent of the process
+	// responsible for the login, if it is known. It allows
+	// the login to be correlated with an audit session that
+	// was started by that process' parent or child.
+	ParentPID int
+
+	// LowConfidence is true if the login was inferred (e.g., from
+	// the processes of an existing session) rather than observed
+	// in the logs of the service that the user logged in through.
+	LowConfidence bool
+
+	// ObservedAt is the time at which the login was read from the
+	// logs. It is later than Source.LoggedAt if the logs were
+	// delayed. Remote user logins expire relative to it, if it is
+	// set, so a backlog of logs does not expire them prematurely.
+	ObservedAt time.Time
 }
 
 func (o RemoteUserLogin) Validate() error {
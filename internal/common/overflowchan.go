@@ -0,0 +1,317 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+// ChanOverflowPolicy determines what an OverflowChan does with
+// a value that is sent while its buffer is full.
+type ChanOverflowPolicy string
+
+const (
+	// ChanOverflowBlock means the sender waits until the buffer
+	// has room for the value. This is the default.
+	ChanOverflowBlock ChanOverflowPolicy = "block"
+
+	// ChanOverflowDropNewest means the value is discarded.
+	ChanOverflowDropNewest ChanOverflowPolicy = "drop-newest"
+
+	// ChanOverflowDropOldest means the oldest value in the
+	// buffer is discarded to make room for the value.
+	ChanOverflowDropOldest ChanOverflowPolicy = "drop-oldest"
+
+	// ChanOverflowSpill means the value is written to a file, along
+	// with the values sent after it, until the buffer has room for
+	// them. The sender waits if the file reaches its maximum size.
+	ChanOverflowSpill ChanOverflowPolicy = "spill"
+)
+
+// chanDropShutdown is the policy label of the values that
+// are discarded because they were still buffered when an
+// OverflowChan stopped.
+const chanDropShutdown = "shutdown"
+
+// DefaultSpillMaxBytes is the default maximum size of the file
+// that an OverflowChan spills values to.
+const DefaultSpillMaxBytes = 256 << 20
+
+// overflowChanDepthInterval is how often an OverflowChan
+// reports its depth while no values are sent.
+const overflowChanDepthInterval = time.Second
+
+// ParseChanOverflowPolicy parses s into a ChanOverflowPolicy.
+func ParseChanOverflowPolicy(s string) (ChanOverflowPolicy, error) {
+	switch ChanOverflowPolicy(s) {
+	case ChanOverflowBlock, ChanOverflowDropNewest, ChanOverflowDropOldest, ChanOverflowSpill:
+		return ChanOverflowPolicy(s), nil
+	default:
+		return "", fmt.Errorf("unknown channel overflow policy: %q", s)
+	}
+}
+
+// OverflowChanConfig configures an OverflowChan.
+type OverflowChanConfig struct {
+	// Name identifies the channel in metrics and file names
+	// (e.g., "audit-lines").
+	Name string
+
+	// BufferSize is the number of values that are buffered in
+	// memory. It must be greater than zero unless Policy is
+	// ChanOverflowBlock.
+	BufferSize int
+
+	// Policy determines what happens to values that are sent while
+	// the buffer is full. ChanOverflowBlock is used if it is empty.
+	Policy ChanOverflowPolicy
+
+	// SpillDir is the directory in which the spill file is created
+	// when Policy is ChanOverflowSpill. The file is named after the
+	// channel and is removed when the OverflowChan stops.
+	SpillDir string
+
+	// SpillMaxBytes is the maximum size of the spill file.
+	// Zero means DefaultSpillMaxBytes.
+	SpillMaxBytes int64
+}
+
+// NewOverflowChan returns a new OverflowChan. Its Run method must be
+// called for values to move from the input channel to the output
+// channel. The logger may be nil.
+func NewOverflowChan[T any](config OverflowChanConfig, m *metrics.PrometheusMetricsProvider, l *zap.SugaredLogger) (*OverflowChan[T], error) {
+	if l == nil {
+		l = zap.NewNop().Sugar()
+	}
+
+	if config.Policy == "" {
+		config.Policy = ChanOverflowBlock
+	}
+
+	if config.BufferSize < 0 || (config.BufferSize == 0 && config.Policy != ChanOverflowBlock) {
+		return nil, fmt.Errorf("channel %s: buffer size must be greater than zero when using the %s policy",
+			config.Name, config.Policy)
+	}
+
+	if config.Policy == ChanOverflowSpill && config.SpillDir == "" {
+		return nil, fmt.Errorf("channel %s: a spill directory is required when using the %s policy",
+			config.Name, config.Policy)
+	}
+
+	if config.SpillMaxBytes == 0 {
+		config.SpillMaxBytes = DefaultSpillMaxBytes
+	}
+
+	return &OverflowChan[T]{
+		config:  config,
+		metrics: m,
+		l:       l,
+		in:      make(chan T),
+		out:     make(chan T, config.BufferSize),
+	}, nil
+}
+
+// OverflowChan is a buffered channel that applies a ChanOverflowPolicy
+// when its buffer is full. Values are sent to the channel returned by
+// In and received from the channel returned by Out. Unless the policy
+// is ChanOverflowBlock, senders do not wait for receivers, which keeps
+// slow receivers from stalling the sources that values are read from
+// (e.g., named pipes).
+type OverflowChan[T any] struct {
+	config  OverflowChanConfig
+	metrics *metrics.PrometheusMetricsProvider
+	l       *zap.SugaredLogger
+	in      chan T
+	out     chan T
+
+	// spill is only accessed by Run.
+	spill *spillQueue[T]
+
+	// unsent is the number of values that were received by Run
+	// but not buffered before ctx was canceled.
+	unsent int
+}
+
+// In returns the channel that values are sent to.
+func (o *OverflowChan[T]) In() chan<- T {
+	return o.in
+}
+
+// Out returns the channel that values are received from.
+func (o *OverflowChan[T]) Out() <-chan T {
+	return o.out
+}
+
+// Run moves values from the input channel to the output channel until
+// ctx is canceled. It returns an error if the spill file cannot be used.
+// Values that are still buffered when ctx is canceled are discarded,
+// and their number is logged and reported as the channel's drops.
+func (o *OverflowChan[T]) Run(ctx context.Context) error {
+	if o.config.Policy == ChanOverflowSpill {
+		spillPath := filepath.Join(o.config.SpillDir, o.config.Name+".spill")
+
+		var err error
+		o.spill, err = newSpillQueue[T](spillPath, o.config.SpillMaxBytes)
+		if err != nil {
+			return fmt.Errorf("channel %s: %w", o.config.Name, err)
+		}
+		defer o.spill.close()
+	}
+
+	ticker := time.NewTicker(overflowChanDepthInterval)
+	defer ticker.Stop()
+
+	for {
+		err := o.next(ctx, ticker.C)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				o.discardBuffered()
+				return nil
+			}
+
+			return fmt.Errorf("channel %s: %w", o.config.Name, err)
+		}
+
+		o.reportDepth()
+	}
+}
+
+// next waits for a value to be sent, or for a spilled value to be
+// received, and handles it.
+func (o *OverflowChan[T]) next(ctx context.Context, tick <-chan time.Time) error {
+	in := o.in
+
+	// spillOut is only set when there are spilled values, so spilled
+	// values are received before the values that were sent after them.
+	var spillOut chan T
+	var spilled T
+
+	if o.spill != nil && o.spill.len > 0 {
+		if o.spill.full() {
+			// The sender waits until there is room
+			// in the buffer for the spilled values.
+			in = nil
+		}
+
+		var err error
+		spilled, err = o.spill.peek()
+		if err != nil {
+			return err
+		}
+
+		spillOut = o.out
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tick:
+		return nil
+	case spillOut <- spilled:
+		return o.spill.pop()
+	case value := <-in:
+		return o.handle(ctx, value)
+	}
+}
+
+// handle applies the overflow policy to a value that was sent.
+func (o *OverflowChan[T]) handle(ctx context.Context, value T) error {
+	if o.spill == nil || o.spill.len == 0 {
+		select {
+		case o.out <- value:
+			return nil
+		default:
+		}
+	}
+
+	switch o.config.Policy {
+	case ChanOverflowDropNewest:
+		o.dropped(1)
+	case ChanOverflowDropOldest:
+		select {
+		case <-o.out:
+			o.dropped(1)
+		default:
+			// The receiver made room for the value.
+		}
+
+		o.out <- value
+	case ChanOverflowSpill:
+		err := o.spill.push(value)
+		if err != nil {
+			return err
+		}
+
+		if o.metrics != nil {
+			o.metrics.IncChannelSpills(o.config.Name)
+		}
+	default:
+		select {
+		case <-ctx.Done():
+			o.unsent++
+			return ctx.Err()
+		case o.out <- value:
+		}
+	}
+
+	return nil
+}
+
+// dropped reports that count values were discarded.
+func (o *OverflowChan[T]) dropped(count float64) {
+	if o.metrics != nil {
+		o.metrics.AddChannelDrops(o.config.Name, string(o.config.Policy), count)
+	}
+}
+
+// discardBuffered empties the buffer when Run stops, and reports how
+// many values were discarded. This includes the values in the buffer
+// and in the spill file (which is removed), as well as the unsent ones.
+func (o *OverflowChan[T]) discardBuffered() {
+	discarded := o.unsent
+
+	for empty := false; !empty; {
+		select {
+		case <-o.out:
+			discarded++
+		default:
+			empty = true
+		}
+	}
+
+	if o.spill != nil {
+		discarded += o.spill.len
+	}
+
+	if discarded == 0 {
+		return
+	}
+
+	o.l.Warnf("channel %s: discarded %d buffered values on shutdown", o.config.Name, discarded)
+
+	if o.metrics != nil {
+		o.metrics.AddChannelDrops(o.config.Name, chanDropShutdown, float64(discarded))
+		o.metrics.SetChannelDepth(o.config.Name, 0)
+	}
+}
+
+// reportDepth reports the number of values that are
+// buffered in memory and spilled to the file.
+func (o *OverflowChan[T]) reportDepth() {
+	if o.metrics == nil {
+		return
+	}
+
+	depth := len(o.out)
+	if o.spill != nil {
+		depth += o.spill.len
+	}
+
+	o.metrics.SetChannelDepth(o.config.Name, float64(depth))
+}
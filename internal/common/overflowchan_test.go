@@ -0,0 +1,322 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+const testOverflowChanName = "test"
+
+func TestParseChanOverflowPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []ChanOverflowPolicy{
+		ChanOverflowBlock, ChanOverflowDropNewest, ChanOverflowDropOldest, ChanOverflowSpill,
+	} {
+		parsed, err := ParseChanOverflowPolicy(string(policy))
+		require.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	_, err := ParseChanOverflowPolicy("drop-everything")
+	assert.Error(t, err)
+}
+
+func TestNewOverflowChan_InvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewOverflowChan[int](OverflowChanConfig{Policy: ChanOverflowDropNewest}, nil, nil)
+	assert.Error(t, err, "buffer size must be positive")
+
+	_, err = NewOverflowChan[int](OverflowChanConfig{BufferSize: -1}, nil, nil)
+	assert.Error(t, err, "buffer size must not be negative")
+
+	_, err = NewOverflowChan[int](OverflowChanConfig{BufferSize: 1, Policy: ChanOverflowSpill}, nil, nil)
+	assert.Error(t, err, "spill directory is required")
+
+	c, err := NewOverflowChan[int](OverflowChanConfig{}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, ChanOverflowBlock, c.config.Policy)
+	assert.Equal(t, int64(DefaultSpillMaxBytes), c.config.SpillMaxBytes)
+}
+
+func TestOverflowChan_Block(t *testing.T) {
+	t.Parallel()
+
+	c, _ := newTestOverflowChan(t, OverflowChanConfig{BufferSize: 1})
+
+	// The first value is buffered and the second
+	// value waits for room in the buffer.
+	c.In() <- 1
+	c.In() <- 2
+
+	select {
+	case c.In() <- 3:
+		t.Fatal("expected the sender to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Equal(t, []int{1, 2}, receiveN(t, c, 2))
+}
+
+func TestOverflowChan_DropNewest(t *testing.T) {
+	t.Parallel()
+
+	c, registry := newTestOverflowChan(t, OverflowChanConfig{
+		BufferSize: 2,
+		Policy:     ChanOverflowDropNewest,
+	})
+
+	sendAll(c, 1, 2, 3, 4)
+
+	require.Eventually(t, func() bool {
+		return testChannelMetric(t, registry, "channel_dropped_total")[testOverflowChanName+"drop-newest"] == 2
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, []int{1, 2}, receiveN(t, c, 2))
+	assert.Empty(t, c.Out())
+}
+
+func TestOverflowChan_DropOldest(t *testing.T) {
+	t.Parallel()
+
+	c, registry := newTestOverflowChan(t, OverflowChanConfig{
+		BufferSize: 2,
+		Policy:     ChanOverflowDropOldest,
+	})
+
+	sendAll(c, 1, 2, 3, 4)
+
+	require.Eventually(t, func() bool {
+		return testChannelMetric(t, registry, "channel_dropped_total")[testOverflowChanName+"drop-oldest"] == 2
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, []int{3, 4}, receiveN(t, c, 2))
+}
+
+func TestOverflowChan_Spill(t *testing.T) {
+	t.Parallel()
+
+	spillDir := t.TempDir()
+
+	c, registry := newTestOverflowChan(t, OverflowChanConfig{
+		BufferSize: 2,
+		Policy:     ChanOverflowSpill,
+		SpillDir:   spillDir,
+	})
+
+	sendAll(c, 1, 2, 3, 4, 5)
+
+	require.Eventually(t, func() bool {
+		return testChannelMetric(t, registry, "channel_spilled_total")[testOverflowChanName] == 3
+	}, time.Second, time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return testChannelMetric(t, registry, "channel_depth")[testOverflowChanName] == 5
+	}, time.Second, time.Millisecond)
+
+	// Spilled values are received in order,
+	// before the values sent after them.
+	assert.Equal(t, []int{1, 2, 3}, receiveN(t, c, 3))
+	sendAll(c, 6)
+	assert.Equal(t, []int{4, 5, 6}, receiveN(t, c, 3))
+
+	spillPath := filepath.Join(spillDir, testOverflowChanName+".spill")
+
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(spillPath)
+		return err == nil && info.Size() == 0
+	}, time.Second, time.Millisecond, "the spill file should be truncated once it is empty")
+}
+
+func TestOverflowChan_SpillFull(t *testing.T) {
+	t.Parallel()
+
+	c, _ := newTestOverflowChan(t, OverflowChanConfig{
+		BufferSize: 1,
+		Policy:     ChanOverflowSpill,
+		SpillDir:   t.TempDir(),
+
+		// Each value is two bytes long ("2\n").
+		SpillMaxBytes: 4,
+	})
+
+	sendAll(c, 1, 2, 3)
+
+	select {
+	case c.In() <- 4:
+		t.Fatal("expected the sender to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go sendAll(c, 4, 5)
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, receiveN(t, c, 5))
+}
+
+func TestOverflowChan_SpillDirDoesNotExist(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewOverflowChan[int](OverflowChanConfig{
+		Name:       testOverflowChanName,
+		BufferSize: 1,
+		Policy:     ChanOverflowSpill,
+		SpillDir:   filepath.Join(t.TempDir(), "nope"),
+	}, nil, nil)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, c.Run(context.Background()), os.ErrNotExist)
+}
+
+func TestOverflowChan_SpillFileRemoved(t *testing.T) {
+	t.Parallel()
+
+	spillDir := t.TempDir()
+
+	c, err := NewOverflowChan[int](OverflowChanConfig{
+		Name:       testOverflowChanName,
+		BufferSize: 1,
+		Policy:     ChanOverflowSpill,
+		SpillDir:   spillDir,
+	}, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	sendAll(c, 1, 2)
+	cancelFn()
+
+	assert.NoError(t, <-done)
+	assert.NoFileExists(t, filepath.Join(spillDir, testOverflowChanName+".spill"))
+}
+
+func TestOverflowChan_DiscardOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	for _, config := range []OverflowChanConfig{
+		{
+			// Two values are buffered, and the third one
+			// is waiting for room when ctx is canceled.
+			BufferSize: 2,
+		},
+		{
+			// Two values are buffered and one is spilled.
+			BufferSize: 2,
+			Policy:     ChanOverflowSpill,
+			SpillDir:   t.TempDir(),
+		},
+	} {
+		registry := prometheus.NewRegistry()
+		config.Name = testOverflowChanName
+
+		c, err := NewOverflowChan[int](config, metrics.NewPrometheusMetricsProviderForRegisterer(registry), nil)
+		require.NoError(t, err)
+
+		ctx, cancelFn := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Run(ctx)
+		}()
+
+		sendAll(c, 1, 2, 3)
+		cancelFn()
+		require.NoError(t, <-done)
+
+		dropped := testChannelMetric(t, registry, "channel_dropped_total")
+		assert.Equal(t, float64(3), dropped[testOverflowChanName+chanDropShutdown], config.Policy)
+		assert.Empty(t, c.Out())
+	}
+}
+
+// newTestOverflowChan creates and runs an OverflowChan
+// that reports metrics to the returned registry.
+func newTestOverflowChan(t *testing.T, config OverflowChanConfig) (*OverflowChan[int], *prometheus.Registry) {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	config.Name = testOverflowChanName
+
+	c, err := NewOverflowChan[int](config, metrics.NewPrometheusMetricsProviderForRegisterer(registry), nil)
+	require.NoError(t, err)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancelFn()
+		assert.NoError(t, <-done)
+	})
+
+	return c, registry
+}
+
+func sendAll(c *OverflowChan[int], values ...int) {
+	for _, value := range values {
+		c.In() <- value
+	}
+}
+
+func receiveN(t *testing.T, c *OverflowChan[int], n int) []int {
+	t.Helper()
+
+	values := make([]int, 0, n)
+
+	for len(values) < n {
+		select {
+		case value := <-c.Out():
+			values = append(values, value)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after receiving %v", values)
+		}
+	}
+
+	return values
+}
+
+// testChannelMetric returns the values of a metric keyed by
+// their concatenated label values.
+func testChannelMetric(t *testing.T, registry *prometheus.Registry, name string) map[string]float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+
+	for _, family := range families {
+		if family.GetName() != metrics.MetricsNamespace+"_"+name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			var key string
+			for _, label := range metric.GetLabel() {
+				key += label.GetValue()
+			}
+
+			if metric.GetGauge() != nil {
+				values[key] = metric.GetGauge().GetValue()
+			} else {
+				values[key] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return values
+}
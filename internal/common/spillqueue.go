@@ -0,0 +1,157 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errSpillQueueFull is returned by spillQueue.push when
+// the queue's file has reached its maximum size.
+var errSpillQueueFull = errors.New("spill queue is full")
+
+// newSpillQueue creates a spillQueue that stores its values in the file
+// found at filePath. The file is truncated if it exists, as values that
+// were spilled before a restart cannot be told apart from values that
+// were already read.
+func newSpillQueue[T any](filePath string, maxBytes int64) (*spillQueue[T], error) {
+	w, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file - %w", err)
+	}
+
+	r, err := os.Open(filePath)
+	if err != nil {
+		_ = w.Close()
+		return nil, fmt.Errorf("failed to open spill file for reading - %w", err)
+	}
+
+	return &spillQueue[T]{
+		filePath: filePath,
+		maxBytes: maxBytes,
+		w:        w,
+		r:        r,
+		br:       bufio.NewReader(r),
+	}, nil
+}
+
+// spillQueue is a FIFO queue of values that are stored in a file as
+// JSON lines. The file is truncated each time the queue is emptied,
+// and values cannot be pushed while it is larger than maxBytes. It is
+// not safe for concurrent use.
+type spillQueue[T any] struct {
+	filePath string
+	maxBytes int64
+
+	w  *os.File
+	r  *os.File
+	br *bufio.Reader
+
+	// size is the size of the file in bytes.
+	size int64
+
+	// len is the number of values in the queue.
+	len int
+
+	// next is the value at the front of the queue, if hasNext is true.
+	// It was already read from the file.
+	next    T
+	hasNext bool
+}
+
+// full returns true if values cannot be pushed until the queue is emptied.
+func (o *spillQueue[T]) full() bool {
+	return o.maxBytes > 0 && o.size >= o.maxBytes
+}
+
+// push adds a value to the back of the queue.
+func (o *spillQueue[T]) push(value T) error {
+	if o.full() {
+		return errSpillQueueFull
+	}
+
+	line, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode value - %w", err)
+	}
+
+	line = append(line, '\n')
+
+	_, err = o.w.Write(line)
+	if err != nil {
+		return fmt.Errorf("failed to write to spill file - %w", err)
+	}
+
+	o.size += int64(len(line))
+	o.len++
+
+	return nil
+}
+
+// peek returns the value at the front of the queue without removing it.
+// The queue must not be empty.
+func (o *spillQueue[T]) peek() (T, error) {
+	if o.hasNext {
+		return o.next, nil
+	}
+
+	var value T
+
+	line, err := o.br.ReadBytes('\n')
+	if err != nil {
+		return value, fmt.Errorf("failed to read from spill file - %w", err)
+	}
+
+	err = json.Unmarshal(line, &value)
+	if err != nil {
+		return value, fmt.Errorf("failed to decode value - %w", err)
+	}
+
+	o.next = value
+	o.hasNext = true
+
+	return value, nil
+}
+
+// pop removes the value at the front of the queue, which must have
+// been returned by peek. The file is truncated if the queue is empty.
+func (o *spillQueue[T]) pop() error {
+	var zero T
+	o.next = zero
+	o.hasNext = false
+	o.len--
+
+	if o.len > 0 {
+		return nil
+	}
+
+	err := o.w.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("failed to truncate spill file - %w", err)
+	}
+
+	_, err = o.r.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("failed to rewind spill file - %w", err)
+	}
+
+	o.br.Reset(o.r)
+	o.size = 0
+
+	return nil
+}
+
+// close closes and removes the queue's file.
+func (o *spillQueue[T]) close() error {
+	_ = o.r.Close()
+
+	err := o.w.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(o.filePath)
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillQueue_RemoteUserLogins(t *testing.T) {
+	t.Parallel()
+
+	filePath := filepath.Join(t.TempDir(), "logins.spill")
+	require.NoError(t, os.WriteFile(filePath, []byte("left over\n"), 0o600))
+
+	q, err := newSpillQueue[RemoteUserLogin](filePath, 0)
+	require.NoError(t, err)
+	defer q.close()
+
+	loggedAt := time.Date(2023, 3, 17, 13, 37, 1, 0, time.UTC)
+
+	for pid := 1; pid <= 2; pid++ {
+		require.NoError(t, q.push(RemoteUserLogin{
+			Source: &auditevent.AuditEvent{
+				Type: ActionLoginIdentifier,
+				Source: auditevent.EventSource{
+					Type:  "IP",
+					Value: "6.6.6.2",
+					Extra: map[string]any{
+						"port": "59145",
+					},
+				},
+				LoggedAt: loggedAt,
+			},
+			PID:        pid,
+			CredUserID: "foo",
+		}))
+	}
+
+	assert.Equal(t, 2, q.len)
+	assert.False(t, q.full())
+
+	for pid := 1; pid <= 2; pid++ {
+		rul, err := q.peek()
+		require.NoError(t, err)
+
+		assert.Equal(t, pid, rul.PID)
+		assert.Equal(t, "foo", rul.CredUserID)
+		assert.Equal(t, "59145", rul.Source.Source.Extra["port"])
+		assert.True(t, loggedAt.Equal(rul.Source.LoggedAt))
+
+		again, err := q.peek()
+		require.NoError(t, err)
+		assert.Equal(t, rul, again, "peek should not remove the value")
+
+		require.NoError(t, q.pop())
+	}
+
+	info, err := os.Stat(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	// The queue can be reused after it is emptied.
+	require.NoError(t, q.push(RemoteUserLogin{PID: 3}))
+	rul, err := q.peek()
+	require.NoError(t, err)
+	assert.Equal(t, 3, rul.PID)
+}
+
+func TestSpillQueue_Full(t *testing.T) {
+	t.Parallel()
+
+	q, err := newSpillQueue[string](filepath.Join(t.TempDir(), "lines.spill"), 10)
+	require.NoError(t, err)
+	defer q.close()
+
+	require.NoError(t, q.push("foo bar")) // "\"foo bar\"\n" is 10 bytes.
+	assert.True(t, q.full())
+	assert.ErrorIs(t, q.push("baz"), errSpillQueueFull)
+}
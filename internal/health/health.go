@@ -24,13 +24,15 @@ var DefaultReadyCheckInterval = 500 * time.Millisecond
 // NewHealth returns a *Health.
 func NewHealth() *Health {
 	return &Health{
-		readyMap: common.NewGenericSyncMap[string, bool](),
+		readyMap: common.NewExpiringSyncMap(common.ExpiringSyncMapConfig[string, bool]{}),
 	}
 }
 
 // Health represents the application's health.
 type Health struct {
-	readyMap *common.GenericSyncMap[string, bool]
+	// readyMap maps components to their readiness.
+	// Its entries never expire.
+	readyMap *common.ExpiringSyncMap[string, bool]
 }
 
 // NewSingleReadinessHealth returns a *Health with its readiness counter
@@ -57,6 +59,14 @@ func (o *Health) OnReady(component string) {
 	o.readyMap.Store(component, true)
 }
 
+// OnNotReady marks an item as not ready. This is meant to be called
+// when a component that was previously marked as ready, using OnReady,
+// is temporarily unable to do its work (e.g., it lost its connection
+// to a data source).
+func (o *Health) OnNotReady(component string) {
+	o.readyMap.Store(component, false)
+}
+
 // WaitForReady returns a channel that is closed when the readiness counter
 // hits zero, signalling that all internal application services are ready.
 func (o *Health) WaitForReady(ctx context.Context) <-chan error {
@@ -91,3 +91,21 @@ func TestHealth_WaitForReadyCtxOrTimeout_TimedOut(t *testing.T) {
 	assert.ErrorIs(t, err, context.DeadlineExceeded)
 	assert.False(t, h.IsReady(), "health should not be ready")
 }
+
+func TestHealth_OnNotReady(t *testing.T) {
+	t.Parallel()
+
+	h := NewSingleReadinessHealth("test")
+	h.OnReady("test")
+
+	assert.True(t, h.IsReady(), "health should be ready")
+
+	h.OnNotReady("test")
+
+	assert.False(t, h.IsReady(), "health should not be ready")
+	assert.Equal(t, ComponentNotReady, h.GetReadyzStatusMap()["test"])
+
+	h.OnReady("test")
+
+	assert.True(t, h.IsReady(), "health should be ready again")
+}
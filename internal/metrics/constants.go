@@ -18,6 +18,16 @@ const (
 	PasswordLogin LoginType = "password"
 	// PasswordLogin is the login type for password logins.
 	UnknownLogin LoginType = "unknown"
+	// KeyboardInteractiveLogin is the login type for keyboard-interactive
+	// logins (e.g., PAM challenges such as one-time passwords).
+	KeyboardInteractiveLogin LoginType = "keyboard-interactive"
+	// GSSAPILogin is the login type for GSSAPI (i.e., Kerberos) logins.
+	GSSAPILogin LoginType = "gssapi"
+	// HostbasedLogin is the login type for host-based logins.
+	HostbasedLogin LoginType = "hostbased"
+	// NoneLogin is the login type for logins that did not require
+	// authentication (e.g., an empty password with PermitEmptyPasswords).
+	NoneLogin LoginType = "none"
 )
 
 type OutcomeType string
@@ -34,4 +44,8 @@ type ErrorType string
 const (
 	// ErrorTypeJournaldWait is the error type for errors waiting for journald.
 	ErrorTypeJournaldWait ErrorType = "journald_wait"
+
+	// ErrorTypeAuditLineParse is the error type for audit log
+	// lines that could not be parsed and were skipped.
+	ErrorTypeAuditLineParse ErrorType = "audit_line_parse"
 )
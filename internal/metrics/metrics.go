@@ -9,7 +9,15 @@ import (
 type PrometheusMetricsProvider struct {
 	auditLogCheck      *prometheus.GaugeVec
 	auditLogModifyTime *prometheus.GaugeVec
+	cacheEvictions     *prometheus.CounterVec
+	cachedEvents       *prometheus.GaugeVec
+	channelDepth       *prometheus.GaugeVec
+	channelDrops       *prometheus.CounterVec
+	channelSpills      *prometheus.CounterVec
 	errors             *prometheus.CounterVec
+	expiredSessions    *prometheus.CounterVec
+	filterDrops        *prometheus.CounterVec
+	namedPipeReconnect *prometheus.CounterVec
 	remoteLogins       *prometheus.CounterVec
 }
 
@@ -28,6 +36,36 @@ func NewPrometheusMetricsProvider() *PrometheusMetricsProvider {
 // - errors_total (counter) - The total number of errors.
 //   - Labels: type
 //   - For more information about the labels, see the `ErrorType`
+//
+// - named_pipe_reconnects_total (counter) - The total number of named pipe reopens.
+//   - Labels: path
+//   - A named pipe is reopened after its writer disconnects
+//
+// - filter_dropped_events_total (counter) - The total number of events dropped by the event filter.
+//   - Labels: rule
+//   - The rule label is the name of the filter rule that dropped the events
+//
+// - cached_audit_events (gauge) - The number of audit events cached while waiting for remote user logins.
+//   - Labels: none
+//
+// - cache_evictions_total (counter) - The total number of cached audit events evicted.
+//   - Labels: policy
+//   - The policy label is the overflow policy applied to the evicted events
+//
+// - unattributed_sessions_expired_total (counter) - The total number of audit sessions that expired.
+//   - Labels: none
+//   - Only sessions whose remote user logins were never found are counted
+//
+// - channel_depth (gauge) - The number of values buffered by an internal channel.
+//   - Labels: channel
+//   - Values spilled to disk are included
+//
+// - channel_dropped_total (counter) - The total number of values dropped by an internal channel.
+//   - Labels: channel, policy
+//   - The policy label is the overflow policy that dropped the values
+//
+// - channel_spilled_total (counter) - The total number of values an internal channel spilled to disk.
+//   - Labels: channel
 func NewPrometheusMetricsProviderForRegisterer(r prometheus.Registerer) *PrometheusMetricsProvider {
 	p := &PrometheusMetricsProvider{
 		auditLogCheck: prometheus.NewGaugeVec(
@@ -55,6 +93,70 @@ func NewPrometheusMetricsProviderForRegisterer(r prometheus.Registerer) *Prometh
 			},
 			[]string{"type"},
 		),
+		filterDrops: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "filter_dropped_events_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of events dropped by each event filter rule.",
+			},
+			[]string{"rule"},
+		),
+		cachedEvents: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "cached_audit_events",
+				Namespace: MetricsNamespace,
+				Help:      "The number of audit events cached while waiting for their sessions' remote user logins.",
+			},
+			[]string{},
+		),
+		cacheEvictions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "cache_evictions_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of cached audit events evicted by each overflow policy.",
+			},
+			[]string{"policy"},
+		),
+		channelDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name:      "channel_depth",
+				Namespace: MetricsNamespace,
+				Help:      "The number of values buffered in memory or spilled to disk by each internal channel.",
+			},
+			[]string{"channel"},
+		),
+		channelDrops: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "channel_dropped_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of values dropped by each internal channel's overflow policy.",
+			},
+			[]string{"channel", "policy"},
+		),
+		channelSpills: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "channel_spilled_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of values spilled to disk by each internal channel.",
+			},
+			[]string{"channel"},
+		),
+		expiredSessions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "unattributed_sessions_expired_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of audit sessions that expired before their remote user logins were found.",
+			},
+			[]string{},
+		),
+		namedPipeReconnect: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:      "named_pipe_reconnects_total",
+				Namespace: MetricsNamespace,
+				Help:      "The total number of times a named pipe was reopened after its writer disconnected.",
+			},
+			[]string{"path"},
+		),
 		remoteLogins: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name:      "remote_logins_total",
@@ -66,7 +168,8 @@ func NewPrometheusMetricsProviderForRegisterer(r prometheus.Registerer) *Prometh
 	}
 
 	// This is variadic function so we can pass as many metrics as we want
-	r.MustRegister(p.remoteLogins, p.auditLogCheck, p.auditLogModifyTime)
+	r.MustRegister(p.remoteLogins, p.auditLogCheck, p.auditLogModifyTime, p.errors, p.namedPipeReconnect, p.filterDrops,
+		p.cachedEvents, p.cacheEvictions, p.expiredSessions, p.channelDepth, p.channelDrops, p.channelSpills)
 	return p
 }
 
@@ -80,6 +183,54 @@ func (p *PrometheusMetricsProvider) IncErrors(errorType ErrorType) {
 	p.errors.WithLabelValues(string(errorType)).Inc()
 }
 
+// IncNamedPipeReconnects increments the number of times the named
+// pipe at the given path was reopened.
+func (p *PrometheusMetricsProvider) IncNamedPipeReconnects(path string) {
+	p.namedPipeReconnect.WithLabelValues(path).Inc()
+}
+
+// IncFilterDrops increments the number of events dropped
+// by the event filter rule with the given name.
+func (p *PrometheusMetricsProvider) IncFilterDrops(rule string) {
+	p.filterDrops.WithLabelValues(rule).Inc()
+}
+
+// SetCachedAuditEvents sets the number of audit events cached
+// while waiting for their sessions' remote user logins.
+func (p *PrometheusMetricsProvider) SetCachedAuditEvents(count float64) {
+	p.cachedEvents.WithLabelValues().Set(count)
+}
+
+// AddCacheEvictions increases the number of cached audit events
+// evicted by the given overflow policy.
+func (p *PrometheusMetricsProvider) AddCacheEvictions(policy string, count float64) {
+	p.cacheEvictions.WithLabelValues(policy).Add(count)
+}
+
+// IncUnattributedSessionsExpired increments the number of audit sessions
+// that expired before their remote user logins were found.
+func (p *PrometheusMetricsProvider) IncUnattributedSessionsExpired() {
+	p.expiredSessions.WithLabelValues().Inc()
+}
+
+// SetChannelDepth sets the number of values buffered by the internal
+// channel with the given name.
+func (p *PrometheusMetricsProvider) SetChannelDepth(channel string, depth float64) {
+	p.channelDepth.WithLabelValues(channel).Set(depth)
+}
+
+// AddChannelDrops increases the number of values dropped by the
+// given overflow policy of the internal channel with the given name.
+func (p *PrometheusMetricsProvider) AddChannelDrops(channel string, policy string, count float64) {
+	p.channelDrops.WithLabelValues(channel, policy).Add(count)
+}
+
+// IncChannelSpills increments the number of values spilled to disk
+// by the internal channel with the given name.
+func (p *PrometheusMetricsProvider) IncChannelSpills(channel string) {
+	p.channelSpills.WithLabelValues(channel).Inc()
+}
+
 // SetAuditCheck sets status of audit.log writes. 0 for negative, 1 for positive.
 func (p *PrometheusMetricsProvider) SetAuditLogCheck(result float64, threshold string) {
 	p.auditLogCheck.WithLabelValues(threshold).Set(result)
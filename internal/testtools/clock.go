@@ -0,0 +1,34 @@
+package testtools
+
+import (
+	"sync"
+	"time"
+)
+
+// TestClock is a clock whose time only changes when it is advanced.
+// It implements common.Clock.
+type TestClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewTestClock returns a TestClock set to now.
+func NewTestClock(now time.Time) *TestClock {
+	return &TestClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (o *TestClock) Now() time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.now
+}
+
+// Advance moves the clock's current time forward by d.
+func (o *TestClock) Advance(d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.now = o.now.Add(d)
+}
@@ -24,5 +24,10 @@ func main() {
 func mainWithError() error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == cmd.ReplayCommandName {
+		return cmd.RunReplay(ctx, os.Args[1:], nil)
+	}
+
 	return cmd.RunNamedPipe(ctx, os.Args, health.NewHealth(), nil)
 }
@@ -2,7 +2,9 @@ package auditd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/elastic/go-libaudit/v2"
@@ -12,6 +14,8 @@ import (
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
 	"github.com/metal-toolbox/audito-maldito/internal/health"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/eventfilter"
 	"github.com/metal-toolbox/audito-maldito/processors/auditd/sessiontracker"
 )
 
@@ -19,6 +23,10 @@ const (
 	// AuditdProcessorComponentName is the name of the component
 	// that reads from auditd. This is used in the health check.
 	AuditdProcessorComponentName = "auditd-processor"
+
+	// DefaultStateSaveInterval is the default value
+	// of Auditd.StateSaveInterval.
+	DefaultStateSaveInterval = 30 * time.Second
 )
 
 // libaudit variables.
@@ -27,6 +35,10 @@ const (
 	eventTimeout             = 2 * time.Second
 	reassemblerInterval      = 500 * time.Millisecond
 	staleDataCleanupInterval = 1 * time.Minute
+
+	// addressCorrelationInterval is how often logins are matched to
+	// sessions by address, once their grace period has passed.
+	addressCorrelationInterval = 5 * time.Second
 )
 
 var logger *zap.SugaredLogger
@@ -45,7 +57,16 @@ type Auditd struct {
 	// A zero time.Time means no events are ignored.
 	After time.Time
 
+	// Before filters audit events after a particular point in time.
+	//
+	// A zero time.Time means no events are ignored.
+	Before time.Time
+
 	// Audits receives audit log lines from one or more audit files.
+	//
+	// Closing the channel indicates that there are no more audit
+	// logs to read. Read flushes any partially-reassembled events
+	// and returns nil when this happens.
 	Audits <-chan string
 
 	// Logins receives common.RemoteUserLogin when a user logs in
@@ -56,6 +77,99 @@ type Auditd struct {
 	EventW *auditevent.EventWriter
 
 	Health *health.Health
+
+	// DisableStaleDataCleanup disables the expiry of remote
+	// user logins and audit sessions that were never
+	// correlated with each other. The cleanup compares their
+	// age against the current time, which is meaningless when
+	// replaying old logs.
+	DisableStaleDataCleanup bool
+
+	// SessionIdleTimeout, if non-zero, ends audit sessions that have
+	// a remote user login and no audit events for this long. This
+	// is done by the stale data cleanup. A UserLogout event is
+	// written for each session that is ended this way.
+	SessionIdleTimeout time.Duration
+
+	// StateFilePath, if non-empty, is the path to a file that the
+	// audit sessions and remote user logins tracked by Read are
+	// saved to. The state is saved every StateSaveInterval and
+	// when Read returns. It is restored when Read starts, which
+	// allows events from sessions that started prior to a restart
+	// to be attributed to their remote user logins.
+	StateFilePath string
+
+	// StateSaveInterval is the interval at which the state is saved.
+	// DefaultStateSaveInterval is used if it is zero.
+	StateSaveInterval time.Duration
+
+	// MachineID identifies the computer. It is used along with
+	// BootID to create the correlation IDs that link each audit
+	// session's events together (refer to
+	// common.SessionCorrelationID).
+	MachineID string
+
+	// BootID identifies the current boot of the computer (refer to
+	// common.GetBootID). State saved during a different boot is
+	// discarded because audit session IDs are not unique across
+	// reboots. It is required if StateFilePath is specified.
+	BootID string
+
+	// ExistingLogins maps the audit session IDs of sessions that
+	// started before Read was called to their remote user logins
+	// (refer to the procsessions package). The sessions are added
+	// to the session tracker after the saved state is restored.
+	// Sessions found in the saved state are not replaced.
+	ExistingLogins map[string]common.RemoteUserLogin
+
+	// SystemActions configures the SystemAction events written for
+	// audit events that are not associated with an audit session.
+	// No SystemAction events are written by default.
+	SystemActions sessiontracker.SystemActionConfig
+
+	// ActionDetails determines which groups of audit record fields
+	// (e.g., the process' working directory and the files it
+	// operated on) are added to UserAction events. None are
+	// added by default.
+	ActionDetails sessiontracker.ActionDetailConfig
+
+	// KeyFilter, if enabled, limits the UserAction and SystemAction
+	// events that are written to those produced by audit rules with
+	// specific keys. All events are written by default.
+	KeyFilter sessiontracker.KeyFilter
+
+	// EventFilter, if non-nil, decides which UserAction and
+	// SystemAction events are written (refer to the eventfilter
+	// package). All events are written by default.
+	EventFilter *eventfilter.Filter
+
+	// CacheConfig limits the number of audit events cached while
+	// waiting for the remote user logins of their audit sessions.
+	// The number is not limited by default.
+	CacheConfig sessiontracker.CacheConfig
+
+	// Correlation enables the strategies used to correlate remote
+	// user logins with audit sessions when their PIDs differ. Only
+	// logins with the same PID as their sessions are correlated by
+	// default.
+	Correlation sessiontracker.CorrelationConfig
+
+	// BadLines configures how audit log lines that cannot be parsed
+	// are handled. By default, Read returns an error when the first
+	// such line is read.
+	BadLines BadLineConfig
+
+	// Metrics, if non-nil, reports the number of cached audit
+	// events, the number of events evicted from the cache, and
+	// the number of audit log lines that were skipped.
+	Metrics *metrics.PrometheusMetricsProvider
+}
+
+// trackerStater is implemented by session trackers
+// whose state can be saved to a file.
+type trackerStater interface {
+	SaveState(filePath string, bootID string) error
+	RestoreState(filePath string, bootID string) error
 }
 
 // Read reads Linux audit messages from Auditd.Logins, parsing them into
@@ -64,11 +178,54 @@ type Auditd struct {
 func (o *Auditd) Read(ctx context.Context) error {
 	reassemblerErrors := make(chan error, 1)
 	tracker := sessiontracker.NewSessionTracker(o.EventW, logger)
+	tracker.SetSystemActionConfig(o.SystemActions)
+	tracker.SetCorrelationIDSource(o.MachineID, o.BootID)
+	tracker.SetActionDetailConfig(o.ActionDetails)
+	tracker.SetKeyFilter(o.KeyFilter)
+	tracker.SetEventFilter(o.EventFilter)
+	tracker.SetCacheConfig(o.CacheConfig)
+	tracker.SetCorrelationConfig(o.Correlation)
+	tracker.SetMetrics(o.Metrics)
+
+	if !o.DisableStaleDataCleanup {
+		tracker.SetRemoteLoginTTL(staleDataCleanupInterval)
+	}
+
+	var stateSaveTicks <-chan time.Time
+	if o.StateFilePath != "" {
+		if o.BootID == "" {
+			return errors.New("a boot id is required to save and restore the session tracker state")
+		}
+
+		o.restoreState(tracker)
+
+		// The state is saved after the reassembler is
+		// closed (i.e., after its events are flushed).
+		defer o.saveState(tracker)
+
+		interval := o.StateSaveInterval
+		if interval == 0 {
+			interval = DefaultStateSaveInterval
+		}
+
+		stateSaveTicker := time.NewTicker(interval)
+		defer stateSaveTicker.Stop()
+
+		stateSaveTicks = stateSaveTicker.C
+	}
+
+	for sessionID, rul := range o.ExistingLogins {
+		err := tracker.AddExistingSession(sessionID, rul)
+		if err != nil {
+			logger.Warnf("failed to add existing audit session '%s' - %s", sessionID, err)
+		}
+	}
 
 	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
 		au:     tracker,
 		errors: reassemblerErrors,
 		after:  o.After,
+		before: o.Before,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create new auditd message resassembler - %w", err)
@@ -78,13 +235,43 @@ func (o *Auditd) Read(ctx context.Context) error {
 
 	go maintainReassemblerLoop(ctx, reassembler, reassemblerInterval)
 
+	var badLines *badLineHandler
+	if o.BadLines.Tolerate {
+		badLines, err = newBadLineHandler(o.BadLines, o.Metrics)
+		if err != nil {
+			return fmt.Errorf("failed to create bad auditd log line handler - %w", err)
+		}
+	}
+
 	parseAuditLogsDone := make(chan error, 1)
 	go func() {
-		parseAuditLogsDone <- parseAuditLogs(ctx, o.Audits, reassembler)
+		parseAuditLogsDone <- parseAuditLogs(ctx, o.Audits, reassembler, badLines)
+
+		if badLines != nil {
+			_ = badLines.close()
+		}
 	}()
 
-	staleDataTicker := time.NewTicker(staleDataCleanupInterval)
-	defer staleDataTicker.Stop()
+	// Remote user logins expire on their own (refer to
+	// SetRemoteLoginTTL), but audit sessions are only deleted
+	// or ended by this ticker. Ending an idle session writes a
+	// UserLogout event, which must happen even when no audit
+	// events or logins arrive.
+	var staleDataTicks <-chan time.Time
+	if !o.DisableStaleDataCleanup {
+		staleDataTicker := time.NewTicker(staleDataCleanupInterval)
+		defer staleDataTicker.Stop()
+
+		staleDataTicks = staleDataTicker.C
+	}
+
+	var addressTicks <-chan time.Time
+	if o.Correlation.Address {
+		addressTicker := time.NewTicker(addressCorrelationInterval)
+		defer addressTicker.Stop()
+
+		addressTicks = addressTicker.C
+	}
 
 	o.Health.OnReady(AuditdProcessorComponentName)
 
@@ -92,16 +279,36 @@ func (o *Auditd) Read(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-staleDataTicker.C:
+		case <-staleDataTicks:
 			aMinuteAgo := time.Now().Add(-staleDataCleanupInterval)
 
-			tracker.DeleteUsersWithoutLoginsBefore(aMinuteAgo)
-			tracker.DeleteRemoteUserLoginsBefore(aMinuteAgo)
+			err := tracker.DeleteUsersWithoutLoginsBefore(aMinuteAgo)
+			if err != nil {
+				return fmt.Errorf("failed to delete audit sessions without remote user logins - %w", err)
+			}
+
+			if o.SessionIdleTimeout > 0 {
+				err := tracker.EndIdleSessionsBefore(time.Now().Add(-o.SessionIdleTimeout))
+				if err != nil {
+					return fmt.Errorf("failed to end idle audit sessions - %w", err)
+				}
+			}
+		case <-addressTicks:
+			err := tracker.CorrelateByAddress(time.Now())
+			if err != nil {
+				return fmt.Errorf("failed to correlate remote user logins by address - %w", err)
+			}
+		case <-stateSaveTicks:
+			o.saveState(tracker)
 		case remoteLogin := <-o.Logins:
 			if err := tracker.RemoteLogin(remoteLogin); err != nil {
 				return fmt.Errorf("failed to handle remote user login - %w", err)
 			}
 		case err := <-parseAuditLogsDone:
+			if err == nil {
+				return flushReassembler(reassembler, reassemblerErrors)
+			}
+
 			return fmt.Errorf("audit log parser exited unexpectedly with error - %w", err)
 		case err := <-reassemblerErrors:
 			return fmt.Errorf("failed to reassemble auditd event - %w", err)
@@ -109,6 +316,48 @@ func (o *Auditd) Read(ctx context.Context) error {
 	}
 }
 
+// restoreState restores the session tracker state saved in
+// StateFilePath. Failing to do so is not fatal because the
+// state only affects sessions that started prior to a restart.
+func (o *Auditd) restoreState(tracker trackerStater) {
+	err := tracker.RestoreState(o.StateFilePath, o.BootID)
+	if err == nil {
+		return
+	}
+
+	var stErr *sessiontracker.SessionTrackerError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		logger.Infof("session tracker state file does not exist, starting with an empty state - %s", err)
+	case errors.As(err, &stErr) && stErr.StaleState():
+		logger.Infof("discarding session tracker state from a previous boot - %s", err)
+	default:
+		logger.Warnf("failed to restore session tracker state, starting with an empty state - %s", err)
+	}
+}
+
+// saveState saves the session tracker state to StateFilePath.
+func (o *Auditd) saveState(tracker trackerStater) {
+	err := tracker.SaveState(o.StateFilePath, o.BootID)
+	if err != nil {
+		logger.Warnf("failed to save session tracker state - %s", err)
+	}
+}
+
+// flushReassembler closes reassembler, which passes any cached
+// events to its callback. It returns the first error produced
+// by the callback, if any.
+func flushReassembler(reassembler *libaudit.Reassembler, reassemblerErrors <-chan error) error {
+	_ = reassembler.Close()
+
+	select {
+	case err := <-reassemblerErrors:
+		return fmt.Errorf("failed to reassemble auditd event - %w", err)
+	default:
+		return nil
+	}
+}
+
 // maintainReassemblerLoop calls libaudit.Reassembler.Maintain in a loop
 // at an interval specified by d.
 func maintainReassemblerLoop(ctx context.Context, reassembler *libaudit.Reassembler, d time.Duration) {
@@ -132,13 +381,19 @@ func maintainReassemblerLoop(ctx context.Context, reassembler *libaudit.Reassemb
 }
 
 // parseAuditLogs parses audit log lines read from lines and pushes them
-// to reass until the provided context is marked as done.
-func parseAuditLogs(ctx context.Context, lines <-chan string, reass *libaudit.Reassembler) error {
+// to reass until the provided context is marked as done. It returns nil
+// if lines is closed. Lines that cannot be parsed are passed to badLines,
+// or make parseAuditLogs return an error if badLines is nil.
+func parseAuditLogs(ctx context.Context, lines <-chan string, reass *libaudit.Reassembler, badLines *badLineHandler) error {
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case line := <-lines:
+		case line, isOpen := <-lines:
+			if !isOpen {
+				return nil
+			}
+
 			if line == "" {
 				// Parsing an empty line results in this error:
 				//    invalid audit message header
@@ -150,6 +405,18 @@ func parseAuditLogs(ctx context.Context, lines <-chan string, reass *libaudit.Re
 			}
 
 			auditMsg, err := auparse.ParseLogLine(line)
+			if err != nil && badLines != nil {
+				err = badLines.handle(line, err)
+				if err != nil {
+					return &parseAuditLogsError{
+						message: err.Error(),
+						inner:   err,
+					}
+				}
+
+				continue
+			}
+
 			if err != nil {
 				return &parseAuditLogsError{
 					message: fmt.Sprintf("failed to parse auditd log line '%s' - %s",
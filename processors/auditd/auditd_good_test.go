@@ -26,6 +26,10 @@ import (
 const (
 	goodAuditdID = "499"
 
+	goodMachineID = "foobar"
+
+	goodBootID = "5f8e5e4a-1b7c-4d1e-8f0e-2a6c3b9d7e41"
+
 	goodAuditdMaxResultingEvents = 300
 
 	goodAuditdSshdPid = 25007
@@ -83,7 +87,9 @@ func TestAuditd_Read_GoodRemoteUserLoginFirst(t *testing.T) {
 			Events: events,
 			T:      t,
 		}),
-		Health: health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		Health:    health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		MachineID: goodMachineID,
+		BootID:    goodBootID,
 	}
 
 	exited := make(chan error, 1)
@@ -146,7 +152,9 @@ func TestAuditd_Read_GoodAuditdEventsFirst(t *testing.T) {
 			Events: events,
 			T:      t,
 		}),
-		Health: health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		Health:    health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		MachineID: goodMachineID,
+		BootID:    goodBootID,
 	}
 
 	exited := make(chan error, 1)
@@ -263,7 +271,7 @@ func newSshdJournaldAuditEvent(unixAccountName string, pid int) common.RemoteUse
 		"sshd",
 	).WithTarget(map[string]string{
 		"host":       "localhost",
-		"machine-id": "foobar",
+		"machine-id": goodMachineID,
 	})
 
 	evt.LoggedAt = time.Now()
@@ -301,18 +309,26 @@ type goodAuditdEventsChecker struct {
 // any writes occur.
 func (o goodAuditdEventsChecker) check() {
 	i := 0
+	numLogouts := 0
 
 	for {
 		select {
 		case err := <-o.exited:
 			o.t.Fatalf("read exited unexpectedly - %v", err)
 		case event := <-o.events:
+			if event.Type == common.ActionLogoutIdentifier {
+				numLogouts++
+				o.checkLogout(event)
+				continue
+			}
+
 			if i > 200 && event.Metadata.Extra["action"] == "disposed-credentials" {
+				assert.Equal(o.t, 1, numLogouts, "expected one logout event before the session ended")
 				return
 			}
 
 			o.checkEvent(i, event, auditevent.EventMetadata{
-				AuditID: goodAuditdID,
+				AuditID: common.SessionCorrelationID(goodMachineID, goodBootID, goodAuditdID),
 				Extra:   metadataForGoodAuditdEvents(i, o.t),
 			})
 
@@ -321,6 +337,18 @@ func (o goodAuditdEventsChecker) check() {
 	}
 }
 
+// checkLogout verifies that the UserLogout event describes the end
+// of the "good" auditd session.
+func (o goodAuditdEventsChecker) checkLogout(target *auditevent.AuditEvent) {
+	assert.Equal(o.t, o.login.Source.Subjects, target.Subjects)
+	assert.Equal(o.t, o.login.Source.Source, target.Source)
+	assert.Equal(o.t, common.SessionCorrelationID(goodMachineID, goodBootID, goodAuditdID), target.Metadata.AuditID)
+	assert.Equal(o.t, o.login.Source.Metadata.AuditID, target.Metadata.Extra["login_audit_id"])
+	assert.Equal(o.t, "USER_END", target.Metadata.Extra["end_reason"])
+	assert.Greater(o.t, target.Metadata.Extra["commands_executed"], 0)
+	assert.Greater(o.t, target.Metadata.Extra["duration_seconds"], float64(0))
+}
+
 // checkEvent verifies that the target auditevent.AuditEvent contains
 // the fields from the original common.RemoteUserLogin stored in
 // the goodAuditdEventsChecker.
@@ -354,6 +382,8 @@ func (o goodAuditdEventsChecker) checkEvent(i int, target *auditevent.AuditEvent
 	assert.Equal(o.t, o.login.Source.Target["machine-id"], target.Target["machine-id"], "i: %d", i)
 
 	assert.Equal(o.t, meta.AuditID, target.Metadata.AuditID, "i: %d", i)
+	assert.Equal(o.t, goodAuditdID, target.Metadata.Extra["audit_session_id"], "i: %d", i)
+	assert.Equal(o.t, o.login.Source.Metadata.AuditID, target.Metadata.Extra["login_audit_id"], "i: %d", i)
 
 	if len(meta.Extra) == 0 {
 		o.t.Fatalf("i: %d | expacted-metadata's extra map is empty", i)
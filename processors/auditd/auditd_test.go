@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"context"
 	_ "embed"
+	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -68,6 +71,110 @@ func TestAuditd_Read_RemoteLoginError(t *testing.T) {
 	}
 }
 
+func TestAuditd_Read_AuditsClosed(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	audits := make(chan string)
+	close(audits)
+
+	a := Auditd{
+		Audits: audits,
+		Logins: make(chan common.RemoteUserLogin),
+		EventW: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: make(chan *auditevent.AuditEvent, 1),
+			T:      t,
+		}),
+		Health:                  health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		DisableStaleDataCleanup: true,
+	}
+
+	err := a.Read(ctx)
+	assert.NoError(t, err)
+}
+
+func TestAuditd_Read_SaveAndRestoreState(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	newAuditd := func(audits <-chan string, logins <-chan common.RemoteUserLogin) Auditd {
+		return Auditd{
+			Audits: audits,
+			Logins: logins,
+			EventW: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+				Ctx:    ctx,
+				Events: make(chan *auditevent.AuditEvent, 1),
+				T:      t,
+			}),
+			Health:                  health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+			DisableStaleDataCleanup: true,
+			StateFilePath:           statePath,
+			BootID:                  "some-boot-id",
+		}
+	}
+
+	audits := make(chan string)
+	logins := make(chan common.RemoteUserLogin)
+	a := newAuditd(audits, logins)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Read(ctx)
+	}()
+
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	case logins <- newSshdJournaldAuditEvent("user", goodAuditdSshdPid):
+	}
+
+	close(audits)
+	require.NoError(t, <-errs)
+
+	assertStateNumLogins := func(exp int) {
+		contents, err := os.ReadFile(statePath)
+		require.NoError(t, err)
+
+		var state struct {
+			Logins []common.RemoteUserLogin `json:"logins"`
+		}
+		require.NoError(t, json.Unmarshal(contents, &state))
+		assert.Len(t, state.Logins, exp)
+	}
+
+	assertStateNumLogins(1)
+
+	// The login is restored by the next Read
+	// and saved again when it returns.
+	audits = make(chan string)
+	close(audits)
+	a = newAuditd(audits, make(chan common.RemoteUserLogin))
+
+	require.NoError(t, a.Read(ctx))
+	assertStateNumLogins(1)
+}
+
+func TestAuditd_Read_StateFileWithoutBootID(t *testing.T) {
+	t.Parallel()
+
+	a := Auditd{
+		Audits:        make(chan string),
+		Logins:        make(chan common.RemoteUserLogin),
+		Health:        health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		StateFilePath: filepath.Join(t.TempDir(), "state.json"),
+	}
+
+	err := a.Read(context.Background())
+	assert.ErrorContains(t, err, "boot id is required")
+}
+
 func TestAuditd_Read_ParseAuditLogError(t *testing.T) {
 	t.Parallel()
 
@@ -102,6 +209,79 @@ func TestAuditd_Read_ParseAuditLogError(t *testing.T) {
 	assert.ErrorAs(t, err, &expErr)
 }
 
+func TestAuditd_Read_TolerateBadLines(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+
+	lines := make(chan string, 2)
+	lines <- "foobar"
+	lines <- "barfoo"
+	close(lines)
+
+	a := Auditd{
+		Audits: lines,
+		Logins: make(chan common.RemoteUserLogin),
+		EventW: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: make(chan *auditevent.AuditEvent, 1),
+			T:      t,
+		}),
+		Health:                  health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		DisableStaleDataCleanup: true,
+		BadLines: BadLineConfig{
+			Tolerate:       true,
+			DeadLetterPath: deadLetterPath,
+		},
+	}
+
+	err := a.Read(ctx)
+	require.NoError(t, err)
+
+	letters := readDeadLetters(t, deadLetterPath)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "foobar", letters[0].Line)
+	assert.Equal(t, "barfoo", letters[1].Line)
+	assert.NotEmpty(t, letters[0].Error)
+}
+
+func TestAuditd_Read_TooManyBadLines(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	lines := make(chan string, 2)
+	lines <- "foobar"
+	lines <- "barfoo"
+
+	a := Auditd{
+		Audits: lines,
+		Logins: make(chan common.RemoteUserLogin),
+		EventW: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: make(chan *auditevent.AuditEvent, 1),
+			T:      t,
+		}),
+		Health:                  health.NewSingleReadinessHealth(AuditdProcessorComponentName),
+		DisableStaleDataCleanup: true,
+		BadLines: BadLineConfig{
+			Tolerate:    true,
+			MaxBadLines: 1,
+		},
+	}
+
+	err := a.Read(ctx)
+
+	var expErr *parseAuditLogsError
+
+	assert.ErrorAs(t, err, &expErr)
+	assert.ErrorContains(t, err, "more than 1 auditd log lines")
+}
+
 func TestAuditd_Read_AuditEventWriterError(t *testing.T) {
 	t.Parallel()
 
@@ -236,7 +416,7 @@ func TestParseAuditLogs_Cancel(t *testing.T) {
 
 	cancelFn()
 
-	err = parseAuditLogs(ctx, lines, reassembler)
+	err = parseAuditLogs(ctx, lines, reassembler, nil)
 
 	assert.ErrorIs(t, err, context.Canceled)
 }
@@ -269,11 +449,34 @@ func TestParseAuditLogs_EmptyAuditLine(t *testing.T) {
 		}
 	}()
 
-	err = parseAuditLogs(ctx, lines, reassembler)
+	err = parseAuditLogs(ctx, lines, reassembler, nil)
 
 	assert.ErrorIs(t, err, context.Canceled)
 }
 
+func TestParseAuditLogs_LinesClosed(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	reassembler, err := libaudit.NewReassembler(maxEventsInFlight, eventTimeout, &reassemblerCB{
+		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
+			return nil
+		}),
+		errors: make(chan error, 1),
+		after:  time.Time{},
+	})
+	require.NoError(t, err, "failed to create reassembler")
+
+	lines := make(chan string)
+	close(lines)
+
+	err = parseAuditLogs(ctx, lines, reassembler, nil)
+
+	assert.NoError(t, err)
+}
+
 func TestParseAuditLogs_LogParseFailure(t *testing.T) {
 	t.Parallel()
 
@@ -292,7 +495,7 @@ func TestParseAuditLogs_LogParseFailure(t *testing.T) {
 	lines := make(chan string, 1)
 	lines <- "foobar"
 
-	err = parseAuditLogs(ctx, lines, reassembler)
+	err = parseAuditLogs(ctx, lines, reassembler, nil)
 
 	var expErr *parseAuditLogsError
 
@@ -348,6 +551,29 @@ func TestReassemblerCB_ReassemblyComplete_EventIsBefore(t *testing.T) {
 	require.Empty(t, rcbErrs, "errors chan should be empty because event occurred before filter")
 }
 
+func TestReassemblerCB_ReassemblyComplete_EventIsAfter(t *testing.T) {
+	t.Parallel()
+
+	rcbErrs := make(chan error, 1)
+	rcb := &reassemblerCB{
+		au: fakest.NewFakeAuditor(func(event *aucoalesce.Event) error {
+			t.Error("auditor should not be called because event occurred after filter")
+			return nil
+		}),
+		errors: rcbErrs,
+		before: time.Now(),
+	}
+
+	rcb.ReassemblyComplete([]*auparse.AuditMessage{
+		{
+			RecordType: auparse.AUDIT_LOGIN,
+			Timestamp:  rcb.before.Add(time.Minute),
+		},
+	})
+
+	require.Empty(t, rcbErrs, "errors chan should be empty because event occurred after filter")
+}
+
 // Refer to the following GitHub issue for details:
 // https://github.com/elastic/go-libaudit/issues/127
 //
@@ -483,7 +709,7 @@ func TestReassemblerCloseFlushesEvents(t *testing.T) {
 	go func() {
 		defer wg.Done()
 
-		err := parseAuditLogs(ctx, lines, reas)
+		err := parseAuditLogs(ctx, lines, reas, nil)
 		assert.ErrorIs(t, err, context.Canceled, "expected context to be cancelled")
 	}()
 
@@ -0,0 +1,214 @@
+package auditd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+const (
+	// DefaultDeadLetterMaxBytes is the default maximum
+	// size of BadLineConfig.DeadLetterPath.
+	DefaultDeadLetterMaxBytes = 10 << 20
+
+	// DefaultBadLineWindow is the default BadLineConfig.Window.
+	DefaultBadLineWindow = time.Minute
+)
+
+// BadLineConfig configures how audit log lines that cannot be
+// parsed are handled. By default, the first such line makes
+// Auditd.Read return an error.
+type BadLineConfig struct {
+	// Tolerate, if true, makes Read skip lines that cannot be parsed.
+	// Each skipped line is logged, counted by the "audit_line_parse"
+	// errors metric, and written to DeadLetterPath.
+	Tolerate bool
+
+	// DeadLetterPath, if non-empty, is the path to a file that
+	// skipped lines are appended to, one JSON object per line,
+	// along with the parse error and the time they were skipped.
+	DeadLetterPath string
+
+	// DeadLetterMaxBytes is the maximum size of DeadLetterPath.
+	// When it is reached, the file is renamed with a ".1" suffix
+	// (replacing the previous one) and a new file is started.
+	// DefaultDeadLetterMaxBytes is used if it is zero.
+	DeadLetterMaxBytes int64
+
+	// MaxBadLines, if non-zero, makes Read return an error if more
+	// than this many lines are skipped within Window.
+	MaxBadLines int
+
+	// Window is the period over which MaxBadLines applies.
+	// DefaultBadLineWindow is used if it is zero.
+	Window time.Duration
+}
+
+// deadLetter is a line of the dead-letter file.
+type deadLetter struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+	Line  string    `json:"line"`
+}
+
+// newBadLineHandler returns a badLineHandler for config,
+// creating its dead-letter file if one is configured.
+func newBadLineHandler(config BadLineConfig, m *metrics.PrometheusMetricsProvider) (*badLineHandler, error) {
+	if config.DeadLetterMaxBytes == 0 {
+		config.DeadLetterMaxBytes = DefaultDeadLetterMaxBytes
+	}
+
+	if config.Window == 0 {
+		config.Window = DefaultBadLineWindow
+	}
+
+	h := &badLineHandler{
+		config:  config,
+		metrics: m,
+		now:     time.Now,
+	}
+
+	if config.DeadLetterPath != "" {
+		err := h.openDeadLetterFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// badLineHandler skips the audit log lines that cannot be parsed
+// according to a BadLineConfig. It is not safe for concurrent use.
+type badLineHandler struct {
+	config  BadLineConfig
+	metrics *metrics.PrometheusMetricsProvider
+	now     func() time.Time
+
+	file *os.File
+	size int64
+
+	// recent contains the times at which the lines skipped
+	// within the last BadLineConfig.Window were skipped,
+	// from oldest to newest.
+	recent []time.Time
+}
+
+// handle skips a line that could not be parsed. It returns an error if
+// BadLineConfig.MaxBadLines was exceeded. Failing to write the line to
+// the dead-letter file is logged rather than returned, so it does not
+// stop audit logs from being processed.
+func (o *badLineHandler) handle(line string, parseErr error) error {
+	now := o.now()
+
+	logger.Warnf("skipping auditd log line that could not be parsed '%s' - %s", line, parseErr)
+
+	if o.metrics != nil {
+		o.metrics.IncErrors(metrics.ErrorTypeAuditLineParse)
+	}
+
+	if o.file != nil {
+		err := o.writeDeadLetter(deadLetter{
+			Time:  now,
+			Error: parseErr.Error(),
+			Line:  line,
+		})
+		if err != nil {
+			logger.Warnf("failed to write auditd log line to dead-letter file - %s", err)
+		}
+	}
+
+	if o.config.MaxBadLines <= 0 {
+		return nil
+	}
+
+	windowStart := now.Add(-o.config.Window)
+
+	numExpired := 0
+	for numExpired < len(o.recent) && !o.recent[numExpired].After(windowStart) {
+		numExpired++
+	}
+
+	o.recent = append(o.recent[numExpired:], now)
+
+	if len(o.recent) > o.config.MaxBadLines {
+		return fmt.Errorf("more than %d auditd log lines could not be parsed within %s",
+			o.config.MaxBadLines, o.config.Window)
+	}
+
+	return nil
+}
+
+// writeDeadLetter appends a line to the dead-letter
+// file, rotating the file if it is too large.
+func (o *badLineHandler) writeDeadLetter(letter deadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+
+	if o.size > 0 && o.size+int64(len(data)) > o.config.DeadLetterMaxBytes {
+		err = o.rotateDeadLetterFile()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := o.file.Write(data)
+	o.size += int64(n)
+
+	return err
+}
+
+// rotateDeadLetterFile replaces the previous dead-letter
+// file with the current one and starts a new file.
+func (o *badLineHandler) rotateDeadLetterFile() error {
+	_ = o.file.Close()
+	o.file = nil
+
+	renameErr := os.Rename(o.config.DeadLetterPath, o.config.DeadLetterPath+".1")
+
+	err := o.openDeadLetterFile()
+	if err != nil {
+		return err
+	}
+
+	if renameErr != nil {
+		return fmt.Errorf("failed to rotate dead-letter file - %w", renameErr)
+	}
+
+	return nil
+}
+
+// openDeadLetterFile opens the dead-letter file for appending.
+func (o *badLineHandler) openDeadLetterFile() error {
+	f, err := os.OpenFile(o.config.DeadLetterPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file - %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat dead-letter file - %w", err)
+	}
+
+	o.file = f
+	o.size = info.Size()
+
+	return nil
+}
+
+// close closes the dead-letter file.
+func (o *badLineHandler) close() error {
+	if o.file == nil {
+		return nil
+	}
+
+	return o.file.Close()
+}
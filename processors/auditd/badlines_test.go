@@ -0,0 +1,185 @@
+package auditd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+func TestBadLineHandler_DeadLetter(t *testing.T) {
+	t.Parallel()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	registry := prometheus.NewRegistry()
+
+	h, err := newBadLineHandler(BadLineConfig{
+		Tolerate:       true,
+		DeadLetterPath: deadLetterPath,
+	}, metrics.NewPrometheusMetricsProviderForRegisterer(registry))
+	require.NoError(t, err)
+
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	require.NoError(t, h.handle("foobar", errors.New("bad line")))
+	require.NoError(t, h.handle("barfoo", errors.New("worse line")))
+	require.NoError(t, h.close())
+
+	assert.Equal(t, []deadLetter{
+		{Time: now, Error: "bad line", Line: "foobar"},
+		{Time: now, Error: "worse line", Line: "barfoo"},
+	}, readDeadLetters(t, deadLetterPath))
+
+	assert.Equal(t, float64(2), testErrorsMetric(t, registry, metrics.ErrorTypeAuditLineParse))
+}
+
+func TestBadLineHandler_DeadLetterAppends(t *testing.T) {
+	t.Parallel()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+
+	for _, line := range []string{"foo", "bar"} {
+		h, err := newBadLineHandler(BadLineConfig{DeadLetterPath: deadLetterPath}, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, h.handle(line, errors.New("bad line")))
+		require.NoError(t, h.close())
+	}
+
+	letters := readDeadLetters(t, deadLetterPath)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "foo", letters[0].Line)
+	assert.Equal(t, "bar", letters[1].Line)
+}
+
+func TestBadLineHandler_DeadLetterRotate(t *testing.T) {
+	t.Parallel()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+
+	h, err := newBadLineHandler(BadLineConfig{
+		DeadLetterPath: deadLetterPath,
+
+		// Each dead letter is larger than this, so every
+		// dead letter but the first rotates the file.
+		DeadLetterMaxBytes: 1,
+	}, nil)
+	require.NoError(t, err)
+
+	for _, line := range []string{"a", "b", "c"} {
+		require.NoError(t, h.handle(line, errors.New("bad line")))
+	}
+	require.NoError(t, h.close())
+
+	letters := readDeadLetters(t, deadLetterPath)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "c", letters[0].Line)
+
+	letters = readDeadLetters(t, deadLetterPath+".1")
+	require.Len(t, letters, 1)
+	assert.Equal(t, "b", letters[0].Line)
+}
+
+func TestBadLineHandler_DeadLetterDirDoesNotExist(t *testing.T) {
+	t.Parallel()
+
+	_, err := newBadLineHandler(BadLineConfig{
+		DeadLetterPath: filepath.Join(t.TempDir(), "nope", "dead-letter.jsonl"),
+	}, nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestBadLineHandler_MaxBadLines(t *testing.T) {
+	t.Parallel()
+
+	h, err := newBadLineHandler(BadLineConfig{
+		MaxBadLines: 2,
+		Window:      time.Minute,
+	}, nil)
+	require.NoError(t, err)
+
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	assert.NoError(t, h.handle("a", errors.New("bad line")))
+
+	now = now.Add(30 * time.Second)
+	assert.NoError(t, h.handle("b", errors.New("bad line")))
+
+	now = now.Add(29 * time.Second)
+	assert.Error(t, h.handle("c", errors.New("bad line")),
+		"three lines within a minute should exceed the limit")
+
+	// Only "c" and "d" were skipped within the last minute.
+	now = now.Add(31 * time.Second)
+	assert.NoError(t, h.handle("d", errors.New("bad line")))
+}
+
+func TestBadLineHandler_NoMaxBadLines(t *testing.T) {
+	t.Parallel()
+
+	h, err := newBadLineHandler(BadLineConfig{}, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, h.handle("foobar", errors.New("bad line")))
+	}
+
+	assert.Empty(t, h.recent)
+}
+
+// readDeadLetters returns the dead letters in the file found at filePath.
+func readDeadLetters(t *testing.T, filePath string) []deadLetter {
+	t.Helper()
+
+	f, err := os.Open(filePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var letters []deadLetter
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter deadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &letter))
+		letters = append(letters, letter)
+	}
+	require.NoError(t, scanner.Err())
+
+	return letters
+}
+
+// testErrorsMetric returns the value of the errors
+// metric for errorType.
+func testErrorsMetric(t *testing.T, registry *prometheus.Registry, errorType metrics.ErrorType) float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != metrics.MetricsNamespace+"_errors_total" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetValue() == string(errorType) {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	return 0
+}
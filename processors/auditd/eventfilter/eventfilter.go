@@ -0,0 +1,326 @@
+// eventfilter package decides which audit events are written based on a
+// list of declarative rules. This allows noisy, low-value events (e.g.,
+// the programs executed by a shell's startup files) to be dropped before
+// they are written to the auditevent.EventWriter.
+//
+// The rules are evaluated in order. The first rule that matches an event
+// determines whether it is kept or dropped. Events that do not match any
+// rule are kept.
+package eventfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+const (
+	// ActionKeep keeps the events matched by a rule.
+	ActionKeep = "keep"
+
+	// ActionDrop drops the events matched by a rule.
+	ActionDrop = "drop"
+)
+
+// Config is the configuration of a Filter. It is
+// usually loaded from a JSON file using LoadConfig.
+type Config struct {
+	// Rules are the filter's rules, in the order
+	// in which they are evaluated.
+	Rules []Rule `json:"rules"`
+}
+
+// Rule keeps or drops the events it matches. A rule matches an event if
+// all of its non-empty fields match. A field matches if any of its
+// values match the event.
+type Rule struct {
+	// Name identifies the rule in metrics and error messages.
+	// It must be unique.
+	Name string `json:"name"`
+
+	// Action is either ActionKeep or ActionDrop.
+	Action string `json:"action"`
+
+	// Exe matches the executable path of the process that produced
+	// the event using shell glob patterns (e.g., "/usr/bin/*").
+	Exe []string `json:"exe,omitempty"`
+
+	// Syscalls matches the name of the event's system call
+	// (e.g., "execve").
+	Syscalls []string `json:"syscalls,omitempty"`
+
+	// Actions matches the event's action (e.g., "executed").
+	Actions []string `json:"actions,omitempty"`
+
+	// Results matches the event's result ("success" or "fail").
+	Results []string `json:"results,omitempty"`
+
+	// Keys matches the keys of the audit rules
+	// that produced the event (e.g., "privesc").
+	Keys []string `json:"keys,omitempty"`
+
+	// LoggedAs matches the name of the account that the user logged
+	// in as. It never matches events without a remote user login.
+	LoggedAs []string `json:"logged_as,omitempty"`
+
+	// UserIDs matches the user's ID (e.g., the identity found in their
+	// SSH certificate). It never matches events without a remote user
+	// login.
+	UserIDs []string `json:"user_ids,omitempty"`
+
+	// SourceCIDRs matches the address the user logged in from using
+	// CIDR notation (e.g., "10.0.0.0/8"). It never matches events
+	// without a remote user login.
+	SourceCIDRs []string `json:"source_cidrs,omitempty"`
+}
+
+// LoadConfig loads a Config from the JSON file found at filePath.
+// Unknown fields are rejected so that a misspelled field does not
+// silently turn a rule into one that matches more events than intended.
+func LoadConfig(filePath string) (Config, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read event filter config file - %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var config Config
+	err = decoder.Decode(&config)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to parse event filter config file '%s' - %w", filePath, err)
+	}
+
+	if decoder.More() {
+		return Config{}, fmt.Errorf("failed to parse event filter config file '%s' - "+
+			"unexpected data after the config object", filePath)
+	}
+
+	return config, nil
+}
+
+// NewFilter validates config and returns a Filter that uses its rules.
+// m, if non-nil, counts the number of events dropped by each rule.
+func NewFilter(config Config, m *metrics.PrometheusMetricsProvider) (*Filter, error) {
+	filter := &Filter{
+		metrics: m,
+	}
+
+	names := make(map[string]struct{}, len(config.Rules))
+
+	for i, rule := range config.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d does not have a name", i)
+		}
+
+		if _, hasIt := names[rule.Name]; hasIt {
+			return nil, fmt.Errorf("rule %d has a duplicate name: %q", i, rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile rule %q - %w", rule.Name, err)
+		}
+
+		filter.rules = append(filter.rules, compiled)
+	}
+
+	return filter, nil
+}
+
+// Filter decides which audit events are written.
+//
+// A nil *Filter keeps every event.
+type Filter struct {
+	rules   []compiledRule
+	metrics *metrics.PrometheusMetricsProvider
+}
+
+// Keep returns true if the audit event should be written. login is
+// the remote user login associated with the event, or nil if it is
+// not associated with one (e.g., for SystemAction events).
+func (o *Filter) Keep(ae *aucoalesce.Event, login *common.RemoteUserLogin) bool {
+	if o == nil {
+		return true
+	}
+
+	for _, rule := range o.rules {
+		if !rule.matches(ae, login) {
+			continue
+		}
+
+		if rule.keep {
+			return true
+		}
+
+		if o.metrics != nil {
+			o.metrics.IncFilterDrops(rule.Name)
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// compiledRule is a validated Rule.
+type compiledRule struct {
+	Rule
+
+	keep  bool
+	cidrs []*net.IPNet
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	compiled := compiledRule{
+		Rule: rule,
+	}
+
+	switch rule.Action {
+	case ActionKeep:
+		compiled.keep = true
+	case ActionDrop:
+	default:
+		return compiledRule{}, fmt.Errorf("unknown action: %q (must be %q or %q)",
+			rule.Action, ActionKeep, ActionDrop)
+	}
+
+	for _, pattern := range rule.Exe {
+		// path.Match only returns an error if the pattern is malformed.
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return compiledRule{}, fmt.Errorf("invalid exe pattern %q - %w", pattern, err)
+		}
+	}
+
+	for _, cidr := range rule.SourceCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return compiledRule{}, fmt.Errorf("invalid source cidr %q - %w", cidr, err)
+		}
+
+		compiled.cidrs = append(compiled.cidrs, ipNet)
+	}
+
+	if rule.isEmpty() {
+		return compiledRule{}, errors.New("rule does not match any fields")
+	}
+
+	return compiled, nil
+}
+
+// isEmpty returns true if the rule has no fields to match.
+func (o Rule) isEmpty() bool {
+	return len(o.Exe) == 0 && len(o.Syscalls) == 0 && len(o.Actions) == 0 &&
+		len(o.Results) == 0 && len(o.Keys) == 0 && len(o.LoggedAs) == 0 &&
+		len(o.UserIDs) == 0 && len(o.SourceCIDRs) == 0
+}
+
+func (o compiledRule) matches(ae *aucoalesce.Event, login *common.RemoteUserLogin) bool {
+	if len(o.Exe) > 0 && !matchesGlob(o.Exe, ae.Process.Exe) {
+		return false
+	}
+
+	if len(o.Syscalls) > 0 && !contains(o.Syscalls, ae.Data["syscall"]) {
+		return false
+	}
+
+	if len(o.Actions) > 0 && !contains(o.Actions, ae.Summary.Action) {
+		return false
+	}
+
+	if len(o.Results) > 0 && !contains(o.Results, ae.Result) {
+		return false
+	}
+
+	if len(o.Keys) > 0 && !containsAny(o.Keys, ae.Tags) {
+		return false
+	}
+
+	if len(o.LoggedAs) == 0 && len(o.UserIDs) == 0 && len(o.cidrs) == 0 {
+		return true
+	}
+
+	if login == nil || login.Source == nil {
+		return false
+	}
+
+	if len(o.LoggedAs) > 0 && !contains(o.LoggedAs, login.Source.Subjects["loggedAs"]) {
+		return false
+	}
+
+	if len(o.UserIDs) > 0 && !contains(o.UserIDs, login.CredUserID) {
+		return false
+	}
+
+	if len(o.cidrs) > 0 && !matchesCIDR(o.cidrs, login.Source.Source.Value) {
+		return false
+	}
+
+	return true
+}
+
+func matchesGlob(patterns []string, s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		// The patterns were validated by compileRule.
+		if matched, _ := path.Match(pattern, s); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesCIDR(cidrs []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(values []string, ss []string) bool {
+	for _, s := range ss {
+		if contains(values, s) {
+			return true
+		}
+	}
+
+	return false
+}
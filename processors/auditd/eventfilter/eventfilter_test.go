@@ -0,0 +1,268 @@
+package eventfilter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+const testConfig = `{
+  "rules": [
+    {"name": "keep-privesc", "action": "keep", "keys": ["privesc"]},
+    {"name": "shell-startup", "action": "drop", "exe": ["/usr/bin/locale", "/usr/bin/lesspipe", "/usr/lib/git-core/*"]},
+    {"name": "failed-stat", "action": "drop", "syscalls": ["stat", "newfstatat"], "results": ["fail"]},
+    {"name": "automation", "action": "drop", "logged_as": ["ansible"], "source_cidrs": ["10.0.0.0/8"]},
+    {"name": "robots", "action": "drop", "user_ids": ["robot@foo.com"], "actions": ["executed"]}
+  ]
+}`
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "filter.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(testConfig), 0o600))
+
+	config, err := LoadConfig(configPath)
+	require.NoError(t, err)
+
+	require.Len(t, config.Rules, 5)
+	assert.Equal(t, Rule{
+		Name:   "shell-startup",
+		Action: ActionDrop,
+		Exe:    []string{"/usr/bin/locale", "/usr/bin/lesspipe", "/usr/lib/git-core/*"},
+	}, config.Rules[1])
+	assert.Equal(t, []string{"10.0.0.0/8"}, config.Rules[3].SourceCIDRs)
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	t.Parallel()
+
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "does-not-exist.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	configPath := filepath.Join(t.TempDir(), "filter.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"rules": [`), 0o600))
+
+	_, err = LoadConfig(configPath)
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_UnknownField(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "filter.json")
+
+	// "exes" is a misspelling of "exe". Ignoring it would
+	// turn the rule into one that drops every failed event.
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"rules": [
+		{"name": "a", "action": "drop", "exes": ["/usr/bin/locale"], "results": ["fail"]}
+	]}`), 0o600))
+
+	_, err := LoadConfig(configPath)
+	assert.ErrorContains(t, err, `unknown field "exes"`)
+
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"rule": []}`), 0o600))
+
+	_, err = LoadConfig(configPath)
+	assert.ErrorContains(t, err, `unknown field "rule"`)
+}
+
+func TestLoadConfig_TrailingData(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "filter.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"rules": []} {"rules": []}`), 0o600))
+
+	_, err := LoadConfig(configPath)
+	assert.Error(t, err)
+}
+
+func TestNewFilter_InvalidRules(t *testing.T) {
+	t.Parallel()
+
+	for name, rules := range map[string][]Rule{
+		"no name":        {{Action: ActionDrop, Actions: []string{"executed"}}},
+		"duplicate name": {{Name: "a", Action: ActionDrop, Actions: []string{"x"}}, {Name: "a", Action: ActionKeep, Actions: []string{"y"}}},
+		"bad action":     {{Name: "a", Action: "ignore", Actions: []string{"executed"}}},
+		"bad exe glob":   {{Name: "a", Action: ActionDrop, Exe: []string{"/usr/bin/["}}},
+		"bad cidr":       {{Name: "a", Action: ActionDrop, SourceCIDRs: []string{"10.0.0.0/33"}}},
+		"no fields":      {{Name: "a", Action: ActionDrop}},
+	} {
+		_, err := NewFilter(Config{Rules: rules}, nil)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFilter_Keep(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "filter.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(testConfig), 0o600))
+
+	config, err := LoadConfig(configPath)
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+
+	filter, err := NewFilter(config, metrics.NewPrometheusMetricsProviderForRegisterer(registry))
+	require.NoError(t, err)
+
+	core := newTestLogin("core", "user@foo.com", "6.6.6.2")
+	ansible := newTestLogin("ansible", "automation@foo.com", "10.1.2.3")
+	robot := newTestLogin("robot", "robot@foo.com", "6.6.6.3")
+
+	type testCase struct {
+		ae      *aucoalesce.Event
+		login   *common.RemoteUserLogin
+		expKeep bool
+	}
+
+	for name, tc := range map[string]testCase{
+		"no match": {
+			ae:      newTestEvent("/usr/bin/vim", "execve", "executed", "success"),
+			login:   &core,
+			expKeep: true,
+		},
+		"exe": {
+			ae:      newTestEvent("/usr/bin/locale", "execve", "executed", "success"),
+			login:   &core,
+			expKeep: false,
+		},
+		"exe glob": {
+			ae:      newTestEvent("/usr/lib/git-core/git-remote-https", "execve", "executed", "success"),
+			login:   &core,
+			expKeep: false,
+		},
+		"exe without login": {
+			ae:      newTestEvent("/usr/bin/lesspipe", "execve", "executed", "success"),
+			expKeep: false,
+		},
+		"earlier keep rule": {
+			ae:      withTags(newTestEvent("/usr/bin/locale", "execve", "executed", "success"), "privesc"),
+			login:   &core,
+			expKeep: true,
+		},
+		"syscall and result": {
+			ae:      newTestEvent("/usr/bin/ls", "newfstatat", "", "fail"),
+			login:   &core,
+			expKeep: false,
+		},
+		"syscall, different result": {
+			ae:      newTestEvent("/usr/bin/ls", "newfstatat", "", "success"),
+			login:   &core,
+			expKeep: true,
+		},
+		"logged as and source cidr": {
+			ae:      newTestEvent("/usr/bin/apt", "execve", "executed", "success"),
+			login:   &ansible,
+			expKeep: false,
+		},
+		"logged as, different source": {
+			ae:      newTestEvent("/usr/bin/apt", "execve", "executed", "success"),
+			login:   ptr(newTestLogin("ansible", "automation@foo.com", "6.6.6.2")),
+			expKeep: true,
+		},
+		"user id and action": {
+			ae:      newTestEvent("/usr/bin/apt", "execve", "executed", "success"),
+			login:   &robot,
+			expKeep: false,
+		},
+		"login fields without login": {
+			ae:      newTestEvent("/usr/bin/apt", "execve", "executed", "success"),
+			expKeep: true,
+		},
+	} {
+		assert.Equal(t, tc.expKeep, filter.Keep(tc.ae, tc.login), name)
+	}
+
+	assert.Equal(t, map[string]float64{
+		"shell-startup": 3,
+		"failed-stat":   1,
+		"automation":    1,
+		"robots":        1,
+	}, filterDrops(t, registry))
+}
+
+func TestFilter_Keep_Nil(t *testing.T) {
+	t.Parallel()
+
+	var filter *Filter
+
+	assert.True(t, filter.Keep(newTestEvent("/usr/bin/locale", "execve", "executed", "success"), nil))
+}
+
+// filterDrops returns the number of events dropped by each rule.
+func filterDrops(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	drops := make(map[string]float64)
+
+	for _, family := range families {
+		if family.GetName() != metrics.MetricsNamespace+"_filter_dropped_events_total" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "rule" {
+					drops[label.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	return drops
+}
+
+func newTestEvent(exe string, syscall string, action string, result string) *aucoalesce.Event {
+	return &aucoalesce.Event{
+		Result: result,
+		Summary: aucoalesce.Summary{
+			Action: action,
+		},
+		Process: aucoalesce.Process{
+			Exe: exe,
+		},
+		Data: map[string]string{
+			"syscall": syscall,
+		},
+	}
+}
+
+func withTags(ae *aucoalesce.Event, tags ...string) *aucoalesce.Event {
+	ae.Tags = tags
+	return ae
+}
+
+func newTestLogin(loggedAs string, userID string, addr string) common.RemoteUserLogin {
+	return common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			Source: auditevent.EventSource{
+				Type:  "IP",
+				Value: addr,
+			},
+			Subjects: map[string]string{
+				"loggedAs": loggedAs,
+				"userID":   userID,
+			},
+		},
+		PID:        999,
+		CredUserID: userID,
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
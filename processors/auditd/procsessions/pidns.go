@@ -0,0 +1,237 @@
+package procsessions
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostPIDNamespace is the value of ParsePIDNamespace's argument that
+// means PIDs belong to the host's (i.e., auditd's) PID namespace.
+const HostPIDNamespace = "host"
+
+// ErrPIDNotFound is returned when no process has a PID
+// in the PID namespace that is being searched.
+var ErrPIDNotFound = errors.New("no process has the pid in the namespace")
+
+// pidNamespaceRE matches the target of a /proc/<pid>/ns/pid
+// symbolic link (e.g., "pid:[4026531836]").
+var pidNamespaceRE = regexp.MustCompile(`^pid:\[\d+\]$`)
+
+// ParsePIDNamespace parses s into a PID namespace identifier like the
+// ones returned by PIDNamespace (e.g., "pid:[4026532561]"). s may be an
+// identifier, its inode number, or the path to a PID namespace file
+// (e.g., "/proc/1234/ns/pid"). An empty string is returned if s is empty
+// or HostPIDNamespace.
+func ParsePIDNamespace(s string) (string, error) {
+	switch {
+	case s == "" || s == HostPIDNamespace:
+		return "", nil
+	case pidNamespaceRE.MatchString(s):
+		return s, nil
+	case strings.Contains(s, "/"):
+		return readPIDNamespace(s)
+	}
+
+	_, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid pid namespace: '%s' (expected '%s', an inode number, 'pid:[<inode>]' or a path)",
+			s, HostPIDNamespace)
+	}
+
+	return "pid:[" + s + "]", nil
+}
+
+// PIDNamespace returns the identifier of the PID namespace of the
+// process with the given PID by reading the proc file system mounted
+// at procRoot.
+func PIDNamespace(procRoot string, pid int) (string, error) {
+	return readPIDNamespace(filepath.Join(procRoot, strconv.Itoa(pid), "ns", "pid"))
+}
+
+// readPIDNamespace reads the target of a PID namespace file.
+func readPIDNamespace(nsPath string) (string, error) {
+	namespace, err := os.Readlink(nsPath)
+	if err != nil {
+		return "", err
+	}
+
+	if !pidNamespaceRE.MatchString(namespace) {
+		return "", fmt.Errorf("'%s' is not a pid namespace ('%s')", nsPath, namespace)
+	}
+
+	return namespace, nil
+}
+
+// HostPIDCache translates PIDs from a PID namespace to the namespace
+// of the proc file system mounted at procRoot. This translates the PIDs
+// logged by a containerized process (e.g., sshd) into the PIDs reported
+// by the host's auditd. Every process is checked when the proc file
+// system is scanned, so the PIDs of all the processes in the namespace
+// are cached by each scan. A cached PID is checked by reading only its
+// process' files. The proc file system is scanned at most once per
+// minScanInterval, so PIDs that cannot be found (e.g., because their
+// processes exited) do not cause a scan each time.
+type HostPIDCache struct {
+	procRoot        string
+	namespace       string
+	minScanInterval time.Duration
+
+	mtx      sync.Mutex
+	hostPIDs map[int]int
+	lastScan time.Time
+}
+
+// NewHostPIDCache returns a new HostPIDCache that translates
+// PIDs from the given PID namespace.
+func NewHostPIDCache(procRoot string, namespace string, minScanInterval time.Duration) *HostPIDCache {
+	return &HostPIDCache{
+		procRoot:        procRoot,
+		namespace:       namespace,
+		minScanInterval: minScanInterval,
+		hostPIDs:        make(map[int]int),
+	}
+}
+
+// HostPID returns the PID, in the namespace of the proc file system,
+// of the process whose PID is nsPID in the cache's PID namespace.
+func (o *HostPIDCache) HostPID(nsPID int) (int, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	hostPID, ok := o.hostPIDs[nsPID]
+	if ok && o.isNamespacePID(hostPID, nsPID) {
+		return hostPID, nil
+	}
+
+	if !o.lastScan.IsZero() && time.Since(o.lastScan) < o.minScanInterval {
+		return 0, fmt.Errorf("failed to find pid %d in namespace %s since the last scan - %w",
+			nsPID, o.namespace, ErrPIDNotFound)
+	}
+
+	err := o.scanUnsafe()
+	if err != nil {
+		return 0, err
+	}
+
+	hostPID, ok = o.hostPIDs[nsPID]
+	if !ok {
+		return 0, fmt.Errorf("failed to find pid %d in namespace %s - %w",
+			nsPID, o.namespace, ErrPIDNotFound)
+	}
+
+	return hostPID, nil
+}
+
+// isNamespacePID returns true if the process whose PID is
+// hostPID has the PID nsPID in the cache's PID namespace
+// (i.e., the PID was not reused by another process).
+func (o *HostPIDCache) isNamespacePID(hostPID int, nsPID int) bool {
+	pid, err := NamespacePID(o.procRoot, hostPID)
+	if err != nil || pid != nsPID {
+		return false
+	}
+
+	namespace, err := PIDNamespace(o.procRoot, hostPID)
+
+	return err == nil && namespace == o.namespace
+}
+
+// scanUnsafe replaces the cached PIDs with the PIDs
+// of the processes in the cache's PID namespace.
+func (o *HostPIDCache) scanUnsafe() error {
+	dirEntries, err := os.ReadDir(o.procRoot)
+	if err != nil {
+		return fmt.Errorf("failed to read proc directory '%s' - %w", o.procRoot, err)
+	}
+
+	o.lastScan = time.Now()
+
+	hostPIDs := make(map[int]int)
+
+	for _, dirEntry := range dirEntries {
+		pid, err := strconv.Atoi(dirEntry.Name())
+		if err != nil || !dirEntry.IsDir() {
+			continue
+		}
+
+		processNamespace, err := PIDNamespace(o.procRoot, pid)
+		if err != nil || processNamespace != o.namespace {
+			continue
+		}
+
+		nsPID, err := NamespacePID(o.procRoot, pid)
+		if err != nil {
+			continue
+		}
+
+		hostPIDs[nsPID] = pid
+	}
+
+	o.hostPIDs = hostPIDs
+
+	return nil
+}
+
+// NamespacePID returns the PID of a process in its own PID namespace
+// (e.g., its PID in a container), given its PID in the namespace of the
+// proc file system mounted at procRoot. It is the reverse of
+// HostPIDCache.HostPID.
+func NamespacePID(procRoot string, pid int) (int, error) {
+	nsPIDs, err := NamespacePIDs(procRoot, pid)
+	if err != nil {
+		return 0, err
+	}
+
+	return nsPIDs[len(nsPIDs)-1], nil
+}
+
+// NamespacePIDs returns the PIDs of a process in each of the PID
+// namespaces it belongs to, from the namespace of the proc file system
+// mounted at procRoot to the process' own PID namespace. They are read
+// from the "NSpid" field of the process' status file.
+func NamespacePIDs(procRoot string, pid int) ([]int, error) {
+	statusPath := filepath.Join(procRoot, strconv.Itoa(pid), "status")
+
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "NSpid:"))
+		if len(fields) == 0 {
+			break
+		}
+
+		nsPIDs := make([]int, len(fields))
+		for i, field := range fields {
+			nsPIDs[i], err = strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' contains an invalid namespace pid - %w", statusPath, err)
+			}
+		}
+
+		return nsPIDs, nil
+	}
+
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+
+	return nil, fmt.Errorf("'%s' does not contain namespace pids", statusPath)
+}
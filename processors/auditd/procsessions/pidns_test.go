@@ -0,0 +1,181 @@
+package procsessions
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testHostNamespace      = "pid:[4026531836]"
+	testContainerNamespace = "pid:[4026532561]"
+)
+
+type testNSProc struct {
+	pid       int
+	namespace string
+	nsPIDs    []int
+}
+
+func TestParsePIDNamespace(t *testing.T) {
+	t.Parallel()
+
+	procRoot := newTestNSProcRoot(t, []testNSProc{
+		{pid: 3000, namespace: testContainerNamespace, nsPIDs: []int{3000, 1}},
+	})
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "", want: ""},
+		{value: HostPIDNamespace, want: ""},
+		{value: testContainerNamespace, want: testContainerNamespace},
+		{value: "4026532561", want: testContainerNamespace},
+		{value: filepath.Join(procRoot, "3000", "ns", "pid"), want: testContainerNamespace},
+	}
+
+	for _, tt := range tests {
+		namespace, err := ParsePIDNamespace(tt.value)
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, namespace, tt.value)
+	}
+
+	for _, value := range []string{"container", "pid:[abc]", "-1", filepath.Join(procRoot, "3001", "ns", "pid")} {
+		_, err := ParsePIDNamespace(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestHostPIDCache_HostPID(t *testing.T) {
+	t.Parallel()
+
+	procRoot := newTestNSProcRoot(t, []testNSProc{
+		{pid: 1, namespace: testHostNamespace, nsPIDs: []int{1}},
+		{pid: 22, namespace: testHostNamespace, nsPIDs: []int{22}},
+		{pid: 3000, namespace: testContainerNamespace, nsPIDs: []int{3000, 1}},
+		{pid: 3022, namespace: testContainerNamespace, nsPIDs: []int{3022, 22}},
+		{pid: 4022, namespace: "pid:[4026532999]", nsPIDs: []int{4022, 22}},
+	})
+
+	cache := NewHostPIDCache(procRoot, testContainerNamespace, 0)
+
+	pid, err := cache.HostPID(22)
+	require.NoError(t, err)
+	assert.Equal(t, 3022, pid)
+
+	pid, err = cache.HostPID(1)
+	require.NoError(t, err)
+	assert.Equal(t, 3000, pid)
+
+	_, err = cache.HostPID(23)
+	assert.ErrorIs(t, err, ErrPIDNotFound)
+
+	// The proc file system is scanned again when a PID is not cached.
+	writeTestNSProc(t, procRoot, testNSProc{pid: 3023, namespace: testContainerNamespace, nsPIDs: []int{3023, 23}})
+
+	pid, err = cache.HostPID(23)
+	require.NoError(t, err)
+	assert.Equal(t, 3023, pid)
+}
+
+func TestHostPIDCache_MinScanInterval(t *testing.T) {
+	t.Parallel()
+
+	procRoot := newTestNSProcRoot(t, []testNSProc{
+		{pid: 3022, namespace: testContainerNamespace, nsPIDs: []int{3022, 22}},
+	})
+
+	cache := NewHostPIDCache(procRoot, testContainerNamespace, time.Hour)
+
+	pid, err := cache.HostPID(22)
+	require.NoError(t, err)
+	assert.Equal(t, 3022, pid)
+
+	// The new process is not found until the next scan.
+	writeTestNSProc(t, procRoot, testNSProc{pid: 3023, namespace: testContainerNamespace, nsPIDs: []int{3023, 23}})
+
+	_, err = cache.HostPID(23)
+	assert.ErrorIs(t, err, ErrPIDNotFound)
+
+	// Cached PIDs are checked, as their processes may
+	// have exited and their host PIDs may have been reused.
+	require.NoError(t, os.RemoveAll(filepath.Join(procRoot, "3022")))
+	writeTestNSProc(t, procRoot, testNSProc{pid: 3022, namespace: testContainerNamespace, nsPIDs: []int{3022, 24}})
+
+	_, err = cache.HostPID(22)
+	assert.ErrorIs(t, err, ErrPIDNotFound)
+}
+
+func TestNamespacePID(t *testing.T) {
+	t.Parallel()
+
+	procRoot := newTestNSProcRoot(t, []testNSProc{
+		{pid: 22, namespace: testHostNamespace, nsPIDs: []int{22}},
+		{pid: 3022, namespace: testContainerNamespace, nsPIDs: []int{3022, 22}},
+	})
+
+	pid, err := NamespacePID(procRoot, 3022)
+	require.NoError(t, err)
+	assert.Equal(t, 22, pid)
+
+	pid, err = NamespacePID(procRoot, 22)
+	require.NoError(t, err)
+	assert.Equal(t, 22, pid)
+
+	namespace, err := PIDNamespace(procRoot, 3022)
+	require.NoError(t, err)
+	assert.Equal(t, testContainerNamespace, namespace)
+
+	_, err = NamespacePID(procRoot, 3023)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestNamespacePIDs_NoNSpid(t *testing.T) {
+	t.Parallel()
+
+	// Kernels older than 4.1 do not have the NSpid field.
+	procRoot := newTestProcRoot(t, []testProc{
+		{pid: 1000, ppid: 500, cmdline: "sshd: core [priv]"},
+	})
+
+	_, err := NamespacePIDs(procRoot, 1000)
+	assert.Error(t, err)
+}
+
+// newTestNSProcRoot creates a fake proc file system containing
+// the files read by HostPIDCache and returns its path.
+func newTestNSProcRoot(t *testing.T, procs []testNSProc) string {
+	t.Helper()
+
+	procRoot := t.TempDir()
+
+	for _, proc := range procs {
+		writeTestNSProc(t, procRoot, proc)
+	}
+
+	return procRoot
+}
+
+// writeTestNSProc adds a process to a fake proc file system.
+func writeTestNSProc(t *testing.T, procRoot string, proc testNSProc) {
+	t.Helper()
+
+	procDir := filepath.Join(procRoot, strconv.Itoa(proc.pid))
+	require.NoError(t, os.MkdirAll(filepath.Join(procDir, "ns"), 0o700))
+
+	nsPIDs := make([]string, len(proc.nsPIDs))
+	for i, nsPID := range proc.nsPIDs {
+		nsPIDs[i] = strconv.Itoa(nsPID)
+	}
+
+	status := "Name:\tsshd\nPid:\t" + strconv.Itoa(proc.pid) + "\nNSpid:\t" + strings.Join(nsPIDs, "\t") + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "status"), []byte(status), 0o600))
+	require.NoError(t, os.Symlink(proc.namespace, filepath.Join(procDir, "ns", "pid")))
+}
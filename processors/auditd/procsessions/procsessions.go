@@ -0,0 +1,278 @@
+// procsessions package finds SSH sessions that are already active by
+// scanning a proc file system (i.e., /proc). This allows audit events
+// from sessions that started before audito-maldito was started to be
+// attributed to a user, albeit with less detail than a login that was
+// observed in sshd's logs.
+package procsessions
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+const (
+	// DefaultProcRoot is the default path to the proc file system.
+	DefaultProcRoot = "/proc"
+
+	// unsetID is the value of a process' loginuid and
+	// sessionid files when they have not been set.
+	unsetID = "4294967295"
+
+	// sshConnectionEnv is the name of the environment variable
+	// that sshd sets for a user's session. Its value contains
+	// the client's IP address and port.
+	sshConnectionEnv = "SSH_CONNECTION"
+)
+
+// sessionProcRE matches the process title that sshd sets for the
+// unprivileged process of a user's session (e.g., "sshd: core@pts/0").
+// In OpenSSH 9.8+, the process is an sshd-session process instead
+// (e.g., "sshd-session: core@pts/0").
+var sessionProcRE = regexp.MustCompile(`^sshd(?:-session)?: (?P<User>[^\s@]+)@(?P<TTY>pts/\d+|notty)$`)
+
+// Session is an SSH session found in a proc file system.
+type Session struct {
+	// SessionID is the Linux audit session ID.
+	SessionID string
+
+	// LoginUID is the Linux audit login user ID.
+	LoginUID string
+
+	// User is the name of the user that logged in.
+	User string
+
+	// TTY is the session's terminal (e.g., "pts/0" or "notty").
+	TTY string
+
+	// PID is the PID of the unprivileged sshd session process.
+	PID int
+
+	// ParentPID is the PID of the privileged sshd process that
+	// started the session. This is the PID that appears in sshd's
+	// logs and in the session's AUDIT_LOGIN event.
+	ParentPID int
+
+	// SourceAddr and SourcePort identify the client, if they could
+	// be found in the environment of one of the session's processes.
+	SourceAddr string
+	SourcePort string
+}
+
+// Find finds active SSH sessions by scanning the proc file system
+// mounted at procRoot. Processes that disappear during the scan, or
+// that cannot be read, are skipped.
+func Find(procRoot string) ([]Session, error) {
+	dirEntries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proc directory '%s' - %w", procRoot, err)
+	}
+
+	var sessions []Session
+
+	// sessionPIDs maps audit session IDs to the PIDs
+	// of the processes that belong to the session.
+	sessionPIDs := make(map[string][]int)
+
+	for _, dirEntry := range dirEntries {
+		pid, err := strconv.Atoi(dirEntry.Name())
+		if err != nil || !dirEntry.IsDir() {
+			continue
+		}
+
+		procDir := filepath.Join(procRoot, dirEntry.Name())
+
+		sessionID, err := readTrimmed(filepath.Join(procDir, "sessionid"))
+		if err != nil || sessionID == unsetID {
+			continue
+		}
+
+		sessionPIDs[sessionID] = append(sessionPIDs[sessionID], pid)
+
+		session, found, err := sessionFromProc(procDir, pid)
+		if err != nil || !found {
+			continue
+		}
+
+		session.SessionID = sessionID
+		sessions = append(sessions, session)
+	}
+
+	for i := range sessions {
+		for _, pid := range sessionPIDs[sessions[i].SessionID] {
+			addr, port, found := sshConnectionFromEnviron(filepath.Join(procRoot, strconv.Itoa(pid), "environ"))
+			if found {
+				sessions[i].SourceAddr = addr
+				sessions[i].SourcePort = port
+				break
+			}
+		}
+	}
+
+	return sessions, nil
+}
+
+// sessionFromProc returns a Session if the process found at procDir
+// is an sshd session process. SessionID and the source fields are
+// not set.
+func sessionFromProc(procDir string, pid int) (Session, bool, error) {
+	cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline"))
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	// sshd overwrites its arguments with the process title,
+	// which may be followed by null characters or spaces.
+	title := strings.TrimRight(string(cmdline), "\x00 ")
+
+	matches := sessionProcRE.FindStringSubmatch(title)
+	if matches == nil {
+		return Session{}, false, nil
+	}
+
+	loginUID, err := readTrimmed(filepath.Join(procDir, "loginuid"))
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	if loginUID == unsetID {
+		return Session{}, false, nil
+	}
+
+	ppid, err := parentPID(filepath.Join(procDir, "status"))
+	if err != nil {
+		return Session{}, false, err
+	}
+
+	return Session{
+		LoginUID:  loginUID,
+		User:      matches[sessionProcRE.SubexpIndex("User")],
+		TTY:       matches[sessionProcRE.SubexpIndex("TTY")],
+		PID:       pid,
+		ParentPID: ppid,
+	}, true, nil
+}
+
+// ParentPID returns the parent PID of the process with the given
+// PID by reading the proc file system mounted at procRoot.
+func ParentPID(procRoot string, pid int) (int, error) {
+	return parentPID(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+}
+
+// parentPID reads the parent PID from a /proc/<pid>/status file.
+func parentPID(statusPath string) (int, error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "PPid:") {
+			return strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "PPid:")))
+		}
+	}
+
+	if scanner.Err() != nil {
+		return 0, scanner.Err()
+	}
+
+	return 0, fmt.Errorf("'%s' does not contain a parent pid", statusPath)
+}
+
+// sshConnectionFromEnviron returns the client address and port found
+// in the SSH_CONNECTION environment variable of a /proc/<pid>/environ
+// file. The variable's value looks like:
+//
+//	<client-addr> <client-port> <server-addr> <server-port>
+func sshConnectionFromEnviron(environPath string) (string, string, bool) {
+	environ, err := os.ReadFile(environPath)
+	if err != nil {
+		return "", "", false
+	}
+
+	for _, env := range strings.Split(string(environ), "\x00") {
+		if !strings.HasPrefix(env, sshConnectionEnv+"=") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(env, sshConnectionEnv+"="))
+		if len(fields) < 2 {
+			return "", "", false
+		}
+
+		return fields[0], fields[1], true
+	}
+
+	return "", "", false
+}
+
+// readTrimmed reads the file found at filePath
+// and trims whitespace from its contents.
+func readTrimmed(filePath string) (string, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// RemoteUserLogins converts sessions into a map of audit session IDs
+// to best-effort common.RemoteUserLogin. The logins are marked as low
+// confidence because the credential that the user authenticated with
+// cannot be determined from the proc file system.
+func RemoteUserLogins(sessions []Session, nodeName string, machineID string) map[string]common.RemoteUserLogin {
+	logins := make(map[string]common.RemoteUserLogin, len(sessions))
+
+	for _, session := range sessions {
+		sourceAddr := session.SourceAddr
+		if sourceAddr == "" {
+			sourceAddr = common.UnknownAddr
+		}
+
+		evt := auditevent.NewAuditEvent(
+			common.ActionLoginIdentifier,
+			auditevent.EventSource{
+				Type:  "IP",
+				Value: sourceAddr,
+				Extra: map[string]any{
+					"port": session.SourcePort,
+				},
+			},
+			auditevent.OutcomeSucceeded,
+			map[string]string{
+				"loggedAs": session.User,
+				"pid":      strconv.Itoa(session.ParentPID),
+				"userID":   common.UnknownUser,
+				"loginUID": session.LoginUID,
+			},
+			"sshd",
+		).WithTarget(map[string]string{
+			"host":       nodeName,
+			"machine-id": machineID,
+		})
+
+		evt.LoggedAt = time.Now()
+
+		logins[session.SessionID] = common.RemoteUserLogin{
+			Source:        evt,
+			PID:           session.ParentPID,
+			CredUserID:    common.UnknownUser,
+			LowConfidence: true,
+		}
+	}
+
+	return logins
+}
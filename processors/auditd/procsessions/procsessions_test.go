@@ -0,0 +1,215 @@
+package procsessions
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+type testProc struct {
+	pid       int
+	ppid      int
+	cmdline   string
+	sessionID string
+	loginUID  string
+	environ   string
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	procRoot := newTestProcRoot(t, []testProc{
+		// sshd listener.
+		{pid: 500, ppid: 1, cmdline: "/usr/sbin/sshd\x00-D\x00", sessionID: unsetID, loginUID: unsetID},
+		// Session with a terminal.
+		{pid: 1000, ppid: 500, cmdline: "sshd: core [priv]\x00\x00\x00", sessionID: "5", loginUID: "500"},
+		{pid: 1001, ppid: 1000, cmdline: "sshd: core@pts/0\x00\x00\x00", sessionID: "5", loginUID: "500"},
+		{
+			pid: 1002, ppid: 1001, cmdline: "-bash\x00", sessionID: "5", loginUID: "500",
+			environ: "HOME=/home/core\x00SSH_CONNECTION=6.6.6.2 59145 10.0.0.1 22\x00",
+		},
+		// Session without a terminal. Its child process'
+		// environment is not readable.
+		{pid: 2000, ppid: 500, cmdline: "sshd: root [priv]", sessionID: "6", loginUID: "0"},
+		{pid: 2001, ppid: 2000, cmdline: "sshd: root@notty", sessionID: "6", loginUID: "0"},
+		// Session whose login uid was never set.
+		{pid: 3001, ppid: 3000, cmdline: "sshd: foo@pts/1", sessionID: "7", loginUID: unsetID},
+		// Not an sshd session.
+		{pid: 4000, ppid: 1, cmdline: "sshd: core@pts/0-but-not-really", sessionID: "8", loginUID: "500"},
+		{pid: 4001, ppid: 1, cmdline: "sshd-auth: core@pts/0", sessionID: "8", loginUID: "500"},
+		// Session of OpenSSH 9.8+, whose session
+		// processes are sshd-session processes.
+		{pid: 5000, ppid: 500, cmdline: "sshd-session: alice [priv]", sessionID: "9", loginUID: "501"},
+		{pid: 5001, ppid: 5000, cmdline: "sshd-session: alice@pts/2", sessionID: "9", loginUID: "501"},
+	})
+
+	// Non-process entries are ignored.
+	require.NoError(t, os.Mkdir(filepath.Join(procRoot, "sys"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(procRoot, "uptime"), []byte("1 1"), 0o600))
+
+	sessions, err := Find(procRoot)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []Session{
+		{
+			SessionID:  "5",
+			LoginUID:   "500",
+			User:       "core",
+			TTY:        "pts/0",
+			PID:        1001,
+			ParentPID:  1000,
+			SourceAddr: "6.6.6.2",
+			SourcePort: "59145",
+		},
+		{
+			SessionID: "6",
+			LoginUID:  "0",
+			User:      "root",
+			TTY:       "notty",
+			PID:       2001,
+			ParentPID: 2000,
+		},
+		{
+			SessionID: "9",
+			LoginUID:  "501",
+			User:      "alice",
+			TTY:       "pts/2",
+			PID:       5001,
+			ParentPID: 5000,
+		},
+	}, sessions)
+}
+
+func TestSessionProcRE(t *testing.T) {
+	t.Parallel()
+
+	for title, exp := range map[string][]string{
+		"sshd: core@pts/0":          {"core", "pts/0"},
+		"sshd: root@notty":          {"root", "notty"},
+		"sshd-session: core@pts/0":  {"core", "pts/0"},
+		"sshd-session: root@notty":  {"root", "notty"},
+		"sshd: core [priv]":         nil,
+		"sshd-session: core [priv]": nil,
+		"sshd-auth: core@pts/0":     nil,
+		"sshd-sessions: core@pts/0": nil,
+	} {
+		matches := sessionProcRE.FindStringSubmatch(title)
+		if exp == nil {
+			assert.Nil(t, matches, title)
+			continue
+		}
+
+		require.NotNil(t, matches, title)
+		assert.Equal(t, exp[0], matches[sessionProcRE.SubexpIndex("User")], title)
+		assert.Equal(t, exp[1], matches[sessionProcRE.SubexpIndex("TTY")], title)
+	}
+}
+
+func TestFind_ProcRootDoesNotExist(t *testing.T) {
+	t.Parallel()
+
+	_, err := Find(filepath.Join(t.TempDir(), "proc"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParentPID(t *testing.T) {
+	t.Parallel()
+
+	procRoot := newTestProcRoot(t, []testProc{
+		{pid: 1000, ppid: 500, cmdline: "sshd: core [priv]"},
+	})
+
+	ppid, err := ParentPID(procRoot, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 500, ppid)
+
+	_, err = ParentPID(procRoot, 1001)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRemoteUserLogins(t *testing.T) {
+	t.Parallel()
+
+	logins := RemoteUserLogins([]Session{
+		{
+			SessionID:  "5",
+			LoginUID:   "500",
+			User:       "core",
+			TTY:        "pts/0",
+			PID:        1001,
+			ParentPID:  1000,
+			SourceAddr: "6.6.6.2",
+			SourcePort: "59145",
+		},
+		{
+			SessionID: "6",
+			LoginUID:  "0",
+			User:      "root",
+			TTY:       "notty",
+			PID:       2001,
+			ParentPID: 2000,
+		},
+	}, "blam", "deadbeef")
+
+	require.Len(t, logins, 2)
+
+	rul := logins["5"]
+	require.NoError(t, rul.Validate())
+	assert.True(t, rul.LowConfidence)
+	assert.Equal(t, 1000, rul.PID)
+	assert.Equal(t, common.UnknownUser, rul.CredUserID)
+	assert.Equal(t, common.ActionLoginIdentifier, rul.Source.Type)
+	assert.Equal(t, "6.6.6.2", rul.Source.Source.Value)
+	assert.Equal(t, "59145", rul.Source.Source.Extra["port"])
+	assert.Equal(t, map[string]string{
+		"loggedAs": "core",
+		"pid":      "1000",
+		"userID":   common.UnknownUser,
+		"loginUID": "500",
+	}, rul.Source.Subjects)
+	assert.Equal(t, map[string]string{
+		"host":       "blam",
+		"machine-id": "deadbeef",
+	}, rul.Source.Target)
+
+	rul = logins["6"]
+	require.NoError(t, rul.Validate())
+	assert.Equal(t, common.UnknownAddr, rul.Source.Source.Value)
+	assert.Equal(t, "root", rul.Source.Subjects["loggedAs"])
+}
+
+// newTestProcRoot creates a fake proc file system containing the
+// files read by Find and returns its path.
+func newTestProcRoot(t *testing.T, procs []testProc) string {
+	t.Helper()
+
+	procRoot := t.TempDir()
+
+	for _, proc := range procs {
+		procDir := filepath.Join(procRoot, strconv.Itoa(proc.pid))
+		require.NoError(t, os.Mkdir(procDir, 0o700))
+
+		files := map[string]string{
+			"cmdline":   proc.cmdline,
+			"sessionid": proc.sessionID,
+			"loginuid":  proc.loginUID,
+			"status":    "Name:\tsshd\nState:\tS (sleeping)\nPid:\t" + strconv.Itoa(proc.pid) + "\nPPid:\t" + strconv.Itoa(proc.ppid) + "\n",
+		}
+
+		if proc.environ != "" {
+			files["environ"] = proc.environ
+		}
+
+		for name, contents := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(procDir, name), []byte(contents), 0o600))
+		}
+	}
+
+	return procRoot
+}
@@ -18,6 +18,7 @@ type reassemblerCB struct {
 	au     sessiontracker.Auditor
 	errors chan<- error
 	after  time.Time
+	before time.Time
 }
 
 func (s *reassemblerCB) ReassemblyComplete(msgs []*auparse.AuditMessage) {
@@ -38,6 +39,10 @@ func (s *reassemblerCB) ReassemblyComplete(msgs []*auparse.AuditMessage) {
 		return
 	}
 
+	if !s.before.IsZero() && event.Timestamp.After(s.before) {
+		return
+	}
+
 	aucoalesce.ResolveIDs(event)
 
 	if err := s.au.AuditdEvent(event); err != nil {
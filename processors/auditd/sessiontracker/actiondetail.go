@@ -0,0 +1,213 @@
+package sessiontracker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+)
+
+const (
+	// ActionDetailProcess adds the process that performed the action
+	// (its PID, parent PID, name, executable and working directory).
+	ActionDetailProcess = "process"
+
+	// ActionDetailFiles adds the files that the action operated on,
+	// as described by the audit event's PATH records.
+	ActionDetailFiles = "files"
+
+	// ActionDetailSyscall adds the system call that performed the
+	// action, its exit code and, if it failed, the errno's name.
+	ActionDetailSyscall = "syscall"
+
+	// ActionDetailUsers adds the process' user and group IDs
+	// (e.g., uid, euid, auid and suid) and their names.
+	ActionDetailUsers = "users"
+
+	// ActionDetailTerminal adds the terminal that the action was
+	// performed from.
+	ActionDetailTerminal = "terminal"
+)
+
+// ActionDetailConfig determines which groups of audit record fields are
+// added to the metadata of UserAction events. Each group is added as a
+// map named after the group (e.g., "process"). A group is omitted if the
+// audit event does not contain any of its fields.
+type ActionDetailConfig struct {
+	Process  bool
+	Files    bool
+	Syscall  bool
+	Users    bool
+	Terminal bool
+}
+
+// AllActionDetails returns an ActionDetailConfig that enables every group.
+func AllActionDetails() ActionDetailConfig {
+	return ActionDetailConfig{
+		Process:  true,
+		Files:    true,
+		Syscall:  true,
+		Users:    true,
+		Terminal: true,
+	}
+}
+
+// ParseActionDetailConfig parses a comma-separated list of group names
+// (e.g., "process,files") into an ActionDetailConfig. "all" enables
+// every group and "none" (or an empty string) disables them.
+func ParseActionDetailConfig(s string) (ActionDetailConfig, error) {
+	var config ActionDetailConfig
+
+	for _, group := range strings.Split(s, ",") {
+		switch strings.TrimSpace(group) {
+		case "", "none":
+		case "all":
+			config = AllActionDetails()
+		case ActionDetailProcess:
+			config.Process = true
+		case ActionDetailFiles:
+			config.Files = true
+		case ActionDetailSyscall:
+			config.Syscall = true
+		case ActionDetailUsers:
+			config.Users = true
+		case ActionDetailTerminal:
+			config.Terminal = true
+		default:
+			return ActionDetailConfig{}, fmt.Errorf("unknown action detail group: %q", group)
+		}
+	}
+
+	return config, nil
+}
+
+// addTo adds the enabled groups of fields found in ae to the
+// metadata of evt.
+func (o ActionDetailConfig) addTo(evt *auditevent.AuditEvent, ae *aucoalesce.Event) {
+	extra := evt.Metadata.Extra
+
+	if o.Process {
+		addNonEmpty(extra, ActionDetailProcess, map[string]string{
+			"pid":  ae.Process.PID,
+			"ppid": ae.Process.PPID,
+			"name": ae.Process.Name,
+			"exe":  ae.Process.Exe,
+			"cwd":  ae.Process.CWD,
+		})
+	}
+
+	if o.Files {
+		files := actionFiles(ae)
+		if len(files) > 0 {
+			extra[ActionDetailFiles] = files
+		}
+	}
+
+	if o.Syscall {
+		syscall := map[string]string{
+			"name": ae.Data["syscall"],
+			"arch": ae.Data["arch"],
+			"exit": ae.Data["exit"],
+		}
+
+		if errno, isErrno := errnoName(ae.Data["exit"]); isErrno {
+			syscall["errno"] = errno
+		}
+
+		addNonEmpty(extra, ActionDetailSyscall, syscall)
+	}
+
+	if o.Users {
+		// User IDs are copied as-is (e.g., "euid": "0"). Their names,
+		// if they were resolved, are suffixed with "Name" (e.g.,
+		// "euidName": "root").
+		users := make(map[string]string, len(ae.User.IDs)+len(ae.User.Names))
+		for k, v := range ae.User.IDs {
+			users[k] = v
+		}
+		for k, v := range ae.User.Names {
+			users[k+"Name"] = v
+		}
+
+		addNonEmpty(extra, ActionDetailUsers, users)
+	}
+
+	if o.Terminal {
+		addNonEmpty(extra, ActionDetailTerminal, map[string]string{
+			"tty":      ae.Data["tty"],
+			"terminal": ae.Data["terminal"],
+		})
+	}
+}
+
+// actionFiles returns the files described by the audit event's PATH
+// records. PARENT records, which describe the directory containing
+// a file, are skipped unless they are the only records. Operations
+// involving more than one file (e.g., rename) produce an entry for
+// each file. Their "nametype" distinguishes them (e.g., "DELETE"
+// for the old name and "CREATE" for the new name).
+func actionFiles(ae *aucoalesce.Event) []map[string]string {
+	var files []map[string]string
+	var parents []map[string]string
+
+	for _, p := range ae.Paths {
+		file := make(map[string]string)
+		for _, k := range []string{"name", "nametype", "inode", "mode", "ouid", "ogid"} {
+			if v := p[k]; v != "" {
+				file[k] = v
+			}
+		}
+
+		if len(file) == 0 {
+			continue
+		}
+
+		if p["nametype"] == "PARENT" {
+			parents = append(parents, file)
+		} else {
+			files = append(files, file)
+		}
+	}
+
+	if len(files) == 0 {
+		return parents
+	}
+
+	return files
+}
+
+// errnoName returns the name of the errno (e.g., "EACCES") found in
+// a system call's exit code. Negative exit codes are errnos. They are
+// usually replaced with their name when the audit record is parsed.
+func errnoName(exit string) (string, bool) {
+	code, err := strconv.Atoi(exit)
+	if err != nil {
+		return exit, strings.HasPrefix(exit, "E")
+	}
+
+	if code >= 0 {
+		return "", false
+	}
+
+	name, found := auparse.AuditErrnoToName[-code]
+
+	return name, found
+}
+
+// addNonEmpty adds the non-empty values of fields to extra
+// under the provided name if there are any.
+func addNonEmpty(extra map[string]any, name string, fields map[string]string) {
+	nonEmpty := make(map[string]string, len(fields))
+	for k, v := range fields {
+		if v != "" {
+			nonEmpty[k] = v
+		}
+	}
+
+	if len(nonEmpty) > 0 {
+		extra[name] = nonEmpty
+	}
+}
@@ -0,0 +1,235 @@
+package sessiontracker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+const (
+	testRenameAuditLog = `type=SYSCALL msg=audit(1668460800.000:31000): arch=c000003e syscall=82 success=yes exit=0 a0=7ffd1c5e6a1b a1=7ffd1c5e6a21 a2=0 a3=0 items=4 ppid=25130 pid=25131 auid=1000 uid=1000 gid=1000 euid=1000 suid=1000 fsuid=1000 egid=1000 sgid=1000 fsgid=1000 tty=pts3 ses=499 comm="mv" exe="/usr/bin/mv" key=(null)
+type=CWD msg=audit(1668460800.000:31000): cwd="/home/someuser"
+type=PATH msg=audit(1668460800.000:31000): item=0 name="/home/someuser" inode=131074 dev=fd:01 mode=040750 ouid=1000 ogid=1000 rdev=00:00 nametype=PARENT cap_fp=0 cap_fi=0 cap_fe=0 cap_fver=0 cap_frootid=0
+type=PATH msg=audit(1668460800.000:31000): item=1 name="/home/someuser" inode=131074 dev=fd:01 mode=040750 ouid=1000 ogid=1000 rdev=00:00 nametype=PARENT cap_fp=0 cap_fi=0 cap_fe=0 cap_fver=0 cap_frootid=0
+type=PATH msg=audit(1668460800.000:31000): item=2 name="old.txt" inode=131080 dev=fd:01 mode=0100640 ouid=1000 ogid=1000 rdev=00:00 nametype=DELETE cap_fp=0 cap_fi=0 cap_fe=0 cap_fver=0 cap_frootid=0
+type=PATH msg=audit(1668460800.000:31000): item=3 name="new.txt" inode=131080 dev=fd:01 mode=0100640 ouid=1000 ogid=1000 rdev=00:00 nametype=CREATE cap_fp=0 cap_fi=0 cap_fe=0 cap_fver=0 cap_frootid=0
+type=PROCTITLE msg=audit(1668460800.000:31000): proctitle=6D76006F6C642E747874006E65772E747874`
+
+	testFailedChmodAuditLog = `type=SYSCALL msg=audit(1668460801.000:31001): arch=c000003e syscall=268 success=no exit=-1 a0=ffffff9c a1=5581d1f1b4d0 a2=1ed a3=0 items=1 ppid=25130 pid=25132 auid=1000 uid=1000 gid=1000 euid=1000 suid=1000 fsuid=1000 egid=1000 sgid=1000 fsgid=1000 tty=pts3 ses=499 comm="chmod" exe="/usr/bin/chmod" key="perm_mod"
+type=CWD msg=audit(1668460801.000:31001): cwd="/home/someuser"
+type=PATH msg=audit(1668460801.000:31001): item=0 name="/etc/shadow" inode=1049321 dev=fd:01 mode=0100640 ouid=0 ogid=42 rdev=00:00 nametype=NORMAL cap_fp=0 cap_fi=0 cap_fe=0 cap_fver=0 cap_frootid=0
+type=PROCTITLE msg=audit(1668460801.000:31001): proctitle=63686D6F6400373535002F6574632F736861646F77`
+)
+
+func TestParseActionDetailConfig(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		s         string
+		expConfig ActionDetailConfig
+	}
+
+	for _, tc := range []testCase{
+		{s: "", expConfig: ActionDetailConfig{}},
+		{s: "none", expConfig: ActionDetailConfig{}},
+		{s: "all", expConfig: AllActionDetails()},
+		{s: "process", expConfig: ActionDetailConfig{Process: true}},
+		{s: "files, syscall", expConfig: ActionDetailConfig{Files: true, Syscall: true}},
+		{s: "users,terminal", expConfig: ActionDetailConfig{Users: true, Terminal: true}},
+	} {
+		config, err := ParseActionDetailConfig(tc.s)
+		require.NoError(t, err, "s: %q", tc.s)
+		assert.Equal(t, tc.expConfig, config, "s: %q", tc.s)
+	}
+
+	_, err := ParseActionDetailConfig("process,environment")
+	assert.Error(t, err)
+}
+
+func TestActionDetailConfig_AddTo_Rename(t *testing.T) {
+	t.Parallel()
+
+	ae := newTestAuditEventFromLog(t, testRenameAuditLog)
+
+	evt := newTestActionEvent()
+	AllActionDetails().addTo(evt, ae)
+
+	assert.Equal(t, map[string]string{
+		"pid":  "25131",
+		"ppid": "25130",
+		"name": "mv",
+		"exe":  "/usr/bin/mv",
+		"cwd":  "/home/someuser",
+	}, evt.Metadata.Extra[ActionDetailProcess])
+
+	files, ok := evt.Metadata.Extra[ActionDetailFiles].([]map[string]string)
+	require.True(t, ok, "files should be a []map[string]string")
+	require.Len(t, files, 2, "parent directories should be skipped")
+	assert.Equal(t, "old.txt", files[0]["name"])
+	assert.Equal(t, "DELETE", files[0]["nametype"])
+	assert.Equal(t, "new.txt", files[1]["name"])
+	assert.Equal(t, "CREATE", files[1]["nametype"])
+	assert.Equal(t, "131080", files[1]["inode"])
+
+	syscall, ok := evt.Metadata.Extra[ActionDetailSyscall].(map[string]string)
+	require.True(t, ok, "syscall should be a map[string]string")
+	assert.Equal(t, "rename", syscall["name"])
+	assert.Equal(t, "0", syscall["exit"])
+	assert.NotContains(t, syscall, "errno")
+
+	users, ok := evt.Metadata.Extra[ActionDetailUsers].(map[string]string)
+	require.True(t, ok, "users should be a map[string]string")
+	for _, id := range []string{"uid", "euid", "auid", "suid"} {
+		assert.Equal(t, "1000", users[id], "id: %s", id)
+	}
+
+	assert.Equal(t, map[string]string{"tty": "pts3"}, evt.Metadata.Extra[ActionDetailTerminal])
+}
+
+func TestActionDetailConfig_AddTo_Failed(t *testing.T) {
+	t.Parallel()
+
+	ae := newTestAuditEventFromLog(t, testFailedChmodAuditLog)
+	require.Equal(t, "fail", ae.Result)
+
+	evt := newTestActionEvent()
+	ActionDetailConfig{Files: true, Syscall: true}.addTo(evt, ae)
+
+	assert.Equal(t, []map[string]string{{
+		"name":     "/etc/shadow",
+		"nametype": "NORMAL",
+		"inode":    "1049321",
+		"mode":     "0100640",
+		"ouid":     "0",
+		"ogid":     "42",
+	}}, evt.Metadata.Extra[ActionDetailFiles])
+
+	syscall, ok := evt.Metadata.Extra[ActionDetailSyscall].(map[string]string)
+	require.True(t, ok, "syscall should be a map[string]string")
+	assert.Equal(t, "fchmodat", syscall["name"])
+	assert.Equal(t, "EPERM", syscall["errno"])
+
+	assert.NotContains(t, evt.Metadata.Extra, ActionDetailProcess)
+	assert.NotContains(t, evt.Metadata.Extra, ActionDetailUsers)
+	assert.NotContains(t, evt.Metadata.Extra, ActionDetailTerminal)
+}
+
+func TestActionDetailConfig_AddTo_None(t *testing.T) {
+	t.Parallel()
+
+	ae := newTestAuditEventFromLog(t, testRenameAuditLog)
+
+	evt := newTestActionEvent()
+	ActionDetailConfig{}.addTo(evt, ae)
+
+	assert.Equal(t, newTestActionEvent().Metadata.Extra, evt.Metadata.Extra)
+}
+
+func TestActionFiles_OnlyParents(t *testing.T) {
+	t.Parallel()
+
+	files := actionFiles(&aucoalesce.Event{
+		Paths: []map[string]string{
+			{"name": "/tmp", "nametype": "PARENT"},
+			{"cap_fp": "0"},
+		},
+	})
+
+	assert.Equal(t, []map[string]string{{"name": "/tmp", "nametype": "PARENT"}}, files)
+}
+
+func TestErrnoName(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		exit     string
+		expName  string
+		expErrno bool
+	}
+
+	for _, tc := range []testCase{
+		{exit: "-13", expName: "EACCES", expErrno: true},
+		{exit: "EACCES", expName: "EACCES", expErrno: true},
+		{exit: "0", expErrno: false},
+		{exit: "3", expErrno: false},
+		{exit: "", expErrno: false},
+	} {
+		name, isErrno := errnoName(tc.exit)
+		assert.Equal(t, tc.expErrno, isErrno, "exit: %q", tc.exit)
+		if tc.expErrno {
+			assert.Equal(t, tc.expName, name, "exit: %q", tc.exit)
+		}
+	}
+}
+
+func TestSessionTracker_AuditdEvent_ActionDetails(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 1)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+	st.SetActionDetailConfig(ActionDetailConfig{Process: true})
+
+	u := &user{srcPID: 999}
+	u.setRemoteUserLoginInfo(common.RemoteUserLogin{
+		Source:     &auditevent.AuditEvent{},
+		PID:        999,
+		CredUserID: "foo",
+	}, "some-correlation-id", CorrelatedByPID)
+	st.sessIDsToUsers.Store("499", u)
+
+	err := st.AuditdEvent(newTestAuditEventFromLog(t, testRenameAuditLog))
+	require.NoError(t, err)
+
+	event := <-events
+	assert.Equal(t, "/home/someuser", event.Metadata.Extra[ActionDetailProcess].(map[string]string)["cwd"])
+	assert.NotContains(t, event.Metadata.Extra, ActionDetailFiles)
+}
+
+// newTestAuditEventFromLog parses and coalesces the audit
+// messages found in log into a single audit event.
+func newTestAuditEventFromLog(t *testing.T, log string) *aucoalesce.Event {
+	t.Helper()
+
+	var msgs []*auparse.AuditMessage
+	for _, line := range strings.Split(log, "\n") {
+		msg, err := auparse.ParseLogLine(line)
+		require.NoError(t, err)
+
+		msgs = append(msgs, msg)
+	}
+
+	ae, err := aucoalesce.CoalesceMessages(msgs)
+	require.NoError(t, err)
+
+	return ae
+}
+
+// newTestActionEvent returns an audit event resembling
+// the output of user.toAuditEvent.
+func newTestActionEvent() *auditevent.AuditEvent {
+	evt := auditevent.NewAuditEvent(common.ActionUserAction, auditevent.EventSource{},
+		auditevent.OutcomeSucceeded, map[string]string{}, "auditd")
+	evt.Metadata.Extra = map[string]any{
+		"action": "renamed",
+	}
+
+	return evt
+}
@@ -0,0 +1,209 @@
+package sessiontracker
+
+import (
+	"fmt"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+// OverflowPolicy determines what happens to the cached audit events
+// that are evicted when a CacheConfig limit is reached.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest means the oldest cached audit events are
+	// evicted and discarded. This is the default.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowWriteUnattributed means the oldest cached audit events
+	// are evicted and written as UserAction events that are marked
+	// with "unattributed": true. The process that caused each event is
+	// used as its source because the remote user login is unknown.
+	OverflowWriteUnattributed OverflowPolicy = "write-unattributed"
+)
+
+// ParseOverflowPolicy parses s into an OverflowPolicy.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch OverflowPolicy(s) {
+	case OverflowDropOldest, OverflowWriteUnattributed:
+		return OverflowPolicy(s), nil
+	default:
+		return "", fmt.Errorf("unknown cache overflow policy: %q", s)
+	}
+}
+
+// CacheConfig limits the number of audit events that are cached while
+// waiting for the remote user logins of their audit sessions.
+type CacheConfig struct {
+	// MaxEventsPerSession is the maximum number of audit events
+	// cached for a single audit session. Zero means no limit.
+	MaxEventsPerSession int
+
+	// MaxEvents is the maximum number of audit events cached for
+	// all audit sessions. The oldest events are evicted first,
+	// regardless of their session. Zero means no limit.
+	MaxEvents int
+
+	// Overflow determines what happens to evicted audit events.
+	// A zero value is equivalent to OverflowDropOldest.
+	Overflow OverflowPolicy
+}
+
+// policy returns the OverflowPolicy to apply to evicted audit events.
+func (o CacheConfig) policy() OverflowPolicy {
+	if o.Overflow == "" {
+		return OverflowDropOldest
+	}
+
+	return o.Overflow
+}
+
+// cacheEvent caches an audit event in the user object until its remote
+// user login is found. The user's oldest cached events are evicted if
+// the user has more than CacheConfig.MaxEventsPerSession events.
+//
+// The caller must hold the sessIDsToUsers lock if u is stored in it.
+func (o *sessionTracker) cacheEvent(sessionID string, u *user, event *aucoalesce.Event) error {
+	u.cached = append(u.cached, event)
+	o.numCached.Add(1)
+
+	var err error
+	if max := o.cacheConfig.MaxEventsPerSession; max > 0 && len(u.cached) > max {
+		err = o.evict(sessionID, u, len(u.cached)-max)
+	}
+
+	o.updateCacheMetrics()
+
+	return err
+}
+
+// enforceCacheLimit evicts the oldest cached audit events of all audit
+// sessions until no more than CacheConfig.MaxEvents events are cached.
+// The sessions are ordered by their oldest cached event, so the session
+// to evict from is found without iterating over every session.
+//
+// It must not be called while the sessIDsToUsers lock is held.
+func (o *sessionTracker) enforceCacheLimit() error {
+	max := o.cacheConfig.MaxEvents
+	if max <= 0 {
+		return nil
+	}
+
+	var err error
+	for err == nil && o.numCached.Load() > int64(max) {
+		oldestID, nextOldest, ok := o.sessIDsToUsers.OldestCached()
+		if !ok {
+			break
+		}
+
+		excess := int(o.numCached.Load()) - max
+
+		err = o.sessIDsToUsers.WithLockedValueDo(oldestID, func(u *user) error {
+			// Evict the session's events that are older than
+			// every other session's events in one pass.
+			n := 1
+			for n < excess && n < len(u.cached) &&
+				(nextOldest == nil || u.cached[n].Timestamp.Before(*nextOldest)) {
+				n++
+			}
+
+			return o.evict(oldestID, u, n)
+		})
+	}
+
+	o.updateCacheMetrics()
+
+	return err
+}
+
+// evict removes the n oldest cached audit events of a user and applies
+// the CacheConfig's OverflowPolicy to them.
+//
+// The caller must hold the sessIDsToUsers lock if u is stored in it.
+func (o *sessionTracker) evict(sessionID string, u *user, n int) error {
+	if n > len(u.cached) {
+		n = len(u.cached)
+	}
+
+	if n <= 0 {
+		return nil
+	}
+
+	evicted := make([]*aucoalesce.Event, n)
+	copy(evicted, u.cached)
+
+	// Allow the evicted events to be garbage collected
+	// before the cache's underlying array is reallocated.
+	for i := 0; i < n; i++ {
+		u.cached[i] = nil
+	}
+
+	u.cached = u.cached[n:]
+	o.numCached.Add(-int64(n))
+
+	policy := o.cacheConfig.policy()
+	if o.metrics != nil {
+		o.metrics.AddCacheEvictions(string(policy), float64(n))
+	}
+
+	for _, ae := range evicted {
+		// The session summary written when the session
+		// ends should include the evicted events.
+		u.countAction(ae)
+
+		if policy != OverflowWriteUnattributed {
+			continue
+		}
+
+		err := o.writeUnattributed(sessionID, ae)
+		if err != nil {
+			return &SessionTrackerError{
+				auditWriteFail: true,
+				message:        fmt.Sprintf("failed to write unattributed event - %s", err),
+				inner:          err,
+			}
+		}
+	}
+
+	return nil
+}
+
+// uncache accounts for a user's cached audit events
+// being removed from the cache by other means (e.g.,
+// because they were written or their user was deleted).
+func (o *sessionTracker) uncache(n int) {
+	if n == 0 {
+		return
+	}
+
+	o.numCached.Add(-int64(n))
+	o.updateCacheMetrics()
+}
+
+// writeUnattributed writes a UserAction event for an audit event whose
+// session's remote user login is unknown. The event is created the same
+// way as a SystemAction event, except that it is linked to the audit
+// session and marked with "unattributed": true.
+func (o *sessionTracker) writeUnattributed(sessionID string, ae *aucoalesce.Event) error {
+	if !o.keyFilter.allows(ae.Tags) || !o.eventFilter.Keep(ae, nil) {
+		return nil
+	}
+
+	evt := o.systemActions.toAuditEvent(ae)
+	evt.Type = common.ActionUserAction
+	evt.Metadata.AuditID = o.correlationID(sessionID)
+	evt.Metadata.Extra["audit_session_id"] = sessionID
+	evt.Metadata.Extra["unattributed"] = true
+
+	o.actionDetails.addTo(evt, ae)
+
+	return o.eventWriter.Write(evt)
+}
+
+func (o *sessionTracker) updateCacheMetrics() {
+	if o.metrics != nil {
+		o.metrics.SetCachedAuditEvents(float64(o.numCached.Load()))
+	}
+}
@@ -0,0 +1,246 @@
+package sessiontracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+func TestParseOverflowPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"drop-oldest", "write-unattributed"} {
+		policy, err := ParseOverflowPolicy(s)
+		require.NoError(t, err)
+		assert.Equal(t, OverflowPolicy(s), policy)
+	}
+
+	_, err := ParseOverflowPolicy("drop-newest")
+	assert.Error(t, err)
+}
+
+func TestSessionTracker_Cache_MaxEventsPerSession(t *testing.T) {
+	t.Parallel()
+
+	st, events, registry := newCacheTestTracker(t, CacheConfig{MaxEventsPerSession: 2})
+
+	started := time.Now()
+
+	login := newAucoalesceEvent(t, "123", "success", started)
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "999"
+	require.NoError(t, st.AuditdEvent(login))
+
+	for i := 1; i <= 3; i++ {
+		ae := newAucoalesceEvent(t, "123", "success", started.Add(time.Duration(i)*time.Second))
+		ae.Summary.Action = "executed"
+		ae.Sequence = uint32(i)
+		require.NoError(t, st.AuditdEvent(ae))
+	}
+
+	assert.Equal(t, int64(2), st.numCached.Load())
+	assert.Equal(t, map[string]float64{"": 2}, metricValues(t, registry, "cached_audit_events"))
+	assert.Equal(t, map[string]float64{"drop-oldest": 2}, metricValues(t, registry, "cache_evictions_total"))
+	assert.Empty(t, events)
+
+	require.NoError(t, st.RemoteLogin(common.RemoteUserLogin{
+		Source:     &auditevent.AuditEvent{},
+		PID:        999,
+		CredUserID: "foo",
+	}))
+
+	assert.Equal(t, int64(0), st.numCached.Load())
+	assert.Equal(t, map[string]float64{"": 0}, metricValues(t, registry, "cached_audit_events"))
+
+	// Only the newest events are written.
+	require.Len(t, events, 2)
+	for i := 0; i < 2; i++ {
+		event := <-events
+		assert.Equal(t, common.ActionUserAction, event.Type)
+		assert.Equal(t, "executed", event.Metadata.Extra["action"])
+	}
+
+	userEnd := newAucoalesceEvent(t, "123", "success", started.Add(time.Minute))
+	userEnd.Type = auparse.AUDIT_USER_END
+	userEnd.Process.PID = "999"
+	require.NoError(t, st.AuditdEvent(userEnd))
+
+	require.Len(t, events, 2)
+	<-events
+
+	// Evicted events are still counted.
+	logout := <-events
+	assert.Equal(t, common.ActionLogoutIdentifier, logout.Type)
+	assert.Equal(t, 3, logout.Metadata.Extra["commands_executed"])
+}
+
+func TestSessionTracker_Cache_WriteUnattributed(t *testing.T) {
+	t.Parallel()
+
+	st, events, registry := newCacheTestTracker(t, CacheConfig{
+		MaxEventsPerSession: 1,
+		Overflow:            OverflowWriteUnattributed,
+	})
+
+	login := newSystemAucoalesceEvent(t, "123")
+	login.Type = auparse.AUDIT_LOGIN
+	require.NoError(t, st.AuditdEvent(login))
+
+	ls := newSystemAucoalesceEvent(t, "123")
+	require.NoError(t, st.AuditdEvent(ls))
+
+	assert.Equal(t, int64(1), st.numCached.Load())
+	assert.Equal(t, map[string]float64{"write-unattributed": 1}, metricValues(t, registry, "cache_evictions_total"))
+
+	require.Len(t, events, 1)
+
+	event := <-events
+	assert.Equal(t, common.ActionUserAction, event.Type)
+	assert.Equal(t, "/usr/bin/passwd", event.Source.Value)
+	assert.Equal(t, "root", event.Subjects["uidName"])
+	assert.Equal(t, st.correlationID("123"), event.Metadata.AuditID)
+	assert.Equal(t, "123", event.Metadata.Extra["audit_session_id"])
+	assert.Equal(t, true, event.Metadata.Extra["unattributed"])
+}
+
+func TestSessionTracker_Cache_MaxEvents(t *testing.T) {
+	t.Parallel()
+
+	st, _, registry := newCacheTestTracker(t, CacheConfig{MaxEvents: 3})
+
+	started := time.Now()
+
+	newEvent := func(sessionID string, pid string, offset time.Duration) *aucoalesce.Event {
+		ae := newAucoalesceEvent(t, sessionID, "success", started.Add(offset))
+		ae.Process.PID = pid
+		return ae
+	}
+
+	login1 := newEvent("1", "1001", 0)
+	login1.Type = auparse.AUDIT_LOGIN
+	require.NoError(t, st.AuditdEvent(login1))
+
+	login2 := newEvent("2", "1002", time.Second)
+	login2.Type = auparse.AUDIT_LOGIN
+	require.NoError(t, st.AuditdEvent(login2))
+
+	action1 := newEvent("1", "1001", 2*time.Second)
+	require.NoError(t, st.AuditdEvent(action1))
+
+	action2 := newEvent("2", "1002", 3*time.Second)
+	require.NoError(t, st.AuditdEvent(action2))
+
+	// The oldest event of all of the sessions is evicted.
+	assert.Equal(t, int64(3), st.numCached.Load())
+	assert.Equal(t, []*aucoalesce.Event{action1}, cachedEvents(t, st, "1"))
+	assert.Equal(t, []*aucoalesce.Event{login2, action2}, cachedEvents(t, st, "2"))
+
+	action3 := newEvent("1", "1001", 4*time.Second)
+	require.NoError(t, st.AuditdEvent(action3))
+
+	assert.Equal(t, int64(3), st.numCached.Load())
+	assert.Equal(t, []*aucoalesce.Event{action1, action3}, cachedEvents(t, st, "1"))
+	assert.Equal(t, []*aucoalesce.Event{action2}, cachedEvents(t, st, "2"))
+
+	assert.Equal(t, map[string]float64{"drop-oldest": 2}, metricValues(t, registry, "cache_evictions_total"))
+	assert.Equal(t, map[string]float64{"": 3}, metricValues(t, registry, "cached_audit_events"))
+}
+
+func TestSessionTracker_DeleteUsersWithoutLoginsBefore_Unattributed(t *testing.T) {
+	t.Parallel()
+
+	st, events, registry := newCacheTestTracker(t, CacheConfig{Overflow: OverflowWriteUnattributed})
+
+	login := newSystemAucoalesceEvent(t, "123")
+	login.Type = auparse.AUDIT_LOGIN
+	require.NoError(t, st.AuditdEvent(login))
+	require.NoError(t, st.AuditdEvent(newSystemAucoalesceEvent(t, "123")))
+
+	require.NoError(t, st.DeleteUsersWithoutLoginsBefore(time.Now().Add(time.Minute)))
+
+	assert.Equal(t, 0, st.sessIDsToUsers.Len())
+	assert.Equal(t, int64(0), st.numCached.Load())
+	assert.Equal(t, map[string]float64{"": 1}, metricValues(t, registry, "unattributed_sessions_expired_total"))
+	assert.Equal(t, map[string]float64{"write-unattributed": 2}, metricValues(t, registry, "cache_evictions_total"))
+
+	require.Len(t, events, 2)
+	for len(events) > 0 {
+		event := <-events
+		assert.Equal(t, true, event.Metadata.Extra["unattributed"])
+	}
+}
+
+// newCacheTestTracker returns a sessionTracker that uses config and
+// reports metrics to the returned registry.
+func newCacheTestTracker(t *testing.T, config CacheConfig) (*sessionTracker, chan *auditevent.AuditEvent, *prometheus.Registry) {
+	t.Helper()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancelFn)
+
+	events := make(chan *auditevent.AuditEvent, 10)
+	registry := prometheus.NewRegistry()
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+	st.SetCacheConfig(config)
+	st.SetMetrics(metrics.NewPrometheusMetricsProviderForRegisterer(registry))
+
+	return st, events, registry
+}
+
+// cachedEvents returns the audit events cached for an audit session.
+func cachedEvents(t *testing.T, st *sessionTracker, sessionID string) []*aucoalesce.Event {
+	t.Helper()
+
+	u, found := st.sessIDsToUsers.Load(sessionID)
+	require.True(t, found, "session %q should exist", sessionID)
+
+	return u.cached
+}
+
+// metricValues returns the values of a metric keyed by their
+// label values. Metrics without labels use an empty key.
+func metricValues(t *testing.T, registry *prometheus.Registry, name string) map[string]float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+
+	for _, family := range families {
+		if family.GetName() != metrics.MetricsNamespace+"_"+name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			var key string
+			for _, label := range metric.GetLabel() {
+				key += label.GetValue()
+			}
+
+			if metric.GetGauge() != nil {
+				values[key] = metric.GetGauge().GetValue()
+			} else {
+				values[key] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return values
+}
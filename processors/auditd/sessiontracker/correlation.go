@@ -0,0 +1,370 @@
+package sessiontracker
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/procsessions"
+)
+
+// CorrelationStrategy is how a remote user login was matched to its
+// audit session. It is added to the session's UserAction and
+// UserLogout events as "correlation_strategy".
+type CorrelationStrategy string
+
+const (
+	// CorrelatedByPID means the PID of the process that logged the
+	// remote user login is the PID of the session's AUDIT_LOGIN event.
+	CorrelatedByPID CorrelationStrategy = "pid"
+
+	// CorrelatedByParentPID means the process that logged the remote
+	// user login is the parent or the child of the process that
+	// produced the session's AUDIT_LOGIN event.
+	CorrelatedByParentPID CorrelationStrategy = "parent-pid"
+
+	// CorrelatedByAddress means the source address of the remote user
+	// login is the address found in the session's audit events, and
+	// no other login or session waiting to be matched has it.
+	CorrelatedByAddress CorrelationStrategy = "address"
+
+	// CorrelatedByExistingSession means the session started before
+	// the sessionTracker was created and its remote user login was
+	// provided by AddExistingSession.
+	CorrelatedByExistingSession CorrelationStrategy = "existing-session"
+)
+
+// CorrelationConfig enables the strategies that are used when a remote
+// user login's PID differs from the PID of its session's AUDIT_LOGIN
+// event. This happens when the process that logs the login and the
+// process that sets the session's login user ID are parent and child
+// (e.g., due to privilege separation, sshd-session, or PAM).
+// Logins are always correlated by PID first.
+type CorrelationConfig struct {
+	// ParentPID enables correlation by parent PID. The parent of
+	// the AUDIT_LOGIN process is found in the audit event's "ppid"
+	// field. The parents of the processes are read from ProcRoot
+	// when it is not.
+	ParentPID bool
+
+	// Address enables correlation by the source IP address and
+	// port of the login, if exactly one pending login and one
+	// pending session share an address. Linux audit records
+	// usually do not include the port. Refer to
+	// sessionTracker.CorrelateByAddress.
+	Address bool
+
+	// AddressGracePeriod is how long a login and a session must
+	// wait to be matched by PID before they are matched by address.
+	// DefaultAddressGracePeriod is used if it is zero.
+	AddressGracePeriod time.Duration
+
+	// ProcRoot is the path to the proc file system used to find
+	// the parents of processes and to translate PIDs between PID
+	// namespaces. The proc file system is not read if it is empty
+	// (e.g., when replaying old logs).
+	ProcRoot string
+
+	// SshdPIDNamespace is the PID namespace of the PIDs in sshd's
+	// logs (e.g., "pid:[4026532561]"), if it is not the namespace
+	// of ProcRoot. This is the case when sshd runs in a container
+	// and auditd runs on the host. The PIDs of remote user logins
+	// are translated to PIDs in ProcRoot's namespace before they
+	// are correlated, using the processes' "NSpid" status fields.
+	// Refer to procsessions.ParsePIDNamespace.
+	SshdPIDNamespace string
+}
+
+// hostPIDScanInterval is how often the proc file system may be scanned
+// to translate the PIDs of remote user logins from sshd's PID namespace.
+// Sessions are usually found by their PIDs in sshd's PID namespace
+// instead, which does not require the proc file system to be read.
+const hostPIDScanInterval = time.Second
+
+// DefaultAddressGracePeriod is the default value of
+// CorrelationConfig.AddressGracePeriod.
+const DefaultAddressGracePeriod = 10 * time.Second
+
+// addressGracePeriod returns the configured AddressGracePeriod,
+// or DefaultAddressGracePeriod if it is not set.
+func (o CorrelationConfig) addressGracePeriod() time.Duration {
+	if o.AddressGracePeriod > 0 {
+		return o.AddressGracePeriod
+	}
+
+	return DefaultAddressGracePeriod
+}
+
+// parentPID returns the parent PID of a process found in the proc file
+// system, or zero if it cannot be found.
+func (o *sessionTracker) parentPID(pid int) int {
+	if o.correlation.ProcRoot == "" {
+		return 0
+	}
+
+	ppid, err := procsessions.ParentPID(o.correlation.ProcRoot, pid)
+	if err != nil {
+		o.l.Debugf("failed to find parent pid of process %d - %s", pid, err)
+		return 0
+	}
+
+	return ppid
+}
+
+// hostPID translates the PID of a remote user login from sshd's PID
+// namespace to ProcRoot's PID namespace. It returns false if the PID
+// does not need to be, or cannot be, translated (e.g., because the
+// process exited).
+func (o *sessionTracker) hostPID(pid int) (int, bool) {
+	if o.hostPIDs == nil {
+		return 0, false
+	}
+
+	hostPID, err := o.hostPIDs.HostPID(pid)
+	if err != nil {
+		o.l.Debugf("failed to translate sshd pid %d to a host pid - %s", pid, err)
+		return 0, false
+	}
+
+	return hostPID, true
+}
+
+// sshdNamespacePID translates the PID of a process that started an
+// audit session to its PID in sshd's PID namespace, which allows remote
+// user logins to be matched to the session without translating their
+// PIDs. Only the process' own files are read. It returns zero if the
+// process is not in sshd's PID namespace.
+func (o *sessionTracker) sshdNamespacePID(pid int) int {
+	if o.correlation.SshdPIDNamespace == "" || o.correlation.ProcRoot == "" {
+		return 0
+	}
+
+	namespace, err := procsessions.PIDNamespace(o.correlation.ProcRoot, pid)
+	if err != nil || namespace != o.correlation.SshdPIDNamespace {
+		return 0
+	}
+
+	nsPID, err := procsessions.NamespacePID(o.correlation.ProcRoot, pid)
+	if err != nil {
+		o.l.Debugf("failed to translate host pid %d to an sshd pid - %s", pid, err)
+		return 0
+	}
+
+	return nsPID
+}
+
+// auditParentPID returns the parent PID of the process that produced an
+// audit event, or zero if it is unknown or correlation by parent PID is
+// disabled.
+func (o *sessionTracker) auditParentPID(event *aucoalesce.Event, pid int) int {
+	if !o.correlation.ParentPID {
+		return 0
+	}
+
+	ppid, err := strconv.Atoi(event.Process.PPID)
+	if err == nil && ppid > 0 {
+		return ppid
+	}
+
+	return o.parentPID(pid)
+}
+
+// findPendingLogin finds the cached remote user login of a new audit
+// session. It returns the login's PID and the strategy that found it.
+func (o *sessionTracker) findPendingLogin(u *user) (int, CorrelationStrategy, bool) {
+	if o.pidsToRULs.Has(u.srcPID) {
+		return u.srcPID, CorrelatedByPID, true
+	}
+
+	// The login's PID may not have been translated
+	// to a host PID because its process exited.
+	if u.nsPID > 0 && o.pidsToRULs.Has(u.nsPID) {
+		return u.nsPID, CorrelatedByPID, true
+	}
+
+	if o.correlation.ParentPID {
+		pid, found := o.pendingRelativeLogin(u)
+		if found {
+			return pid, CorrelatedByParentPID, true
+		}
+	}
+
+	return 0, "", false
+}
+
+// pendingRelativeLogin returns the PID of the cached remote user login
+// that was logged by the parent or a child of the process that started
+// the audit session. It returns false if more than one login matches,
+// as the match is ambiguous. The logins are found by their PIDs and
+// parent PIDs, so the cached logins are not iterated over.
+func (o *sessionTracker) pendingRelativeLogin(u *user) (int, bool) {
+	childPID, _, numChildren := o.pidsToRULs.LoadByIndex(u.srcPID)
+	if numChildren > 1 {
+		return 0, false
+	}
+
+	hasParent := u.srcPPID > 0 && o.pidsToRULs.Has(u.srcPPID)
+
+	switch {
+	case numChildren == 1 && hasParent:
+		return 0, false
+	case numChildren == 1:
+		return childPID, true
+	case hasParent:
+		return u.srcPPID, true
+	default:
+		return 0, false
+	}
+}
+
+// CorrelateByAddress attributes the audit sessions without a remote
+// user login to the cached logins that have their source addresses.
+// A session and a login are only matched if the login's address is
+// found in no other pending session and the session's address is the
+// address of no other cached login. The session and the login must
+// also be older than CorrelationConfig.AddressGracePeriod, so that
+// a session is not matched by address before the PID strategies had
+// a chance to find its login (e.g., because sshd's logs are delayed).
+//
+// It does nothing unless correlation by address is enabled.
+func (o *sessionTracker) CorrelateByAddress(now time.Time) error {
+	if !o.correlation.Address {
+		return nil
+	}
+
+	before := now.Add(-o.correlation.addressGracePeriod())
+
+	// Both maps are locked for the duration, so that no login or
+	// session can be added or matched while they are compared. The
+	// remote user login map is locked before the session map to
+	// avoid a deadlock with auditEventWithoutSession.
+	return o.pidsToRULs.WithLockedValuesDo(func(ruls map[int]common.RemoteUserLogin) error {
+		return o.sessIDsToUsers.WithLockedAllPendingDo(func(pending map[string]*user) error {
+			for sessionID, u := range pending {
+				if u.srcAddr == "" || !u.added.Before(before) {
+					continue
+				}
+
+				rul, found := uniqueLoginWithAddressOf(u, ruls)
+				if !found || !observedAt(rul).Before(before) ||
+					numSessionsWithAddressOf(rul, pending) != 1 {
+					continue
+				}
+
+				err := o.correlateByAddress(sessionID, u, rul)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+}
+
+// correlateByAddress attributes an audit session to a remote user login
+// found by CorrelateByAddress. It must be called while both the remote
+// user login map and the session map are locked.
+func (o *sessionTracker) correlateByAddress(sessionID string, u *user, rul common.RemoteUserLogin) error {
+	if o.l.Level().Enabled(zap.DebugLevel) {
+		o.l.With(
+			"auditSessionID", sessionID,
+			"pid", rul.PID,
+			"correlationStrategy", CorrelatedByAddress).
+			Debugln("found remote user login for audit session")
+	}
+
+	o.pidsToRULs.DeleteUnsafe(rul.PID)
+
+	u.setRemoteUserLoginInfo(rul, o.correlationID(sessionID), CorrelatedByAddress)
+
+	err := o.writeAndClearCache(u)
+
+	if u.disposed {
+		o.sessIDsToUsers.DeleteUnsafe(sessionID)
+	}
+
+	if err != nil {
+		return &SessionTrackerError{
+			auditWriteFail: true,
+			message: fmt.Sprintf("failed to write cached events for user '%s' - %s",
+				rul.CredUserID, err),
+			inner: err,
+		}
+	}
+
+	return nil
+}
+
+// uniqueLoginWithAddressOf returns the remote user login that has the
+// audit session's source address. It returns false if more than one
+// login has it, as the match is ambiguous.
+func uniqueLoginWithAddressOf(u *user, ruls map[int]common.RemoteUserLogin) (common.RemoteUserLogin, bool) {
+	var found common.RemoteUserLogin
+	var numFound int
+
+	for _, rul := range ruls {
+		if !u.hasAddressOf(rul) {
+			continue
+		}
+
+		numFound++
+		if numFound > 1 {
+			return common.RemoteUserLogin{}, false
+		}
+
+		found = rul
+	}
+
+	return found, numFound == 1
+}
+
+// numSessionsWithAddressOf returns the number of audit sessions whose
+// source address is the source address of the remote user login.
+func numSessionsWithAddressOf(rul common.RemoteUserLogin, pending map[string]*user) int {
+	var num int
+
+	for _, u := range pending {
+		if u.hasAddressOf(rul) {
+			num++
+		}
+	}
+
+	return num
+}
+
+// setAddress records the source address found in one of the audit
+// events of a session without a remote user login, if it was not
+// known before.
+func (o *user) setAddress(event *aucoalesce.Event) {
+	if o.hasRUL || o.srcAddr != "" || event.Source == nil || event.Source.IP == "" {
+		return
+	}
+
+	o.srcAddr = event.Source.IP
+	o.srcPort = event.Source.Port
+}
+
+// hasAddressOf returns true if the audit session's source
+// address is the source address of the remote user login.
+func (o *user) hasAddressOf(rul common.RemoteUserLogin) bool {
+	return o.srcAddr != "" && addressesMatch(o.srcAddr, o.srcPort, rul)
+}
+
+// addressesMatch returns true if ip and port are the source address
+// of the remote user login. The ports are only compared if both are
+// known.
+func addressesMatch(ip string, port string, rul common.RemoteUserLogin) bool {
+	loginIP := rul.Source.Source.Value
+	if loginIP == "" || loginIP == common.UnknownAddr || loginIP != ip {
+		return false
+	}
+
+	loginPort, _ := rul.Source.Source.Extra["port"].(string)
+
+	return port == "" || loginPort == "" || port == loginPort
+}
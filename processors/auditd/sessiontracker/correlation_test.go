@@ -0,0 +1,484 @@
+package sessiontracker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+func TestSessionTracker_Correlation_PID(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{ParentPID: true, Address: true})
+
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(1000, "6.6.6.2", "59145")))
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 1000, 1)))
+
+	requireCorrelated(t, events, 1, CorrelatedByPID)
+}
+
+func TestSessionTracker_Correlation_ParentPID_LoginFirst(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{ParentPID: true})
+
+	// The process that logged the login is the
+	// parent of the AUDIT_LOGIN process.
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(1000, "6.6.6.2", "59145")))
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 1001, 1000)))
+
+	requireCorrelated(t, events, 1, CorrelatedByParentPID)
+	assert.Equal(t, 0, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_Correlation_ParentPID_SessionFirst(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{
+		ParentPID: true,
+		ProcRoot:  newCorrelationTestProcRoot(t, map[int]int{1001: 1000}),
+	})
+
+	// The process that logged the login is the child of the
+	// AUDIT_LOGIN process. Its parent is found in the proc
+	// file system.
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 1000, 0)))
+	assert.Empty(t, events)
+
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(1001, "6.6.6.2", "59145")))
+
+	requireCorrelated(t, events, 1, CorrelatedByParentPID)
+}
+
+func TestSessionTracker_Correlation_ParentPID_Ambiguous(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{ParentPID: true})
+
+	login1 := newCorrelationTestRUL(1001, "6.6.6.2", "59145")
+	login1.ParentPID = 1000
+	require.NoError(t, st.RemoteLogin(login1))
+
+	login2 := newCorrelationTestRUL(1002, "6.6.6.3", "59146")
+	login2.ParentPID = 1000
+	require.NoError(t, st.RemoteLogin(login2))
+
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 1000, 0)))
+
+	assert.Empty(t, events)
+	assert.Equal(t, 2, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_Correlation_ParentPID_SessionFirst_Ambiguous(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{ParentPID: true})
+
+	// Both sessions were started by children of the login's process.
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 1001, 1000)))
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "124", 1002, 1000)))
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(1000, "6.6.6.2", "59145")))
+
+	assert.Empty(t, events)
+	assert.Equal(t, 1, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_Correlation_ChildLogin_LoginFirst(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{ParentPID: true})
+
+	// The process that logged the login is the
+	// child of the AUDIT_LOGIN process.
+	login := newCorrelationTestRUL(1001, "6.6.6.2", "59145")
+	login.ParentPID = 1000
+	require.NoError(t, st.RemoteLogin(login))
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 1000, 0)))
+
+	requireCorrelated(t, events, 1, CorrelatedByParentPID)
+	assert.Equal(t, 0, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_Correlation_Address(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{ParentPID: true, Address: true})
+
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(3000, "6.6.6.2", "59145")))
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(3001, "6.6.6.3", "59146")))
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 2000, 1)))
+	assert.Empty(t, events)
+
+	// The address is found in a later event of the session.
+	userStart := newCorrelationTestEvent(t, "123", auparse.AUDIT_USER_START)
+	userStart.Source = &aucoalesce.Address{IP: "6.6.6.2"}
+	require.NoError(t, st.AuditdEvent(userStart))
+
+	// The login and the session are only correlated
+	// by address after the grace period.
+	require.NoError(t, st.CorrelateByAddress(time.Now()))
+	assert.Empty(t, events)
+
+	require.NoError(t, st.CorrelateByAddress(afterAddressGracePeriod()))
+
+	requireCorrelated(t, events, 2, CorrelatedByAddress)
+	assert.Equal(t, 1, st.pidsToRULs.Len())
+	assert.True(t, st.pidsToRULs.Has(3001))
+	assert.Empty(t, st.sessIDsToUsers.pending)
+}
+
+func TestSessionTracker_Correlation_Address_SessionFirst(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{Address: true})
+
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 2000, 1)))
+
+	userStart := newCorrelationTestEvent(t, "123", auparse.AUDIT_USER_START)
+	userStart.Source = &aucoalesce.Address{IP: "6.6.6.2"}
+	require.NoError(t, st.AuditdEvent(userStart))
+
+	// The ports are only compared if both are known.
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(3000, "6.6.6.2", "59145")))
+	assert.Empty(t, events)
+
+	require.NoError(t, st.CorrelateByAddress(afterAddressGracePeriod()))
+
+	requireCorrelated(t, events, 2, CorrelatedByAddress)
+}
+
+func TestSessionTracker_Correlation_Address_AmbiguousLogins(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{Address: true})
+
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(3000, "6.6.6.2", "59145")))
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(3001, "6.6.6.2", "59146")))
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 2000, 1)))
+
+	userStart := newCorrelationTestEvent(t, "123", auparse.AUDIT_USER_START)
+	userStart.Source = &aucoalesce.Address{IP: "6.6.6.2"}
+	require.NoError(t, st.AuditdEvent(userStart))
+
+	require.NoError(t, st.CorrelateByAddress(afterAddressGracePeriod()))
+
+	assert.Empty(t, events)
+	assert.Equal(t, 2, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_Correlation_Address_AmbiguousSessions(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{Address: true})
+
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(3000, "6.6.6.2", "59145")))
+
+	// The login's address is the address of both sessions, even
+	// though each session's address is only the login's address.
+	for _, sessionID := range []string{"123", "124"} {
+		login := newCorrelationTestLogin(t, sessionID, 2000, 1)
+		login.Source = &aucoalesce.Address{IP: "6.6.6.2"}
+		require.NoError(t, st.AuditdEvent(login))
+	}
+
+	require.NoError(t, st.CorrelateByAddress(afterAddressGracePeriod()))
+
+	assert.Empty(t, events)
+	assert.Equal(t, 1, st.pidsToRULs.Len())
+	assert.Len(t, st.sessIDsToUsers.pending, 2)
+}
+
+func TestSessionTracker_Correlation_Address_LatePIDMatch(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{Address: true})
+
+	fooLogin := newCorrelationTestRUL(3000, "6.6.6.2", "59145")
+	fooLogin.Source.Subjects = map[string]string{"userID": "foo"}
+
+	barLogin := newCorrelationTestRUL(3001, "6.6.6.2", "59146")
+	barLogin.Source.Subjects = map[string]string{"userID": "bar"}
+
+	fooSession := newCorrelationTestLogin(t, "123", 3000, 1)
+	fooSession.Source = &aucoalesce.Address{IP: "6.6.6.2"}
+
+	barSession := newCorrelationTestLogin(t, "124", 3001, 1)
+	barSession.Source = &aucoalesce.Address{IP: "6.6.6.2"}
+
+	// foo's session and bar's login are the only pending session
+	// and login with the address until their PID matches arrive.
+	require.NoError(t, st.AuditdEvent(fooSession))
+	require.NoError(t, st.RemoteLogin(barLogin))
+	require.NoError(t, st.CorrelateByAddress(time.Now()))
+	assert.Empty(t, events)
+
+	require.NoError(t, st.AuditdEvent(barSession))
+	require.NoError(t, st.RemoteLogin(fooLogin))
+	require.NoError(t, st.CorrelateByAddress(afterAddressGracePeriod()))
+
+	require.Len(t, events, 2)
+
+	users := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		event := <-events
+		assert.Equal(t, string(CorrelatedByPID), event.Metadata.Extra["correlation_strategy"])
+
+		sessionID, _ := event.Metadata.Extra["audit_session_id"].(string)
+		users[sessionID] = event.Subjects["userID"]
+	}
+
+	assert.Equal(t, map[string]string{"123": "foo", "124": "bar"}, users)
+	assert.Equal(t, 0, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_Correlation_Disabled(t *testing.T) {
+	t.Parallel()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{})
+
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(1000, "6.6.6.2", "59145")))
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 1001, 1000)))
+
+	userStart := newCorrelationTestEvent(t, "123", auparse.AUDIT_USER_START)
+	userStart.Source = &aucoalesce.Address{IP: "6.6.6.2"}
+	require.NoError(t, st.AuditdEvent(userStart))
+	require.NoError(t, st.CorrelateByAddress(afterAddressGracePeriod()))
+
+	assert.Empty(t, events)
+	assert.Equal(t, 1, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_Correlation_PIDNamespace_LoginFirst(t *testing.T) {
+	t.Parallel()
+
+	procRoot := t.TempDir()
+	writeCorrelationTestNSProc(t, procRoot, 3022, 22)
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{
+		ProcRoot:         procRoot,
+		SshdPIDNamespace: testSshdPIDNamespace,
+	})
+
+	// sshd logs its PID in the container's PID namespace.
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(22, "6.6.6.2", "59145")))
+	assert.True(t, st.pidsToRULs.Has(3022))
+
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 3022, 0)))
+
+	requireCorrelated(t, events, 1, CorrelatedByPID)
+}
+
+func TestSessionTracker_Correlation_PIDNamespace_SessionFirst(t *testing.T) {
+	t.Parallel()
+
+	procRoot := t.TempDir()
+	writeCorrelationTestNSProc(t, procRoot, 3022, 22)
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{
+		ProcRoot:         procRoot,
+		SshdPIDNamespace: testSshdPIDNamespace,
+	})
+
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 3022, 0)))
+	assert.Empty(t, events)
+
+	// The session is found by its PID in sshd's PID namespace,
+	// so the proc file system is not read to translate the
+	// login's PID.
+	require.NoError(t, os.RemoveAll(filepath.Join(procRoot, "3022")))
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(22, "6.6.6.2", "59145")))
+
+	requireCorrelated(t, events, 1, CorrelatedByPID)
+
+	u, ok := st.sessIDsToUsers.Load("123")
+	require.True(t, ok)
+	assert.Equal(t, 3022, u.login.PID)
+}
+
+func TestSessionTracker_Correlation_PIDNamespace_Reverse(t *testing.T) {
+	t.Parallel()
+
+	procRoot := t.TempDir()
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{
+		ProcRoot:         procRoot,
+		SshdPIDNamespace: testSshdPIDNamespace,
+	})
+
+	// The login's process cannot be found, so
+	// its PID is not translated to a host PID.
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(22, "6.6.6.2", "59145")))
+	assert.True(t, st.pidsToRULs.Has(22))
+
+	// The session's PID is translated to sshd's PID namespace instead.
+	writeCorrelationTestNSProc(t, procRoot, 3022, 22)
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 3022, 0)))
+
+	requireCorrelated(t, events, 1, CorrelatedByPID)
+	assert.Equal(t, 0, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_Correlation_PIDNamespace_OtherNamespace(t *testing.T) {
+	t.Parallel()
+
+	procRoot := t.TempDir()
+	writeCorrelationTestNSProc(t, procRoot, 3022, 22)
+
+	st, events := newCorrelationTestTracker(t, CorrelationConfig{
+		ProcRoot:         procRoot,
+		SshdPIDNamespace: "pid:[4026532999]",
+	})
+
+	require.NoError(t, st.RemoteLogin(newCorrelationTestRUL(22, "6.6.6.2", "59145")))
+	require.NoError(t, st.AuditdEvent(newCorrelationTestLogin(t, "123", 3022, 0)))
+
+	assert.Empty(t, events)
+	assert.True(t, st.pidsToRULs.Has(22))
+}
+
+func TestAddressesMatch(t *testing.T) {
+	t.Parallel()
+
+	rul := newCorrelationTestRUL(1000, "6.6.6.2", "59145")
+
+	assert.True(t, addressesMatch("6.6.6.2", "59145", rul))
+	assert.True(t, addressesMatch("6.6.6.2", "", rul))
+	assert.False(t, addressesMatch("6.6.6.2", "59146", rul))
+	assert.False(t, addressesMatch("6.6.6.3", "", rul))
+
+	unknown := newCorrelationTestRUL(1000, common.UnknownAddr, "")
+	assert.False(t, addressesMatch(common.UnknownAddr, "", unknown))
+}
+
+// newCorrelationTestTracker returns a sessionTracker that uses config.
+func newCorrelationTestTracker(t *testing.T, config CorrelationConfig) (*sessionTracker, chan *auditevent.AuditEvent) {
+	t.Helper()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancelFn)
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+	st.SetCorrelationConfig(config)
+
+	return st, events
+}
+
+// afterAddressGracePeriod returns a time at which the logins and
+// sessions added by a test can be correlated by address.
+func afterAddressGracePeriod() time.Time {
+	return time.Now().Add(DefaultAddressGracePeriod + time.Second)
+}
+
+// newCorrelationTestRUL returns a remote user login for a client
+// with the given address.
+func newCorrelationTestRUL(pid int, addr string, port string) common.RemoteUserLogin {
+	return common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			Source: auditevent.EventSource{
+				Type:  "IP",
+				Value: addr,
+				Extra: map[string]any{
+					"port": port,
+				},
+			},
+			LoggedAt: time.Now(),
+		},
+		PID:        pid,
+		CredUserID: "foo",
+	}
+}
+
+// newCorrelationTestEvent returns an audit event of the given type.
+func newCorrelationTestEvent(t *testing.T, sessionID string, eventType auparse.AuditMessageType) *aucoalesce.Event {
+	t.Helper()
+
+	ae := newAucoalesceEvent(t, sessionID, "success", time.Now())
+	ae.Type = eventType
+
+	return ae
+}
+
+// newCorrelationTestLogin returns an AUDIT_LOGIN event produced by the
+// process with the given PID. The event has no parent PID if ppid is 0.
+func newCorrelationTestLogin(t *testing.T, sessionID string, pid int, ppid int) *aucoalesce.Event {
+	t.Helper()
+
+	ae := newCorrelationTestEvent(t, sessionID, auparse.AUDIT_LOGIN)
+	ae.Process.PID = strconv.Itoa(pid)
+
+	if ppid > 0 {
+		ae.Process.PPID = strconv.Itoa(ppid)
+	}
+
+	return ae
+}
+
+// newCorrelationTestProcRoot creates a fake proc file system containing
+// the status files of processes, which are given as a map of PIDs to
+// their parent PIDs, and returns its path.
+func newCorrelationTestProcRoot(t *testing.T, parents map[int]int) string {
+	t.Helper()
+
+	procRoot := t.TempDir()
+
+	for pid, ppid := range parents {
+		procDir := filepath.Join(procRoot, strconv.Itoa(pid))
+		require.NoError(t, os.Mkdir(procDir, 0o700))
+
+		status := "Name:\tsshd\nPid:\t" + strconv.Itoa(pid) + "\nPPid:\t" + strconv.Itoa(ppid) + "\n"
+		require.NoError(t, os.WriteFile(filepath.Join(procDir, "status"), []byte(status), 0o600))
+	}
+
+	return procRoot
+}
+
+// testSshdPIDNamespace is the PID namespace of
+// the processes created by writeCorrelationTestNSProc.
+const testSshdPIDNamespace = "pid:[4026532561]"
+
+// writeCorrelationTestNSProc adds a process whose PID is nsPID in
+// testSshdPIDNamespace to a fake proc file system.
+func writeCorrelationTestNSProc(t *testing.T, procRoot string, pid int, nsPID int) {
+	t.Helper()
+
+	procDir := filepath.Join(procRoot, strconv.Itoa(pid))
+	require.NoError(t, os.MkdirAll(filepath.Join(procDir, "ns"), 0o700))
+
+	status := "Name:\tsshd-session\nPid:\t" + strconv.Itoa(pid) + "\nPPid:\t1\nNSpid:\t" +
+		strconv.Itoa(pid) + "\t" + strconv.Itoa(nsPID) + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "status"), []byte(status), 0o600))
+	require.NoError(t, os.Symlink(testSshdPIDNamespace, filepath.Join(procDir, "ns", "pid")))
+}
+
+// requireCorrelated requires numEvents UserAction events to be
+// written for the session, attributed using the given strategy.
+func requireCorrelated(t *testing.T, events chan *auditevent.AuditEvent, numEvents int, strategy CorrelationStrategy) {
+	t.Helper()
+
+	require.Len(t, events, numEvents)
+
+	for i := 0; i < numEvents; i++ {
+		event := <-events
+		assert.Equal(t, common.ActionUserAction, event.Type)
+		assert.Equal(t, "123", event.Metadata.Extra["audit_session_id"])
+		assert.Equal(t, string(strategy), event.Metadata.Extra["correlation_strategy"])
+	}
+}
@@ -5,6 +5,7 @@ type SessionTrackerError struct {
 	remoteLoginFail bool   // set when remote login cannot be validated
 	parsePIDFail    bool   // set when PID of the session cannot be parsed
 	auditWriteFail  bool   // set when the audit event fails to write to event writer
+	staleState      bool   // set when a saved state was produced during a different boot
 	message         string // the error message
 	inner           error  // the error object
 }
@@ -24,6 +25,12 @@ func (o *SessionTrackerError) AuditEventWriteFailed() bool {
 	return o.auditWriteFail
 }
 
+// StaleState returns true when a saved state was not restored
+// because it was produced during a different boot.
+func (o *SessionTrackerError) StaleState() bool {
+	return o.staleState
+}
+
 // Error returns the error message.
 func (o *SessionTrackerError) Error() string {
 	return o.message
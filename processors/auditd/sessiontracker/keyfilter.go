@@ -0,0 +1,62 @@
+package sessiontracker
+
+import (
+	"strings"
+)
+
+// KeyFilter limits the UserAction and SystemAction events that are
+// written to those produced by audit rules with specific keys (e.g.,
+// "-k identity"). This allows the audit rules to determine which
+// actions are written. A zero value KeyFilter allows every event.
+type KeyFilter struct {
+	keys map[string]struct{}
+}
+
+// NewKeyFilter returns a KeyFilter that allows events produced by audit
+// rules with any of the provided keys. Empty keys are ignored. If no
+// keys are provided, the KeyFilter allows every event.
+func NewKeyFilter(keys []string) KeyFilter {
+	filter := KeyFilter{
+		keys: make(map[string]struct{}, len(keys)),
+	}
+
+	for _, key := range keys {
+		if key != "" {
+			filter.keys[key] = struct{}{}
+		}
+	}
+
+	return filter
+}
+
+// ParseKeyFilter parses a comma-separated list of keys
+// (e.g., "identity,privesc") into a KeyFilter.
+func ParseKeyFilter(s string) KeyFilter {
+	keys := strings.Split(s, ",")
+	for i := range keys {
+		keys[i] = strings.TrimSpace(keys[i])
+	}
+
+	return NewKeyFilter(keys)
+}
+
+// Enabled returns true if the KeyFilter only allows some events.
+func (o KeyFilter) Enabled() bool {
+	return len(o.keys) > 0
+}
+
+// allows returns true if an audit event with the provided keys
+// (i.e., aucoalesce.Event.Tags) should be written.
+func (o KeyFilter) allows(keys []string) bool {
+	if !o.Enabled() {
+		return true
+	}
+
+	for _, key := range keys {
+		if _, found := o.keys[key]; found {
+			return true
+		}
+	}
+
+	return false
+}
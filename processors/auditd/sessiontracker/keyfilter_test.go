@@ -0,0 +1,175 @@
+package sessiontracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/auparse"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/eventfilter"
+)
+
+func TestKeyFilter_Allows(t *testing.T) {
+	t.Parallel()
+
+	var zero KeyFilter
+	assert.False(t, zero.Enabled())
+	assert.True(t, zero.allows(nil))
+	assert.True(t, zero.allows([]string{"identity"}))
+
+	empty := ParseKeyFilter("")
+	assert.False(t, empty.Enabled())
+	assert.True(t, empty.allows(nil))
+
+	filter := ParseKeyFilter("identity, privesc,")
+	assert.True(t, filter.Enabled())
+	assert.True(t, filter.allows([]string{"identity"}))
+	assert.True(t, filter.allows([]string{"sshd_config", "privesc"}))
+	assert.False(t, filter.allows([]string{"sshd_config"}))
+	assert.False(t, filter.allows(nil))
+}
+
+func TestSessionTracker_AuditdEvent_KeyFilter(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+	st.SetKeyFilter(NewKeyFilter([]string{"privesc"}))
+	st.SetSystemActionConfig(SystemActionConfig{Mode: SystemActionsAll})
+
+	require.NoError(t, st.RemoteLogin(common.RemoteUserLogin{
+		Source:     &auditevent.AuditEvent{},
+		PID:        999,
+		CredUserID: "foo",
+	}))
+
+	started := time.Now()
+
+	login := newAucoalesceEvent(t, "123", "success", started)
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "999"
+	require.NoError(t, st.AuditdEvent(login))
+
+	sudo := newAucoalesceEvent(t, "123", "success", started.Add(time.Second))
+	sudo.Summary.Action = "executed"
+	sudo.Tags = []string{"privesc"}
+	require.NoError(t, st.AuditdEvent(sudo))
+
+	ls := newAucoalesceEvent(t, "123", "success", started.Add(2*time.Second))
+	ls.Summary.Action = "executed"
+	ls.Tags = []string{"exec"}
+	require.NoError(t, st.AuditdEvent(ls))
+
+	passwd := newSystemAucoalesceEvent(t, "unset")
+	passwd.Tags = []string{"identity"}
+	require.NoError(t, st.AuditdEvent(passwd))
+
+	cron := newSystemAucoalesceEvent(t, "unset")
+	cron.Tags = []string{"privesc"}
+	require.NoError(t, st.AuditdEvent(cron))
+
+	userEnd := newAucoalesceEvent(t, "123", "success", started.Add(time.Minute))
+	userEnd.Type = auparse.AUDIT_USER_END
+	userEnd.Process.PID = "999"
+	require.NoError(t, st.AuditdEvent(userEnd))
+
+	require.Len(t, events, 3)
+
+	action := <-events
+	assert.Equal(t, common.ActionUserAction, action.Type)
+	assert.Equal(t, []string{"privesc"}, action.Metadata.Extra["keys"])
+
+	systemAction := <-events
+	assert.Equal(t, common.ActionSystemAction, systemAction.Type)
+	assert.Equal(t, []string{"privesc"}, systemAction.Metadata.Extra["keys"])
+
+	// Filtered actions are still counted.
+	logout := <-events
+	assert.Equal(t, common.ActionLogoutIdentifier, logout.Type)
+	assert.Equal(t, 2, logout.Metadata.Extra["commands_executed"])
+}
+
+func TestSessionTracker_AuditdEvent_EventFilter(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	filter, err := eventfilter.NewFilter(eventfilter.Config{
+		Rules: []eventfilter.Rule{
+			{Name: "shell-startup", Action: eventfilter.ActionDrop, Exe: []string{"/usr/bin/locale"}},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+	st.SetEventFilter(filter)
+	st.SetActionDetailConfig(ActionDetailConfig{Process: true})
+
+	require.NoError(t, st.RemoteLogin(common.RemoteUserLogin{
+		Source:     &auditevent.AuditEvent{},
+		PID:        999,
+		CredUserID: "foo",
+	}))
+
+	started := time.Now()
+
+	login := newAucoalesceEvent(t, "123", "success", started)
+	login.Type = auparse.AUDIT_LOGIN
+	login.Process.PID = "999"
+	require.NoError(t, st.AuditdEvent(login))
+
+	locale := newAucoalesceEvent(t, "123", "success", started.Add(time.Second))
+	locale.Summary.Action = "executed"
+	locale.Process.Exe = "/usr/bin/locale"
+	require.NoError(t, st.AuditdEvent(locale))
+
+	vim := newAucoalesceEvent(t, "123", "success", started.Add(2*time.Second))
+	vim.Summary.Action = "executed"
+	vim.Process.Exe = "/usr/bin/vim"
+	require.NoError(t, st.AuditdEvent(vim))
+
+	userEnd := newAucoalesceEvent(t, "123", "success", started.Add(time.Minute))
+	userEnd.Type = auparse.AUDIT_USER_END
+	userEnd.Process.PID = "999"
+	require.NoError(t, st.AuditdEvent(userEnd))
+
+	// The login, vim, user end, and logout events.
+	require.Len(t, events, 4)
+
+	var logout *auditevent.AuditEvent
+	for len(events) > 0 {
+		event := <-events
+		if event.Type == common.ActionLogoutIdentifier {
+			logout = event
+			continue
+		}
+
+		process, _ := event.Metadata.Extra[ActionDetailProcess].(map[string]string)
+		assert.NotEqual(t, "/usr/bin/locale", process["exe"])
+	}
+
+	// Dropped actions are still counted.
+	require.NotNil(t, logout)
+	assert.Equal(t, 2, logout.Metadata.Extra["commands_executed"])
+}
@@ -0,0 +1,541 @@
+package sessiontracker
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// newSessionMap returns a new, empty sessionMap.
+func newSessionMap() *sessionMap {
+	return &sessionMap{
+		users:         make(map[string]*user),
+		pids:          make(map[int]string),
+		pendingPPIDs:  make(pidIndex),
+		pendingNSPIDs: make(pidIndex),
+		entries:       make(map[string]*expiryEntry),
+		cachedEntries: make(map[string]*expiryEntry),
+	}
+}
+
+// sessionMap contains the tracked audit sessions. Like
+// common.GenericSyncMap, it maps audit session IDs to user objects
+// and is safe for concurrent use. In addition, it indexes the
+// sessions by the PID of the process that started them and orders
+// them by when they expire. The sessions without a remote user login
+// are also indexed by the parent PID of that process and by its PID
+// in sshd's PID namespace, and the sessions with cached audit events are ordered by their oldest cached event.
+// This allows remote user logins to be matched to their sessions, and
+// expired sessions and the oldest cached events to be found, without
+// iterating over every session.
+type sessionMap struct {
+	mtx sync.Mutex
+
+	// users maps audit session IDs to their user objects.
+	users map[string]*user
+
+	// pids maps the PID of the process that started an audit
+	// session (i.e., user.srcPID) to the session's ID. If more
+	// than one session has the same PID, the newest one is used.
+	pids map[int]string
+
+	// pendingPPIDs maps the parent PID of the process that started
+	// an audit session without a remote user login (i.e.,
+	// user.srcPPID) to the IDs of the sessions that have it.
+	pendingPPIDs pidIndex
+
+	// pendingNSPIDs maps the PID in sshd's PID namespace of the
+	// process that started an audit session without a remote user
+	// login (i.e., user.nsPID) to the IDs of the sessions that have it.
+	pendingNSPIDs pidIndex
+
+	// entries maps audit session IDs to their
+	// positions in the pending or active queue.
+	entries map[string]*expiryEntry
+
+	// pending contains the sessions that do not have a remote
+	// user login, ordered by when they were added.
+	pending expiryQueue
+
+	// active contains the sessions that have a remote user login,
+	// ordered by their last activity.
+	active expiryQueue
+
+	// cachedEntries maps audit session IDs to their
+	// positions in the cached queue.
+	cachedEntries map[string]*expiryEntry
+
+	// cached contains the sessions that have cached audit events,
+	// ordered by their oldest cached event.
+	cached expiryQueue
+}
+
+// Has returns true if the audit session is tracked.
+func (m *sessionMap) Has(sessionID string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	_, ok := m.users[sessionID]
+	return ok
+}
+
+// Load returns the user object of an audit session.
+func (m *sessionMap) Load(sessionID string) (*user, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	u, ok := m.users[sessionID]
+	return u, ok
+}
+
+// Len returns the number of tracked audit sessions.
+func (m *sessionMap) Len() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return len(m.users)
+}
+
+// Store tracks an audit session, replacing the
+// session's existing user object if there is one.
+func (m *sessionMap) Store(sessionID string, u *user) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.DeleteUnsafe(sessionID)
+
+	m.users[sessionID] = u
+	m.pids[u.srcPID] = sessionID
+	m.requeueUnsafe(sessionID, u)
+}
+
+// DeleteUnsafe stops tracking an audit session without locking.
+// It must only be called from a callback that holds the lock.
+func (m *sessionMap) DeleteUnsafe(sessionID string) {
+	u, ok := m.users[sessionID]
+	if !ok {
+		return
+	}
+
+	delete(m.users, sessionID)
+
+	if m.pids[u.srcPID] == sessionID {
+		delete(m.pids, u.srcPID)
+	}
+
+	if entry, ok := m.entries[sessionID]; ok {
+		m.removeEntryUnsafe(entry)
+		delete(m.entries, sessionID)
+	}
+
+	if entry, ok := m.cachedEntries[sessionID]; ok {
+		entry.queue.remove(entry)
+		delete(m.cachedEntries, sessionID)
+	}
+}
+
+// OldestCached returns the ID of the audit session with the oldest
+// cached audit event and the time of the oldest cached audit event of
+// all other sessions, which is nil if no other session has cached
+// events. The returned bool is false if no session has cached events.
+func (m *sessionMap) OldestCached() (string, *time.Time, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if len(m.cached) == 0 {
+		return "", nil, false
+	}
+
+	// The second-oldest entry of a min-heap
+	// is one of the first entry's children.
+	var nextOldest *time.Time
+	for i := 1; i <= 2 && i < len(m.cached); i++ {
+		if nextOldest == nil || m.cached[i].key.Before(*nextOldest) {
+			key := m.cached[i].key
+			nextOldest = &key
+		}
+	}
+
+	return m.cached[0].sessionID, nextOldest, true
+}
+
+// Iterate calls the callback for each audit session. If the callback
+// returns false, the iteration stops. The callback is called while
+// the map is locked, so it should only call the map's Unsafe methods.
+func (m *sessionMap) Iterate(cb func(sessionID string, u *user) bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for id, u := range m.users {
+		if !cb(id, u) {
+			break
+		}
+	}
+}
+
+// WithLockedValueDo calls the callback with the user object of an
+// audit session, if it exists. The callback is called while the map
+// is locked, so it should only call the map's Unsafe methods.
+func (m *sessionMap) WithLockedValueDo(sessionID string, cb func(u *user) error) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	u, ok := m.users[sessionID]
+	if !ok {
+		return nil
+	}
+
+	defer m.requeueUnsafe(sessionID, u)
+
+	return cb(u)
+}
+
+// WithLockedPIDDo calls the callback with the ID and user object of
+// the newest audit session started by the process with the given PID,
+// if it exists. The callback is called while the map is locked, so it
+// should only call the map's Unsafe methods. The returned bool is
+// true if the callback was called.
+func (m *sessionMap) WithLockedPIDDo(pid int, cb func(sessionID string, u *user) error) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	sessionID, ok := m.pids[pid]
+	if !ok {
+		return false, nil
+	}
+
+	u := m.users[sessionID]
+
+	defer m.requeueUnsafe(sessionID, u)
+
+	return true, cb(sessionID, u)
+}
+
+// WithLockedPendingNamespacePIDDo calls the callback with the ID and
+// user object of the audit session without a remote user login that
+// was started by the process whose PID in sshd's PID namespace is
+// nsPID. The callback is not called if more than one session matches,
+// as the match is ambiguous. The callback is called while the map is
+// locked, so it should only call the map's Unsafe methods. The returned
+// bool is true if the callback was called.
+func (m *sessionMap) WithLockedPendingNamespacePIDDo(nsPID int, cb func(sessionID string, u *user) error) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	sessionID, ok := m.pendingNSPIDs.only(nsPID)
+	if !ok {
+		return false, nil
+	}
+
+	u := m.users[sessionID]
+
+	defer m.requeueUnsafe(sessionID, u)
+
+	return true, cb(sessionID, u)
+}
+
+// WithLockedPendingRelativeDo calls the callback with the ID and user
+// object of the audit session without a remote user login that was
+// started by a child of the process with the given PID, or by its
+// parent (ppid), if ppid is not zero. Like WithLockedPIDDo, the newest
+// session started by the parent is used. The callback is not called
+// if more than one session matches, as the match is ambiguous. The
+// sessions are found using the PID indexes, so the pending sessions
+// are not iterated over. The callback is called while the map is
+// locked, so it should only call the map's Unsafe methods. The
+// returned bool is true if the callback was called.
+func (m *sessionMap) WithLockedPendingRelativeDo(pid int, ppid int, cb func(sessionID string, u *user) error) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if len(m.pendingPPIDs[pid]) > 1 {
+		return false, nil
+	}
+
+	found, _ := m.pendingPPIDs.only(pid)
+
+	if ppid > 0 {
+		sessionID, ok := m.pids[ppid]
+		if ok && sessionID != found && !m.users[sessionID].hasRUL {
+			if found != "" {
+				return false, nil
+			}
+
+			found = sessionID
+		}
+	}
+
+	if found == "" {
+		return false, nil
+	}
+
+	u := m.users[found]
+
+	defer m.requeueUnsafe(found, u)
+
+	return true, cb(found, u)
+}
+
+// WithLockedAllPendingDo calls the callback with the IDs and user
+// objects of all the audit sessions without a remote user login. The
+// callback is called while the map is locked, so it should only call
+// the map's Unsafe methods. The sessions are requeued after the
+// callback returns, as their remote user logins may have been set.
+func (m *sessionMap) WithLockedAllPendingDo(cb func(pending map[string]*user) error) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	pending := make(map[string]*user, len(m.pending))
+	for _, entry := range m.pending {
+		pending[entry.sessionID] = entry.u
+	}
+
+	defer func() {
+		for sessionID, u := range pending {
+			m.requeueUnsafe(sessionID, u)
+		}
+	}()
+
+	return cb(pending)
+}
+
+// DeletePendingBefore deletes the audit sessions without a remote user
+// login that were added before t, from oldest to newest. The callback
+// is called with each deleted session while the map is locked. If the
+// callback returns false, no more sessions are deleted.
+func (m *sessionMap) DeletePendingBefore(t time.Time, cb func(sessionID string, u *user) bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for len(m.pending) > 0 {
+		entry := m.pending[0]
+		if !entry.key.Before(t) {
+			return
+		}
+
+		m.DeleteUnsafe(entry.sessionID)
+
+		if !cb(entry.sessionID, entry.u) {
+			return
+		}
+	}
+}
+
+// DeleteIdleBefore deletes the audit sessions with a remote user login
+// whose last activity occurred before t, from least to most recently
+// active. The callback is called with each deleted session while the
+// map is locked. If the callback returns false, no more sessions are
+// deleted.
+func (m *sessionMap) DeleteIdleBefore(t time.Time, cb func(sessionID string, u *user) bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for len(m.active) > 0 {
+		entry := m.active[0]
+
+		// The last activity of a session is updated without
+		// reordering the queue. Its position is corrected
+		// when it reaches the front of the queue instead.
+		if !entry.key.Equal(entry.u.lastActivity) {
+			entry.key = entry.u.lastActivity
+			heap.Fix(&m.active, entry.index)
+			continue
+		}
+
+		if !entry.key.Before(t) {
+			return
+		}
+
+		m.DeleteUnsafe(entry.sessionID)
+
+		if !cb(entry.sessionID, entry.u) {
+			return
+		}
+	}
+}
+
+// requeueUnsafe adds a tracked audit session to the expiry queue
+// it belongs to, moving it from the other queue if necessary
+// (e.g., after its remote user login was found).
+func (m *sessionMap) requeueUnsafe(sessionID string, u *user) {
+	if m.users[sessionID] != u {
+		// The session was deleted or replaced.
+		return
+	}
+
+	m.requeueCachedUnsafe(sessionID, u)
+
+	queue, key := &m.pending, u.added
+	if u.hasRUL {
+		queue, key = &m.active, u.lastActivity
+	}
+
+	entry, ok := m.entries[sessionID]
+	if ok {
+		if entry.queue == queue {
+			return
+		}
+
+		m.removeEntryUnsafe(entry)
+	}
+
+	entry = &expiryEntry{
+		sessionID: sessionID,
+		u:         u,
+		key:       key,
+		queue:     queue,
+	}
+
+	m.entries[sessionID] = entry
+	heap.Push(queue, entry)
+
+	if queue == &m.pending {
+		m.pendingPPIDs.add(u.srcPPID, sessionID)
+		m.pendingNSPIDs.add(u.nsPID, sessionID)
+	}
+}
+
+// removeEntryUnsafe removes an audit session from the pending or
+// active queue, and from the PID indexes of pending sessions.
+func (m *sessionMap) removeEntryUnsafe(entry *expiryEntry) {
+	entry.queue.remove(entry)
+
+	if entry.queue == &m.pending {
+		m.pendingPPIDs.remove(entry.u.srcPPID, entry.sessionID)
+		m.pendingNSPIDs.remove(entry.u.nsPID, entry.sessionID)
+	}
+}
+
+// requeueCachedUnsafe adds a tracked audit session to the cached
+// queue, moves it within the queue, or removes it from the queue
+// according to its oldest cached audit event. Unlike the last activity
+// of active sessions, the oldest cached event must be kept up to date
+// because the front of the queue is used to decide which events are
+// evicted.
+func (m *sessionMap) requeueCachedUnsafe(sessionID string, u *user) {
+	entry, ok := m.cachedEntries[sessionID]
+
+	if len(u.cached) == 0 {
+		if ok {
+			entry.queue.remove(entry)
+			delete(m.cachedEntries, sessionID)
+		}
+
+		return
+	}
+
+	oldest := u.cached[0].Timestamp
+
+	if ok {
+		if !entry.key.Equal(oldest) {
+			entry.key = oldest
+			heap.Fix(entry.queue, entry.index)
+		}
+
+		return
+	}
+
+	entry = &expiryEntry{
+		sessionID: sessionID,
+		u:         u,
+		key:       oldest,
+		queue:     &m.cached,
+	}
+
+	m.cachedEntries[sessionID] = entry
+	heap.Push(&m.cached, entry)
+}
+
+// pidIndex maps PIDs to the IDs of the audit sessions that have them.
+// Non-positive PIDs (i.e., unknown ones) are not indexed.
+type pidIndex map[int]map[string]struct{}
+
+func (o pidIndex) add(pid int, sessionID string) {
+	if pid <= 0 {
+		return
+	}
+
+	sessionIDs, ok := o[pid]
+	if !ok {
+		sessionIDs = make(map[string]struct{})
+		o[pid] = sessionIDs
+	}
+
+	sessionIDs[sessionID] = struct{}{}
+}
+
+func (o pidIndex) remove(pid int, sessionID string) {
+	sessionIDs, ok := o[pid]
+	if !ok {
+		return
+	}
+
+	delete(sessionIDs, sessionID)
+
+	if len(sessionIDs) == 0 {
+		delete(o, pid)
+	}
+}
+
+// only returns the ID of the audit session that has the PID.
+// It returns false if no session, or more than one, has it.
+func (o pidIndex) only(pid int) (string, bool) {
+	sessionIDs := o[pid]
+	if len(sessionIDs) != 1 {
+		return "", false
+	}
+
+	for sessionID := range sessionIDs {
+		return sessionID, true
+	}
+
+	return "", false
+}
+
+// expiryEntry is an audit session's position in an expiryQueue.
+type expiryEntry struct {
+	sessionID string
+	u         *user
+	key       time.Time
+	queue     *expiryQueue
+	index     int
+}
+
+// expiryQueue is a min-heap of audit sessions ordered by when they
+// expire (or, for the cached queue, by their oldest cached audit
+// event). It implements heap.Interface.
+type expiryQueue []*expiryEntry
+
+func (q expiryQueue) Len() int {
+	return len(q)
+}
+
+func (q expiryQueue) Less(i, j int) bool {
+	return q[i].key.Before(q[j].key)
+}
+
+func (q expiryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *expiryQueue) Push(x any) {
+	entry := x.(*expiryEntry) //nolint:forcetypeassert // Only called by heap.Push.
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return entry
+}
+
+// remove removes an entry from the queue.
+func (q *expiryQueue) remove(entry *expiryEntry) {
+	heap.Remove(q, entry.index)
+}
@@ -0,0 +1,331 @@
+package sessiontracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+func TestSessionMap_WithLockedPIDDo(t *testing.T) {
+	t.Parallel()
+
+	m := newSessionMap()
+
+	older := &user{srcPID: 999}
+	newer := &user{srcPID: 999}
+	other := &user{srcPID: 1000}
+
+	m.Store("1", older)
+	m.Store("2", newer)
+	m.Store("3", other)
+
+	var foundID string
+	found, err := m.WithLockedPIDDo(999, func(sessionID string, u *user) error {
+		foundID = sessionID
+		assert.Same(t, newer, u)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "2", foundID, "the newest session should be used")
+
+	// Deleting an older session with the same
+	// PID does not remove the newer one's index.
+	require.NoError(t, m.WithLockedValueDo("1", func(*user) error {
+		m.DeleteUnsafe("1")
+		return nil
+	}))
+
+	found, _ = m.WithLockedPIDDo(999, func(string, *user) error { return nil })
+	assert.True(t, found)
+
+	require.NoError(t, m.WithLockedValueDo("2", func(*user) error {
+		m.DeleteUnsafe("2")
+		return nil
+	}))
+
+	found, _ = m.WithLockedPIDDo(999, func(string, *user) error { return nil })
+	assert.False(t, found)
+
+	assert.Equal(t, 1, m.Len())
+}
+
+func TestSessionMap_WithLockedPendingRelativeDo(t *testing.T) {
+	t.Parallel()
+
+	m := newSessionMap()
+
+	m.Store("1", &user{srcPID: 1001, srcPPID: 1000})
+	m.Store("2", &user{srcPID: 2000, srcPPID: 1})
+	m.Store("3", &user{srcPID: 3001, srcPPID: 3000})
+	m.Store("4", &user{srcPID: 3002, srcPPID: 3000})
+
+	var foundID string
+	find := func(pid int, ppid int) bool {
+		foundID = ""
+
+		found, err := m.WithLockedPendingRelativeDo(pid, ppid, func(sessionID string, _ *user) error {
+			foundID = sessionID
+			return nil
+		})
+		require.NoError(t, err)
+
+		return found
+	}
+
+	// The session was started by a child of the login's process.
+	assert.True(t, find(1000, 1))
+	assert.Equal(t, "1", foundID)
+
+	// The session was started by the parent of the login's process.
+	assert.True(t, find(2001, 2000))
+	assert.Equal(t, "2", foundID)
+
+	assert.False(t, find(3000, 0), "sessions 3 and 4 are both children of 3000")
+	assert.False(t, find(1000, 2000), "the child and the parent are ambiguous")
+	assert.False(t, find(4000, 0))
+
+	// Sessions are removed from the parent PID
+	// index once their remote user login is found.
+	_, err := m.WithLockedPIDDo(3001, func(sessionID string, u *user) error {
+		u.setRemoteUserLoginInfo(common.RemoteUserLogin{
+			Source:     &auditevent.AuditEvent{},
+			PID:        3001,
+			CredUserID: "foo",
+		}, "some-correlation-id", CorrelatedByPID)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.True(t, find(3000, 0))
+	assert.Equal(t, "4", foundID)
+
+	assert.False(t, find(3002, 3001), "session 3 has a remote user login")
+
+	require.NoError(t, m.WithLockedValueDo("4", func(*user) error {
+		m.DeleteUnsafe("4")
+		return nil
+	}))
+
+	assert.False(t, find(3000, 0))
+	assert.NotContains(t, m.pendingPPIDs, 3000)
+}
+
+func TestSessionMap_WithLockedPendingNamespacePIDDo(t *testing.T) {
+	t.Parallel()
+
+	m := newSessionMap()
+
+	m.Store("1", &user{srcPID: 3022, nsPID: 22})
+	m.Store("2", &user{srcPID: 3023, nsPID: 23})
+	m.Store("3", &user{srcPID: 4023, nsPID: 23})
+
+	var foundID string
+	find := func(nsPID int) bool {
+		foundID = ""
+
+		found, err := m.WithLockedPendingNamespacePIDDo(nsPID, func(sessionID string, u *user) error {
+			foundID = sessionID
+
+			u.setRemoteUserLoginInfo(common.RemoteUserLogin{
+				Source:     &auditevent.AuditEvent{},
+				PID:        u.srcPID,
+				CredUserID: "foo",
+			}, "some-correlation-id", CorrelatedByPID)
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		return found
+	}
+
+	assert.True(t, find(22))
+	assert.Equal(t, "1", foundID)
+
+	assert.False(t, find(22), "session 1 has a remote user login")
+	assert.False(t, find(23), "sessions 2 and 3 have the same namespace pid")
+	assert.False(t, find(3022))
+	assert.NotContains(t, m.pendingNSPIDs, 22)
+}
+
+func TestSessionMap_WithLockedAllPendingDo(t *testing.T) {
+	t.Parallel()
+
+	m := newSessionMap()
+
+	m.Store("1", &user{srcPID: 1})
+	m.Store("2", &user{srcPID: 2})
+	m.Store("3", &user{srcPID: 3})
+
+	err := m.WithLockedAllPendingDo(func(pending map[string]*user) error {
+		assert.Len(t, pending, 3)
+
+		// Sessions whose remote user logins are set
+		// are moved to the active queue afterwards.
+		pending["1"].setRemoteUserLoginInfo(common.RemoteUserLogin{
+			Source:     &auditevent.AuditEvent{},
+			PID:        1,
+			CredUserID: "foo",
+		}, "some-correlation-id", CorrelatedByAddress)
+
+		m.DeleteUnsafe("2")
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.False(t, m.Has("2"))
+	assert.Len(t, m.pending, 1)
+	assert.Len(t, m.active, 1)
+}
+
+func TestSessionMap_DeletePendingBefore(t *testing.T) {
+	t.Parallel()
+
+	m := newSessionMap()
+
+	now := time.Now()
+
+	m.Store("3", &user{srcPID: 3, added: now.Add(-time.Minute)})
+	m.Store("1", &user{srcPID: 1, added: now.Add(-3 * time.Minute)})
+	m.Store("2", &user{srcPID: 2, added: now.Add(-2 * time.Minute)})
+	m.Store("4", &user{srcPID: 4, added: now})
+
+	// Sessions are moved to the active queue
+	// when their remote user login is found.
+	_, err := m.WithLockedPIDDo(2, func(sessionID string, u *user) error {
+		u.setRemoteUserLoginInfo(common.RemoteUserLogin{
+			Source:     &auditevent.AuditEvent{},
+			PID:        2,
+			CredUserID: "foo",
+		}, "some-correlation-id", CorrelatedByPID)
+		return nil
+	})
+	require.NoError(t, err)
+
+	var deleted []string
+	m.DeletePendingBefore(now.Add(-30*time.Second), func(sessionID string, _ *user) bool {
+		deleted = append(deleted, sessionID)
+		return true
+	})
+
+	assert.Equal(t, []string{"1", "3"}, deleted)
+	assert.True(t, m.Has("2"))
+	assert.True(t, m.Has("4"))
+	assert.Len(t, m.pending, 1)
+	assert.Len(t, m.active, 1)
+}
+
+func TestSessionMap_DeleteIdleBefore(t *testing.T) {
+	t.Parallel()
+
+	m := newSessionMap()
+
+	now := time.Now()
+
+	for i, lastActivity := range []time.Time{
+		now.Add(-3 * time.Hour),
+		now.Add(-2 * time.Hour),
+		now.Add(-1 * time.Hour),
+	} {
+		u := &user{srcPID: i + 1, lastActivity: lastActivity}
+		u.setRemoteUserLoginInfo(common.RemoteUserLogin{
+			Source:     &auditevent.AuditEvent{},
+			PID:        i + 1,
+			CredUserID: "foo",
+		}, "some-correlation-id", CorrelatedByPID)
+
+		m.Store(string(rune('a'+i)), u)
+	}
+
+	// The oldest session becomes active again.
+	err := m.WithLockedValueDo("a", func(u *user) error {
+		u.lastActivity = now
+		return nil
+	})
+	require.NoError(t, err)
+
+	var deleted []string
+	m.DeleteIdleBefore(now.Add(-90*time.Minute), func(sessionID string, _ *user) bool {
+		deleted = append(deleted, sessionID)
+		return true
+	})
+
+	assert.Equal(t, []string{"b"}, deleted)
+	assert.Equal(t, 2, m.Len())
+
+	// Deletion stops when the callback returns false.
+	deleted = nil
+	m.DeleteIdleBefore(now.Add(time.Minute), func(sessionID string, _ *user) bool {
+		deleted = append(deleted, sessionID)
+		return false
+	})
+
+	assert.Equal(t, []string{"c"}, deleted)
+	assert.Equal(t, 1, m.Len())
+	assert.Len(t, m.active, 1)
+}
+
+func TestSessionMap_OldestCached(t *testing.T) {
+	t.Parallel()
+
+	m := newSessionMap()
+
+	now := time.Now()
+	event := func(age time.Duration) *aucoalesce.Event {
+		return &aucoalesce.Event{Timestamp: now.Add(-age)}
+	}
+
+	_, _, ok := m.OldestCached()
+	assert.False(t, ok)
+
+	m.Store("1", &user{srcPID: 1, cached: []*aucoalesce.Event{event(2 * time.Minute), event(0)}})
+	m.Store("2", &user{srcPID: 2, cached: []*aucoalesce.Event{event(3 * time.Minute)}})
+	m.Store("3", &user{srcPID: 3, cached: []*aucoalesce.Event{event(time.Minute)}})
+	m.Store("4", &user{srcPID: 4})
+
+	oldestID, nextOldest, ok := m.OldestCached()
+	require.True(t, ok)
+	assert.Equal(t, "2", oldestID)
+	require.NotNil(t, nextOldest)
+	assert.True(t, now.Add(-2*time.Minute).Equal(*nextOldest))
+
+	// Sessions are reordered when their oldest cached event changes.
+	require.NoError(t, m.WithLockedValueDo("1", func(u *user) error {
+		u.cached = u.cached[1:]
+		return nil
+	}))
+	require.NoError(t, m.WithLockedValueDo("2", func(u *user) error {
+		u.cached = nil
+		return nil
+	}))
+
+	oldestID, nextOldest, ok = m.OldestCached()
+	require.True(t, ok)
+	assert.Equal(t, "3", oldestID)
+	require.NotNil(t, nextOldest)
+	assert.True(t, now.Equal(*nextOldest))
+
+	// Deleted sessions are removed.
+	require.NoError(t, m.WithLockedValueDo("3", func(*user) error {
+		m.DeleteUnsafe("3")
+		return nil
+	}))
+
+	oldestID, nextOldest, ok = m.OldestCached()
+	require.True(t, ok)
+	assert.Equal(t, "1", oldestID)
+	assert.Nil(t, nextOldest)
+
+	m.DeletePendingBefore(now.Add(time.Minute), func(string, *user) bool { return true })
+
+	_, _, ok = m.OldestCached()
+	assert.False(t, ok)
+}
@@ -3,6 +3,7 @@ package sessiontracker
 import (
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/go-libaudit/v2/aucoalesce"
@@ -11,6 +12,9 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/eventfilter"
+	"github.com/metal-toolbox/audito-maldito/processors/auditd/procsessions"
 )
 
 // Implement Auditor interface.
@@ -22,12 +26,15 @@ func NewSessionTracker(eventWriter *auditevent.EventWriter, l *zap.SugaredLogger
 		l = zap.NewNop().Sugar()
 	}
 
-	return &sessionTracker{
-		sessIDsToUsers: common.NewGenericSyncMap[string, *user](),
-		pidsToRULs:     common.NewGenericSyncMap[int, common.RemoteUserLogin](),
+	st := &sessionTracker{
+		sessIDsToUsers: newSessionMap(),
 		eventWriter:    eventWriter,
 		l:              l,
 	}
+
+	st.pidsToRULs = st.newRemoteLoginMap(common.SystemClock{})
+
+	return st
 }
 
 // sessionTracker tracks both remote user logins and auditd sessions,
@@ -39,8 +46,9 @@ type sessionTracker struct {
 	// them. It also acts as an auditd event cache.
 	//
 	// The map key is the auditd session ID and the value is
-	// the corresponding user object.
-	sessIDsToUsers *common.GenericSyncMap[string, *user]
+	// the corresponding user object. The sessions are indexed
+	// by their source PID and ordered by when they expire.
+	sessIDsToUsers *sessionMap
 
 	// pidsToRULs caches remote user logins if an auditd
 	// session has not started. This alleviates the race
@@ -48,17 +56,155 @@ type sessionTracker struct {
 	//
 	// The map key is the PID of a process responsible
 	// for remote user logins and the value is the data
-	// associated with the remote login.
-	pidsToRULs *common.GenericSyncMap[int, common.RemoteUserLogin]
+	// associated with the remote login. Logins expire
+	// remoteLoginTTL after they were observed (or
+	// logged, if that is unknown).
+	pidsToRULs *common.ExpiringSyncMap[int, common.RemoteUserLogin]
+
+	// remoteLoginTTL is how long remote user logins wait
+	// for their auditd session. Zero means they never
+	// expire.
+	remoteLoginTTL time.Duration
 
 	// eventWriter is the auditevent.EventWriter to write
 	// the resulting audit event to.
 	eventWriter *auditevent.EventWriter
 
+	// systemActions configures the SystemAction events written
+	// for audit events that are not associated with a session.
+	systemActions SystemActionConfig
+
+	// actionDetails determines which audit record fields
+	// are added to UserAction events.
+	actionDetails ActionDetailConfig
+
+	// keyFilter determines which UserAction and SystemAction
+	// events are written based on their audit rule keys.
+	keyFilter KeyFilter
+
+	// eventFilter decides which UserAction and
+	// SystemAction events are written.
+	eventFilter *eventfilter.Filter
+
+	// correlation enables the strategies used to correlate
+	// remote user logins with audit sessions when their
+	// PIDs differ.
+	correlation CorrelationConfig
+
+	// hostPIDs, if non-nil, translates the PIDs of remote user
+	// logins from sshd's PID namespace to host PIDs.
+	hostPIDs *procsessions.HostPIDCache
+
+	// cacheConfig limits the number of audit events cached
+	// while waiting for remote user logins.
+	cacheConfig CacheConfig
+
+	// numCached is the number of audit events
+	// cached by all of the user objects.
+	numCached atomic.Int64
+
+	// metrics, if non-nil, reports the state of the cache.
+	metrics *metrics.PrometheusMetricsProvider
+
+	// machineID and bootID identify the computer and its current
+	// boot. They are used to create session correlation IDs
+	// (refer to common.SessionCorrelationID).
+	machineID string
+	bootID    string
+
 	// l is the logger to use.
 	l *zap.SugaredLogger
 }
 
+// SetSystemActionConfig configures the SystemAction events written for
+// audit events that are not associated with an audit session. It must
+// be called before the sessionTracker is used.
+func (o *sessionTracker) SetSystemActionConfig(config SystemActionConfig) {
+	o.systemActions = config
+}
+
+// SetActionDetailConfig determines which groups of audit record fields
+// are added to UserAction events. It must be called before the
+// sessionTracker is used.
+func (o *sessionTracker) SetActionDetailConfig(config ActionDetailConfig) {
+	o.actionDetails = config
+}
+
+// SetKeyFilter limits the UserAction and SystemAction events that are
+// written to those produced by audit rules with specific keys. It must
+// be called before the sessionTracker is used.
+func (o *sessionTracker) SetKeyFilter(filter KeyFilter) {
+	o.keyFilter = filter
+}
+
+// SetEventFilter sets the eventfilter.Filter that decides which UserAction
+// and SystemAction events are written. A nil filter keeps every event.
+// It must be called before the sessionTracker is used.
+func (o *sessionTracker) SetEventFilter(filter *eventfilter.Filter) {
+	o.eventFilter = filter
+}
+
+// SetCacheConfig limits the number of audit events cached while waiting
+// for remote user logins. The number is not limited by default. It must
+// be called before the sessionTracker is used.
+func (o *sessionTracker) SetCacheConfig(config CacheConfig) {
+	o.cacheConfig = config
+}
+
+// SetRemoteLoginTTL sets how long a remote user login waits for its
+// auditd session, starting from when the login was observed (or logged,
+// if the time it was observed is unknown). Logins that are not matched
+// to a session in time are discarded. Logins never expire by default.
+// It must be called before the sessionTracker is used.
+func (o *sessionTracker) SetRemoteLoginTTL(ttl time.Duration) {
+	o.remoteLoginTTL = ttl
+}
+
+// SetCorrelationConfig enables the strategies used to correlate remote
+// user logins with audit sessions when their PIDs differ. Only logins
+// with the same PID as their sessions are correlated by default. It
+// must be called before the sessionTracker is used.
+func (o *sessionTracker) SetCorrelationConfig(config CorrelationConfig) {
+	o.correlation = config
+
+	if config.SshdPIDNamespace != "" && config.ProcRoot != "" {
+		o.hostPIDs = procsessions.NewHostPIDCache(config.ProcRoot, config.SshdPIDNamespace, hostPIDScanInterval)
+	}
+}
+
+// SetMetrics sets the metrics provider used to report the number of
+// cached audit events, cache evictions, and audit sessions that expired
+// without a remote user login. It must be called before the
+// sessionTracker is used.
+func (o *sessionTracker) SetMetrics(m *metrics.PrometheusMetricsProvider) {
+	o.metrics = m
+}
+
+// actionOutput returns the actionOutput used to write
+// the UserAction events of each user.
+func (o *sessionTracker) actionOutput() actionOutput {
+	return actionOutput{
+		writer:      o.eventWriter,
+		details:     o.actionDetails,
+		keyFilter:   o.keyFilter,
+		eventFilter: o.eventFilter,
+	}
+}
+
+// SetCorrelationIDSource sets the machine ID and boot ID used to create
+// the correlation IDs that link a session's events together (refer to
+// common.SessionCorrelationID). It must be called before the
+// sessionTracker is used.
+func (o *sessionTracker) SetCorrelationIDSource(machineID string, bootID string) {
+	o.machineID = machineID
+	o.bootID = bootID
+}
+
+// correlationID returns the correlation ID of an audit session.
+func (o *sessionTracker) correlationID(sessionID string) string {
+	return common.SessionCorrelationID(o.machineID, o.bootID, sessionID)
+}
+
 // RemoteLogin validates and checks if there is an auditd session already present for the
 // RemoteLogin passed as parameter. It modifies the user object by setting the remote login information.
 func (o *sessionTracker) RemoteLogin(rul common.RemoteUserLogin) error {
@@ -77,38 +223,56 @@ func (o *sessionTracker) RemoteLogin(rul common.RemoteUserLogin) error {
 		}
 	}
 
-	// Check if there is an auditd session for this login.
 	var found bool
-	var writeErr error
-	o.sessIDsToUsers.Iterate(func(asi string, u *user) bool {
-		if u.srcPID == rul.PID {
+
+	isHostPID := o.correlation.SshdPIDNamespace == ""
+	if !isHostPID {
+		// Sessions are indexed by their PIDs in sshd's PID
+		// namespace, so they are found without translating
+		// the login's PID.
+		found, err = o.sessIDsToUsers.WithLockedPendingNamespacePIDDo(rul.PID,
+			func(asi string, u *user) error {
+				rul.PID = u.srcPID
+				return o.remoteLoginFoundSession(rul, CorrelatedByPID, debugLogger)(asi, u)
+			})
+		if found {
+			return err
+		}
+
+		hostPID, translated := o.hostPID(rul.PID)
+		if translated {
 			if debugLogger != nil {
-				debugLogger.With(
-					"auditSessionID", asi,
-					"auditSessionStartTime", u.added,
-					"numCachedAuditEvents", len(u.cached),
-					"hasRUL", u.hasRUL).
-					Debugln("found existing audit session for remote user login")
+				debugLogger.Debugf("translated sshd pid %d to host pid %d", rul.PID, hostPID)
 			}
 
-			// We modify the user object in-place, in this section
-			// since it's thread-safe (i.e., it's a pointer).
-			u.setRemoteUserLoginInfo(rul)
+			rul.PID = hostPID
+			isHostPID = true
+		}
+	}
 
-			found = true
-			writeErr = u.writeAndClearCache(o.eventWriter)
-			// stop iteration
-			return false
+	// If the login's PID could not be translated to a host PID
+	// (e.g., because its process exited), it is cached using its
+	// PID in sshd's PID namespace until its session is found.
+	if isHostPID {
+		if o.correlation.ParentPID && rul.ParentPID == 0 {
+			rul.ParentPID = o.parentPID(rul.PID)
 		}
 
-		return true
-	})
+		// Check if there is an auditd session for this login.
+		found, err = o.sessIDsToUsers.WithLockedPIDDo(rul.PID,
+			o.remoteLoginFoundSession(rul, CorrelatedByPID, debugLogger))
+	}
+
+	if !found && isHostPID && o.correlation.ParentPID {
+		found, err = o.sessIDsToUsers.WithLockedPendingRelativeDo(rul.PID, rul.ParentPID,
+			o.remoteLoginFoundSession(rul, CorrelatedByParentPID, debugLogger))
+	}
 
 	if found {
 		// We found an audit session for this login, and the
 		// user object has been modified in-place. We can
 		// return early.
-		return writeErr
+		return err
 	}
 
 	if debugLogger != nil {
@@ -121,23 +285,56 @@ func (o *sessionTracker) RemoteLogin(rul common.RemoteUserLogin) error {
 			rul.PID)
 	}
 
-	o.pidsToRULs.Store(rul.PID, rul)
+	o.storeRemoteLogin(rul)
 
 	return nil
 }
 
-// AuditdEvent takes coalesced event as parameter. It process only the events where Session is not blank or unset.
+// remoteLoginFoundSession returns a sessionMap callback that attributes
+// an existing audit session to a remote user login that was matched
+// to it using strategy.
+func (o *sessionTracker) remoteLoginFoundSession(rul common.RemoteUserLogin, strategy CorrelationStrategy,
+	debugLogger *zap.SugaredLogger,
+) func(asi string, u *user) error {
+	return func(asi string, u *user) error {
+		if debugLogger != nil {
+			debugLogger.With(
+				"auditSessionID", asi,
+				"auditSessionStartTime", u.added,
+				"numCachedAuditEvents", len(u.cached),
+				"hasRUL", u.hasRUL,
+				"correlationStrategy", strategy).
+				Debugln("found existing audit session for remote user login")
+		}
+
+		// We modify the user object in-place, in this section
+		// since it's thread-safe (i.e., it's a pointer).
+		u.setRemoteUserLoginInfo(rul, o.correlationID(asi), strategy)
+
+		writeErr := o.writeAndClearCache(u)
+
+		// The cached events may include the end of the session.
+		if u.disposed {
+			o.sessIDsToUsers.DeleteUnsafe(asi)
+		}
+
+		return writeErr
+	}
+}
+
+// AuditdEvent takes coalesced event as parameter. Events where Session is blank or unset are only written
+// as SystemAction events if they are enabled (refer to SetSystemActionConfig).
 // It checks if the event session is present in active audit sessions and then it triggers the audit with that session.
 // If the event is not present then it triggers the audit without the session.
 func (o *sessionTracker) AuditdEvent(event *aucoalesce.Event) error {
-	// TODO: Handle the "SystemAction" type (where session == "unset").
-	//  ps: "unset" is a string.
-
 	// Short-circuit if event is not associated with an audit session.
 	// Processes like "cron" may run as a user, triggering an event
-	// with no session ID. We want to skip those.
+	// with no session ID. These are only written as SystemAction
+	// events if enabled by the SystemActionConfig.
+	//
+	// ps: "unset" is a string.
 	if event.Session == "" || event.Session == "unset" {
-		return nil
+		return o.systemAction(event)
 	}
 
 	debugLogger := o.l.With(
@@ -146,11 +343,39 @@ func (o *sessionTracker) AuditdEvent(event *aucoalesce.Event) error {
 		"auditSessionID", event.Session)
 	debugLogger.Debugln("new audit event")
 
+	var err error
 	if o.sessIDsToUsers.Has(event.Session) {
-		return o.auditEventWithSession(event, debugLogger)
+		err = o.auditEventWithSession(event, debugLogger)
+	} else {
+		err = o.auditEventWithoutSession(event, debugLogger)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return o.enforceCacheLimit()
+}
+
+// systemAction writes a SystemAction event for an audit event that
+// is not associated with an audit session if the SystemActionConfig,
+// KeyFilter and eventfilter.Filter allow it.
+func (o *sessionTracker) systemAction(event *aucoalesce.Event) error {
+	if !o.systemActions.shouldWrite(event) || !o.keyFilter.allows(event.Tags) ||
+		!o.eventFilter.Keep(event, nil) {
+		return nil
+	}
+
+	err := o.eventWriter.Write(o.systemActions.toAuditEvent(event))
+	if err != nil {
+		return &SessionTrackerError{
+			auditWriteFail: true,
+			message:        fmt.Sprintf("failed to write system action event - %s", err),
+			inner:          err,
+		}
 	}
 
-	return o.auditEventWithoutSession(event, debugLogger)
+	return nil
 }
 
 // auditEventWithSession handles an audit event that is associated with
@@ -171,24 +396,26 @@ func (o *sessionTracker) auditEventWithSession(event *aucoalesce.Event, debugLog
 			"hasRUL", u.hasRemoteUserLoginInfo()).
 			Debugln("found existing audit session for audit event")
 
+		u.lastActivity = event.Timestamp
+
 		if !u.hasRemoteUserLoginInfo() {
 			debugLogger.Debugln("caching audit event")
 
+			// The address is used by CorrelateByAddress.
+			u.setAddress(event)
+
 			// Cache the event if the audit session does not have
 			// any associated common.RemoteUserLogin object.
-			u.cached = append(u.cached, event)
-
-			return nil
+			return o.cacheEvent(event.Session, u, event)
 		}
 
-		// It looks like AUDIT_CRED_DISP indicates the
-		// canonical end of a user session - but, there is
-		// also AUDIT_USER_END, which occurs just before.
-		if event.Type == auparse.AUDIT_CRED_DISP {
-			defer o.sessIDsToUsers.DeleteUnsafe(event.Session)
-		}
+		defer func() {
+			if u.disposed {
+				o.sessIDsToUsers.DeleteUnsafe(event.Session)
+			}
+		}()
 
-		err := u.writeAndClearCache(o.eventWriter)
+		err := o.writeAndClearCache(u)
 		if err != nil {
 			return &SessionTrackerError{
 				auditWriteFail: true,
@@ -198,7 +425,7 @@ func (o *sessionTracker) auditEventWithSession(event *aucoalesce.Event, debugLog
 			}
 		}
 
-		err = o.eventWriter.Write(u.toAuditEvent(event))
+		err = u.writeAction(o.actionOutput(), event)
 		if err != nil {
 			return &SessionTrackerError{
 				auditWriteFail: true,
@@ -241,21 +468,33 @@ func (o *sessionTracker) auditEventWithoutSession(event *aucoalesce.Event, debug
 	}
 
 	u := &user{
-		added:  time.Now(),
-		srcPID: srcPID,
+		added:        time.Now(),
+		srcPID:       srcPID,
+		srcPPID:      o.auditParentPID(event, srcPID),
+		nsPID:        o.sshdNamespacePID(srcPID),
+		started:      event.Timestamp,
+		lastActivity: event.Timestamp,
 	}
 
-	if o.pidsToRULs.Has(srcPID) {
-		return o.pidsToRULs.WithLockedValueDo(srcPID, func(rul common.RemoteUserLogin) error {
-			debugLogger.Debugln("found existing remote user login for new audit session")
+	u.setAddress(event)
 
-			o.pidsToRULs.DeleteUnsafe(srcPID)
+	rulPID, strategy, found := o.findPendingLogin(u)
+	if found {
+		claimed := false
+
+		err = o.pidsToRULs.WithLockedValueDo(rulPID, func(rul common.RemoteUserLogin) error {
+			claimed = true
+
+			debugLogger.With("correlationStrategy", strategy).
+				Debugln("found existing remote user login for new audit session")
+
+			o.pidsToRULs.DeleteUnsafe(rulPID)
 
-			u.setRemoteUserLoginInfo(rul)
+			u.setRemoteUserLoginInfo(rul, o.correlationID(event.Session), strategy)
 
 			o.sessIDsToUsers.Store(event.Session, u)
 
-			err = o.eventWriter.Write(u.toAuditEvent(event))
+			err = u.writeAction(o.actionOutput(), event)
 			if err != nil {
 				return &SessionTrackerError{
 					auditWriteFail: true,
@@ -266,6 +505,11 @@ func (o *sessionTracker) auditEventWithoutSession(event *aucoalesce.Event, debug
 
 			return nil
 		})
+
+		// The login may have expired since it was found.
+		if claimed {
+			return err
+		}
 	}
 
 	debugLogger.Debugln("no existing remote user login for new audit session")
@@ -273,15 +517,50 @@ func (o *sessionTracker) auditEventWithoutSession(event *aucoalesce.Event, debug
 
 	// Cache the event if the audit session does not have
 	// any associated common.RemoteUserLogin object.
-	u.cached = append(u.cached, event)
+	err = o.cacheEvent(event.Session, u, event)
 
 	o.sessIDsToUsers.Store(event.Session, u)
+	return err
+}
+
+// AddExistingSession tracks an audit session that started before the
+// sessionTracker was created, attributing its events to rul. It does
+// nothing if the audit session is already being tracked (e.g., because
+// it was restored by RestoreState).
+func (o *sessionTracker) AddExistingSession(sessionID string, rul common.RemoteUserLogin) error {
+	err := rul.Validate()
+	if err != nil {
+		return &SessionTrackerError{
+			remoteLoginFail: true,
+			message:         fmt.Sprintf("failed to validate remote user login - %s", err),
+			inner:           err,
+		}
+	}
+
+	if o.sessIDsToUsers.Has(sessionID) {
+		return nil
+	}
+
+	now := time.Now()
+
+	// The time the session started is unknown.
+	u := &user{
+		added:        now,
+		srcPID:       rul.PID,
+		lastActivity: now,
+	}
+
+	u.setRemoteUserLoginInfo(rul, o.correlationID(sessionID), CorrelatedByExistingSession)
+
+	o.sessIDsToUsers.Store(sessionID, u)
+
 	return nil
 }
 
-// DeleteUsersWithoutLoginsBefore it takes a time parameter. It iterates over active audit sessions.
-// If the session is added before the timestamp and the user does not have a remote login, then it deletes that session.
-func (o *sessionTracker) DeleteUsersWithoutLoginsBefore(t time.Time) {
+// DeleteUsersWithoutLoginsBefore deletes the audit sessions that were added before t
+// and do not have a remote user login. The sessions' cached events are evicted
+// according to the CacheConfig's OverflowPolicy.
+func (o *sessionTracker) DeleteUsersWithoutLoginsBefore(t time.Time) error {
 	var debugLogger *zap.SugaredLogger
 	if o.l.Level().Enabled(zap.DebugLevel) {
 		debugLogger = o.l.With(
@@ -289,62 +568,146 @@ func (o *sessionTracker) DeleteUsersWithoutLoginsBefore(t time.Time) {
 			"before", t.String())
 	}
 
-	o.sessIDsToUsers.Iterate(func(id string, u *user) bool {
-		if !u.hasRUL && u.added.Before(t) {
-			if debugLogger != nil {
-				debugLogger.With(
-					"auditSessionID", id,
-					"auditSessionStartTime", u.added.String()).
-					Debugln("removing unused audit session")
-			}
+	var err error
 
-			// this is fine as the function is called from within
-			// the Iterate function, which is safe for concurrent
-			// access. The lock is already held.
-			o.sessIDsToUsers.DeleteUnsafe(id)
+	o.sessIDsToUsers.DeletePendingBefore(t, func(id string, u *user) bool {
+		if debugLogger != nil {
+			debugLogger.With(
+				"auditSessionID", id,
+				"auditSessionStartTime", u.added.String()).
+				Debugln("removing unused audit session")
 		}
-		return true
+
+		if o.metrics != nil {
+			o.metrics.IncUnattributedSessionsExpired()
+		}
+
+		err = o.evict(id, u, len(u.cached))
+
+		return err == nil
 	})
+
+	o.updateCacheMetrics()
+
+	return err
 }
 
-// DeleteRemoteUserLoginsBefore takes a time parameter.
-// It iterates over remote user logins and checks if a login was before the timestamp,
-// then it deletes that remote user login.
-func (o *sessionTracker) DeleteRemoteUserLoginsBefore(t time.Time) {
-	var debugLogger *zap.SugaredLogger
-	if o.l.Level().Enabled(zap.DebugLevel) {
-		debugLogger = o.l.With(
-			"cacheCleanup", "deleteRemoteUserLoginsBefore",
-			"before", t.String())
-	}
+// EndIdleSessionsBefore ends audit sessions that have a remote user login
+// and whose last audit event occurred before t. A UserLogout event is
+// written for each session. This removes sessions whose end was never
+// observed (e.g., because the session ended while the application was
+// not running).
+func (o *sessionTracker) EndIdleSessionsBefore(t time.Time) error {
+	var err error
 
-	o.pidsToRULs.Iterate(func(pid int, userLogin common.RemoteUserLogin) bool {
-		if userLogin.Source.LoggedAt.Before(t) {
-			if debugLogger != nil {
-				debugLogger.With(
-					"pid", pid,
-					"source", *userLogin.Source).
-					Debugln("removing unused remote user login")
+	o.sessIDsToUsers.DeleteIdleBefore(t, func(id string, u *user) bool {
+		o.l.Infof("ending idle audit session '%s' (last activity: %s)", id, u.lastActivity)
+
+		if u.ended {
+			return true
+		}
+
+		err = u.writeLogout(o.eventWriter, id, sessionEndExpired, u.lastActivity)
+		if err != nil {
+			err = &SessionTrackerError{
+				auditWriteFail: true,
+				message:        fmt.Sprintf("failed to write logout event - %s", err),
+				inner:          err,
 			}
 
-			o.pidsToRULs.DeleteUnsafe(pid)
+			return false
 		}
+
 		return true
 	})
+
+	return err
+}
+
+// newRemoteLoginMap returns a map for the remote user logins that
+// are waiting for their auditd sessions. The clock is injectable
+// so that tests can control when logins expire.
+func (o *sessionTracker) newRemoteLoginMap(clock common.Clock) *common.ExpiringSyncMap[int, common.RemoteUserLogin] {
+	return common.NewExpiringSyncMap(common.ExpiringSyncMapConfig[int, common.RemoteUserLogin]{
+		OnEvict: o.remoteLoginExpired,
+		Index:   remoteLoginParentPID,
+		Clock:   clock,
+	})
+}
+
+// remoteLoginParentPID indexes the cached remote user logins by the
+// parent PIDs of the processes that logged them, so that they can be
+// found by correlation by parent PID.
+func remoteLoginParentPID(rul common.RemoteUserLogin) (int, bool) {
+	return rul.ParentPID, rul.ParentPID > 0
 }
 
+// storeRemoteLogin caches a remote user login until its auditd
+// session starts or until it expires.
+func (o *sessionTracker) storeRemoteLogin(rul common.RemoteUserLogin) {
+	var deadline time.Time
+	if o.remoteLoginTTL > 0 {
+		deadline = observedAt(rul).Add(o.remoteLoginTTL)
+	}
+
+	o.pidsToRULs.StoreUntil(rul.PID, rul, deadline)
+}
+
+// observedAt returns the time at which a remote user login was read
+// from the logs, or the time it was logged if that is unknown.
+func observedAt(rul common.RemoteUserLogin) time.Time {
+	if rul.ObservedAt.IsZero() {
+		return rul.Source.LoggedAt
+	}
+
+	return rul.ObservedAt
+}
+
+// remoteLoginExpired is called when a remote user login
+// expires before its auditd session started.
+func (o *sessionTracker) remoteLoginExpired(pid int, rul common.RemoteUserLogin) {
+	if o.l.Level().Enabled(zap.DebugLevel) {
+		o.l.With(
+			"cacheCleanup", "remoteLoginExpired",
+			"pid", pid,
+			"source", *rul.Source).
+			Debugln("removing unused remote user login")
+	}
+}
+
+// sessionEndExpired is the reason a session ended when it
+// was removed by EndIdleSessionsBefore. The other reasons
+// are the names of audit event types (e.g., "USER_END").
+const sessionEndExpired = "expired"
+
 type user struct {
-	added  time.Time              // the time when user was added
-	srcPID int                    // source PID
-	hasRUL bool                   // true if there is a remote user login
-	login  common.RemoteUserLogin // current remote user login
-	cached []*aucoalesce.Event    // list of events tied to the user
+	added         time.Time              // the time when user was added
+	srcPID        int                    // source PID
+	srcPPID       int                    // parent of the source PID, if known
+	nsPID         int                    // source PID in sshd's PID namespace, if it is in it
+	srcAddr       string                 // source address from the audit events, if known
+	srcPort       string                 // source port from the audit events, if known
+	hasRUL        bool                   // true if there is a remote user login
+	login         common.RemoteUserLogin // current remote user login
+	strategy      CorrelationStrategy    // how the remote user login was found
+	correlationID string                 // links the session's events together
+	cached        []*aucoalesce.Event    // list of events tied to the user
+	started       time.Time              // the time the session started, if known
+	lastActivity  time.Time              // the time of the session's last audit event
+	numCommands   int                    // the number of commands executed in the session
+	numFailed     int                    // the number of failed actions in the session
+	ended         bool                   // true if a logout event was written
+	disposed      bool                   // true if the session's credentials were disposed
 }
 
-// setRemoteUserLoginInfo sets the remote user login for a user.
-func (o *user) setRemoteUserLoginInfo(login common.RemoteUserLogin) {
+// setRemoteUserLoginInfo sets the remote user login for a user along
+// with the correlation ID of the user's audit session and the strategy
+// that matched the login to the session.
+func (o *user) setRemoteUserLoginInfo(login common.RemoteUserLogin, correlationID string, strategy CorrelationStrategy) {
 	o.hasRUL = true
 	o.login = login
+	o.correlationID = correlationID
+	o.strategy = strategy
 }
 
 // hasRemoteUserLoginInfo checks if there is a remote user login present for the user.
@@ -382,7 +745,6 @@ func (o *user) toAuditEvent(ae *aucoalesce.Event) *auditevent.AuditEvent {
 	).WithTarget(o.login.Source.Target)
 
 	evt.LoggedAt = ae.Timestamp
-	evt.Metadata.AuditID = ae.Session
 
 	// TODO: Talk with Ozz about this. The metadata fields appear to
 	//  be OS-specific (git grep "executed" in go-libaudit).
@@ -397,19 +759,76 @@ func (o *user) toAuditEvent(ae *aucoalesce.Event) *auditevent.AuditEvent {
 		evt.Metadata.Extra["process_args"] = ae.Process.Args
 	}
 
+	// set the keys of the audit rules that produced the event
+	if len(ae.Tags) > 0 {
+		evt.Metadata.Extra["keys"] = ae.Tags
+	}
+
+	o.setSessionMetadata(evt, ae.Session)
+
 	return evt
 }
 
-// writeAndClearCache takes an event writer as parameter.
+// setSessionMetadata sets the metadata that links an event to the
+// user's session and UserLogin event. The audit ID is set to the
+// session's correlation ID, which is shared by all of the session's
+// UserAction and UserLogout events.
+//
+// The UserLogin event is written when sshd logs the login, which is
+// before the audit session starts. As a result, it does not contain
+// the correlation ID. Instead, its audit ID is added as "login_audit_id".
+func (o *user) setSessionMetadata(evt *auditevent.AuditEvent, sessionID string) {
+	evt.Metadata.AuditID = o.correlationID
+	evt.Metadata.Extra["audit_session_id"] = sessionID
+
+	if o.login.Source.Metadata.AuditID != "" {
+		evt.Metadata.Extra["login_audit_id"] = o.login.Source.Metadata.AuditID
+	}
+
+	if o.strategy != "" {
+		evt.Metadata.Extra["correlation_strategy"] = string(o.strategy)
+	}
+
+	if o.login.LowConfidence {
+		evt.Metadata.Extra["attribution_confidence"] = "low"
+	}
+}
+
+// actionOutput determines how a user's UserAction events are written.
+type actionOutput struct {
+	// writer is the auditevent.EventWriter to write events to.
+	writer *auditevent.EventWriter
+
+	// details determines which audit record fields are added.
+	details ActionDetailConfig
+
+	// keyFilter and eventFilter determine which events are written.
+	keyFilter   KeyFilter
+	eventFilter *eventfilter.Filter
+}
+
+// writeAndClearCache writes a user's cached audit events
+// and removes them from the cache.
+func (o *sessionTracker) writeAndClearCache(u *user) error {
+	numCached := len(u.cached)
+
+	err := u.writeAndClearCache(o.actionOutput())
+
+	o.uncache(numCached - len(u.cached))
+
+	return err
+}
+
+// writeAndClearCache takes an actionOutput as parameter.
 // It processes the cached coalesced events of the user and converts that to an audit event.
 // It then writes the audit event to the audit logs and then cleans the event cache of the user.
-func (o *user) writeAndClearCache(writer *auditevent.EventWriter) error {
+func (o *user) writeAndClearCache(out actionOutput) error {
 	if len(o.cached) == 0 {
 		return nil
 	}
 
 	for i := range o.cached {
-		err := writer.Write(o.toAuditEvent(o.cached[i]))
+		err := o.writeAction(out, o.cached[i])
 		if err != nil {
 			return err
 		}
@@ -419,3 +838,105 @@ func (o *user) writeAndClearCache(writer *auditevent.EventWriter) error {
 
 	return nil
 }
+
+// writeAction writes a UserAction event for the audit event, if the
+// actionOutput's filters allow it, and updates the session's activity
+// counters. A UserLogout event is written as well if the audit event is
+// the first to indicate the end of the session.
+func (o *user) writeAction(out actionOutput, ae *aucoalesce.Event) error {
+	if out.keyFilter.allows(ae.Tags) && out.eventFilter.Keep(ae, &o.login) {
+		evt := o.toAuditEvent(ae)
+		out.details.addTo(evt, ae)
+
+		err := out.writer.Write(evt)
+		if err != nil {
+			return err
+		}
+	}
+
+	o.countAction(ae)
+
+	if !o.isEndEvent(ae) {
+		return nil
+	}
+
+	if ae.Type == auparse.AUDIT_CRED_DISP {
+		o.disposed = true
+	}
+
+	if o.ended {
+		return nil
+	}
+
+	err := o.writeLogout(out.writer, ae.Session, ae.Type.String(), ae.Timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to write logout event - %w", err)
+	}
+
+	return nil
+}
+
+// countAction updates the session's activity counters.
+func (o *user) countAction(ae *aucoalesce.Event) {
+	if ae.Summary.Action == "executed" {
+		o.numCommands++
+	}
+
+	if ae.Result == "fail" {
+		o.numFailed++
+	}
+}
+
+// isEndEvent returns true if the audit event indicates the end of
+// the session.
+//
+// It looks like AUDIT_CRED_DISP indicates the canonical end of a
+// user session - but, there is also AUDIT_USER_END, which occurs
+// just before. Other processes in the session (e.g., sudo) produce
+// these events as well. Only the events produced by the process
+// that started the session (i.e., the process that produced the
+// session's AUDIT_LOGIN event) end it. Events without a PID are
+// assumed to be produced by that process.
+func (o *user) isEndEvent(ae *aucoalesce.Event) bool {
+	if ae.Type != auparse.AUDIT_USER_END && ae.Type != auparse.AUDIT_CRED_DISP {
+		return false
+	}
+
+	return ae.Process.PID == "" || ae.Process.PID == strconv.Itoa(o.srcPID)
+}
+
+// writeLogout writes a UserLogout event describing the end of the
+// user's session. The event contains the same subjects and source
+// as the UserLogin event and a summary of the session's activity.
+func (o *user) writeLogout(writer *auditevent.EventWriter, sessionID string, reason string, endedAt time.Time) error {
+	o.ended = true
+
+	subjectsCopy := make(map[string]string, len(o.login.Source.Subjects))
+	for k, v := range o.login.Source.Subjects {
+		subjectsCopy[k] = v
+	}
+
+	evt := auditevent.NewAuditEvent(
+		common.ActionLogoutIdentifier,
+		o.login.Source.Source,
+		auditevent.OutcomeSucceeded,
+		subjectsCopy,
+		"auditd",
+	).WithTarget(o.login.Source.Target)
+
+	evt.LoggedAt = endedAt
+	evt.Metadata.Extra = map[string]any{
+		"end_reason":        reason,
+		"commands_executed": o.numCommands,
+		"failed_actions":    o.numFailed,
+	}
+
+	if !o.started.IsZero() {
+		evt.Metadata.Extra["started_at"] = o.started
+		evt.Metadata.Extra["duration_seconds"] = endedAt.Sub(o.started).Seconds()
+	}
+
+	o.setSessionMetadata(evt, sessionID)
+
+	return writer.Write(evt)
+}
@@ -3,6 +3,8 @@ package sessiontracker
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"strconv"
 	"testing"
 	"time"
@@ -245,7 +247,8 @@ func TestSessionTracker_AuditdEvent_ExistingSession_Ended(t *testing.T) {
 	// As a result, the code contained in the loop will not execute.
 	numExtraEvents := 2
 	numEventsToWrite := int(testtools.Intn(t, int64(numExtraEvents+1), 100))
-	events := make(chan *auditevent.AuditEvent, numEventsToWrite+numExtraEvents)
+	// The additional event is the UserLogout event.
+	events := make(chan *auditevent.AuditEvent, numEventsToWrite+numExtraEvents+1)
 
 	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
 		Ctx:    ctx,
@@ -303,7 +306,177 @@ func TestSessionTracker_AuditdEvent_ExistingSession_Ended(t *testing.T) {
 
 	assert.Equal(t, st.sessIDsToUsers.Len(), 0)
 	assert.Equal(t, st.pidsToRULs.Len(), 0)
-	assert.Len(t, events, numEventsToWrite)
+	require.Len(t, events, numEventsToWrite+1)
+
+	for i := 0; i < numEventsToWrite; i++ {
+		assert.Equal(t, common.ActionUserAction, (<-events).Type)
+	}
+
+	logout := <-events
+	assert.Equal(t, common.ActionLogoutIdentifier, logout.Type)
+	assert.Equal(t, "CRED_DISP", logout.Metadata.Extra["end_reason"])
+}
+
+func TestSessionTracker_AuditdEvent_ExistingSession_Logout(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+
+	started := time.Date(2023, 3, 17, 13, 37, 0, 0, time.UTC)
+
+	loginEvent := newAucoalesceEvent(t, "123", "success", started)
+	loginEvent.Type = auparse.AUDIT_LOGIN
+	loginEvent.Process.PID = "999"
+
+	rul := common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			Subjects: map[string]string{
+				"loggedAs": "core",
+			},
+			Source: auditevent.EventSource{
+				Type:  "IP",
+				Value: "127.0.0.1",
+			},
+			Target: map[string]string{
+				"host": "foo",
+			},
+		},
+		PID:        999,
+		CredUserID: "foo",
+	}
+
+	require.NoError(t, st.RemoteLogin(rul))
+	require.NoError(t, st.AuditdEvent(loginEvent))
+
+	executed := newAucoalesceEvent(t, "123", "success", started.Add(time.Second))
+	executed.Summary.Action = "executed"
+	require.NoError(t, st.AuditdEvent(executed))
+
+	failedExec := newAucoalesceEvent(t, "123", "fail", started.Add(2*time.Second))
+	failedExec.Summary.Action = "executed"
+	require.NoError(t, st.AuditdEvent(failedExec))
+
+	// sudo produces these events using the same session ID.
+	// They must not end the session.
+	for _, eventType := range []auparse.AuditMessageType{auparse.AUDIT_USER_END, auparse.AUDIT_CRED_DISP} {
+		sudoEvent := newAucoalesceEvent(t, "123", "success", started.Add(3*time.Second))
+		sudoEvent.Type = eventType
+		sudoEvent.Process.PID = "1234"
+		require.NoError(t, st.AuditdEvent(sudoEvent))
+	}
+
+	require.Equal(t, 1, st.sessIDsToUsers.Len())
+
+	userEnd := newAucoalesceEvent(t, "123", "success", started.Add(time.Minute))
+	userEnd.Type = auparse.AUDIT_USER_END
+	userEnd.Process.PID = "999"
+	require.NoError(t, st.AuditdEvent(userEnd))
+
+	credDisp := newAucoalesceEvent(t, "123", "success", started.Add(time.Minute))
+	credDisp.Type = auparse.AUDIT_CRED_DISP
+	credDisp.Process.PID = "999"
+	require.NoError(t, st.AuditdEvent(credDisp))
+
+	assert.Equal(t, 0, st.sessIDsToUsers.Len())
+
+	// login, 2 commands, 2 sudo events and USER_END.
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, common.ActionUserAction, (<-events).Type, "i: %d", i)
+	}
+
+	logout := <-events
+	assert.Equal(t, common.ActionLogoutIdentifier, logout.Type)
+	assert.Equal(t, "auditd", logout.Component)
+	assert.Equal(t, rul.Source.Subjects, logout.Subjects)
+	assert.Equal(t, rul.Source.Source, logout.Source)
+	assert.Equal(t, rul.Source.Target, logout.Target)
+	assert.Equal(t, common.SessionCorrelationID("", "", "123"), logout.Metadata.AuditID)
+	assert.Equal(t, userEnd.Timestamp, logout.LoggedAt)
+	assert.Equal(t, map[string]any{
+		"audit_session_id":     "123",
+		"end_reason":           "USER_END",
+		"commands_executed":    2,
+		"failed_actions":       1,
+		"started_at":           started,
+		"duration_seconds":     float64(60),
+		"correlation_strategy": "pid",
+	}, logout.Metadata.Extra)
+
+	// CRED_DISP is written, but it does not produce another logout.
+	assert.Equal(t, common.ActionUserAction, (<-events).Type)
+	assert.Empty(t, events)
+}
+
+func TestSessionTracker_EndIdleSessionsBefore(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 10)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+
+	now := time.Now()
+	login := common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			Subjects: map[string]string{
+				"loggedAs": "core",
+			},
+		},
+		PID:        999,
+		CredUserID: "foo",
+	}
+
+	st.sessIDsToUsers.Store("idle", &user{
+		srcPID:        999,
+		hasRUL:        true,
+		login:         login,
+		correlationID: "some-correlation-id",
+		lastActivity:  now.Add(-time.Hour),
+	})
+	st.sessIDsToUsers.Store("active", &user{
+		srcPID:       1000,
+		hasRUL:       true,
+		login:        login,
+		lastActivity: now,
+	})
+	// Sessions without a login are removed by
+	// DeleteUsersWithoutLoginsBefore instead.
+	st.sessIDsToUsers.Store("no-login", &user{
+		srcPID:       1001,
+		lastActivity: now.Add(-time.Hour),
+	})
+
+	err := st.EndIdleSessionsBefore(now.Add(-time.Minute))
+	require.NoError(t, err)
+
+	assert.False(t, st.sessIDsToUsers.Has("idle"))
+	assert.True(t, st.sessIDsToUsers.Has("active"))
+	assert.True(t, st.sessIDsToUsers.Has("no-login"))
+
+	require.Len(t, events, 1)
+
+	logout := <-events
+	assert.Equal(t, common.ActionLogoutIdentifier, logout.Type)
+	assert.Equal(t, "some-correlation-id", logout.Metadata.AuditID)
+	assert.Equal(t, "idle", logout.Metadata.Extra["audit_session_id"])
+	assert.Equal(t, now.Add(-time.Hour), logout.LoggedAt)
+	assert.Equal(t, "expired", logout.Metadata.Extra["end_reason"])
+	assert.NotContains(t, logout.Metadata.Extra, "duration_seconds")
 }
 
 func TestSessionTracker_AuditdEvent_ExistingSession_NoRUL(t *testing.T) {
@@ -591,7 +764,72 @@ func TestSessionTracker_AuditdEvent_CreateSession_NoRUL(t *testing.T) {
 	assert.Len(t, cachedsess.cached, numEvents)
 }
 
-func TestSessionTracker_DeleteRemoteUserLoginsBefore(t *testing.T) {
+func TestSessionTracker_AddExistingSession(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 1)
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: events,
+		T:      t,
+	}), nil)
+
+	rul := common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			Subjects: map[string]string{
+				"loggedAs": "core",
+			},
+			Source: auditevent.EventSource{
+				Type:  "IP",
+				Value: "127.0.0.1",
+			},
+		},
+		PID:           999,
+		CredUserID:    common.UnknownUser,
+		LowConfidence: true,
+	}
+
+	err := st.AddExistingSession("123", rul)
+	require.NoError(t, err)
+
+	// Existing sessions are not replaced.
+	otherRUL := rul
+	otherRUL.PID = 1000
+	err = st.AddExistingSession("123", otherRUL)
+	require.NoError(t, err)
+
+	u, found := st.sessIDsToUsers.Load("123")
+	require.True(t, found, "expected to find audit session")
+	assert.True(t, u.hasRUL)
+	assert.Equal(t, 999, u.srcPID)
+	assert.Equal(t, rul, u.login)
+
+	err = st.AuditdEvent(newAucoalesceEvent(t, "123", "success", time.Now()))
+	require.NoError(t, err)
+
+	event := <-events
+	assert.Equal(t, "low", event.Metadata.Extra["attribution_confidence"])
+	assert.Equal(t, "core", event.Subjects["loggedAs"])
+}
+
+func TestSessionTracker_AddExistingSession_ValidateErr(t *testing.T) {
+	t.Parallel()
+
+	st := NewSessionTracker(nil, nil)
+
+	err := st.AddExistingSession("123", common.RemoteUserLogin{PID: 999})
+
+	var expErr *SessionTrackerError
+	require.ErrorAs(t, err, &expErr)
+	assert.True(t, expErr.RemoteLoginFailed())
+	assert.Equal(t, 0, st.sessIDsToUsers.Len())
+}
+
+func TestSessionTracker_RemoteLoginTTL(t *testing.T) {
 	t.Parallel()
 
 	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
@@ -603,10 +841,14 @@ func TestSessionTracker_DeleteRemoteUserLoginsBefore(t *testing.T) {
 		T:      t,
 	}), nil)
 
-	for i := 0; i < int(testtools.Intn(t, 1, 100)); i++ {
-		err := st.RemoteLogin(common.RemoteUserLogin{
+	clock := testtools.NewTestClock(time.Now())
+	st.pidsToRULs = st.newRemoteLoginMap(clock)
+	st.SetRemoteLoginTTL(time.Minute)
+
+	newRUL := func(pid int, loggedAt time.Time) common.RemoteUserLogin {
+		return common.RemoteUserLogin{
 			Source: &auditevent.AuditEvent{
-				LoggedAt: time.Now().Add(-time.Minute),
+				LoggedAt: loggedAt,
 				Subjects: map[string]string{
 					"some key": "some value",
 				},
@@ -615,17 +857,96 @@ func TestSessionTracker_DeleteRemoteUserLoginsBefore(t *testing.T) {
 					Value: "127.0.0.1",
 				},
 			},
-			PID:        int(testtools.Intn(t, 2, 65535)),
+			PID:        pid,
 			CredUserID: "foo",
-		})
-		if err != nil {
-			t.Fatal(err)
 		}
 	}
 
-	st.DeleteRemoteUserLoginsBefore(time.Now())
+	numOld := int(testtools.Intn(t, 1, 100))
+	for i := 0; i < numOld; i++ {
+		err := st.RemoteLogin(newRUL(1000+i, clock.Now().Add(-30*time.Second)))
+		require.NoError(t, err)
+	}
 
-	assert.Equal(t, st.pidsToRULs.Len(), 0)
+	err := st.RemoteLogin(newRUL(999, clock.Now()))
+	require.NoError(t, err)
+
+	assert.Equal(t, numOld+1, st.pidsToRULs.Len())
+
+	// Logins expire a TTL after they were logged.
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, 1, st.pidsToRULs.Len())
+	assert.True(t, st.pidsToRULs.Has(999))
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, 0, st.pidsToRULs.Len())
+}
+
+func TestSessionTracker_RemoteLoginTTL_ObservedAt(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: make(chan *auditevent.AuditEvent, 1),
+		T:      t,
+	}), nil)
+
+	clock := testtools.NewTestClock(time.Now())
+	st.pidsToRULs = st.newRemoteLoginMap(clock)
+	st.SetRemoteLoginTTL(time.Minute)
+
+	// The login was logged an hour ago, but it was delayed
+	// (e.g., by a backlog in a named pipe) until now.
+	err := st.RemoteLogin(common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			LoggedAt: clock.Now().Add(-time.Hour),
+			Subjects: map[string]string{
+				"some key": "some value",
+			},
+			Source: auditevent.EventSource{
+				Type:  "sshd",
+				Value: "127.0.0.1",
+			},
+		},
+		PID:        999,
+		CredUserID: "foo",
+		ObservedAt: clock.Now(),
+	})
+	require.NoError(t, err)
+
+	// Logins expire a TTL after they were observed.
+	clock.Advance(30 * time.Second)
+	assert.True(t, st.pidsToRULs.Has(999))
+
+	clock.Advance(30 * time.Second)
+	assert.False(t, st.pidsToRULs.Has(999))
+}
+
+func TestSessionTracker_RemoteLoginTTL_Disabled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Events: make(chan *auditevent.AuditEvent, 1),
+		T:      t,
+	}), nil)
+
+	err := st.RemoteLogin(common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			LoggedAt: time.Now().Add(-24 * time.Hour),
+		},
+		PID:        999,
+		CredUserID: "foo",
+	})
+	require.NoError(t, err)
+
+	assert.True(t, st.pidsToRULs.Has(999), "logins should not expire without a TTL")
 }
 
 func TestSessionTracker_DeleteUsersWithoutLoginsBefore(t *testing.T) {
@@ -652,9 +973,13 @@ func TestSessionTracker_DeleteUsersWithoutLoginsBefore(t *testing.T) {
 		}
 	}
 
-	st.DeleteUsersWithoutLoginsBefore(time.Now())
+	err := st.DeleteUsersWithoutLoginsBefore(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	assert.Equal(t, st.sessIDsToUsers.Len(), 0)
+	assert.Equal(t, int64(0), st.numCached.Load())
 }
 
 func TestUser_ToAuditEvent(t *testing.T) {
@@ -666,6 +991,9 @@ func TestUser_ToAuditEvent(t *testing.T) {
 		hasRUL: false,
 		login: common.RemoteUserLogin{
 			Source: &auditevent.AuditEvent{
+				Metadata: auditevent.EventMetadata{
+					AuditID: "some-login-id",
+				},
 				Subjects: map[string]string{
 					"some key": "some value",
 				},
@@ -675,6 +1003,7 @@ func TestUser_ToAuditEvent(t *testing.T) {
 				},
 			},
 		},
+		correlationID: "some-correlation-id",
 	}
 
 	ae := &aucoalesce.Event{
@@ -700,9 +1029,11 @@ func TestUser_ToAuditEvent(t *testing.T) {
 	assert.Equal(t, "auditd", event.Component)
 	assert.Equal(t, event.Outcome, auditevent.OutcomeSucceeded)
 	assert.Equal(t, ae.Timestamp, event.LoggedAt)
-	assert.Equal(t, ae.Session, event.Metadata.AuditID)
+	assert.Equal(t, "some-correlation-id", event.Metadata.AuditID)
 
-	assert.Len(t, event.Metadata.Extra, 4)
+	assert.Len(t, event.Metadata.Extra, 6)
+	assert.Equal(t, ae.Session, event.Metadata.Extra["audit_session_id"])
+	assert.Equal(t, "some-login-id", event.Metadata.Extra["login_audit_id"])
 	assert.Equal(t, ae.Summary.Action, event.Metadata.Extra["action"])
 	assert.Equal(t, ae.Summary.How, event.Metadata.Extra["how"])
 	assert.Equal(t, ae.Summary.Object, event.Metadata.Extra["object"])
@@ -759,6 +1090,7 @@ func TestUser_ToAuditEvent_Fail(t *testing.T) {
 
 	event := u.toAuditEvent(ae)
 	assert.Nil(t, event.Metadata.Extra["process_args"])
+	assert.Nil(t, event.Metadata.Extra["attribution_confidence"])
 	assert.Equal(t, event.Outcome, auditevent.OutcomeFailed)
 }
 
@@ -795,11 +1127,13 @@ func TestUser_WriteAndClearCache(t *testing.T) {
 
 	events := make(chan *auditevent.AuditEvent, numEvents)
 
-	err := u.writeAndClearCache(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
-		Ctx:    ctx,
-		Events: events,
-		T:      t,
-	}))
+	err := u.writeAndClearCache(actionOutput{
+		writer: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: events,
+			T:      t,
+		}),
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -841,12 +1175,14 @@ func TestUser_WriteAndClearCache_WriteErr(t *testing.T) {
 
 	expErr := errors.New("write error")
 
-	err := u.writeAndClearCache(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
-		Ctx:    ctx,
-		Events: make(chan *auditevent.AuditEvent),
-		T:      t,
-		Err:    expErr,
-	}))
+	err := u.writeAndClearCache(actionOutput{
+		writer: auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: make(chan *auditevent.AuditEvent),
+			T:      t,
+			Err:    expErr,
+		}),
+	})
 
 	assert.ErrorIs(t, err, expErr)
 
@@ -873,3 +1209,139 @@ func newAucoalesceEvent(t *testing.T, sessionID, outcome string, timestamp time.
 
 	return ae
 }
+
+// benchmarkSessionCounts are the numbers of concurrent audit sessions
+// used by the benchmarks. The cost of each operation should not grow
+// with the number of sessions.
+var benchmarkSessionCounts = []int{10, 100, 1000, 10000}
+
+func BenchmarkSessionTracker_RemoteLogin(b *testing.B) {
+	for _, numSessions := range benchmarkSessionCounts {
+		b.Run(fmt.Sprintf("sessions=%d", numSessions), func(b *testing.B) {
+			st := newBenchmarkSessionTracker(numSessions, false)
+
+			login := common.RemoteUserLogin{
+				Source:     &auditevent.AuditEvent{},
+				CredUserID: "foo",
+			}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				login.PID = benchmarkPID(i % numSessions)
+
+				err := st.RemoteLogin(login)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSessionTracker_RemoteLogin_PIDMiss benchmarks remote user
+// logins whose PIDs do not match any session, which makes RemoteLogin
+// look for the sessions of their parent and child processes.
+func BenchmarkSessionTracker_RemoteLogin_PIDMiss(b *testing.B) {
+	for _, numSessions := range benchmarkSessionCounts {
+		b.Run(fmt.Sprintf("sessions=%d", numSessions), func(b *testing.B) {
+			st := newBenchmarkSessionTracker(numSessions, false)
+			st.SetCorrelationConfig(CorrelationConfig{ParentPID: true})
+
+			login := common.RemoteUserLogin{
+				Source:     &auditevent.AuditEvent{},
+				ParentPID:  1,
+				CredUserID: "foo",
+			}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				login.PID = benchmarkPID(numSessions + i%numSessions)
+
+				err := st.RemoteLogin(login)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				st.pidsToRULs.Delete(login.PID)
+			}
+		})
+	}
+}
+
+func BenchmarkSessionTracker_DeleteUsersWithoutLoginsBefore(b *testing.B) {
+	for _, numSessions := range benchmarkSessionCounts {
+		b.Run(fmt.Sprintf("sessions=%d", numSessions), func(b *testing.B) {
+			st := newBenchmarkSessionTracker(numSessions, true)
+			added := time.Now().Add(-time.Hour)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				st.sessIDsToUsers.Store("pending", &user{added: added, srcPID: 1})
+
+				err := st.DeleteUsersWithoutLoginsBefore(time.Now())
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSessionTracker_EndIdleSessionsBefore(b *testing.B) {
+	for _, numSessions := range benchmarkSessionCounts {
+		b.Run(fmt.Sprintf("sessions=%d", numSessions), func(b *testing.B) {
+			st := newBenchmarkSessionTracker(numSessions, true)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				err := st.EndIdleSessionsBefore(time.Now().Add(-time.Hour))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// newBenchmarkSessionTracker returns a sessionTracker with numSessions
+// audit sessions that have a remote user login if hasLogins is true.
+func newBenchmarkSessionTracker(numSessions int, hasLogins bool) *sessionTracker {
+	st := NewSessionTracker(auditevent.NewDefaultAuditEventWriter(io.Discard), nil)
+
+	now := time.Now()
+
+	for i := 0; i < numSessions; i++ {
+		u := &user{
+			added:        now,
+			srcPID:       benchmarkPID(i),
+			srcPPID:      benchmarkPPID(i),
+			lastActivity: now,
+		}
+
+		if hasLogins {
+			u.setRemoteUserLoginInfo(common.RemoteUserLogin{
+				Source:     &auditevent.AuditEvent{},
+				PID:        u.srcPID,
+				CredUserID: "foo",
+			}, "some-correlation-id", CorrelatedByPID)
+		}
+
+		st.sessIDsToUsers.Store(strconv.Itoa(i), u)
+	}
+
+	return st
+}
+
+func benchmarkPID(i int) int {
+	return 1000 + i
+}
+
+// benchmarkPPID returns the parent PID of the i-th benchmark session.
+// It never equals a PID returned by benchmarkPID.
+func benchmarkPPID(i int) int {
+	return 1000000 + i
+}
@@ -0,0 +1,195 @@
+package sessiontracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+const (
+	// stateVersion is the version of the state file format.
+	// It must be incremented when the format changes in
+	// a backwards-incompatible way.
+	stateVersion = 1
+
+	stateFilePerms = 0o640
+)
+
+// trackerState is a snapshot of a sessionTracker that is saved
+// to a file, allowing the sessionTracker to be restored after
+// the application restarts.
+type trackerState struct {
+	Version int       `json:"version"`
+	BootID  string    `json:"boot_id"`
+	SavedAt time.Time `json:"saved_at"`
+
+	// Sessions are the audit sessions found in sessIDsToUsers.
+	Sessions []sessionState `json:"sessions"`
+
+	// Logins are the remote user logins found in pidsToRULs.
+	Logins []common.RemoteUserLogin `json:"logins"`
+}
+
+// sessionState is the saved state of a single audit session.
+//
+// Cached audit events are not saved. They belong to sessions that
+// have no remote user login, which are removed shortly after they
+// are restored by the stale data cleanup.
+type sessionState struct {
+	SessionID    string                  `json:"session_id"`
+	Added        time.Time               `json:"added"`
+	SrcPID       int                     `json:"src_pid"`
+	SrcPPID      int                     `json:"src_ppid,omitempty"`
+	SrcAddr      string                  `json:"src_addr,omitempty"`
+	SrcPort      string                  `json:"src_port,omitempty"`
+	Login        *common.RemoteUserLogin `json:"login,omitempty"`
+	Strategy     CorrelationStrategy     `json:"correlation_strategy,omitempty"`
+	Started      time.Time               `json:"started"`
+	LastActivity time.Time               `json:"last_activity"`
+	NumCommands  int                     `json:"num_commands"`
+	NumFailed    int                     `json:"num_failed"`
+	Ended        bool                    `json:"ended"`
+}
+
+// SaveState saves the sessionTracker's audit sessions and remote user
+// logins to the file found at filePath. bootID identifies the current
+// boot of the computer. It is used by RestoreState to discard state
+// that was saved prior to a reboot.
+func (o *sessionTracker) SaveState(filePath string, bootID string) error {
+	state := trackerState{
+		Version: stateVersion,
+		BootID:  bootID,
+		SavedAt: time.Now(),
+	}
+
+	// Remote user logins are moved from pidsToRULs to
+	// sessIDsToUsers when their audit session starts.
+	// Saving pidsToRULs first means that a login which
+	// is moved in the meantime is saved twice rather
+	// than not at all.
+	o.pidsToRULs.Iterate(func(_ int, rul common.RemoteUserLogin) bool {
+		state.Logins = append(state.Logins, rul)
+		return true
+	})
+
+	o.sessIDsToUsers.Iterate(func(id string, u *user) bool {
+		ss := sessionState{
+			SessionID:    id,
+			Added:        u.added,
+			SrcPID:       u.srcPID,
+			SrcPPID:      u.srcPPID,
+			SrcAddr:      u.srcAddr,
+			SrcPort:      u.srcPort,
+			Started:      u.started,
+			LastActivity: u.lastActivity,
+			NumCommands:  u.numCommands,
+			NumFailed:    u.numFailed,
+			Ended:        u.ended,
+		}
+
+		if u.hasRUL {
+			login := u.login
+			ss.Login = &login
+			ss.Strategy = u.strategy
+		}
+
+		state.Sessions = append(state.Sessions, ss)
+		return true
+	})
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session tracker state - %w", err)
+	}
+
+	err = common.WriteFileAtomic(filePath, data, stateFilePerms)
+	if err != nil {
+		return fmt.Errorf("failed to write session tracker state file - %w", err)
+	}
+
+	return nil
+}
+
+// RestoreState restores audit sessions and remote user logins that were
+// saved by SaveState to the file found at filePath. A *SessionTrackerError
+// is returned if the state was saved during a boot other than bootID
+// (refer to SessionTrackerError.StaleState). Audit session IDs are not
+// unique across reboots, so such state is not restored.
+func (o *sessionTracker) RestoreState(filePath string, bootID string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read session tracker state file - %w", err)
+	}
+
+	var state trackerState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal session tracker state - %w", err)
+	}
+
+	if state.Version != stateVersion {
+		return fmt.Errorf("unsupported session tracker state version: %d", state.Version)
+	}
+
+	if state.BootID != bootID {
+		return &SessionTrackerError{
+			staleState: true,
+			message: fmt.Sprintf("session tracker state was saved during a different boot ('%s')",
+				state.BootID),
+		}
+	}
+
+	numLogins := 0
+	for _, rul := range state.Logins {
+		err := rul.Validate()
+		if err != nil {
+			o.l.Warnf("skipping invalid remote user login in session tracker state - %s", err)
+			continue
+		}
+
+		o.storeRemoteLogin(rul)
+		numLogins++
+	}
+
+	numSessions := 0
+	for _, ss := range state.Sessions {
+		u := &user{
+			added:        ss.Added,
+			srcPID:       ss.SrcPID,
+			srcPPID:      ss.SrcPPID,
+			srcAddr:      ss.SrcAddr,
+			srcPort:      ss.SrcPort,
+			started:      ss.Started,
+			lastActivity: ss.LastActivity,
+			numCommands:  ss.NumCommands,
+			numFailed:    ss.NumFailed,
+			ended:        ss.Ended,
+		}
+
+		if ss.Login != nil {
+			err := ss.Login.Validate()
+			if err != nil {
+				o.l.Warnf("skipping audit session '%s' with invalid remote user login in session tracker state - %s",
+					ss.SessionID, err)
+				continue
+			}
+
+			u.setRemoteUserLoginInfo(*ss.Login, o.correlationID(ss.SessionID), ss.Strategy)
+
+			// Remove the copy of the login that may have
+			// been saved while its session was starting.
+			o.pidsToRULs.Delete(ss.Login.PID)
+		}
+
+		o.sessIDsToUsers.Store(ss.SessionID, u)
+		numSessions++
+	}
+
+	o.l.Infof("restored %d audit sessions and %d remote user logins saved at %s",
+		numSessions, numLogins, state.SavedAt)
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package sessiontracker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+const testBootID = "0b3e2b69-3c1e-4b4c-a4e5-1e6f4a7d0c2d"
+
+func TestSessionTracker_SaveState_RestoreState(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	events := make(chan *auditevent.AuditEvent, 1)
+	newTracker := func() *sessionTracker {
+		st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+			Ctx:    ctx,
+			Events: events,
+			T:      t,
+		}), nil)
+		st.SetCorrelationIDSource("deadbeef", testBootID)
+
+		return st
+	}
+
+	added := time.Date(2023, 3, 17, 13, 37, 0, 0, time.UTC)
+
+	expSessionRUL := newTestStateRUL(999, added)
+	expPendingRUL := newTestStateRUL(2000, added)
+
+	st := newTracker()
+	st.sessIDsToUsers.Store("123", &user{
+		added:        added,
+		srcPID:       999,
+		hasRUL:       true,
+		login:        expSessionRUL,
+		started:      added,
+		lastActivity: added.Add(time.Minute),
+		numCommands:  2,
+		numFailed:    1,
+	})
+	st.sessIDsToUsers.Store("456", &user{
+		added:  added,
+		srcPID: 1000,
+		cached: []*aucoalesce.Event{newAucoalesceEvent(t, "456", "success", added)},
+	})
+	st.pidsToRULs.Store(expPendingRUL.PID, expPendingRUL)
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	err := st.SaveState(statePath, testBootID)
+	require.NoError(t, err)
+
+	restored := newTracker()
+
+	err = restored.RestoreState(statePath, testBootID)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, restored.sessIDsToUsers.Len())
+
+	u, found := restored.sessIDsToUsers.Load("123")
+	require.True(t, found, "expected to find audit session 123")
+	assert.Equal(t, &user{
+		added:         added,
+		srcPID:        999,
+		hasRUL:        true,
+		login:         expSessionRUL,
+		correlationID: common.SessionCorrelationID("deadbeef", testBootID, "123"),
+		started:       added,
+		lastActivity:  added.Add(time.Minute),
+		numCommands:   2,
+		numFailed:     1,
+	}, u)
+
+	u, found = restored.sessIDsToUsers.Load("456")
+	require.True(t, found, "expected to find audit session 456")
+	assert.Equal(t, &user{
+		added:  added,
+		srcPID: 1000,
+	}, u, "cached events should not be restored")
+
+	assert.Equal(t, 1, restored.pidsToRULs.Len())
+
+	rul, found := restored.pidsToRULs.Load(expPendingRUL.PID)
+	require.True(t, found, "expected to find remote user login in pidsToRULs")
+	assert.Equal(t, expPendingRUL, rul)
+
+	// Events from a restored session are attributed
+	// to the session's remote user login.
+	err = restored.AuditdEvent(newAucoalesceEvent(t, "123", "success", time.Now()))
+	require.NoError(t, err)
+
+	event := <-events
+	assert.Equal(t, expSessionRUL.Source.Subjects, event.Subjects)
+	assert.Equal(t, common.SessionCorrelationID("deadbeef", testBootID, "123"), event.Metadata.AuditID)
+}
+
+func TestSessionTracker_RestoreState_DifferentBoot(t *testing.T) {
+	t.Parallel()
+
+	st := NewSessionTracker(nil, nil)
+	st.pidsToRULs.Store(999, newTestStateRUL(999, time.Now()))
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	err := st.SaveState(statePath, "previous-boot")
+	require.NoError(t, err)
+
+	restored := NewSessionTracker(nil, nil)
+
+	err = restored.RestoreState(statePath, testBootID)
+
+	var expErr *SessionTrackerError
+	require.ErrorAs(t, err, &expErr)
+	assert.True(t, expErr.StaleState(), "expected stale state to be true - it is false")
+	assert.Equal(t, 0, restored.pidsToRULs.Len())
+}
+
+func TestSessionTracker_RestoreState_FileDoesNotExist(t *testing.T) {
+	t.Parallel()
+
+	st := NewSessionTracker(nil, nil)
+
+	err := st.RestoreState(filepath.Join(t.TempDir(), "state.json"), testBootID)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSessionTracker_RestoreState_UnsupportedVersion(t *testing.T) {
+	t.Parallel()
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	err := os.WriteFile(statePath, []byte(`{"version":0,"boot_id":"`+testBootID+`"}`), 0o600)
+	require.NoError(t, err)
+
+	st := NewSessionTracker(nil, nil)
+
+	err = st.RestoreState(statePath, testBootID)
+	assert.ErrorContains(t, err, "unsupported session tracker state version")
+}
+
+func TestSessionTracker_RestoreState_SkipsInvalidLogins(t *testing.T) {
+	t.Parallel()
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	err := os.WriteFile(statePath, []byte(`{
+  "version": 1,
+  "boot_id": "`+testBootID+`",
+  "sessions": [{"session_id": "123", "src_pid": 999, "login": {"PID": 999}}],
+  "logins": [{"PID": 1000, "CredUserID": "foo"}]
+}`), 0o600)
+	require.NoError(t, err)
+
+	st := NewSessionTracker(nil, nil)
+
+	err = st.RestoreState(statePath, testBootID)
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, st.sessIDsToUsers.Len())
+	assert.Equal(t, 0, st.pidsToRULs.Len())
+}
+
+func newTestStateRUL(pid int, loggedAt time.Time) common.RemoteUserLogin {
+	return common.RemoteUserLogin{
+		Source: &auditevent.AuditEvent{
+			LoggedAt: loggedAt,
+			Subjects: map[string]string{
+				"loggedAs": "core",
+				"userID":   "foo",
+			},
+			Source: auditevent.EventSource{
+				Type:  "IP",
+				Value: "127.0.0.1",
+				Extra: map[string]any{
+					"port": "666",
+				},
+			},
+			Target: map[string]string{
+				"host": "foo",
+			},
+		},
+		PID:        pid,
+		CredUserID: "foo",
+	}
+}
@@ -0,0 +1,142 @@
+package sessiontracker
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+// SystemActionMode determines which audit events that are not associated
+// with an audit session (e.g., events produced by cron jobs, systemd units
+// and daemons) are written as SystemAction events.
+type SystemActionMode string
+
+const (
+	// SystemActionsNone means no SystemAction events are written.
+	// This is the default.
+	SystemActionsNone SystemActionMode = "none"
+
+	// SystemActionsAll means a SystemAction event is written for
+	// every audit event that is not associated with an audit session.
+	SystemActionsAll SystemActionMode = "all"
+
+	// SystemActionsKeyed means a SystemAction event is written only
+	// for audit events produced by audit rules that specify a key
+	// (e.g., "-k identity").
+	SystemActionsKeyed SystemActionMode = "keyed"
+)
+
+// ParseSystemActionMode parses s into a SystemActionMode.
+func ParseSystemActionMode(s string) (SystemActionMode, error) {
+	switch SystemActionMode(s) {
+	case SystemActionsNone, SystemActionsAll, SystemActionsKeyed:
+		return SystemActionMode(s), nil
+	default:
+		return "", fmt.Errorf("unknown system action mode: %q", s)
+	}
+}
+
+// SystemActionConfig configures the SystemAction events written
+// for audit events that are not associated with an audit session.
+type SystemActionConfig struct {
+	// Mode determines which audit events are written. A zero
+	// value is equivalent to SystemActionsNone.
+	Mode SystemActionMode
+
+	// NodeName and MachineID identify the computer that
+	// produced the audit events.
+	NodeName  string
+	MachineID string
+}
+
+// shouldWrite returns true if a SystemAction event
+// should be written for the provided audit event.
+func (o SystemActionConfig) shouldWrite(ae *aucoalesce.Event) bool {
+	switch o.Mode {
+	case SystemActionsAll:
+		return true
+	case SystemActionsKeyed:
+		return len(ae.Tags) > 0
+	default:
+		return false
+	}
+}
+
+// toAuditEvent converts an audit event that is not associated with
+// an audit session into a SystemAction event. The process that
+// caused the event is used as the event's source and the process'
+// user IDs (e.g., uid and auid) are used as its subjects.
+func (o SystemActionConfig) toAuditEvent(ae *aucoalesce.Event) *auditevent.AuditEvent {
+	outcome := auditevent.OutcomeFailed
+	if ae.Result == "success" {
+		outcome = auditevent.OutcomeSucceeded
+	}
+
+	sourceValue := ae.Process.Exe
+	if sourceValue == "" {
+		sourceValue = ae.Process.Name
+	}
+	if sourceValue == "" {
+		sourceValue = common.UnknownAddr
+	}
+
+	sourceExtra := map[string]any{}
+	for k, v := range map[string]string{
+		"pid":  ae.Process.PID,
+		"ppid": ae.Process.PPID,
+		"name": ae.Process.Name,
+		"cwd":  ae.Process.CWD,
+	} {
+		if v != "" {
+			sourceExtra[k] = v
+		}
+	}
+
+	// User IDs are copied as-is (e.g., "auid": "1000"). Their
+	// names, if they were resolved, are suffixed with "Name"
+	// (e.g., "auidName": "core").
+	subjects := make(map[string]string, len(ae.User.IDs)+len(ae.User.Names))
+	for k, v := range ae.User.IDs {
+		subjects[k] = v
+	}
+	for k, v := range ae.User.Names {
+		subjects[k+"Name"] = v
+	}
+
+	evt := auditevent.NewAuditEvent(
+		common.ActionSystemAction,
+		auditevent.EventSource{
+			Type:  "process",
+			Value: sourceValue,
+			Extra: sourceExtra,
+		},
+		outcome,
+		subjects,
+		"auditd",
+	).WithTarget(map[string]string{
+		"host":       o.NodeName,
+		"machine-id": o.MachineID,
+	})
+
+	evt.LoggedAt = ae.Timestamp
+	evt.Metadata.AuditID = strconv.FormatUint(uint64(ae.Sequence), 10)
+	evt.Metadata.Extra = map[string]any{
+		"action": ae.Summary.Action,
+		"how":    ae.Summary.How,
+		"object": ae.Summary.Object,
+	}
+
+	if len(ae.Process.Args) > 0 {
+		evt.Metadata.Extra["process_args"] = ae.Process.Args
+	}
+
+	if len(ae.Tags) > 0 {
+		evt.Metadata.Extra["keys"] = ae.Tags
+	}
+
+	return evt
+}
@@ -0,0 +1,192 @@
+package sessiontracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-libaudit/v2/aucoalesce"
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/testtools"
+)
+
+func TestParseSystemActionMode(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"none", "all", "keyed"} {
+		mode, err := ParseSystemActionMode(s)
+		require.NoError(t, err)
+		assert.Equal(t, SystemActionMode(s), mode)
+	}
+
+	_, err := ParseSystemActionMode("some")
+	assert.Error(t, err)
+}
+
+func TestSessionTracker_AuditdEvent_SystemAction(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		mode      SystemActionMode
+		tags      []string
+		expWrites bool
+	}
+
+	for name, tc := range map[string]testCase{
+		"zero value":     {mode: "", tags: []string{"identity"}, expWrites: false},
+		"none":           {mode: SystemActionsNone, tags: []string{"identity"}, expWrites: false},
+		"all":            {mode: SystemActionsAll, tags: nil, expWrites: true},
+		"keyed with key": {mode: SystemActionsKeyed, tags: []string{"identity"}, expWrites: true},
+		"keyed, no key":  {mode: SystemActionsKeyed, tags: nil, expWrites: false},
+	} {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+			defer cancelFn()
+
+			events := make(chan *auditevent.AuditEvent, 2)
+
+			st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+				Ctx:    ctx,
+				Events: events,
+				T:      t,
+			}), nil)
+			st.SetSystemActionConfig(SystemActionConfig{Mode: tc.mode})
+
+			for _, session := range []string{"", "unset"} {
+				event := newSystemAucoalesceEvent(t, session)
+				event.Tags = tc.tags
+
+				err := st.AuditdEvent(event)
+				require.NoError(t, err)
+			}
+
+			if tc.expWrites {
+				assert.Len(t, events, 2)
+			} else {
+				assert.Empty(t, events)
+			}
+
+			assert.Equal(t, 0, st.sessIDsToUsers.Len())
+		})
+	}
+}
+
+func TestSessionTracker_AuditdEvent_SystemAction_WriteErr(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFn()
+
+	expErr := errors.New("forced failure")
+
+	st := NewSessionTracker(auditevent.NewAuditEventWriter(&testtools.TestAuditEncoder{
+		Ctx:    ctx,
+		Err:    expErr,
+		Events: make(chan *auditevent.AuditEvent, 1),
+		T:      t,
+	}), nil)
+	st.SetSystemActionConfig(SystemActionConfig{Mode: SystemActionsAll})
+
+	err := st.AuditdEvent(newSystemAucoalesceEvent(t, "unset"))
+
+	var stErr *SessionTrackerError
+	require.ErrorAs(t, err, &stErr)
+	assert.True(t, stErr.AuditEventWriteFailed())
+	assert.ErrorIs(t, err, expErr)
+}
+
+func TestSystemActionConfig_ToAuditEvent(t *testing.T) {
+	t.Parallel()
+
+	config := SystemActionConfig{
+		Mode:      SystemActionsAll,
+		NodeName:  "blam",
+		MachineID: "deadbeef",
+	}
+
+	ae := newSystemAucoalesceEvent(t, "unset")
+	ae.Tags = []string{"identity"}
+
+	event := config.toAuditEvent(ae)
+
+	assert.Equal(t, common.ActionSystemAction, event.Type)
+	assert.Equal(t, "auditd", event.Component)
+	assert.Equal(t, auditevent.OutcomeSucceeded, event.Outcome)
+	assert.Equal(t, ae.Timestamp, event.LoggedAt)
+	assert.Equal(t, "1234", event.Metadata.AuditID)
+	assert.Equal(t, auditevent.EventSource{
+		Type:  "process",
+		Value: "/usr/bin/passwd",
+		Extra: map[string]any{
+			"pid":  "666",
+			"ppid": "1",
+			"name": "passwd",
+			"cwd":  "/",
+		},
+	}, event.Source)
+	assert.Equal(t, map[string]string{
+		"uid":      "0",
+		"auid":     "unset",
+		"uidName":  "root",
+		"auidName": "unset",
+	}, event.Subjects)
+	assert.Equal(t, map[string]string{
+		"host":       "blam",
+		"machine-id": "deadbeef",
+	}, event.Target)
+	assert.Equal(t, map[string]any{
+		"action":       ae.Summary.Action,
+		"how":          ae.Summary.How,
+		"object":       ae.Summary.Object,
+		"process_args": ae.Process.Args,
+		"keys":         ae.Tags,
+	}, event.Metadata.Extra)
+
+	ae.Result = "fail"
+	ae.Process = aucoalesce.Process{}
+	ae.Tags = nil
+
+	event = config.toAuditEvent(ae)
+
+	assert.Equal(t, auditevent.OutcomeFailed, event.Outcome)
+	assert.Equal(t, common.UnknownAddr, event.Source.Value)
+	assert.Empty(t, event.Source.Extra)
+	assert.NotContains(t, event.Metadata.Extra, "process_args")
+	assert.NotContains(t, event.Metadata.Extra, "keys")
+}
+
+func newSystemAucoalesceEvent(t *testing.T, sessionID string) *aucoalesce.Event {
+	t.Helper()
+
+	ae := newAucoalesceEvent(t, sessionID, "success", time.Now())
+	ae.Sequence = 1234
+	ae.Process = aucoalesce.Process{
+		PID:  "666",
+		PPID: "1",
+		Name: "passwd",
+		Exe:  "/usr/bin/passwd",
+		CWD:  "/",
+		Args: []string{"passwd", "root"},
+	}
+	ae.User = aucoalesce.User{
+		IDs: map[string]string{
+			"uid":  "0",
+			"auid": "unset",
+		},
+		Names: map[string]string{
+			"uid":  "root",
+			"auid": "unset",
+		},
+	}
+
+	return ae
+}
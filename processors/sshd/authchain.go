@@ -0,0 +1,163 @@
+package sshd
+
+import (
+	"time"
+
+	"github.com/metal-toolbox/auditevent"
+	"go.uber.org/zap"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+const (
+	// authChainTTL is how long the methods that a connection partially
+	// authenticated with are kept while waiting for it to complete the
+	// remaining methods. It is longer than sshd's default LoginGraceTime
+	// (two minutes) to leave time for slow second factors.
+	authChainTTL = 10 * time.Minute
+
+	// metadataAuthMethods is the key of the event metadata that lists
+	// the authentication methods that a user completed to log in.
+	metadataAuthMethods = "authMethods"
+
+	// Authentication method names, as logged by sshd.
+	authMethodPublicKey = "publickey"
+	authMethodPassword  = "password"
+
+	idxAuthOutcome = "Outcome"
+	idxAuthMethod  = "Method"
+	idxAuthInfo    = "Info"
+)
+
+// authMethod is an authentication method that a user completed.
+type authMethod struct {
+	// Method is the name of the method, including its submethod
+	// (e.g., "publickey" or "keyboard-interactive/pam").
+	Method string `json:"method"`
+
+	// Info contains details about how the method was completed, if sshd
+	// logged them (e.g., the public key's type and fingerprint, followed
+	// by its certificate's details if it is a certificate).
+	Info string `json:"info,omitempty"`
+}
+
+// authChainKey identifies the connection that an authMethod was
+// completed for. sshd logs the outcomes of a connection's methods
+// from the same process.
+type authChainKey struct {
+	pid    string
+	source string
+	port   string
+}
+
+// newAuthChains returns a map for the methods that connections
+// partially authenticated with, which expire after authChainTTL.
+func newAuthChains() *common.ExpiringSyncMap[authChainKey, []authMethod] {
+	return common.NewExpiringSyncMap(common.ExpiringSyncMapConfig[authChainKey, []authMethod]{
+		TTL: authChainTTL,
+	})
+}
+
+// processAuthStepEntry handles the "Partial" and "Postponed" outcomes
+// of an authentication method.
+//
+// When sshd_config's AuthenticationMethods requires more than one
+// method (e.g., "publickey,keyboard-interactive"), sshd logs "Partial"
+// for each method but the last one, which is logged as "Accepted".
+// The partial methods are saved so that the UserLogin event written
+// for the accepted method lists every method the user completed.
+func processAuthStepEntry(config *SshdProcessorer) error {
+	matches := authStepRE.FindStringSubmatch(config.logEntry)
+	if matches == nil {
+		logger.Infoln("got auth step entry with no regular expression matches")
+		return nil
+	}
+
+	outcome := matches[authStepRE.SubexpIndex(idxAuthOutcome)]
+	method := authMethod{
+		Method: matches[authStepRE.SubexpIndex(idxAuthMethod)],
+		Info:   matches[authStepRE.SubexpIndex(idxAuthInfo)],
+	}
+
+	if outcome != "Partial" {
+		if logger.Level().Enabled(zap.DebugLevel) {
+			logger.Debugf("ignoring %s outcome of %s authentication for pid %s",
+				outcome, method.Method, config.pid)
+		}
+
+		return nil
+	}
+
+	if config.authChains == nil {
+		return nil
+	}
+
+	key := authChainKey{
+		pid:    config.pid,
+		source: matches[authStepRE.SubexpIndex(idxLoginSource)],
+		port:   matches[authStepRE.SubexpIndex(idxLoginPort)],
+	}
+
+	methods, _ := config.authChains.Load(key)
+	config.authChains.Store(key, append(methods, method))
+
+	return nil
+}
+
+// finishAuthChain adds the methods that the connection from source and
+// port partially authenticated with, followed by the method that it
+// was accepted with, to the event's metadata. method is the name of the
+// accepted method, in case it cannot be parsed. The partial methods are
+// forgotten. It returns the user ID (i.e., key ID) of the first
+// certificate found among the partial methods, or an empty string if
+// there is none. This identifies the user when the accepted method
+// does not (e.g., a certificate followed by a one-time password).
+func (s *SshdProcessorer) finishAuthChain(evt *auditevent.AuditEvent, method string, source string, port string) string {
+	final := authMethod{Method: method}
+
+	matches := authStepRE.FindStringSubmatch(s.logEntry)
+	if matches != nil {
+		final.Method = matches[authStepRE.SubexpIndex(idxAuthMethod)]
+		final.Info = matches[authStepRE.SubexpIndex(idxAuthInfo)]
+	}
+
+	var partial []authMethod
+	if s.authChains != nil {
+		key := authChainKey{
+			pid:    s.pid,
+			source: source,
+			port:   port,
+		}
+
+		partial, _ = s.authChains.Load(key)
+		s.authChains.Delete(key)
+	}
+
+	if evt.Metadata.Extra == nil {
+		evt.Metadata.Extra = make(map[string]any, 1)
+	}
+
+	evt.Metadata.Extra[metadataAuthMethods] = append(partial, final)
+
+	for _, step := range partial {
+		idMatches := certIDRE.FindStringSubmatch(step.Info)
+		if idMatches != nil {
+			return idMatches[certIDRE.SubexpIndex(idxCertUserID)]
+		}
+	}
+
+	return ""
+}
+
+// chainedUserID sets the event's "userID" subject to chainUserID,
+// which was returned by finishAuthChain, if it is not empty. It
+// returns the resulting user ID.
+func chainedUserID(evt *auditevent.AuditEvent, chainUserID string) string {
+	if chainUserID == "" {
+		return common.UnknownUser
+	}
+
+	evt.Subjects["userID"] = chainUserID
+
+	return chainUserID
+}
@@ -0,0 +1,119 @@
+package sshd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+//nolint:lll // These are test cases.
+const (
+	testPartialCertEntry = "Partial publickey for core from 127.0.0.1 port 666 ssh2: ED25519-CERT SHA256:YI+caZKJCNaXgsD0NvRZ2fLaEeF46cEVyadru/SL76o ID foo@bar.com (serial 0) CA ED25519 SHA256:Pcs5TWfcOSKb7Rw/XyvHfUcaQzmw6HtLrjUoyXuzIj8"
+	testPartialCertInfo  = "ED25519-CERT SHA256:YI+caZKJCNaXgsD0NvRZ2fLaEeF46cEVyadru/SL76o ID foo@bar.com (serial 0) CA ED25519 SHA256:Pcs5TWfcOSKb7Rw/XyvHfUcaQzmw6HtLrjUoyXuzIj8"
+)
+
+func TestAuthChain_PublicKeyThenPassword(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+	ctx := context.Background()
+
+	err := p.ProcessSshdLogEntry(ctx, SshdLogEntry{
+		Message: "Postponed publickey for core from 127.0.0.1 port 666 ssh2 [preauth]",
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	err = p.ProcessSshdLogEntry(ctx, SshdLogEntry{
+		Message: testPartialCertEntry,
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	assert.Nil(t, enc.evt, "partial and postponed outcomes should not write events")
+
+	err = p.ProcessSshdLogEntry(ctx, SshdLogEntry{
+		Message: "Accepted password for core from 127.0.0.1 port 666 ssh2",
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, []authMethod{
+		{Method: authMethodPublicKey, Info: testPartialCertInfo},
+		{Method: authMethodPassword},
+	}, enc.evt.Metadata.Extra[metadataAuthMethods])
+	assert.Equal(t, "foo@bar.com", enc.evt.Subjects["userID"],
+		"the user should be identified by the partial method's certificate")
+
+	select {
+	case login := <-logins:
+		assert.Equal(t, "foo@bar.com", login.CredUserID)
+	default:
+		t.Fatal("expected login event to be sent to channel")
+	}
+
+	// The partial method is forgotten once the chain is finished.
+	err = p.ProcessSshdLogEntry(ctx, SshdLogEntry{
+		Message: "Accepted password for core from 127.0.0.1 port 666 ssh2",
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []authMethod{
+		{Method: authMethodPassword},
+	}, enc.evt.Metadata.Extra[metadataAuthMethods])
+}
+
+func TestAuthChain_SingleMethod(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo",
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, []authMethod{
+		{Method: authMethodPublicKey, Info: "ED25519 SHA256:foo"},
+	}, enc.evt.Metadata.Extra[metadataAuthMethods])
+
+	login := <-logins
+	assert.Equal(t, common.UnknownUser, login.CredUserID)
+}
+
+func TestAuthChain_OtherConnection(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+	ctx := context.Background()
+
+	err := p.ProcessSshdLogEntry(ctx, SshdLogEntry{
+		Message: testPartialCertEntry,
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	// Another connection, logged by another process, does
+	// not include the first connection's partial method.
+	err = p.ProcessSshdLogEntry(ctx, SshdLogEntry{
+		Message: "Accepted publickey for core from 127.0.0.1 port 667 ssh2: ED25519 SHA256:foo",
+		PID:     "101",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, []authMethod{
+		{Method: authMethodPublicKey, Info: "ED25519 SHA256:foo"},
+	}, enc.evt.Metadata.Extra[metadataAuthMethods])
+
+	login := <-logins
+	assert.Equal(t, common.UnknownUser, login.CredUserID)
+}
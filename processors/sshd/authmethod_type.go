@@ -0,0 +1,173 @@
+package sshd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+const (
+	// Authentication method names, as logged by sshd, that are
+	// handled by processAuthMethodEntry. keyboard-interactive is
+	// logged with its submethod (e.g., "keyboard-interactive/pam").
+	authMethodKeyboardInteractive = "keyboard-interactive"
+	authMethodGSSAPI              = "gssapi-with-mic"
+	authMethodHostbased           = "hostbased"
+	authMethodNone                = "none"
+
+	idxClientUser = "ClientUser"
+	idxClientHost = "ClientHost"
+)
+
+// authMethodLoginType returns the login type of an authentication
+// method handled by processAuthMethodEntry.
+func authMethodLoginType(method string) metrics.LoginType {
+	switch {
+	case method == authMethodKeyboardInteractive,
+		strings.HasPrefix(method, authMethodKeyboardInteractive+"/"):
+		return metrics.KeyboardInteractiveLogin
+	case method == authMethodGSSAPI:
+		return metrics.GSSAPILogin
+	case method == authMethodHostbased:
+		return metrics.HostbasedLogin
+	case method == authMethodNone:
+		return metrics.NoneLogin
+	default:
+		return metrics.UnknownLogin
+	}
+}
+
+// processAuthMethodEntry handles the "Accepted" and "Failed" outcomes
+// of the authentication methods that are not handled by the publickey
+// and password processors: keyboard-interactive, gssapi-with-mic,
+// hostbased and none.
+//
+// For gssapi-with-mic, sshd logs the client's Kerberos principal,
+// which is used as the user ID. For the other methods, the user ID is
+// only known if the user completed a certificate before the method
+// (see finishAuthChain).
+func processAuthMethodEntry(config *SshdProcessorer) error {
+	matches := authStepRE.FindStringSubmatch(config.logEntry)
+	if matches == nil {
+		logger.Infoln("got auth method entry with no regular expression matches")
+		return nil
+	}
+
+	method := matches[authStepRE.SubexpIndex(idxAuthMethod)]
+	source := matches[authStepRE.SubexpIndex(idxLoginSource)]
+	port := matches[authStepRE.SubexpIndex(idxLoginPort)]
+	info := matches[authStepRE.SubexpIndex(idxAuthInfo)]
+	loginType := authMethodLoginType(method)
+
+	outcome := auditevent.OutcomeSucceeded
+	if matches[authStepRE.SubexpIndex(idxAuthOutcome)] != "Accepted" {
+		outcome = auditevent.OutcomeFailed
+	}
+
+	evt := auditevent.NewAuditEvent(
+		common.ActionLoginIdentifier,
+		auditevent.EventSource{
+			Type:  "IP",
+			Value: source,
+			Extra: map[string]any{
+				"port": port,
+			},
+		},
+		outcome,
+		map[string]string{
+			"loggedAs": matches[authStepRE.SubexpIndex(idxLoginUserName)],
+			"userID":   common.UnknownUser,
+			"pid":      config.pid,
+		},
+		"sshd",
+	).WithTarget(map[string]string{
+		"host":       config.nodeName,
+		"machine-id": config.machineID,
+	})
+
+	evt.LoggedAt = config.when
+
+	switch method {
+	case authMethodGSSAPI:
+		// The principal is only logged when the method succeeds.
+		if info != "" {
+			evt.Subjects["userID"] = info
+		}
+	case authMethodHostbased:
+		infoMatches := hostbasedInfoRE.FindStringSubmatch(info)
+		if infoMatches == nil {
+			break
+		}
+
+		ed, ederr := extraDataForHostbased(infoMatches)
+		if ederr != nil {
+			logger.Errorf("failed to create extra data for hostbased login event - %s", ederr)
+		} else {
+			evt = evt.WithData(ed)
+		}
+	}
+
+	if outcome == auditevent.OutcomeFailed {
+		// Increment metric even if it fails to write the event
+		config.metrics.IncLogins(loginType, metrics.Failure)
+
+		if err := config.write(evt); err != nil {
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+
+		return nil
+	}
+
+	config.metrics.IncLogins(loginType, metrics.Success)
+
+	pid, err := strconv.Atoi(config.pid)
+	if err != nil {
+		logger.Errorf("failed to convert pid string to int ('%s') - %s",
+			config.pid, err)
+		return nil
+	}
+
+	chainUserID := config.finishAuthChain(evt, method, source, port)
+
+	credUserID := evt.Subjects["userID"]
+	if credUserID == common.UnknownUser {
+		credUserID = chainedUserID(evt, chainUserID)
+	}
+
+	if err := config.write(evt); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+
+	select {
+	case <-config.ctx.Done():
+		return nil
+	case config.logins <- common.RemoteUserLogin{
+		Source:     evt,
+		PID:        pid,
+		CredUserID: credUserID,
+		ObservedAt: config.observed,
+	}:
+		return nil
+	}
+}
+
+// extraDataForHostbased returns the client host's key and the client
+// host and user names that sshd logged for a hostbased authentication,
+// given the matches of hostbasedInfoRE.
+func extraDataForHostbased(matches []string) (*json.RawMessage, error) {
+	extraData := map[string]string{
+		idxLoginAlg:   matches[hostbasedInfoRE.SubexpIndex(idxLoginAlg)],
+		idxSSHKeySum:  matches[hostbasedInfoRE.SubexpIndex(idxSSHKeySum)],
+		idxClientUser: matches[hostbasedInfoRE.SubexpIndex(idxClientUser)],
+		idxClientHost: matches[hostbasedInfoRE.SubexpIndex(idxClientHost)],
+	}
+	raw, err := json.Marshal(extraData)
+	rawmsg := json.RawMessage(raw)
+	return &rawmsg, err
+}
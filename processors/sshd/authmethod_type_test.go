@@ -0,0 +1,177 @@
+package sshd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+func TestProcessAuthMethodEntry_KeyboardInteractive(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Accepted keyboard-interactive/pam for core from 127.0.0.1 port 666 ssh2",
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, common.ActionLoginIdentifier, enc.evt.Type)
+	assert.Equal(t, auditevent.OutcomeSucceeded, enc.evt.Outcome)
+	assert.Equal(t, "127.0.0.1", enc.evt.Source.Value)
+	assert.Equal(t, "666", enc.evt.Source.Extra["port"])
+	assert.Equal(t, "core", enc.evt.Subjects["loggedAs"])
+	assert.Equal(t, common.UnknownUser, enc.evt.Subjects["userID"])
+	assert.Equal(t, "100", enc.evt.Subjects["pid"])
+	assert.Equal(t, []authMethod{
+		{Method: "keyboard-interactive/pam"},
+	}, enc.evt.Metadata.Extra[metadataAuthMethods])
+
+	select {
+	case login := <-logins:
+		assert.Equal(t, 100, login.PID)
+		assert.Equal(t, common.UnknownUser, login.CredUserID)
+		assert.Same(t, enc.evt, login.Source)
+	default:
+		t.Fatal("expected login event to be sent to channel")
+	}
+}
+
+func TestProcessAuthMethodEntry_GSSAPI(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Accepted gssapi-with-mic for core from 127.0.0.1 port 666 ssh2: foo@EXAMPLE.COM",
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, auditevent.OutcomeSucceeded, enc.evt.Outcome)
+	assert.Equal(t, "core", enc.evt.Subjects["loggedAs"])
+	assert.Equal(t, "foo@EXAMPLE.COM", enc.evt.Subjects["userID"],
+		"the user should be identified by their kerberos principal")
+
+	select {
+	case login := <-logins:
+		assert.Equal(t, "foo@EXAMPLE.COM", login.CredUserID)
+	default:
+		t.Fatal("expected login event to be sent to channel")
+	}
+}
+
+func TestProcessAuthMethodEntry_Hostbased(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Accepted hostbased for core from 127.0.0.1 port 666 ssh2: " +
+			"ED25519 SHA256:frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI, " +
+			`client user "core", client host "client.example.com"`,
+		PID: "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, auditevent.OutcomeSucceeded, enc.evt.Outcome)
+	assert.Equal(t, common.UnknownUser, enc.evt.Subjects["userID"])
+	assert.Equal(t, map[string]string{
+		idxLoginAlg:   "ED25519 SHA256",
+		idxSSHKeySum:  "frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI",
+		idxClientUser: "core",
+		idxClientHost: "client.example.com",
+	}, testEventData(t, enc.evt))
+
+	select {
+	case <-logins:
+	default:
+		t.Fatal("expected login event to be sent to channel")
+	}
+}
+
+func TestProcessAuthMethodEntry_CertificateThenKeyboardInteractive(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+	ctx := context.Background()
+
+	err := p.ProcessSshdLogEntry(ctx, SshdLogEntry{
+		Message: testPartialCertEntry,
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	err = p.ProcessSshdLogEntry(ctx, SshdLogEntry{
+		Message: "Accepted keyboard-interactive/pam for core from 127.0.0.1 port 666 ssh2",
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, "foo@bar.com", enc.evt.Subjects["userID"])
+	assert.Equal(t, []authMethod{
+		{Method: authMethodPublicKey, Info: testPartialCertInfo},
+		{Method: "keyboard-interactive/pam"},
+	}, enc.evt.Metadata.Extra[metadataAuthMethods])
+
+	select {
+	case login := <-logins:
+		assert.Equal(t, "foo@bar.com", login.CredUserID)
+	default:
+		t.Fatal("expected login event to be sent to channel")
+	}
+}
+
+func TestProcessAuthMethodEntry_Failed(t *testing.T) {
+	t.Parallel()
+
+	for _, msg := range []string{
+		"Failed keyboard-interactive/pam for core from 127.0.0.1 port 666 ssh2",
+		"Failed keyboard-interactive/pam for invalid user cow from 127.0.0.1 port 666 ssh2",
+		"Failed gssapi-with-mic for core from 127.0.0.1 port 666 ssh2",
+		"Failed hostbased for core from 127.0.0.1 port 666 ssh2: " +
+			"ED25519 SHA256:frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI, " +
+			`client user "core", client host "client.example.com"`,
+	} {
+		p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+		err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+			Message: msg,
+			PID:     "100",
+		})
+		require.NoError(t, err, msg)
+
+		require.NotNil(t, enc.evt, msg)
+		assert.Equal(t, auditevent.OutcomeFailed, enc.evt.Outcome, msg)
+		assert.Equal(t, common.UnknownUser, enc.evt.Subjects["userID"], msg)
+		assert.Nil(t, enc.evt.Metadata.Extra[metadataAuthMethods], msg)
+		assert.Empty(t, logins, "failed logins should not be sent to the logins channel")
+	}
+}
+
+func TestAuthMethodLoginType(t *testing.T) {
+	t.Parallel()
+
+	for method, exp := range map[string]metrics.LoginType{
+		"keyboard-interactive":     metrics.KeyboardInteractiveLogin,
+		"keyboard-interactive/pam": metrics.KeyboardInteractiveLogin,
+		"gssapi-with-mic":          metrics.GSSAPILogin,
+		"hostbased":                metrics.HostbasedLogin,
+		"none":                     metrics.NoneLogin,
+		"keyboard-interactiveness": metrics.UnknownLogin,
+		"gssapi-keyex":             metrics.UnknownLogin,
+	} {
+		assert.Equal(t, exp, authMethodLoginType(method), method)
+	}
+}
@@ -29,7 +29,7 @@ func nastyPTRRecord(config *SshdProcessorer) error {
 
 	evt := dnsLogToAuditEvent(dnsName, source, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -57,7 +57,7 @@ func reverseMappingCheckFailed(config *SshdProcessorer) error {
 
 	evt := dnsLogToAuditEvent(dnsName, source, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -85,7 +85,7 @@ func doesNotMapBackToAddr(config *SshdProcessorer) error {
 
 	evt := dnsLogToAuditEvent(dnsName, source, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
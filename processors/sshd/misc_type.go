@@ -50,7 +50,7 @@ func rootLoginRefused(config *SshdProcessorer) error {
 
 	evt.LoggedAt = config.when
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -97,7 +97,7 @@ func badOwnerOrModesForHostFile(config *SshdProcessorer) error {
 
 	evt.LoggedAt = config.when
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -153,7 +153,7 @@ func maxAuthAttemptsExceeded(config *SshdProcessorer) error {
 
 	evt.LoggedAt = config.when
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -209,7 +209,7 @@ func failedPasswordAuth(config *SshdProcessorer) error {
 
 	evt.LoggedAt = config.when
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
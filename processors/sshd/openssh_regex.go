@@ -94,6 +94,59 @@ var (
 	//nolint:lll // This is a long regex... pretty hard to cut it without making it less readable.
 	failedPasswordAuthRE = regexp.MustCompile(`^Failed password for (?P<Username>.*) from (?P<Source>.*) port (?P<Port>\d+) ssh[[:alnum:]]+$`)
 
+	// failedPublicKeyRE matches the "Failed" outcome of the log message
+	// described by loginRE for public keys and certificates. It is only
+	// logged when LogLevel is set to VERBOSE. The user is prefixed with
+	// "invalid user " if they do not exist. Certificate details follow
+	// the key, like they do for "Accepted" messages (see certIDRE).
+	//
+	// Refer to the documentation for loginRE for more information.
+	//
+	// Example:
+	//
+	//	Failed publickey for auditomalditotesting from 127.0.0.1 port 38234 ssh2:
+	//	    ED25519 SHA256:frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI
+	//
+	//nolint:lll // This is a long regex... pretty hard to cut it without making it less readable.
+	failedPublicKeyRE = regexp.MustCompile(`^Failed publickey for (?:invalid user )?(?P<Username>.*) from (?P<Source>.*) port (?P<Port>\d+) ssh[[:alnum:]]+: (?P<Alg>[\w -]+):(?P<SSHKeySum>\S+)`)
+
+	// authStepRE matches any outcome of the log message described by
+	// loginRE, for any authentication method. It is used for the
+	// "Partial" and "Postponed" outcomes. "Partial" means the user
+	// completed one of several methods required by the
+	// AuthenticationMethods option of sshd_config. "Postponed" means
+	// the outcome is not known yet (e.g., sshd is waiting for the
+	// client to prove it has the key that it offered).
+	//
+	// Refer to the documentation for loginRE for more information.
+	//
+	// Examples:
+	//
+	//	Partial publickey for auditomalditotesting from 127.0.0.1 port 38234 ssh2:
+	//	    ED25519 SHA256:frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI
+	//
+	//	Postponed keyboard-interactive for auditomalditotesting from 127.0.0.1 port 38234 ssh2
+	//
+	//nolint:lll // This is a long regex... pretty hard to cut it without making it less readable.
+	authStepRE = regexp.MustCompile(`^(?P<Outcome>Accepted|Failed|Partial|Postponed) (?P<Method>\S+) for (?:invalid user )?(?P<Username>.*) from (?P<Source>.*) port (?P<Port>\d+) ssh[[:alnum:]]+(?:: (?P<Info>.*))?$`)
+
+	// hostbasedInfoRE matches the information that sshd logs about a
+	// host-based authentication attempt, which follows the "ssh2: " of
+	// the message described by authStepRE.
+	//
+	// From auth2-hostbased.c:
+	//
+	//	auth2_record_info(authctxt,
+	//	    "client user \"%.100s\", client host \"%.100s\"", cuser, chost);
+	//
+	// Example:
+	//
+	//	ED25519 SHA256:frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI,
+	//	    client user "core", client host "client.example.com"
+	//
+	//nolint:lll // This is a long regex... pretty hard to cut it without making it less readable.
+	hostbasedInfoRE = regexp.MustCompile(`^(?P<Alg>[\w -]+):(?P<SSHKeySum>\S+?)(?:, client user "(?P<ClientUser>.*)", client host "(?P<ClientHost>.*)")?$`)
+
 	// certIDRE matches the sshd user-certificate log message,
 	// allowing us to extract information about the user's
 	// SSH certificate.
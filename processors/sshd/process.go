@@ -0,0 +1,54 @@
+package sshd
+
+import "strings"
+
+// The names of the sshd processes that log about a connection.
+// Since OpenSSH 9.8, the sshd listener starts an sshd-session process
+// for each connection, which in turn starts an sshd-auth process for
+// user authentication (OpenSSH 10.0+). The processes have different
+// PIDs and log using their own syslog identifiers.
+const (
+	ProcessSshd        = "sshd"
+	ProcessSshdSession = "sshd-session"
+	ProcessSshdAuth    = "sshd-auth"
+)
+
+// ProcessNames are the syslog identifiers of the log entries
+// that are passed to the sshd processor.
+var ProcessNames = []string{
+	ProcessSshd,
+	ProcessSshdSession,
+	ProcessSshdAuth,
+}
+
+// IsProcessName returns true if name is the
+// syslog identifier of an sshd process.
+func IsProcessName(name string) bool {
+	for _, processName := range ProcessNames {
+		if name == processName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// privsepSuffixes are appended by the sshd-session process to the
+// messages it receives from its unprivileged child (e.g., sshd-auth)
+// before logging them.
+var privsepSuffixes = []string{
+	" [preauth]",
+	" [postauth]",
+}
+
+// trimPrivsepSuffix removes the privilege separation
+// suffix from a log message, if it has one.
+func trimPrivsepSuffix(message string) string {
+	for _, suffix := range privsepSuffixes {
+		if strings.HasSuffix(message, suffix) {
+			return strings.TrimSuffix(message, suffix)
+		}
+	}
+
+	return message
+}
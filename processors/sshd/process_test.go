@@ -0,0 +1,114 @@
+package sshd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+func TestIsProcessName(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, IsProcessName("sshd"))
+	assert.True(t, IsProcessName("sshd-session"))
+	assert.True(t, IsProcessName("sshd-auth"))
+	assert.False(t, IsProcessName("sshd-keygen"))
+	assert.False(t, IsProcessName(""))
+}
+
+func TestTrimPrivsepSuffix(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "Connection closed by 127.0.0.1 port 666",
+		trimPrivsepSuffix("Connection closed by 127.0.0.1 port 666 [preauth]"))
+	assert.Equal(t, "Received disconnect from 127.0.0.1 port 666:11: disconnected by user",
+		trimPrivsepSuffix("Received disconnect from 127.0.0.1 port 666:11: disconnected by user [postauth]"))
+	assert.Equal(t, "Connection closed", trimPrivsepSuffix("Connection closed"))
+}
+
+// These log entries are produced by OpenSSH 9.8+, whose
+// sshd-session processes log the connections' logins.
+func TestProcessSshdLogEntry_SshdSession(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo",
+		PID:     "1201",
+		Process: ProcessSshdSession,
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, common.ActionLoginIdentifier, enc.evt.Type)
+	assert.Equal(t, "1201", enc.evt.Subjects["pid"])
+	assert.Equal(t, ProcessSshdSession, enc.evt.Subjects["process"])
+
+	select {
+	case login := <-logins:
+		assert.Equal(t, 1201, login.PID)
+		assert.Equal(t, ProcessSshdSession, login.Source.Subjects["process"])
+	default:
+		t.Fatal("expected login event to be sent to channel")
+	}
+}
+
+func TestProcessSshdLogEntry_Preauth(t *testing.T) {
+	t.Parallel()
+
+	p, enc, _ := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "maximum authentication attempts exceeded for core from 127.0.0.1 port 666 ssh2 [preauth]",
+		PID:     "1201",
+		Process: ProcessSshdSession,
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, common.ActionLoginIdentifier, enc.evt.Type)
+	assert.Equal(t, auditevent.OutcomeFailed, enc.evt.Outcome)
+	assert.Equal(t, "127.0.0.1", enc.evt.Source.Value)
+	assert.Equal(t, ProcessSshdSession, enc.evt.Subjects["process"])
+}
+
+func TestProcessSshdLogEntry_UnknownProcess(t *testing.T) {
+	t.Parallel()
+
+	p, enc, _ := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo",
+		PID:     "1201",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.NotContains(t, enc.evt.Subjects, "process")
+}
+
+func newTestSplitProcessSshdProcessor(t *testing.T) (SshdProcessor, *testAuditEventEncoder, chan common.RemoteUserLogin) {
+	t.Helper()
+
+	enc := &testAuditEventEncoder{t: t}
+	logins := make(chan common.RemoteUserLogin, 1)
+
+	p := NewSshdProcessor(
+		context.Background(),
+		logins,
+		"testnode",
+		"testmid",
+		auditevent.NewAuditEventWriter(enc),
+		metrics.NewPrometheusMetricsProviderForRegisterer(prometheus.NewRegistry()),
+	)
+
+	return p, enc, logins
+}
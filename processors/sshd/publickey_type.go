@@ -0,0 +1,71 @@
+package sshd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/metal-toolbox/auditevent"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+	"github.com/metal-toolbox/audito-maldito/internal/metrics"
+)
+
+// processFailedPublicKeyEntry writes a failed UserLogin event for a
+// public key or certificate that the user failed to authenticate with.
+// sshd only logs these attempts when LogLevel is set to VERBOSE.
+func processFailedPublicKeyEntry(config *SshdProcessorer) error {
+	matches := failedPublicKeyRE.FindStringSubmatch(config.logEntry)
+	if matches == nil {
+		logger.Infoln("got failed publickey entry with no regular expression matches for identifiers")
+		return nil
+	}
+
+	alg := matches[failedPublicKeyRE.SubexpIndex(idxLoginAlg)]
+	keySum := matches[failedPublicKeyRE.SubexpIndex(idxSSHKeySum)]
+
+	evt := auditevent.NewAuditEvent(
+		common.ActionLoginIdentifier,
+		auditevent.EventSource{
+			Type:  "IP",
+			Value: matches[failedPublicKeyRE.SubexpIndex(idxLoginSource)],
+			Extra: map[string]any{
+				"port": matches[failedPublicKeyRE.SubexpIndex(idxLoginPort)],
+			},
+		},
+		auditevent.OutcomeFailed,
+		map[string]string{
+			"loggedAs": matches[failedPublicKeyRE.SubexpIndex(idxLoginUserName)],
+			"pid":      config.pid,
+		},
+		"sshd",
+	).WithTarget(map[string]string{
+		"host":       config.nodeName,
+		"machine-id": config.machineID,
+	})
+
+	evt.LoggedAt = config.when
+
+	idMatches := certIDRE.FindStringSubmatch(strings.TrimPrefix(config.logEntry, matches[0]))
+	if idMatches == nil {
+		// Increment metric even if it fails to write the event
+		config.metrics.IncLogins(metrics.SSHKeyLogin, metrics.Failure)
+		addEventInfoForUnknownUser(evt, alg, keySum)
+	} else {
+		config.metrics.IncLogins(metrics.SSHCertLogin, metrics.Failure)
+		evt.Subjects["userID"] = idMatches[certIDRE.SubexpIndex(idxCertUserID)]
+
+		ed, ederr := extraDataWithCA(alg, keySum,
+			idMatches[certIDRE.SubexpIndex(idxCertSerial)], idMatches[certIDRE.SubexpIndex(idxCertCA)])
+		if ederr != nil {
+			logger.Errorf("failed to create extra data for failed login event - %s", ederr)
+		} else {
+			evt = evt.WithData(ed)
+		}
+	}
+
+	if err := config.write(evt); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package sshd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/metal-toolbox/auditevent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-toolbox/audito-maldito/internal/common"
+)
+
+func TestProcessFailedPublicKeyEntry(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Failed publickey for core from 127.0.0.1 port 38234 ssh2: " +
+			"ED25519 SHA256:frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI",
+		PID: "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, common.ActionLoginIdentifier, enc.evt.Type)
+	assert.Equal(t, auditevent.OutcomeFailed, enc.evt.Outcome)
+	assert.Equal(t, "127.0.0.1", enc.evt.Source.Value)
+	assert.Equal(t, "38234", enc.evt.Source.Extra["port"])
+	assert.Equal(t, "core", enc.evt.Subjects["loggedAs"])
+	assert.Equal(t, common.UnknownUser, enc.evt.Subjects["userID"])
+	assert.Equal(t, "100", enc.evt.Subjects["pid"])
+	assert.Equal(t, map[string]string{
+		idxLoginAlg:  "ED25519 SHA256",
+		idxSSHKeySum: "frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI",
+	}, testEventData(t, enc.evt))
+
+	assert.Empty(t, logins, "failed logins should not be sent to the logins channel")
+}
+
+func TestProcessFailedPublicKeyEntry_Certificate(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Failed publickey for core from 127.0.0.1 port 38656 ssh2: " +
+			"ED25519-CERT SHA256:frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI " +
+			"ID foo@bar.com (serial 7) CA ED25519 SHA256:3PCaZkpmyZdYJSgpa2xv4wJiLmLPj1Y8oFgfrON7vJE",
+		PID: "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, auditevent.OutcomeFailed, enc.evt.Outcome)
+	assert.Equal(t, "core", enc.evt.Subjects["loggedAs"])
+	assert.Equal(t, "foo@bar.com", enc.evt.Subjects["userID"])
+	assert.Equal(t, map[string]string{
+		idxLoginAlg:   "ED25519-CERT SHA256",
+		idxSSHKeySum:  "frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI",
+		idxCertSerial: "7",
+		idxCertCA:     "CA ED25519 SHA256:3PCaZkpmyZdYJSgpa2xv4wJiLmLPj1Y8oFgfrON7vJE",
+	}, testEventData(t, enc.evt))
+
+	assert.Empty(t, logins, "failed logins should not be sent to the logins channel")
+}
+
+func TestProcessFailedPublicKeyEntry_InvalidUser(t *testing.T) {
+	t.Parallel()
+
+	p, enc, _ := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Failed publickey for invalid user cow from 127.0.0.1 port 38234 ssh2: " +
+			"RSA SHA256:frGtfUnZ8huEWJjAGnmLsmCqE0to2nuvfP4qhIUIUaI",
+		PID: "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, auditevent.OutcomeFailed, enc.evt.Outcome)
+	assert.Equal(t, "cow", enc.evt.Subjects["loggedAs"])
+}
+
+// testEventData returns the data of an event whose data
+// is a JSON object whose values are strings.
+func testEventData(t *testing.T, evt *auditevent.AuditEvent) map[string]string {
+	t.Helper()
+
+	require.NotNil(t, evt.Data)
+
+	var data map[string]string
+	require.NoError(t, json.Unmarshal(*evt.Data, &data))
+
+	return data
+}
@@ -35,7 +35,7 @@ func revokedPublicKeyByFile(config *SshdProcessorer) error {
 
 	evt := revokedLogToAuditEvent(keyType, fingerprint, filePath, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -69,7 +69,7 @@ func revokedPublicKeyByFileErr(config *SshdProcessorer) error {
 
 	evt := revokedLogToAuditEvent(keyType, fingerprint, filePath, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
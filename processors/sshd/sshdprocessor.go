@@ -34,6 +34,8 @@ func NewSshdProcessor(
 		machineID: machineID,
 		eventW:    eventW,
 		metrics:   m,
+
+		authChains: newAuthChains(),
 	}
 }
 
@@ -44,22 +46,40 @@ type SshdProcessorer struct {
 	nodeName  string
 	machineID string
 	when      time.Time
+	observed  time.Time
 	pid       string
+	process   string
 	eventW    *auditevent.EventWriter
 	metrics   *metrics.PrometheusMetricsProvider
+
+	// authChains contains the authentication methods that connections
+	// completed while other methods are required to log in. It is
+	// shared by the SshdProcessorer of each log entry.
+	authChains *common.ExpiringSyncMap[authChainKey, []authMethod]
 }
 
 func (s *SshdProcessorer) ProcessSshdLogEntry(ctx context.Context, sm SshdLogEntry) error {
+	observed := time.Now()
+
+	when := sm.Timestamp
+	if when.IsZero() {
+		when = observed
+	}
+
 	return ProcessEntry(&SshdProcessorer{
 		ctx:       ctx,
 		logins:    s.logins,
-		logEntry:  sm.Message,
+		logEntry:  trimPrivsepSuffix(sm.Message),
 		nodeName:  s.nodeName,
 		machineID: s.machineID,
-		when:      time.Now(),
+		when:      when,
+		observed:  observed,
 		pid:       sm.PID,
+		process:   sm.Process,
 		eventW:    s.eventW,
 		metrics:   s.metrics,
+
+		authChains: s.authChains,
 	})
 }
 
@@ -79,6 +99,10 @@ const (
 	idxSSHKeyFP      = "SSHKeyFingerprint"
 )
 
+// metadataObservedAt is the key of the event metadata that
+// contains the time at which the log entry was processed.
+const metadataObservedAt = "observedAt"
+
 var logger *zap.SugaredLogger
 
 func SetLogger(l *zap.SugaredLogger) {
@@ -110,6 +134,17 @@ func extraDataWithCA(alg, keySum, certSerial, caData string) (*json.RawMessage,
 type SshdLogEntry struct {
 	Message string
 	PID     string
+
+	// Process is the syslog identifier of the sshd process that
+	// produced the log entry (e.g., ProcessSshdSession). It is
+	// empty if the ingester does not know it.
+	Process string
+
+	// Timestamp is the time at which sshd produced the log entry,
+	// according to the source that the ingester read it from (e.g.,
+	// the syslog header or journald's __REALTIME_TIMESTAMP). The time
+	// at which the entry was processed is used if it is zero.
+	Timestamp time.Time
 }
 
 func ProcessEntry(config *SshdProcessorer) error {
@@ -120,6 +155,18 @@ func ProcessEntry(config *SshdProcessorer) error {
 	case strings.HasPrefix(config.logEntry, "Accepted password"):
 		entryFunc = processAcceptedPasswordEntry
 		config.metrics.IncLogins(metrics.PasswordLogin, metrics.Success)
+	case strings.HasPrefix(config.logEntry, "Accepted "),
+		strings.HasPrefix(config.logEntry, "Failed keyboard-interactive"),
+		strings.HasPrefix(config.logEntry, "Failed gssapi-with-mic"),
+		strings.HasPrefix(config.logEntry, "Failed hostbased"):
+		// Methods other than publickey and password (whose
+		// "Accepted" outcomes are handled above).
+		entryFunc = processAuthMethodEntry
+	case strings.HasPrefix(config.logEntry, "Failed publickey"):
+		entryFunc = processFailedPublicKeyEntry
+	case strings.HasPrefix(config.logEntry, "Partial "),
+		strings.HasPrefix(config.logEntry, "Postponed "):
+		entryFunc = processAuthStepEntry
 	case strings.HasPrefix(config.logEntry, "Certificate invalid"):
 		entryFunc = processCertificateInvalidEntry
 	case strings.HasPrefix(config.logEntry, "Invalid user"):
@@ -171,6 +218,30 @@ func ProcessEntry(config *SshdProcessorer) error {
 	return nil
 }
 
+// write adds the name of the sshd process that produced the log entry,
+// if it is known, to the event's subjects and writes the event.
+// OpenSSH 9.8+ logs a connection from more than one process, so the
+// PID alone does not tell which process logged the event.
+//
+// The time at which the log entry was processed is added to the event's
+// metadata as "observedAt". It differs from the event's LoggedAt when
+// the log entry was delayed (e.g., by a backlog in a named pipe).
+func (s *SshdProcessorer) write(evt *auditevent.AuditEvent) error {
+	if s.process != "" {
+		evt.Subjects["process"] = s.process
+	}
+
+	if !s.observed.IsZero() {
+		if evt.Metadata.Extra == nil {
+			evt.Metadata.Extra = make(map[string]any, 1)
+		}
+
+		evt.Metadata.Extra[metadataObservedAt] = s.observed
+	}
+
+	return s.eventW.Write(evt)
+}
+
 func addEventInfoForUnknownUser(evt *auditevent.AuditEvent, alg, keySum string) {
 	evt.Subjects["userID"] = common.UnknownUser
 	ed, ederr := extraDataWithoutCA(alg, keySum)
@@ -223,6 +294,10 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 
 	evt.LoggedAt = config.when
 
+	// The user may have completed other methods (e.g., a certificate)
+	// before this one. See processAuthStepEntry.
+	chainUserID := config.finishAuthChain(evt, authMethodPublicKey, matches[sourceIdx], matches[portIdx])
+
 	// SSHLogin with certificate/ssh key but no cert info
 	if len(config.logEntry) == len(matches[0]) {
 		// TODO: This log message is incorrect... but I am not sure
@@ -231,7 +306,8 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 		// Increment metric even if it fails to write the event
 		config.metrics.IncLogins(metrics.SSHKeyLogin, metrics.Success)
 		addEventInfoForUnknownUser(evt, matches[algIdx], matches[keyIdx])
-		if err := config.eventW.Write(evt); err != nil {
+		credUserID := chainedUserID(evt, chainUserID)
+		if err := config.write(evt); err != nil {
 			// NOTE(jaosorior): Not being able to write audit events
 			// merits us panicking here.
 			return fmt.Errorf("failed to write event: %w", err)
@@ -242,7 +318,8 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 		case config.logins <- common.RemoteUserLogin{
 			Source:     evt,
 			PID:        pid,
-			CredUserID: common.UnknownUser,
+			CredUserID: credUserID,
+			ObservedAt: config.observed,
 		}:
 			return nil
 		}
@@ -258,7 +335,8 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 		config.metrics.IncLogins(metrics.SSHCertLogin, metrics.Success)
 
 		addEventInfoForUnknownUser(evt, matches[algIdx], matches[keyIdx])
-		if err := config.eventW.Write(evt); err != nil {
+		credUserID := chainedUserID(evt, chainUserID)
+		if err := config.write(evt); err != nil {
 			// NOTE(jaosorior): Not being able to write audit events
 			// merits us panicking here.
 			return fmt.Errorf("failed to write event: %w", err)
@@ -269,7 +347,8 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 		case config.logins <- common.RemoteUserLogin{
 			Source:     evt,
 			PID:        pid,
-			CredUserID: common.UnknownUser,
+			CredUserID: credUserID,
+			ObservedAt: config.observed,
 		}:
 			return nil
 		}
@@ -299,7 +378,7 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 	config.metrics.IncLogins(metrics.SSHCertLogin, metrics.Success)
 
 	// SSHLogin with certificate/ssh key with CA info
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		// NOTE(jaosorior): Not being able to write audit events
 		// merits us panicking here.
 		return fmt.Errorf("failed to write event: %w", err)
@@ -321,6 +400,7 @@ func processAcceptPublicKeyEntry(config *SshdProcessorer) error {
 		Source:     evt,
 		PID:        pid,
 		CredUserID: usernameFromCert,
+		ObservedAt: config.observed,
 	}:
 		return nil
 	}
@@ -381,7 +461,9 @@ func processAcceptedPasswordEntry(config *SshdProcessorer) error {
 
 	evt.LoggedAt = config.when
 
-	if err := config.eventW.Write(evt); err != nil {
+	credUserID := chainedUserID(evt, config.finishAuthChain(evt, authMethodPassword, source, port))
+
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -391,7 +473,8 @@ func processAcceptedPasswordEntry(config *SshdProcessorer) error {
 	case config.logins <- common.RemoteUserLogin{
 		Source:     evt,
 		PID:        pid,
-		CredUserID: common.UnknownUser,
+		CredUserID: credUserID,
+		ObservedAt: config.observed,
 	}:
 		return nil
 	}
@@ -448,7 +531,7 @@ func processCertificateInvalidEntry(config *SshdProcessorer) error {
 
 	// Increment metric even if it fails to write the event
 	config.metrics.IncLogins(metrics.SSHCertLogin, metrics.Failure)
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		// NOTE(jaosorior): Not being able to write audit events
 		// merits us error-ing here.
 		return fmt.Errorf("failed to write event: %w", err)
@@ -503,7 +586,7 @@ func processInvalidUserEntry(config *SshdProcessorer) error {
 	config.metrics.IncLogins(metrics.UnknownLogin, metrics.Failure)
 
 	evt.LoggedAt = config.when
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		// NOTE(jaosorior): Not being able to write audit events
 		// merits us error-ing here.
 		return fmt.Errorf("failed to write event: %w", err)
@@ -430,3 +430,58 @@ func TestEntryProcessing(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessSshdLogEntry_Timestamp(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	loggedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	before := time.Now()
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message:   "Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo",
+		PID:       "100",
+		Timestamp: loggedAt,
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.Equal(t, loggedAt, enc.evt.LoggedAt)
+
+	observedAt, ok := enc.evt.Metadata.Extra[metadataObservedAt].(time.Time)
+	require.True(t, ok, "expected the event's metadata to contain the time it was observed")
+	assert.False(t, observedAt.Before(before))
+
+	select {
+	case login := <-logins:
+		assert.Equal(t, loggedAt, login.Source.LoggedAt)
+		assert.Equal(t, observedAt, login.ObservedAt)
+	default:
+		t.Fatal("expected login event to be sent to channel")
+	}
+}
+
+func TestProcessSshdLogEntry_NoTimestamp(t *testing.T) {
+	t.Parallel()
+
+	p, enc, logins := newTestSplitProcessSshdProcessor(t)
+
+	err := p.ProcessSshdLogEntry(context.Background(), SshdLogEntry{
+		Message: "Accepted publickey for core from 127.0.0.1 port 666 ssh2: ED25519 SHA256:foo",
+		PID:     "100",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, enc.evt)
+	assert.False(t, enc.evt.LoggedAt.IsZero())
+	assert.Equal(t, enc.evt.LoggedAt, enc.evt.Metadata.Extra[metadataObservedAt],
+		"the time the log entry was observed should be used when its timestamp is unknown")
+
+	select {
+	case login := <-logins:
+		assert.Equal(t, enc.evt.LoggedAt, login.ObservedAt)
+	default:
+		t.Fatal("expected login event to be sent to channel")
+	}
+}
@@ -56,7 +56,7 @@ func processNotInAllowUsersEntry(config *SshdProcessorer) error {
 
 	evt := userLogToAuditEvent(username, source, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -90,7 +90,7 @@ func userNonExistentShell(config *SshdProcessorer) error {
 	evt.Metadata.Extra["shell"] = shell
 
 	evt.LoggedAt = config.when
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -123,7 +123,7 @@ func userNonExecutableShell(config *SshdProcessorer) error {
 
 	evt.Metadata.Extra["shell"] = shell
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -151,7 +151,7 @@ func userInDenyUsers(config *SshdProcessorer) error {
 
 	evt := userLogToAuditEvent(username, source, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -179,7 +179,7 @@ func userNotInAnyGroup(config *SshdProcessorer) error {
 
 	evt := userLogToAuditEvent(username, source, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -207,7 +207,7 @@ func userGroupInDenyGroups(config *SshdProcessorer) error {
 
 	evt := userLogToAuditEvent(username, source, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 
@@ -235,7 +235,7 @@ func userGroupNotListedInAllowGroups(config *SshdProcessorer) error {
 
 	evt := userLogToAuditEvent(username, source, config)
 
-	if err := config.eventW.Write(evt); err != nil {
+	if err := config.write(evt); err != nil {
 		return fmt.Errorf("failed to write event: %w", err)
 	}
 